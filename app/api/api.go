@@ -0,0 +1,11 @@
+// Package api holds the OpenAPI description of the JSON API. The document
+// is embedded so the server can publish it and tests can validate real
+// requests and responses against it.
+package api
+
+import _ "embed"
+
+// Spec is the OpenAPI 3.1 document served at /api/v1/openapi.json.
+//
+//go:embed openapi.json
+var Spec []byte
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/config"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+)
+
+const usage = `Usage: keys [-config file] <command> [flags]
+
+Commands:
+  list                           list the signing keys and which one signs
+  rotate [-alg A] [-delay D]     add a key that starts signing after D
+                                 (default: twice jwt.key_refresh_interval)
+  retire [-force] <id>           delete a key that no longer signs
+`
+
+func main() {
+	configPath := flag.String("config", "", "path to a YAML or TOML config file (defaults to $CONFIG_FILE)")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	cfg, err := config.Read(*configPath)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := errors.Join(cfg.ValidateMongo(), cfg.ValidateSigningKeys()); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.ConnectTimeout)
+	defer cancel()
+
+	client, err := database.Connect(ctx, cfg.Mongo.URI)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	store := database.NewSigningKeyRepository(client, cfg.Mongo.Database)
+
+	switch command {
+	case "list":
+		keys, err := store.FindAll(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to list keys: %v", err)
+		}
+		if len(keys) == 0 {
+			log.Println("No signing keys; tokens are signed with jwt.secret.")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tALGORITHM\tCREATED\tENCRYPTED\tSTATE")
+		for i, key := range keys {
+			encrypted := "no"
+			if key.Sealed {
+				encrypted = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", key.ID, key.Algorithm,
+				key.CreatedAt.Local().Format("2006-01-02 15:04:05"), encrypted, state(keys, i, time.Now()))
+		}
+		w.Flush()
+
+	case "rotate":
+		fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+		alg := fs.String("alg", auth.AlgRS256, "algorithm: "+strings.Join(auth.Algorithms, ", "))
+		delay := fs.Duration("delay", 2*cfg.JWT.KeyRefreshInterval, "how long until the key starts signing")
+		fs.Parse(args)
+
+		// Every replica has to have loaded the key before any of them
+		// signs with it, or the others would reject its tokens.
+		if *delay < cfg.JWT.KeyRefreshInterval {
+			log.Printf("Warning: -delay is shorter than jwt.key_refresh_interval (%s); replicas that have not reloaded the keys will reject tokens signed with the new key.",
+				cfg.JWT.KeyRefreshInterval)
+		}
+		key, err := auth.NewSigningKey(*alg, time.Now().Add(*delay))
+		if err != nil {
+			log.Fatalf("Failed to generate key: %v", err)
+		}
+		// The key was checked by config validation.
+		if encryptionKey, _ := cfg.JWT.EncryptionKey(); encryptionKey != nil {
+			if err := auth.SealSigningKey(key, encryptionKey); err != nil {
+				log.Fatalf("Failed to encrypt key: %v", err)
+			}
+		} else {
+			log.Println("Warning: jwt.key_encryption_key is not set; the private key is stored unencrypted.")
+		}
+		if err := store.Create(context.Background(), key); err != nil {
+			log.Fatalf("Failed to store key: %v", err)
+		}
+		log.Printf("Added %s key %s; it starts signing at %s.", key.Algorithm, key.ID,
+			key.ActivatesAt.Local().Format("2006-01-02 15:04:05"))
+		log.Printf("Retire the previous key once it has not signed for the access-token expiry, jwt.expiry (%s); refresh tokens are not signed and do not need the key.", cfg.JWT.Expiry)
+
+	case "retire":
+		fs := flag.NewFlagSet("retire", flag.ExitOnError)
+		force := fs.Bool("force", false, "retire even if access tokens signed with the key may not have expired (jwt.expiry); refresh tokens do not depend on keys")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		id := fs.Arg(0)
+
+		keys, err := store.FindAll(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to list keys: %v", err)
+		}
+		i := slices.IndexFunc(keys, func(k models.SigningKey) bool { return k.ID == id })
+		if i < 0 {
+			log.Fatalf("No signing key %s.", id)
+		}
+		// A key signs until the next one activates, and its access tokens
+		// are good for jwt.expiry after that. Refresh tokens are opaque, so
+		// sessions outlive the key.
+		now := time.Now()
+		switch {
+		case i == len(keys)-1 || keys[i+1].ActivatesAt.After(now):
+			if !keys[i].ActivatesAt.After(now) {
+				log.Fatalf("Key %s is signing; rotate to a new key first.", id)
+			}
+		case keys[i+1].ActivatesAt.Add(cfg.JWT.Expiry).After(now) && !*force:
+			log.Fatalf("Access tokens signed with key %s are valid until %s (jwt.expiry), and retiring it now makes their sessions renew early; use -force to retire it anyway.",
+				id, keys[i+1].ActivatesAt.Add(cfg.JWT.Expiry).Local().Format("2006-01-02 15:04:05"))
+		}
+		if err := store.Delete(context.Background(), id); err != nil {
+			log.Fatalf("Failed to retire key: %v", err)
+		}
+		log.Printf("Retired key %s.", id)
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// state describes keys[i]: whether it signs now, will sign, or has been
+// replaced and only verifies.
+func state(keys []models.SigningKey, i int, now time.Time) string {
+	if keys[i].ActivatesAt.After(now) {
+		return "signs from " + keys[i].ActivatesAt.Local().Format("2006-01-02 15:04:05")
+	}
+	if i+1 < len(keys) && !keys[i+1].ActivatesAt.After(now) {
+		return "verifies only, replaced " + keys[i+1].ActivatesAt.Local().Format("2006-01-02 15:04:05")
+	}
+	return "signing"
+}
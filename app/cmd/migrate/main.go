@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/config"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/migrations"
+)
+
+const usage = `Usage: migrate [-config file] <command> [flags]
+
+Commands:
+  up [-steps N]               apply pending migrations (all by default)
+  down [-steps N]             revert the last N applied migrations (default 1)
+  status                      list migrations and when they were applied
+  create [-dir D] <name...>   write a new numbered migration file
+`
+
+func main() {
+	configPath := flag.String("config", "", "path to a YAML or TOML config file (defaults to $CONFIG_FILE)")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	// create only writes a source file and needs no database.
+	if command == "create" {
+		if err := create(args); err != nil {
+			log.Fatalf("Failed to create migration: %v", err)
+		}
+		return
+	}
+
+	cfg, err := config.Read(*configPath)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := cfg.ValidateMongo(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.ConnectTimeout)
+	defer cancel()
+
+	client, err := database.Connect(ctx, cfg.Mongo.URI)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	migrator := migrations.New(client, cfg.Mongo.Database, cfg.Migrations.LockTimeout)
+
+	switch command {
+	case "up":
+		fs := flag.NewFlagSet("up", flag.ExitOnError)
+		steps := fs.Int("steps", 0, "number of migrations to apply (0 = all)")
+		fs.Parse(args)
+
+		applied, err := migrator.Up(context.Background(), *steps)
+		for _, m := range applied {
+			log.Printf("Applied %04d %s", m.Version, m.Description)
+		}
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		if len(applied) == 0 {
+			log.Println("No pending migrations.")
+		}
+
+	case "down":
+		fs := flag.NewFlagSet("down", flag.ExitOnError)
+		steps := fs.Int("steps", 1, "number of migrations to revert")
+		fs.Parse(args)
+
+		reverted, err := migrator.Down(context.Background(), *steps)
+		for _, m := range reverted {
+			log.Printf("Reverted %04d %s", m.Version, m.Description)
+		}
+		if err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		if len(reverted) == 0 {
+			log.Println("No applied migrations.")
+		}
+
+	case "status":
+		statuses, err := migrator.Status(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			if s.Unknown {
+				applied += " (unknown to this binary)"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Description, applied)
+		}
+		w.Flush()
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+var migrationFile = regexp.MustCompile(`^(\d{4})_.*\.go$`)
+
+const migrationTemplate = `package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register(Migration{
+		Version:     %d,
+		Description: %q,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return nil
+		},
+	})
+}
+`
+
+// create writes NNNN_name.go into the migrations package, numbered one past
+// the highest existing file.
+func create(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	dir := fs.String("dir", filepath.Join("internal", "migrations"), "migrations package directory")
+	fs.Parse(args)
+
+	description := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if description == "" {
+		return fmt.Errorf("a migration name is required")
+	}
+
+	entries, err := os.ReadDir(*dir)
+	if err != nil {
+		return err
+	}
+	next := 1
+	for _, e := range entries {
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if match == nil {
+			continue
+		}
+		if v, _ := strconv.Atoi(match[1]); v >= next {
+			next = v + 1
+		}
+	}
+
+	slug := strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(description), "_"), "_")
+	path := filepath.Join(*dir, fmt.Sprintf("%04d_%s.go", next, slug))
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, migrationTemplate, next, description); err != nil {
+		return err
+	}
+
+	fmt.Println("Created", path)
+	return nil
+}
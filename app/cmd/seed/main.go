@@ -2,30 +2,52 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
-	"os"
-	"time"
 
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/config"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
 )
 
 func main() {
-	mongoURI := getEnv("MONGODB_URI", "mongodb://localhost:27017")
-	dbName := getEnv("MONGODB_DATABASE", "tasksdb")
+	configPath := flag.String("config", "", "path to a YAML or TOML config file (defaults to $CONFIG_FILE)")
+	flag.Parse()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	cfg, err := config.Read(*configPath)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := cfg.ValidateMongo(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	// The admin password has to meet the same policy as users' own.
+	passwords, err := auth.NewPasswordPolicy(auth.PasswordPolicyConfig{
+		MinLength:      cfg.Password.MinLength,
+		MaxLength:      cfg.Password.MaxLength,
+		RejectCommon:   cfg.Password.RejectCommon,
+		CommonListFile: cfg.Password.CommonListFile,
+		RejectPersonal: cfg.Password.RejectPersonal,
+	})
+	if err != nil {
+		log.Fatalf("Failed to load password policy: %v", err)
+	}
+	if err := cfg.ValidateAdmin(passwords.Validate); err != nil {
+		log.Fatalf("Invalid admin configuration: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.ConnectTimeout)
 	defer cancel()
 
-	client, err := database.Connect(ctx, mongoURI)
+	client, err := database.Connect(ctx, cfg.Mongo.URI)
 	if err != nil {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
 	defer client.Disconnect(context.Background())
 
-	userRepo := database.NewUserRepository(client, dbName)
+	userRepo := database.NewUserRepository(client, cfg.Mongo.Database)
 
 	// Check if admin already exists
 	count, err := userRepo.Count(context.Background())
@@ -39,9 +61,9 @@ func main() {
 	}
 
 	// Create admin user
-	email := getEnv("ADMIN_EMAIL", "admin@example.com")
-	password := getEnv("ADMIN_PASSWORD", "admin123")
-	name := getEnv("ADMIN_NAME", "Admin User")
+	email := cfg.Admin.Email
+	password := cfg.Admin.Password
+	name := cfg.Admin.Name
 
 	hashedPassword, err := auth.HashPassword(password)
 	if err != nil {
@@ -67,15 +89,12 @@ func main() {
 	fmt.Println("Admin user created successfully!")
 	fmt.Println("===========================================")
 	fmt.Printf("Email: %s\n", email)
-	fmt.Printf("Password: %s\n", password)
+	if cfg.DevMode {
+		fmt.Printf("Password: %s\n", password)
+	} else {
+		fmt.Println("Password: (as configured in ADMIN_PASSWORD)")
+	}
 	fmt.Println("===========================================")
 	fmt.Println("Please change the password after first login.")
 	fmt.Println("===========================================")
 }
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
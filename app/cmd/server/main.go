@@ -2,205 +2,364 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/signal"
 	"time"
 
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/account"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/config"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/flash"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/handlers"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/health"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/middleware"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/migrations"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/server"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/sso"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/tracing"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/trash"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/twofactor"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/version"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func main() {
-	mongoURI := getEnv("MONGODB_URI", "mongodb://localhost:27017")
-	dbName := getEnv("MONGODB_DATABASE", "tasksdb")
-	port := getEnv("PORT", "8080")
-	jwtSecret := getEnv("JWT_SECRET", "default-secret-change-in-production")
-	jwtExpiryStr := getEnv("JWT_EXPIRY", "24h")
+	configPath := flag.String("config", "", "path to a YAML or TOML config file (defaults to $CONFIG_FILE)")
+	flag.Parse()
 
-	jwtExpiry, err := time.ParseDuration(jwtExpiryStr)
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Printf("Invalid JWT_EXPIRY, using default 24h: %v", err)
-		jwtExpiry = 24 * time.Hour
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	logger, err := logging.New(os.Stdout, cfg.Logging.Format, cfg.Logging.Level)
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	if cfg.DevMode {
+		logger.Warn("DEV_MODE is enabled; insecure defaults are allowed")
+	}
+	logger.Info("starting", "version", version.Version, "commit", version.Commit, "build_date", version.BuildDate)
+	logger.Info("effective configuration", "config", cfg)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:       cfg.Tracing.Exporter,
+		Endpoint:       cfg.Tracing.Endpoint,
+		Insecure:       cfg.Tracing.Insecure,
+		SampleRatio:    cfg.Tracing.SampleRatio,
+		ServiceName:    cfg.Tracing.ServiceName,
+		ServiceVersion: version.Version,
+	})
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.ConnectTimeout)
 	defer cancel()
 
-	client, err := database.Connect(ctx, mongoURI)
+	client, err := database.Connect(ctx, cfg.Mongo.URI)
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		logger.Error("failed to connect to MongoDB", "error", err)
+		os.Exit(1)
 	}
-	defer func() {
-		if err := client.Disconnect(context.Background()); err != nil {
-			log.Printf("Error disconnecting from MongoDB: %v", err)
-		}
-	}()
 
-	log.Println("Connected to MongoDB")
+	logger.Info("connected to MongoDB", "database", cfg.Mongo.Database)
 
 	// Initialize repositories
-	taskRepo := database.NewTaskRepository(client, dbName)
-	userRepo := database.NewUserRepository(client, dbName)
-	inviteRepo := database.NewInviteRepository(client, dbName)
+	taskRepo := database.NewTaskRepository(client, cfg.Mongo.Database)
+	userRepo := database.NewUserRepository(client, cfg.Mongo.Database)
+	inviteRepo := database.NewInviteRepository(client, cfg.Mongo.Database)
+	idempotencyRepo := database.NewIdempotencyRepository(client, cfg.Mongo.Database)
+
+	checker := health.NewChecker(cfg.Server.HealthCheckTimeout)
+	checker.AddCheck("mongo", func(ctx context.Context) error {
+		return client.Ping(ctx, nil)
+	})
 
-	// Create indexes
-	if err := userRepo.CreateIndexes(context.Background()); err != nil {
-		log.Printf("Warning: Failed to create user indexes: %v", err)
+	// Schema migrations. With auto-apply, one replica applies them under
+	// the cluster-wide lock while the others wait for it.
+	migrator := migrations.New(client, cfg.Mongo.Database, cfg.Migrations.LockTimeout)
+	var upErr error
+	if cfg.Migrations.AutoApply {
+		var applied []migrations.Migration
+		applied, upErr = migrator.Up(context.Background(), 0)
+		for _, m := range applied {
+			logger.Info("applied migration", "version", m.Version, "description", m.Description)
+		}
+		if upErr != nil {
+			logger.Error("failed to apply migrations", "error", upErr)
+		}
+	}
+	pending, err := migrator.Pending(context.Background())
+	switch {
+	case err != nil:
+		logger.Error("failed to check migrations", "error", err)
+		checker.SetMigrationStatus("unknown: " + err.Error())
+	case upErr != nil:
+		logger.Warn("migrations pending", "count", len(pending))
+		checker.SetMigrationStatus(fmt.Sprintf("%s: %v (%d pending)", health.StatusFailed, upErr, len(pending)))
+	case len(pending) > 0:
+		logger.Warn("migrations pending", "count", len(pending), "next", pending[0].Version)
+		checker.SetMigrationStatus(fmt.Sprintf("%d pending", len(pending)))
+	default:
+		checker.SetMigrationStatus("current")
 	}
-	if err := inviteRepo.CreateIndexes(context.Background()); err != nil {
-		log.Printf("Warning: Failed to create invite indexes: %v", err)
+	if cfg.Migrations.RequireCurrent && (err != nil || len(pending) > 0) {
+		logger.Error("refusing to start until migrations are applied; run `migrate up`")
+		os.Exit(1)
 	}
 
-	// Initialize auth config
+	// Session tokens are signed with the stored keys, reloaded by a worker
+	// so keys added by the keys command reach every replica. The key
+	// encryption key was checked by config validation.
+	signingKeyRepo := database.NewSigningKeyRepository(client, cfg.Mongo.Database)
+	keyEncryptionKey, _ := cfg.JWT.EncryptionKey()
+	keys := auth.NewKeyset(signingKeyRepo, cfg.JWT.Secret, cfg.JWT.VerifyLegacy, keyEncryptionKey)
+	if err := keys.Load(context.Background()); err != nil {
+		logger.Error("failed to load signing keys", "error", err)
+		os.Exit(1)
+	}
+
+	// The key was checked by config validation.
+	twoFactorKey, _ := cfg.TwoFactor.Key()
+	settingsRepo := database.NewSettingsRepository(client, cfg.Mongo.Database)
+	twoFactor, err := twofactor.NewService(userRepo, settingsRepo, twoFactorKey, cfg.TwoFactor.Issuer, cfg.TwoFactor.RequireForAdmins)
+	if err != nil {
+		logger.Error("failed to set up two-factor authentication", "error", err)
+		os.Exit(1)
+	}
+
+	// Access tokens are short-lived; RequireAuth renews them with the
+	// refresh tokens stored here.
+	refreshTokenRepo := database.NewRefreshTokenRepository(client, cfg.Mongo.Database)
 	authConfig := &auth.Config{
-		JWTSecret: jwtSecret,
+		JWTSecret: cfg.JWT.Secret,
+		Keys:      keys,
+		Sessions: auth.NewSessions(userRepo, refreshTokenRepo, keys, auth.SessionConfig{
+			AccessExpiry:   cfg.JWT.Expiry,
+			RefreshExpiry:  cfg.JWT.RefreshExpiry,
+			RememberExpiry: cfg.JWT.RememberExpiry,
+			TwoFactor:      twoFactor,
+		}),
+	}
+
+	var singleSignOn *sso.Service
+	if cfg.SSO.Enabled() {
+		singleSignOn = sso.NewService(userRepo, sso.Config{
+			IssuerURL:      cfg.SSO.IssuerURL,
+			ClientID:       cfg.SSO.ClientID,
+			ClientSecret:   cfg.SSO.ClientSecret,
+			RedirectURL:    cfg.SSO.RedirectURL,
+			AllowedDomains: cfg.SSO.AllowedDomains,
+			ButtonLabel:    cfg.SSO.ButtonLabel,
+		}, cfg.JWT.Secret)
+	}
+
+	passwords, err := auth.NewPasswordPolicy(auth.PasswordPolicyConfig{
+		MinLength:      cfg.Password.MinLength,
+		MaxLength:      cfg.Password.MaxLength,
+		RejectCommon:   cfg.Password.RejectCommon,
+		CommonListFile: cfg.Password.CommonListFile,
+		RejectPersonal: cfg.Password.RejectPersonal,
+	})
+	if err != nil {
+		logger.Error("failed to load password policy", "error", err)
+		os.Exit(1)
+	}
+
+	// CORS is only enabled for the JSON API; pages stay same-origin
+	apiCORS := middleware.CORS(&middleware.CORSPolicy{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		ExposedHeaders:   cfg.CORS.ExposedHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           cfg.CORS.MaxAge,
+	})
+
+	securityConfig := &middleware.SecurityConfig{
+		ContentSecurityPolicy: cfg.Security.ContentSecurityPolicy,
+		HSTSMaxAge:            cfg.Security.HSTSMaxAge,
+		HSTSIncludeSubdomains: cfg.Security.HSTSIncludeSubdomains,
+		HSTSPreload:           cfg.Security.HSTSPreload,
+		FrameOptions:          cfg.Security.FrameOptions,
+		ReferrerPolicy:        cfg.Security.ReferrerPolicy,
+		PermissionsPolicy:     cfg.Security.PermissionsPolicy,
 	}
 
 	// Initialize handlers
 	taskHandler := handlers.NewTaskHandler(taskRepo)
-	authHandler := handlers.NewAuthHandler(userRepo, inviteRepo, authConfig, jwtExpiry)
-	pageHandler := handlers.NewPageHandler(taskRepo, userRepo, inviteRepo)
+	authHandler := handlers.NewAuthHandler(userRepo, inviteRepo, twoFactor, singleSignOn, passwords, authConfig)
+	pageHandler := handlers.NewPageHandler(taskRepo, userRepo, inviteRepo, cfg.Trash.Retention)
+	accounts := account.NewService(userRepo, taskRepo, inviteRepo, idempotencyRepo, refreshTokenRepo, cfg.Accounts.DeletionGrace)
+	accountHandler := handlers.NewAccountHandler(accounts, userRepo, authConfig)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactor, userRepo, authConfig)
+	idempotency := handlers.NewIdempotency(idempotencyRepo, cfg.Server.IdempotencyKeyTTL)
 
 	mux := http.NewServeMux()
 
 	// Static files
 	fs := http.FileServer(http.Dir("web/static"))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	mux.Handle("GET /static/", http.StripPrefix("/static/", fs))
+
+	requireAuth := auth.RequireAuth(authConfig)
+	// RequireAuth runs first so RequireAdmin can see the claims.
+	requireAdmin := func(h http.Handler) http.Handler {
+		return requireAuth(auth.RequireAdmin(authConfig)(h))
+	}
 
 	// Public routes
-	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			pageHandler.ShowLogin(w, r)
-		} else if r.Method == http.MethodPost {
-			authHandler.HandleLogin(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-	mux.HandleFunc("/logout", authHandler.HandleLogout)
-	mux.HandleFunc("/register/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			pageHandler.ShowRegister(w, r)
-		} else if r.Method == http.MethodPost {
-			authHandler.HandleRegister(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	mux.HandleFunc("GET /login", authHandler.ShowLogin)
+	mux.HandleFunc("POST /login", authHandler.HandleLogin)
+	mux.HandleFunc("GET /login/2fa", authHandler.ShowLoginTwoFactor)
+	mux.HandleFunc("POST /login/2fa", authHandler.HandleLoginTwoFactor)
+	mux.HandleFunc("POST /login/2fa/setup", authHandler.SetupLoginTwoFactor)
+	mux.HandleFunc("POST /login/2fa/confirm", authHandler.ConfirmLoginTwoFactor)
+	mux.HandleFunc("GET /login/sso", authHandler.StartSSO)
+	mux.HandleFunc("GET /login/sso/callback", authHandler.HandleSSOCallback)
+	mux.HandleFunc("POST /logout", authHandler.HandleLogout)
+	mux.HandleFunc("GET /register/{token}", authHandler.ShowRegister)
+	mux.HandleFunc("POST /register/{token}", authHandler.HandleRegister)
 
 	// Auth form handler
-	mux.Handle("/api/login", http.HandlerFunc(authHandler.HandleLogin))
+	mux.HandleFunc("POST /api/login", authHandler.HandleLogin)
 
 	// Protected page routes
-	mux.Handle("/", auth.RequireAuth(authConfig)(http.HandlerFunc(pageHandler.ShowDashboard)))
-	mux.Handle("/tasks/new", auth.RequireAuth(authConfig)(http.HandlerFunc(pageHandler.ShowTaskForm)))
-	mux.Handle("/tasks/", auth.RequireAuth(authConfig)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/tasks/" {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
-		}
-		if r.Method == http.MethodGet && hasEditSuffix(r.URL.Path) {
-			pageHandler.ShowEditForm(w, r)
-		} else if r.Method == http.MethodPost && hasDeleteSuffix(r.URL.Path) {
-			pageHandler.DeleteTask(w, r)
-		} else if r.Method == http.MethodPost {
-			pageHandler.UpdateTask(w, r)
-		} else {
-			http.Error(w, "Not found", http.StatusNotFound)
-		}
-	})))
-	mux.Handle("/tasks", auth.RequireAuth(authConfig)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			pageHandler.CreateTask(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})))
+	mux.Handle("GET /{$}", requireAuth(http.HandlerFunc(pageHandler.ShowDashboard)))
+	mux.Handle("GET /tasks/new", requireAuth(http.HandlerFunc(pageHandler.ShowTaskForm)))
+	mux.Handle("POST /tasks", requireAuth(http.HandlerFunc(pageHandler.CreateTask)))
+	mux.Handle("GET /tasks/{id}", requireAuth(http.HandlerFunc(pageHandler.ShowTask)))
+	mux.Handle("GET /tasks/{id}/edit", requireAuth(http.HandlerFunc(pageHandler.ShowEditForm)))
+	mux.Handle("POST /tasks/{id}", requireAuth(http.HandlerFunc(pageHandler.UpdateTask)))
+	mux.Handle("POST /tasks/{id}/delete", requireAuth(http.HandlerFunc(pageHandler.DeleteTask)))
+	mux.Handle("POST /tasks/{id}/revisions/{version}/revert", requireAuth(http.HandlerFunc(pageHandler.RevertTask)))
+	mux.Handle("POST /tasks/bulk", requireAuth(http.HandlerFunc(pageHandler.BulkTasks)))
+	mux.Handle("GET /tasks/export", requireAuth(http.HandlerFunc(pageHandler.ExportTasks)))
+	mux.Handle("GET /tasks/import", requireAuth(http.HandlerFunc(pageHandler.ShowImport)))
+	mux.Handle("POST /tasks/import/preview", requireAuth(http.HandlerFunc(pageHandler.PreviewImport)))
+	mux.Handle("POST /tasks/import", requireAuth(idempotency.Form(http.HandlerFunc(pageHandler.ImportTasks))))
+	mux.Handle("GET /trash", requireAuth(http.HandlerFunc(pageHandler.ShowTrash)))
+	mux.Handle("POST /trash/{id}/restore", requireAuth(http.HandlerFunc(pageHandler.RestoreTask)))
+	mux.Handle("POST /trash/{id}/delete", requireAuth(http.HandlerFunc(pageHandler.PurgeTask)))
+	mux.Handle("GET /account", requireAuth(http.HandlerFunc(accountHandler.ShowAccount)))
+	mux.Handle("GET /account/export", requireAuth(http.HandlerFunc(accountHandler.ExportAccount)))
+	mux.Handle("POST /account/delete", requireAuth(http.HandlerFunc(accountHandler.DeleteAccount)))
+	mux.Handle("POST /account/cancel-deletion", requireAuth(http.HandlerFunc(accountHandler.CancelAccountDeletion)))
+	mux.Handle("GET /account/reauth", requireAuth(http.HandlerFunc(authHandler.StartReauth)))
+	mux.Handle("GET /account/2fa", requireAuth(http.HandlerFunc(twoFactorHandler.ShowTwoFactor)))
+	mux.Handle("POST /account/2fa/setup", requireAuth(http.HandlerFunc(twoFactorHandler.SetupTwoFactor)))
+	mux.Handle("POST /account/2fa/confirm", requireAuth(http.HandlerFunc(twoFactorHandler.ConfirmTwoFactor)))
+	mux.Handle("POST /account/2fa/recovery-codes", requireAuth(http.HandlerFunc(twoFactorHandler.RegenerateRecoveryCodes)))
+	mux.Handle("POST /account/2fa/disable", requireAuth(http.HandlerFunc(twoFactorHandler.DisableTwoFactor)))
 
 	// Admin routes
-	adminMux := http.NewServeMux()
-	adminMux.HandleFunc("/admin/invites", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			pageHandler.ShowInvites(w, r)
-		} else if r.Method == http.MethodPost {
-			pageHandler.CreateInvite(w, r)
+	mux.Handle("GET /admin/invites", requireAdmin(http.HandlerFunc(pageHandler.ShowInvites)))
+	mux.Handle("POST /admin/invites", requireAdmin(idempotency.Form(http.HandlerFunc(pageHandler.CreateInvite))))
+	mux.Handle("GET /admin/users", requireAdmin(http.HandlerFunc(accountHandler.ShowUsers)))
+	mux.Handle("GET /admin/users/{id}/export", requireAdmin(http.HandlerFunc(accountHandler.ExportUser)))
+	mux.Handle("POST /admin/users/{id}/delete", requireAdmin(http.HandlerFunc(accountHandler.DeleteUser)))
+	mux.Handle("POST /admin/users/{id}/cancel-deletion", requireAdmin(http.HandlerFunc(accountHandler.CancelUserDeletion)))
+	mux.Handle("GET /admin/2fa", requireAdmin(http.HandlerFunc(twoFactorHandler.ShowAdminTwoFactor)))
+	mux.Handle("POST /admin/2fa", requireAdmin(http.HandlerFunc(twoFactorHandler.SetAdminTwoFactor)))
+
+	// Other methods on page routes get the HTML 405 page and unknown paths
+	// the 404 page, instead of falling through to the dashboard.
+	for _, path := range []string{
+		"/login", "/login/2fa", "/login/2fa/setup", "/login/2fa/confirm",
+		"/login/sso", "/login/sso/callback",
+		"/logout", "/register/{token}", "/tasks",
+		"/tasks/{id}", "/tasks/{id}/edit", "/tasks/{id}/delete",
+		"/tasks/{id}/revisions/{version}/revert", "/tasks/import/preview",
+		"/trash", "/trash/{id}/restore", "/trash/{id}/delete", "/account",
+		"/account/export", "/account/delete", "/account/cancel-deletion", "/account/reauth",
+		"/account/2fa", "/account/2fa/setup", "/account/2fa/confirm",
+		"/account/2fa/recovery-codes", "/account/2fa/disable",
+		"/admin/invites", "/admin/users", "/admin/users/{id}/export",
+		"/admin/users/{id}/delete", "/admin/users/{id}/cancel-deletion",
+		"/admin/2fa",
+	} {
+		mux.HandleFunc(path, handlers.MethodNotAllowed)
+	}
+	mux.HandleFunc("/", handlers.NotFound)
+
+	// JSON API (protected). The OpenAPI document is public.
+	mux.Handle("GET "+handlers.APIPrefix+"/openapi.json", apiCORS(http.HandlerFunc(handlers.OpenAPISpec)))
+	mux.Handle("/api/", apiCORS(requireAuth(handlers.NewAPIRouter(taskHandler, idempotency))))
+
+	// Health checks and build metadata. /health is kept as an alias of
+	// /livez for existing probes.
+	mux.HandleFunc("/livez", checker.LivenessHandler)
+	mux.HandleFunc("/health", checker.LivenessHandler)
+	mux.HandleFunc("/readyz", checker.ReadinessHandler)
+	mux.HandleFunc("/version", version.Handler)
+
+	// Public keys for other services to verify session tokens with.
+	mux.HandleFunc("GET /.well-known/jwks.json", keys.JWKSHandler)
+
+	// Metrics
+	var metricsServer *http.Server
+	if cfg.Metrics.Enabled {
+		metrics.RegisterActiveInvites(inviteRepo.CountActive)
+
+		if cfg.Metrics.Addr != "" {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle("/metrics", metrics.Handler(cfg.Metrics.Token))
+			metricsServer = &http.Server{
+				Addr:              cfg.Metrics.Addr,
+				Handler:           metricsMux,
+				ReadHeaderTimeout: 5 * time.Second,
+			}
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			mux.Handle("/metrics", metrics.Handler(cfg.Metrics.Token))
 		}
-	})
-	mux.Handle("/admin/", auth.RequireAdmin(authConfig)(auth.RequireAuth(authConfig)(adminMux)))
-
-	// API routes (protected)
-	mux.Handle("/api/tasks", auth.RequireAuth(authConfig)(http.HandlerFunc(taskHandler.HandleTasks)))
-	mux.Handle("/api/tasks/", auth.RequireAuth(authConfig)(http.HandlerFunc(taskHandler.HandleTasks)))
-
-	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"healthy"}`))
-	})
-
-	server := &http.Server{
-		Addr:         ":" + port,
-		Handler:      corsMiddleware(mux),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
 	}
 
-	go func() {
-		log.Printf("Server starting on port %s", port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
-		}
-	}()
-
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
-
-	log.Println("Shutting down server...")
-
-	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	// Middleware chain, outermost first. Metrics and TraceRoute wrap the mux
+	// directly so they can read the matched route pattern.
+	var handler http.Handler = middleware.TraceRoute(mux)
+	handler = middleware.Metrics(handler)
+	handler = flash.NewStore(cfg.JWT.Secret).Middleware(handler)
+	handler = middleware.SecurityHeaders(securityConfig)(handler)
+	handler = middleware.RequestLogger(logger)(handler)
+	handler = otelhttp.NewHandler(handler, "http.server")
+
+	httpServer := &http.Server{
+		Addr:         ":" + cfg.Server.Port,
+		Handler:      handler,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 
-	log.Println("Server exited")
-}
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	// Shutdown order: drain, stop HTTP servers, stop workers, then release
+	// MongoDB and flush any spans recorded along the way.
+	srv := server.New(logger, checker, cfg.Server.DrainPeriod, cfg.Server.ShutdownTimeout)
+	srv.AddHTTPServer("http", httpServer, nil)
+	if metricsServer != nil {
+		srv.AddHTTPServer("metrics", metricsServer, nil)
+	}
+	srv.AddWorker("trash-purge", trash.Purger(taskRepo, cfg.Trash.Retention, cfg.Trash.PurgeInterval, logger))
+	srv.AddWorker("account-purge", account.Purger(accounts, cfg.Accounts.PurgeInterval, logger))
+	srv.AddWorker("signing-keys", auth.KeyRefresher(keys, cfg.JWT.KeyRefreshInterval, logger))
+	srv.AddCloser("mongo", client.Disconnect)
+	srv.AddCloser("tracing", shutdownTracing)
+
+	ctx, stop := server.SignalContext(context.Background())
+	defer stop()
+
+	if err := srv.Run(ctx); err != nil {
+		logger.Error("server failed", "error", err)
+		os.Exit(1)
 	}
-	return defaultValue
-}
-
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-func hasEditSuffix(path string) bool {
-	return len(path) > 5 && path[len(path)-5:] == "/edit"
-}
-
-func hasDeleteSuffix(path string) bool {
-	return len(path) > 7 && path[len(path)-7:] == "/delete"
 }
@@ -0,0 +1,169 @@
+// Package account handles data subject requests: exporting everything
+// stored about a user, and deleting an account with all its data after a
+// grace period.
+package account
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/taskio"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Service exports and deletes accounts across every store.
+type Service struct {
+	users       database.UserStore
+	tasks       database.TaskStore
+	invites     database.InviteStore
+	idempotency database.IdempotencyStore
+	sessions    database.RefreshTokenStore
+	grace       time.Duration
+}
+
+// NewService returns a Service that deletes accounts grace after the
+// deletion is requested.
+func NewService(users database.UserStore, tasks database.TaskStore, invites database.InviteStore, idempotency database.IdempotencyStore, sessions database.RefreshTokenStore, grace time.Duration) *Service {
+	return &Service{
+		users:       users,
+		tasks:       tasks,
+		invites:     invites,
+		idempotency: idempotency,
+		sessions:    sessions,
+		grace:       grace,
+	}
+}
+
+// Grace is how long after a deletion request an account is deleted.
+func (s *Service) Grace() time.Duration {
+	return s.grace
+}
+
+// exportReadme describes the files of an export.
+const exportReadme = `This archive holds all data stored about your account.
+
+profile.json         Your account. Your password hash, two-factor secret and
+                     recovery codes are not included.
+tasks.json           Your tasks, including those in the trash.
+task_revisions.json  Every change made to your tasks, with who made it and
+                     when. The app keeps no other audit log.
+invites.json         The invites you created.
+`
+
+// Export writes a zip archive of everything stored about the user.
+// Temporary records kept for Idempotency-Key replays are left out; they
+// expire within a day.
+func (s *Service) Export(ctx context.Context, w io.Writer, user *models.User) error {
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+	}
+	writeJSON := func(name string, v any) error {
+		f, err := create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	f, err := create("README.txt")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, exportReadme); err != nil {
+		return err
+	}
+	if err := writeJSON("profile.json", user); err != nil {
+		return err
+	}
+
+	// Active tasks are streamed; the trash is small enough to list.
+	f, err = create("tasks.json")
+	if err != nil {
+		return err
+	}
+	tw := taskio.NewWriter(f, taskio.JSON)
+	if err := s.tasks.EachByUserID(ctx, user.ID, tw.Write); err != nil {
+		return err
+	}
+	trashed, err := s.tasks.FindDeletedByUserID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for i := range trashed {
+		if err := tw.Write(&trashed[i]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	revisions, err := s.tasks.FindAllRevisionsByUserID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("task_revisions.json", revisions); err != nil {
+		return err
+	}
+	invites, err := s.invites.FindByInviter(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("invites.json", invites); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ScheduleDeletion schedules the account's deletion after the grace
+// period and returns when it will happen. The user's sessions end, on
+// every device, once their access tokens expire.
+func (s *Service) ScheduleDeletion(ctx context.Context, userID primitive.ObjectID) (time.Time, error) {
+	at := time.Now().Add(s.grace)
+	if err := s.users.ScheduleDeletion(ctx, userID, at); err != nil {
+		return time.Time{}, err
+	}
+	if err := s.sessions.DeleteByUserID(ctx, userID); err != nil {
+		return time.Time{}, fmt.Errorf("end sessions: %w", err)
+	}
+	return at, nil
+}
+
+// CancelDeletion keeps the account. It fails with database.ErrUserNotFound
+// once the grace period is over.
+func (s *Service) CancelDeletion(ctx context.Context, userID primitive.ObjectID) error {
+	return s.users.CancelDeletion(ctx, userID)
+}
+
+// Delete removes the user and everything they own. The user goes last, so
+// a deletion that fails part way is retried by the Purger.
+func (s *Service) Delete(ctx context.Context, user *models.User) error {
+	if err := s.sessions.DeleteByUserID(ctx, user.ID); err != nil {
+		return fmt.Errorf("delete sessions: %w", err)
+	}
+	if err := s.idempotency.DeleteByUserID(ctx, user.ID); err != nil {
+		return fmt.Errorf("delete idempotency records: %w", err)
+	}
+	if _, err := s.tasks.PurgeAllByUserID(ctx, user.ID); err != nil {
+		return fmt.Errorf("delete tasks: %w", err)
+	}
+	if _, err := s.invites.DeleteByInviterOrEmail(ctx, user.ID, user.Email); err != nil {
+		return fmt.Errorf("delete invites: %w", err)
+	}
+	// Another replica may have finished the same deletion.
+	if err := s.users.Delete(ctx, user.ID); err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	return nil
+}
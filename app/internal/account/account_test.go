@@ -0,0 +1,246 @@
+package account
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+)
+
+type fixture struct {
+	service     *Service
+	users       *memory.UserRepository
+	tasks       *memory.TaskRepository
+	invites     *memory.InviteRepository
+	idempotency *memory.IdempotencyRepository
+	sessions    *memory.RefreshTokenRepository
+	user, other *models.User
+}
+
+// newFixture creates two users, each with an edited task, a trashed task,
+// a sent invite, an idempotency record and a session. The first user was invited by
+// the second.
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	ctx := t.Context()
+	f := &fixture{
+		users:       memory.NewUserRepository(),
+		tasks:       memory.NewTaskRepository(),
+		invites:     memory.NewInviteRepository(),
+		idempotency: memory.NewIdempotencyRepository(),
+		sessions:    memory.NewRefreshTokenRepository(),
+	}
+	f.service = NewService(f.users, f.tasks, f.invites, f.idempotency, f.sessions, time.Hour)
+
+	for _, email := range []string{"ada@example.com", "bob@example.com"} {
+		user := &models.User{Email: email, Name: "U", PasswordHash: "secret-hash", Role: models.RoleUser}
+		if err := f.users.Create(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		for _, title := range []string{"kept", "trashed"} {
+			task := &models.Task{UserID: user.ID, Title: title, Status: models.StatusPending}
+			if err := f.tasks.Create(ctx, task); err != nil {
+				t.Fatal(err)
+			}
+			if title == "trashed" {
+				if err := f.tasks.DeleteByUserID(ctx, task.ID.Hex(), user.ID); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			task.Title = "kept, edited"
+			if err := f.tasks.UpdateByUserID(ctx, task.ID.Hex(), user.ID, task); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.invites.Create(ctx, &models.Invite{Token: "from-" + email, Email: "friend-of-" + email, InvitedBy: user.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.idempotency.Reserve(ctx, &models.IdempotencyRecord{UserID: user.ID, Key: "k", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		session := &models.RefreshToken{UserID: user.ID, FamilyID: user.ID, TokenHash: "session-" + email, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := f.sessions.Create(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+		if f.user == nil {
+			f.user = user
+		} else {
+			f.other = user
+		}
+	}
+	if err := f.invites.Create(ctx, &models.Invite{Token: "to-ada", Email: f.user.Email, InvitedBy: f.other.ID}); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestExport(t *testing.T) {
+	f := newFixture(t)
+
+	var buf bytes.Buffer
+	if err := f.service.Export(t.Context(), &buf, f.user); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, file := range zr.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	for _, name := range []string{"README.txt", "profile.json", "tasks.json", "task_revisions.json", "invites.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("export is missing %s", name)
+		}
+	}
+
+	var profile map[string]any
+	if err := json.Unmarshal(files["profile.json"], &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile["email"] != f.user.Email || strings.Contains(string(files["profile.json"]), "secret-hash") {
+		t.Errorf("profile.json = %s", files["profile.json"])
+	}
+
+	var tasks []models.Task
+	if err := json.Unmarshal(files["tasks.json"], &tasks); err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[0].Title != "kept, edited" || tasks[1].DeletedAt == nil {
+		t.Errorf("tasks.json = %+v, want the active and the trashed task", tasks)
+	}
+	for _, task := range tasks {
+		if task.UserID != f.user.ID {
+			t.Errorf("exported another user's task: %+v", task)
+		}
+	}
+
+	var revisions []models.TaskRevision
+	if err := json.Unmarshal(files["task_revisions.json"], &revisions); err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].UserID != f.user.ID {
+		t.Errorf("task_revisions.json = %+v", revisions)
+	}
+
+	var invites []models.Invite
+	if err := json.Unmarshal(files["invites.json"], &invites); err != nil {
+		t.Fatal(err)
+	}
+	if len(invites) != 1 || invites[0].InvitedBy != f.user.ID {
+		t.Errorf("invites.json = %+v, want only the invite the user sent", invites)
+	}
+}
+
+func TestDeletionGracePeriod(t *testing.T) {
+	f := newFixture(t)
+	ctx := t.Context()
+
+	at, err := f.service.ScheduleDeletion(ctx, f.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(at); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("deletion scheduled in %v, want the grace period", d)
+	}
+	if _, err := f.sessions.FindByHash(ctx, "session-"+f.user.Email); err == nil {
+		t.Error("session left after scheduling the deletion")
+	}
+	if _, err := f.sessions.FindByHash(ctx, "session-"+f.other.Email); err != nil {
+		t.Errorf("other user's session: %v", err)
+	}
+	purge(ctx, f.service, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if _, err := f.users.FindByID(ctx, f.user.ID); err != nil {
+		t.Fatalf("account deleted during the grace period: %v", err)
+	}
+
+	if err := f.service.CancelDeletion(ctx, f.user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := f.users.FindByID(ctx, f.user.ID); user.DeletionScheduledAt != nil {
+		t.Errorf("DeletionScheduledAt = %v after cancelling", user.DeletionScheduledAt)
+	}
+}
+
+func TestPurgerDeletesEverything(t *testing.T) {
+	f := newFixture(t)
+	if err := f.users.ScheduleDeletion(t.Context(), f.user.ID, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		Purger(f.service, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := f.users.FindByID(t.Context(), f.user.ID); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("account was not deleted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	ctx = t.Context()
+	if tasks, _ := f.tasks.FindByUserID(ctx, f.user.ID); len(tasks) != 0 {
+		t.Errorf("tasks left: %+v", tasks)
+	}
+	if tasks, _ := f.tasks.FindDeletedByUserID(ctx, f.user.ID); len(tasks) != 0 {
+		t.Errorf("trashed tasks left: %+v", tasks)
+	}
+	if revisions, _ := f.tasks.FindAllRevisionsByUserID(ctx, f.user.ID); len(revisions) != 0 {
+		t.Errorf("revisions left: %+v", revisions)
+	}
+	if _, err := f.idempotency.FindByKey(ctx, f.user.ID, "k"); err == nil {
+		t.Error("idempotency record left")
+	}
+	if _, err := f.sessions.FindByHash(ctx, "session-"+f.user.Email); err == nil {
+		t.Error("session left")
+	}
+	invites, _ := f.invites.FindAll(ctx)
+	if len(invites) != 1 || invites[0].InvitedBy != f.other.ID || invites[0].Email == f.user.Email {
+		t.Errorf("invites left = %+v, want only the other user's unrelated invite", invites)
+	}
+
+	// The other user is untouched.
+	if tasks, _ := f.tasks.FindByUserID(ctx, f.other.ID); len(tasks) != 1 {
+		t.Errorf("other user's tasks = %+v", tasks)
+	}
+	if revisions, _ := f.tasks.FindAllRevisionsByUserID(ctx, f.other.ID); len(revisions) != 1 {
+		t.Errorf("other user's revisions = %+v", revisions)
+	}
+	if _, err := f.idempotency.FindByKey(ctx, f.other.ID, "k"); err != nil {
+		t.Errorf("other user's idempotency record: %v", err)
+	}
+
+	// Deleting again, as another replica might, succeeds.
+	if err := f.service.Delete(ctx, f.user); err != nil {
+		t.Errorf("repeated Delete: %v", err)
+	}
+	if err := f.service.CancelDeletion(ctx, f.user.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("CancelDeletion after deletion = %v, want not found", err)
+	}
+}
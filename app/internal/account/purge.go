@@ -0,0 +1,47 @@
+package account
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/server"
+)
+
+// Purger returns a worker that deletes the accounts whose grace period is
+// over, once on start and then every interval. Running it on every replica
+// is safe: deleting an account is idempotent.
+func Purger(s *Service, interval time.Duration, logger *slog.Logger) server.Worker {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purge(ctx, s, logger)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+func purge(ctx context.Context, s *Service, logger *slog.Logger) {
+	users, err := s.users.FindDueForDeletion(ctx, time.Now())
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("failed to find accounts due for deletion", "error", err)
+		}
+		return
+	}
+	for _, user := range users {
+		if err := s.Delete(ctx, &user); err != nil {
+			if ctx.Err() == nil {
+				logger.Error("failed to delete account", "user_id", user.ID.Hex(), "error", err)
+			}
+			continue
+		}
+		logger.Info("deleted account", "user_id", user.ID.Hex())
+	}
+}
@@ -15,8 +15,15 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID primitive.ObjectID, email, role, secret string, expiry time.Duration) (string, error) {
-	claims := Claims{
+// GenerateToken issues a session token signed with the current key.
+func GenerateToken(userID primitive.ObjectID, email, role string, keys *Keyset, expiry time.Duration) (string, error) {
+	token, _, err := generateToken(userID, email, role, keys, expiry)
+	return token, err
+}
+
+// generateToken is GenerateToken, also returning the token's claims.
+func generateToken(userID primitive.ObjectID, email, role string, keys *Keyset, expiry time.Duration) (string, *Claims, error) {
+	claims := &Claims{
 		UserID: userID,
 		Email:  email,
 		Role:   role,
@@ -26,18 +33,15 @@ func GenerateToken(userID primitive.ObjectID, email, role, secret string, expiry
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	token, err := keys.sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
 }
 
-func ValidateToken(tokenString, secret string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(secret), nil
-	})
-
+func ValidateToken(tokenString string, keys *Keyset) (*Claims, error) {
+	token, err := keys.parse(tokenString, &Claims{})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/server"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithms a signing key can use. HS256 keys are symmetric and are not
+// published, so only this app can verify the tokens they sign.
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+)
+
+// Algorithms lists the supported algorithms.
+var Algorithms = []string{AlgRS256, AlgES256, AlgEdDSA, AlgHS256}
+
+// NewSigningKey generates a key for alg that starts signing at activatesAt.
+// Its ID starts with the creation date, to tell keys apart when listing
+// them.
+func NewSigningKey(alg string, activatesAt time.Time) (*models.SigningKey, error) {
+	var private []byte
+	var err error
+	switch alg {
+	case AlgRS256:
+		var key *rsa.PrivateKey
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err == nil {
+			private, err = x509.MarshalPKCS8PrivateKey(key)
+		}
+	case AlgES256:
+		var key *ecdsa.PrivateKey
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err == nil {
+			private, err = x509.MarshalPKCS8PrivateKey(key)
+		}
+	case AlgEdDSA:
+		var key ed25519.PrivateKey
+		if _, key, err = ed25519.GenerateKey(rand.Reader); err == nil {
+			private, err = x509.MarshalPKCS8PrivateKey(key)
+		}
+	case AlgHS256:
+		private = make([]byte, 32)
+		_, err = rand.Read(private)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.SigningKey{
+		ID:          time.Now().UTC().Format("20060102") + "-" + rand.Text()[:8],
+		Algorithm:   alg,
+		PrivateKey:  private,
+		ActivatesAt: activatesAt,
+	}, nil
+}
+
+// SealSigningKey encrypts the private key of key with AES-GCM under
+// encryptionKey, a 32-byte key, before it is stored. The key ID is
+// authenticated along with it, so a private key copied to another key does
+// not decrypt.
+func SealSigningKey(key *models.SigningKey, encryptionKey []byte) error {
+	if key.Sealed {
+		return errors.New("signing key is already sealed")
+	}
+	aead, err := newKeyCipher(encryptionKey)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key.PrivateKey = aead.Seal(nonce, nonce, key.PrivateKey, []byte(key.ID))
+	key.Sealed = true
+	return nil
+}
+
+// openSigningKey returns the private key of s, decrypting it with
+// encryptionKey if it is sealed.
+func openSigningKey(s models.SigningKey, encryptionKey []byte) ([]byte, error) {
+	if !s.Sealed {
+		return s.PrivateKey, nil
+	}
+	if encryptionKey == nil {
+		return nil, errors.New("the key is encrypted; set jwt.key_encryption_key")
+	}
+	aead, err := newKeyCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.PrivateKey) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted key")
+	}
+	nonce, ciphertext := s.PrivateKey[:aead.NonceSize()], s.PrivateKey[aead.NonceSize():]
+	private, err := aead.Open(nil, nonce, ciphertext, []byte(s.ID))
+	if err != nil {
+		return nil, errors.New("cannot decrypt the key; was jwt.key_encryption_key changed?")
+	}
+	return private, nil
+}
+
+func newKeyCipher(encryptionKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("key encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Keyset signs and verifies session tokens with the keys in a
+// SigningKeyStore. Tokens carry the ID of their key in the kid header.
+//
+// Tokens without a kid were signed with HS256 and the JWT secret, as all
+// tokens were before there were signing keys. The secret still signs
+// while no stored key has activated, and verifies such tokens unless
+// legacy verification is turned off.
+type Keyset struct {
+	store         database.SigningKeyStore
+	legacy        []byte
+	verifyLegacy  bool
+	encryptionKey []byte
+
+	mu   sync.RWMutex
+	keys []*signingKey // in order of activation
+}
+
+type signingKey struct {
+	id          string
+	method      jwt.SigningMethod
+	private     any
+	public      crypto.PublicKey
+	activatesAt time.Time
+}
+
+// NewKeyset returns a Keyset with no keys loaded; call Load before use. A
+// nil store leaves only the secret. encryptionKey decrypts the keys
+// sealed with SealSigningKey; without it only unsealed keys load.
+func NewKeyset(store database.SigningKeyStore, secret string, verifyLegacy bool, encryptionKey []byte) *Keyset {
+	return &Keyset{store: store, legacy: []byte(secret), verifyLegacy: verifyLegacy, encryptionKey: encryptionKey}
+}
+
+// Load reads the keys from the store, replacing those loaded before. On
+// error the previous keys stay in use.
+func (k *Keyset) Load(ctx context.Context) error {
+	if k.store == nil {
+		return nil
+	}
+	stored, err := k.store.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	keys := make([]*signingKey, 0, len(stored))
+	for _, s := range stored {
+		key, err := parseSigningKey(s, k.encryptionKey)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", s.ID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+func parseSigningKey(s models.SigningKey, encryptionKey []byte) (*signingKey, error) {
+	private, err := openSigningKey(s, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{id: s.ID, activatesAt: s.ActivatesAt}
+	if s.Algorithm == AlgHS256 {
+		key.method = jwt.SigningMethodHS256
+		key.private = private
+		key.public = private
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, private, &private.PublicKey
+	case *ecdsa.PrivateKey:
+		if private.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 needs a P-256 key")
+		}
+		key.method, key.private, key.public = jwt.SigningMethodES256, private, &private.PublicKey
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, private, private.Public()
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	if key.method.Alg() != s.Algorithm {
+		return nil, fmt.Errorf("key type does not match algorithm %s", s.Algorithm)
+	}
+	return key, nil
+}
+
+// sign signs claims with the current signing key, or the secret if no key
+// has activated yet.
+func (k *Keyset) sign(claims jwt.Claims) (string, error) {
+	key := k.signingKey()
+	if key == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.legacy)
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// parse verifies tokenString with the key named by its kid and decodes it
+// into claims.
+func (k *Keyset) parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, k.verificationKey, jwt.WithValidMethods(Algorithms))
+}
+
+func (k *Keyset) verificationKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if !k.verifyLegacy && k.signingKey() != nil {
+			return nil, errors.New("token has no key ID")
+		}
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return k.legacy, nil
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.id != kid {
+			continue
+		}
+		// The algorithm is the key's, never the token's choice.
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, errors.New("signing method does not match the key")
+		}
+		return key.public, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// signingKey returns the most recently activated key, or nil.
+func (k *Keyset) signingKey() *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		if !k.keys[i].activatesAt.After(now) {
+			return k.keys[i]
+		}
+	}
+	return nil
+}
+
+// JWKS returns the public keys, including those not active yet, so that
+// other services can verify tokens as soon as a new key starts signing.
+func (k *Keyset) JWKS() jose.JSONWebKeySet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	for _, key := range k.keys {
+		if key.method == jwt.SigningMethodHS256 {
+			continue
+		}
+		set.Keys = append(set.Keys, jose.JSONWebKey{
+			Key:       key.public,
+			KeyID:     key.id,
+			Algorithm: key.method.Alg(),
+			Use:       "sig",
+		})
+	}
+	return set
+}
+
+// JWKSHandler serves JWKS as /.well-known/jwks.json.
+func (k *Keyset) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(k.JWKS())
+}
+
+// KeyRefresher returns a worker that reloads the keys every interval, so
+// that keys added by the keys command are picked up by every replica.
+func KeyRefresher(keys *Keyset, interval time.Duration, logger *slog.Logger) server.Worker {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := keys.Load(ctx); err != nil && ctx.Err() == nil {
+				logger.Error("failed to reload signing keys", "error", err)
+			}
+		}
+	}
+}
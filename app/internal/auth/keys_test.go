@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestKeysetRotation(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewSigningKeyRepository()
+	keys := NewKeyset(store, "secret", true, nil)
+	userID := primitive.NewObjectID()
+
+	// token issues a token with keys and returns it with its kid.
+	token := func(keys *Keyset) (string, string) {
+		t.Helper()
+		signed, err := GenerateToken(userID, "ada@example.com", "user", keys, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, _, err := jwt.NewParser().ParseUnverified(signed, &Claims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		kid, _ := parsed.Header["kid"].(string)
+		return signed, kid
+	}
+
+	// Without keys the secret signs, as before there were any.
+	legacy, kid := token(keys)
+	if kid != "" {
+		t.Fatalf("token signed with the secret has kid %q", kid)
+	}
+
+	// Each algorithm signs once its key has activated, and tokens signed
+	// with the keys it replaced still verify.
+	signed := []string{legacy}
+	for _, alg := range Algorithms {
+		key, err := NewSigningKey(alg, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Create(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := keys.Load(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		tokenString, kid := token(keys)
+		if kid != key.ID {
+			t.Errorf("%s: kid = %q, want %q", alg, kid, key.ID)
+		}
+		signed = append(signed, tokenString)
+		for _, s := range signed {
+			if claims, err := ValidateToken(s, keys); err != nil || claims.UserID != userID {
+				t.Errorf("%s: %v", alg, err)
+			}
+		}
+	}
+
+	// The JWKS publishes the public keys, which excludes the HS256 one.
+	if n := len(keys.JWKS().Keys); n != 3 {
+		t.Errorf("JWKS has %d keys, want 3", n)
+	}
+
+	// A key that has not activated is published but does not sign yet.
+	next, err := NewSigningKey(AlgES256, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Create(ctx, next)
+	keys.Load(ctx)
+	if _, kid := token(keys); kid == next.ID {
+		t.Error("a key signed before it activated")
+	}
+	if n := len(keys.JWKS().Keys); n != 4 {
+		t.Errorf("JWKS has %d keys, want 4", n)
+	}
+
+	// With legacy verification off, tokens without a kid are refused.
+	strict := NewKeyset(store, "secret", false, nil)
+	if err := strict.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(legacy, strict); err == nil {
+		t.Error("legacy token verified with legacy verification off")
+	}
+
+	// A retired key no longer verifies.
+	_, kid = token(keys)
+	store.Delete(ctx, kid)
+	keys.Load(ctx)
+	if _, err := ValidateToken(signed[len(signed)-1], keys); err == nil {
+		t.Error("token verified with a retired key")
+	}
+}
+
+func TestKeysetRejectsForgedTokens(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewSigningKeyRepository()
+	key, err := NewSigningKey(AlgRS256, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Create(ctx, key)
+	keys := NewKeyset(store, "secret", true, nil)
+	if err := keys.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	claims := Claims{
+		UserID:           primitive.NewObjectID(),
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}
+
+	// An HS256 token keyed with the RSA key's ID must not be checked
+	// against anything but that key, with RS256.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = key.ID
+	tokenString, _ := forged.SignedString([]byte("secret"))
+	if _, err := ValidateToken(tokenString, keys); err == nil {
+		t.Error("HS256 token verified against an RS256 key")
+	}
+
+	// Nor does a pending login token, signed with a key derived from the
+	// secret, pass for a session.
+	pending, err := GeneratePendingToken(claims.UserID, false, "secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(pending, keys); err == nil {
+		t.Error("pending login token verified as a session token")
+	}
+}
+
+func TestKeysetSealedKeys(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewSigningKeyRepository()
+	encryptionKey := bytes.Repeat([]byte{1}, 32)
+
+	// Keys stored before there was an encryption key keep loading next to
+	// sealed ones.
+	plain, err := NewSigningKey(AlgEdDSA, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Create(ctx, plain)
+	for _, alg := range []string{AlgRS256, AlgHS256} {
+		key, err := NewSigningKey(alg, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		private := slices.Clone(key.PrivateKey)
+		if err := SealSigningKey(key, encryptionKey); err != nil {
+			t.Fatal(err)
+		}
+		if !key.Sealed || bytes.Contains(key.PrivateKey, private) {
+			t.Fatalf("%s: the private key is stored in the clear", alg)
+		}
+		store.Create(ctx, key)
+	}
+	keys := NewKeyset(store, "secret", true, encryptionKey)
+	if err := keys.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := GenerateToken(primitive.NewObjectID(), "ada@example.com", "user", keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(signed, keys); err != nil {
+		t.Errorf("token signed with a sealed key: %v", err)
+	}
+
+	// Without the right encryption key they do not load.
+	for name, key := range map[string][]byte{"no key": nil, "another key": bytes.Repeat([]byte{2}, 32)} {
+		if err := NewKeyset(store, "secret", true, key).Load(ctx); err == nil {
+			t.Errorf("%s: sealed keys loaded", name)
+		}
+	}
+
+	// Nor does a sealed key copied to another ID.
+	stored, err := store.FindAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := stored[len(stored)-1]
+	copied.ID = "copied"
+	if _, err := parseSigningKey(copied, encryptionKey); err == nil {
+		t.Error("a sealed key decrypted under another ID")
+	}
+}
@@ -2,44 +2,76 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"net/http"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/flash"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
 )
 
 type contextKey string
 
 const UserContextKey contextKey = "user"
 
+// Config holds the keys that sign session tokens, and the secret from
+// which the keys for other short-lived tokens, such as the pending second
+// login step, are derived. With Sessions set, RequireAuth renews expired
+// access tokens.
 type Config struct {
 	JWTSecret string
+	Keys      *Keyset
+	Sessions  *Sessions
 }
 
 func RequireAuth(cfg *Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("token")
-			if err != nil {
+			claims, err := cfg.authenticate(w, r)
+			if errors.Is(err, ErrSessionExpired) && !hasSessionCookie(r) {
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
-
-			claims, err := ValidateToken(cookie.Value, cfg.JWTSecret)
+			if errors.Is(err, ErrRefreshTokenReused) {
+				logging.FromContext(r.Context()).Warn("refresh token reused; session revoked")
+			} else if err != nil && !errors.Is(err, ErrSessionExpired) {
+				logging.FromContext(r.Context()).Error("failed to renew session", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
 			if err != nil {
-				http.SetCookie(w, &http.Cookie{
-					Name:   "token",
-					Value:  "",
-					Path:   "/",
-					MaxAge: -1,
-				})
+				ClearSessionCookies(w)
+				flash.Info(r.Context(), "Your session has expired. Please log in again.")
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
 
+			logging.AddAttrs(r.Context(), "user_id", claims.UserID.Hex())
 			ctx := context.WithValue(r.Context(), UserContextKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// authenticate returns the claims of the request's access token, renewing
+// it if it has expired.
+func (cfg *Config) authenticate(w http.ResponseWriter, r *http.Request) (*Claims, error) {
+	if cookie, err := r.Cookie(AccessCookie); err == nil {
+		if claims, err := ValidateToken(cookie.Value, cfg.Keys); err == nil {
+			return claims, nil
+		}
+	}
+	if cfg.Sessions == nil {
+		return nil, ErrSessionExpired
+	}
+	return cfg.Sessions.Renew(r.Context(), w, r)
+}
+
+func hasSessionCookie(r *http.Request) bool {
+	_, accessErr := r.Cookie(AccessCookie)
+	_, refreshErr := r.Cookie(RefreshCookie)
+	return accessErr == nil || refreshErr == nil
+}
+
 func RequireAdmin(cfg *Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -56,14 +88,11 @@ func RequireAdmin(cfg *Config) func(http.Handler) http.Handler {
 func OptionalAuth(cfg *Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("token")
-			if err == nil {
-				claims, err := ValidateToken(cookie.Value, cfg.JWTSecret)
-				if err == nil {
-					ctx := context.WithValue(r.Context(), UserContextKey, claims)
-					next.ServeHTTP(w, r.WithContext(ctx))
-					return
-				}
+			if claims, err := cfg.authenticate(w, r); err == nil {
+				logging.AddAttrs(r.Context(), "user_id", claims.UserID.Hex())
+				ctx := context.WithValue(r.Context(), UserContextKey, claims)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
 			}
 			next.ServeHTTP(w, r)
 		})
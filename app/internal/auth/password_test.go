@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("hash = %q, want argon2id with the default parameters", hash)
+	}
+	if !CheckPassword(hash, "correct horse") || CheckPassword(hash, "wrong horse") {
+		t.Error("argon2id hash does not check the password")
+	}
+	if NeedsRehash(hash) {
+		t.Error("NeedsRehash is true for a current hash")
+	}
+
+	// Hashes made with other parameters still verify, but are replaced.
+	weaker := strings.Replace(hash, "t=2", "t=1", 1)
+	if CheckPassword(weaker, "correct horse") {
+		t.Error("changing the parameters kept the hash valid")
+	}
+	if !NeedsRehash(weaker) {
+		t.Error("NeedsRehash is false for other parameters")
+	}
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(string(legacy), "correct horse") || CheckPassword(string(legacy), "wrong horse") {
+		t.Error("bcrypt hash does not check the password")
+	}
+	if !NeedsRehash(string(legacy)) {
+		t.Error("NeedsRehash is false for a bcrypt hash")
+	}
+
+	for _, invalid := range []string{"", "$argon2id$", "$argon2id$v=19$m=0,t=2,p=1$c2FsdA$a2V5"} {
+		if CheckPassword(invalid, "") {
+			t.Errorf("CheckPassword(%q) = true", invalid)
+		}
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	// A breached password list in the Pwned Passwords format, with a plain
+	// password added.
+	sum := sha1.Sum([]byte("gravel lantern tide"))
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	content := strings.ToUpper(hex.EncodeToString(sum[:])) + ":42\ncobblestone path\n"
+	if err := os.WriteFile(list, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewPasswordPolicy(PasswordPolicyConfig{
+		MinLength:      10,
+		MaxLength:      64,
+		RejectCommon:   true,
+		CommonListFile: list,
+		RejectPersonal: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		password string
+		ok       bool
+	}{
+		{"quiet harbour lamp", true},
+		{"short", false},
+		{strings.Repeat("x", 65), false},
+		{"Password123", false},         // built-in list, any case
+		{"gravel lantern tide", false}, // hashed entry in the file
+		{"Cobblestone Path", false},    // plain entry in the file
+		{"lovelace the great", false},  // part of the name
+		{"xx-ada.l-xx-99", false},      // email before the @
+	} {
+		err := policy.Validate(tt.password, "ada.l@example.com", "Ada Lovelace")
+		if tt.ok != (err == nil) {
+			t.Errorf("Validate(%q) = %v, want ok %v", tt.password, err, tt.ok)
+		}
+		var errs models.ValidationErrors
+		if err != nil && (!errors.As(err, &errs) || errs[0].Field != "password") {
+			t.Errorf("Validate(%q) = %#v, want a password field error", tt.password, err)
+		}
+	}
+
+	lenient, err := NewPasswordPolicy(PasswordPolicyConfig{MinLength: 8, MaxLength: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lenient.Validate("password123", "ada@example.com", "Ada"); err != nil {
+		t.Errorf("lenient policy: Validate = %v", err)
+	}
+
+	if _, err := NewPasswordPolicy(PasswordPolicyConfig{RejectCommon: true, CommonListFile: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("missing list file was accepted")
+	}
+}
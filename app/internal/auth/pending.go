@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PendingClaims identify a user who has entered their password but not yet
+// passed the second login step, and whether they asked to be remembered.
+type PendingClaims struct {
+	UserID   primitive.ObjectID `json:"user_id"`
+	Remember bool               `json:"remember,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GeneratePendingToken issues a token for the second login step. It is
+// signed with a key derived from secret, so it is never accepted as a
+// session token.
+func GeneratePendingToken(userID primitive.ObjectID, remember bool, secret string, expiry time.Duration) (string, error) {
+	claims := PendingClaims{
+		UserID:   userID,
+		Remember: remember,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(pendingKey(secret))
+}
+
+func ValidatePendingToken(tokenString, secret string) (*PendingClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &PendingClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return pendingKey(secret), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*PendingClaims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, errors.New("invalid token")
+}
+
+func pendingKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("login-2fa"))
+	return mac.Sum(nil)
+}
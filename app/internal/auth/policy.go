@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+)
+
+// commonPasswords is a short list of the most common passwords, checked
+// even without a list file.
+//
+//go:embed common_passwords.txt
+var commonPasswords []byte
+
+// PasswordPolicyConfig configures a PasswordPolicy. With RejectCommon,
+// passwords on the built-in list of common passwords are refused, as are
+// those in CommonListFile if it is set. The file has one password per
+// line or, as in the Pwned Passwords downloads, the SHA-1 hash of one,
+// optionally followed by ":count". RejectPersonal refuses passwords that
+// contain the user's email or name.
+type PasswordPolicyConfig struct {
+	MinLength      int
+	MaxLength      int
+	RejectCommon   bool
+	CommonListFile string
+	RejectPersonal bool
+}
+
+// PasswordPolicy decides which passwords users may choose. Existing
+// passwords are not checked again, so a stricter policy applies as
+// passwords are set.
+type PasswordPolicy struct {
+	cfg    PasswordPolicyConfig
+	common map[string]struct{} // lowercase passwords and uppercase SHA-1 hashes
+}
+
+// NewPasswordPolicy returns a PasswordPolicy, reading the common password
+// list file if there is one.
+func NewPasswordPolicy(cfg PasswordPolicyConfig) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{cfg: cfg, common: make(map[string]struct{})}
+	if !cfg.RejectCommon {
+		return p, nil
+	}
+	if err := p.addCommon(bytes.NewReader(commonPasswords)); err != nil {
+		return nil, err
+	}
+	if cfg.CommonListFile != "" {
+		f, err := os.Open(cfg.CommonListFile)
+		if err != nil {
+			return nil, fmt.Errorf("common password list: %w", err)
+		}
+		defer f.Close()
+		if err := p.addCommon(f); err != nil {
+			return nil, fmt.Errorf("common password list: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func (p *PasswordPolicy) addCommon(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			p.common[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		p.common[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// MinLength is the shortest password allowed, in characters.
+func (p *PasswordPolicy) MinLength() int {
+	return p.cfg.MinLength
+}
+
+// Validate checks a new password for the user with email and name.
+func (p *PasswordPolicy) Validate(password, email, name string) error {
+	var errs models.ValidationErrors
+	switch n := utf8.RuneCountInString(password); {
+	case n < p.cfg.MinLength:
+		errs.Add("password", fmt.Sprintf("password must be at least %d characters", p.cfg.MinLength))
+	case n > p.cfg.MaxLength:
+		errs.Add("password", fmt.Sprintf("password must be at most %d characters", p.cfg.MaxLength))
+	case p.isCommon(password):
+		errs.Add("password", "password is too common; choose one that is harder to guess")
+	case p.cfg.RejectPersonal && containsPersonal(password, email, name):
+		errs.Add("password", "password must not contain your email or name")
+	}
+	return errs.Err()
+}
+
+func (p *PasswordPolicy) isCommon(password string) bool {
+	if len(p.common) == 0 {
+		return false
+	}
+	if _, ok := p.common[strings.ToLower(password)]; ok {
+		return true
+	}
+	sum := sha1.Sum([]byte(password))
+	_, ok := p.common[strings.ToUpper(hex.EncodeToString(sum[:]))]
+	return ok
+}
+
+// containsPersonal reports whether password contains the email, the part
+// of it before the @, the name, or any word of the name, ignoring case.
+// Parts shorter than three characters are too likely to match by chance.
+func containsPersonal(password, email, name string) bool {
+	password = strings.ToLower(password)
+	local, _, _ := strings.Cut(email, "@")
+	parts := append([]string{email, local, name}, strings.Fields(name)...)
+	for _, part := range parts {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if utf8.RuneCountInString(part) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GenerateReauthToken issues a token showing that the user confirmed who
+// they are again, for users without a password to confirm changes with.
+// It is signed with a key derived from secret, so it is never accepted as
+// a session token or a pending login.
+func GenerateReauthToken(userID primitive.ObjectID, secret string, expiry time.Duration) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Subject:   userID.Hex(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(reauthKey(secret))
+}
+
+// ValidateReauthToken returns the ID of the user a token from
+// GenerateReauthToken was issued to.
+func ValidateReauthToken(tokenString, secret string) (primitive.ObjectID, error) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (interface{}, error) {
+		return reauthKey(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return primitive.NilObjectID, errors.New("invalid token")
+	}
+	return id, nil
+}
+
+func reauthKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("reauth"))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The session cookies: a short-lived access token, and the refresh token
+// that renews it.
+const (
+	AccessCookie  = "token"
+	RefreshCookie = "refresh"
+)
+
+// refreshReuseGrace is how long after its use a refresh token may be
+// presented again without revoking its session. Requests sent together
+// with an expired access token all try to renew it, and only the first
+// gets the next refresh token; the others get an access token only.
+const refreshReuseGrace = 30 * time.Second
+
+var (
+	// ErrSessionExpired is returned when there is no session to renew.
+	ErrSessionExpired = errors.New("session expired")
+	// ErrRefreshTokenReused is returned when a refresh token is used again
+	// after its session has moved on, which means it was copied. The
+	// session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// SessionConfig sets how long session tokens last. A refresh token is good
+// for RefreshExpiry after it is issued, or RememberExpiry for logins with
+// "remember me", and every renewal issues a new one, so a session lasts
+// as long as it is used within that time.
+//
+// TwoFactor, if set, is asked on every renewal whether a user without
+// two-factor authentication must have it, so a session outlives a
+// requirement to use it by one access token at most.
+type SessionConfig struct {
+	AccessExpiry   time.Duration
+	RefreshExpiry  time.Duration
+	RememberExpiry time.Duration
+	TwoFactor      TwoFactorPolicy
+}
+
+// TwoFactorPolicy reports whether a user must use two-factor
+// authentication to log in. twofactor.Service implements it.
+type TwoFactorPolicy interface {
+	Required(ctx context.Context, user *models.User) (bool, error)
+}
+
+// Sessions starts, renews and ends login sessions. A session is an access
+// token, which RequireAuth checks on every request, and a family of
+// refresh tokens, which renew it once it expires.
+type Sessions struct {
+	users  database.UserStore
+	tokens database.RefreshTokenStore
+	keys   *Keyset
+	cfg    SessionConfig
+}
+
+func NewSessions(users database.UserStore, tokens database.RefreshTokenStore, keys *Keyset, cfg SessionConfig) *Sessions {
+	return &Sessions{users: users, tokens: tokens, keys: keys, cfg: cfg}
+}
+
+// Start sets the cookies of a new session for user. A persistent session
+// keeps its cookies when the browser is closed.
+func (s *Sessions) Start(ctx context.Context, w http.ResponseWriter, user *models.User, persistent bool) error {
+	_, err := s.issue(ctx, w, user, primitive.NewObjectID(), persistent)
+	return err
+}
+
+// Renew issues a new access token from the request's refresh token, and
+// replaces the refresh token with the next one of its family. The user is
+// read again, so changes to their role take effect, and a user who now
+// has to use two-factor authentication but has not set it up has to log
+// in again to do so.
+func (s *Sessions) Renew(ctx context.Context, w http.ResponseWriter, r *http.Request) (*Claims, error) {
+	cookie, err := r.Cookie(RefreshCookie)
+	if err != nil {
+		return nil, ErrSessionExpired
+	}
+	token, err := s.tokens.FindByHash(ctx, hashRefreshToken(cookie.Value))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrSessionExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rotate := true
+	if token.UsedAt != nil {
+		if time.Since(*token.UsedAt) > refreshReuseGrace {
+			if err := s.tokens.DeleteFamily(ctx, token.FamilyID); err != nil {
+				return nil, err
+			}
+			return nil, ErrRefreshTokenReused
+		}
+		rotate = false
+	} else if err := s.tokens.MarkUsed(ctx, token.ID); errors.Is(err, database.ErrRefreshTokenUsed) {
+		// Another request renewed the session just now.
+		rotate = false
+	} else if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrSessionExpired
+	} else if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.FindByID(ctx, token.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, ErrSessionExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.DeletionDue() {
+		return nil, ErrSessionExpired
+	}
+	if s.cfg.TwoFactor != nil && !user.TwoFactorEnabled {
+		required, err := s.cfg.TwoFactor.Required(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			return nil, ErrSessionExpired
+		}
+	}
+
+	if !rotate {
+		access, claims, err := generateToken(user.ID, user.Email, user.Role, s.keys, s.accessExpiry(user))
+		if err != nil {
+			return nil, err
+		}
+		setSessionCookie(w, AccessCookie, access, s.accessExpiry(user), token.Persistent)
+		return claims, nil
+	}
+	return s.issue(ctx, w, user, token.FamilyID, token.Persistent)
+}
+
+// End revokes the request's session and clears its cookies.
+func (s *Sessions) End(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ClearSessionCookies(w)
+
+	cookie, err := r.Cookie(RefreshCookie)
+	if err != nil {
+		return nil
+	}
+	token, err := s.tokens.FindByHash(ctx, hashRefreshToken(cookie.Value))
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.tokens.DeleteFamily(ctx, token.FamilyID)
+}
+
+// RevokeAll revokes every session of the user. Their access tokens stay
+// valid until they expire.
+func (s *Sessions) RevokeAll(ctx context.Context, userID primitive.ObjectID) error {
+	return s.tokens.DeleteByUserID(ctx, userID)
+}
+
+// Restart revokes every session of user, the request's included, and
+// starts a new one for the request, persistent if the request's was. It
+// is used when the account's protection changes, so that sessions started
+// before then end.
+func (s *Sessions) Restart(ctx context.Context, w http.ResponseWriter, r *http.Request, user *models.User) error {
+	persistent := false
+	if cookie, err := r.Cookie(RefreshCookie); err == nil {
+		token, err := s.tokens.FindByHash(ctx, hashRefreshToken(cookie.Value))
+		if err == nil {
+			persistent = token.Persistent
+		} else if !errors.Is(err, database.ErrNotFound) {
+			return err
+		}
+	}
+	if err := s.RevokeAll(ctx, user.ID); err != nil {
+		return err
+	}
+	return s.Start(ctx, w, user, persistent)
+}
+
+// issue sets the cookies for an access token and the next refresh token of
+// a family, and returns the access token's claims.
+func (s *Sessions) issue(ctx context.Context, w http.ResponseWriter, user *models.User, familyID primitive.ObjectID, persistent bool) (*Claims, error) {
+	refreshExpiry := s.cfg.RefreshExpiry
+	if persistent {
+		refreshExpiry = s.cfg.RememberExpiry
+	}
+	// A session started during the grace period of an account deletion
+	// ends when the account is deleted.
+	if user.DeletionPending() {
+		refreshExpiry = min(refreshExpiry, time.Until(*user.DeletionScheduledAt))
+	}
+
+	refresh := rand.Text()
+	err := s.tokens.Create(ctx, &models.RefreshToken{
+		UserID:     user.ID,
+		FamilyID:   familyID,
+		TokenHash:  hashRefreshToken(refresh),
+		Persistent: persistent,
+		ExpiresAt:  time.Now().Add(refreshExpiry),
+	})
+	if err != nil {
+		return nil, err
+	}
+	access, claims, err := generateToken(user.ID, user.Email, user.Role, s.keys, s.accessExpiry(user))
+	if err != nil {
+		return nil, err
+	}
+
+	setSessionCookie(w, AccessCookie, access, s.accessExpiry(user), persistent)
+	setSessionCookie(w, RefreshCookie, refresh, refreshExpiry, persistent)
+	return claims, nil
+}
+
+func (s *Sessions) accessExpiry(user *models.User) time.Duration {
+	if user.DeletionPending() {
+		return min(s.cfg.AccessExpiry, time.Until(*user.DeletionScheduledAt))
+	}
+	return s.cfg.AccessExpiry
+}
+
+// setSessionCookie sets a session cookie. Unless the session is
+// persistent, it is a browser session cookie, which the browser drops when
+// it is closed.
+func setSessionCookie(w http.ResponseWriter, name, value string, expiry time.Duration, persistent bool) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   false, // Set to true in production with HTTPS
+		SameSite: http.SameSiteStrictMode,
+	}
+	if persistent {
+		cookie.MaxAge = int(expiry.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+// ClearSessionCookies removes the session cookies, without revoking the
+// session; see Sessions.End.
+func ClearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{AccessCookie, RefreshCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:   name,
+			Value:  "",
+			Path:   "/",
+			MaxAge: -1,
+		})
+	}
+}
+
+// hashRefreshToken returns the hash under which a refresh token is stored,
+// so the tokens cannot be used by someone who reads the database.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
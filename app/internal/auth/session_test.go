@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+)
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	ctx := t.Context()
+	users, tokens := memory.NewUserRepository(), memory.NewRefreshTokenRepository()
+	sessions := NewSessions(users, tokens, NewKeyset(nil, "secret", true, nil), SessionConfig{
+		AccessExpiry:   time.Minute,
+		RefreshExpiry:  time.Hour,
+		RememberExpiry: time.Hour,
+	})
+	user := &models.User{Email: "ada@example.com", Name: "Ada", Role: models.RoleUser}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := sessions.Start(ctx, rec, user, false); err != nil {
+		t.Fatal(err)
+	}
+	var current *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == RefreshCookie {
+			current = c
+		}
+	}
+	renew := func(c *http.Cookie) (*http.Cookie, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(c)
+		rec := httptest.NewRecorder()
+		if _, err := sessions.Renew(ctx, rec, req); err != nil {
+			return nil, err
+		}
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == RefreshCookie {
+				return c, nil
+			}
+		}
+		return nil, nil
+	}
+
+	next, err := renew(current)
+	if err != nil || next == nil {
+		t.Fatalf("Renew = %v, %v", next, err)
+	}
+
+	// The first token, used longer ago than the grace period allows for
+	// a concurrent renewal, turns up again: it was copied.
+	stolen, err := tokens.FindByHash(ctx, hashRefreshToken(current.Value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := time.Now().Add(-time.Minute)
+	stolen.UsedAt = &used
+	stolen.TokenHash = hashRefreshToken("stolen")
+	if err := tokens.Create(ctx, stolen); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renew(&http.Cookie{Name: RefreshCookie, Value: "stolen"}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused token: Renew = %v, want ErrRefreshTokenReused", err)
+	}
+
+	// That ends the session for the legitimate holder as well.
+	if _, err := renew(next); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("after reuse: Renew = %v, want ErrSessionExpired", err)
+	}
+}
+
+// requireTwoFactor is a TwoFactorPolicy that requires two-factor
+// authentication of everyone while it is true.
+type requireTwoFactor bool
+
+func (r *requireTwoFactor) Required(context.Context, *models.User) (bool, error) {
+	return bool(*r), nil
+}
+
+func TestSessionsFollowTwoFactor(t *testing.T) {
+	ctx := t.Context()
+	users, tokens := memory.NewUserRepository(), memory.NewRefreshTokenRepository()
+	var required requireTwoFactor
+	sessions := NewSessions(users, tokens, NewKeyset(nil, "secret", true, nil), SessionConfig{
+		AccessExpiry:   time.Minute,
+		RefreshExpiry:  time.Hour,
+		RememberExpiry: time.Hour,
+		TwoFactor:      &required,
+	})
+	user := &models.User{Email: "ada@example.com", Name: "Ada", Role: models.RoleAdmin}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == RefreshCookie {
+				return c
+			}
+		}
+		t.Fatal("no refresh cookie")
+		return nil
+	}
+	// start starts a session and returns its refresh cookie.
+	start := func(persistent bool) *http.Cookie {
+		rec := httptest.NewRecorder()
+		if err := sessions.Start(ctx, rec, user, persistent); err != nil {
+			t.Fatal(err)
+		}
+		return refreshCookie(rec)
+	}
+	request := func(c *http.Cookie) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(c)
+		return req
+	}
+	renew := func(c *http.Cookie) error {
+		_, err := sessions.Renew(ctx, httptest.NewRecorder(), request(c))
+		return err
+	}
+
+	// Once it is required, a session without it cannot be renewed.
+	session := start(false)
+	required = true
+	if err := renew(session); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("Renew while required = %v, want ErrSessionExpired", err)
+	}
+
+	// Turning it on restarts the session that did so and ends the others.
+	required = false
+	here, elsewhere := start(true), start(false)
+	if err := users.SetTOTPSecret(ctx, user.ID, "sealed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.EnableTwoFactor(ctx, user.ID, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	if err := sessions.Restart(ctx, rec, request(here), user); err != nil {
+		t.Fatal(err)
+	}
+	restarted := refreshCookie(rec)
+	if restarted.MaxAge <= 0 {
+		t.Error("the restarted session is no longer persistent")
+	}
+	for name, c := range map[string]*http.Cookie{"the old session": here, "another session": elsewhere} {
+		if err := renew(c); !errors.Is(err, ErrSessionExpired) {
+			t.Errorf("Renew of %s = %v, want ErrSessionExpired", name, err)
+		}
+	}
+	required = true
+	if err := renew(restarted); err != nil {
+		t.Errorf("Renew with two-factor authentication = %v", err)
+	}
+}
@@ -0,0 +1,417 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Insecure values that used to be silently applied as fallbacks. They are
+// still the defaults in dev mode, but Validate refuses them otherwise.
+const (
+	InsecureJWTSecret     = "default-secret-change-in-production"
+	InsecureAdminPassword = "admin123"
+)
+
+// DefaultContentSecurityPolicy only allows same-origin resources and
+// nonce-tagged script elements. Inline event handler attributes are
+// blocked, so pages attach their handlers from nonce-tagged scripts.
+// {nonce} is replaced per request by middleware.SecurityHeaders.
+const DefaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'nonce-{nonce}'; " +
+	"style-src 'self'; " +
+	"img-src 'self' data:; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// Config is the typed application configuration. Values are resolved in
+// order: built-in defaults, the optional config file, then environment
+// variables named by the env tags.
+type Config struct {
+	DevMode    bool             `yaml:"dev_mode" toml:"dev_mode" env:"DEV_MODE"`
+	Server     ServerConfig     `yaml:"server" toml:"server"`
+	Mongo      MongoConfig      `yaml:"mongo" toml:"mongo"`
+	Migrations MigrationsConfig `yaml:"migrations" toml:"migrations"`
+	JWT        JWTConfig        `yaml:"jwt" toml:"jwt"`
+	Logging    LoggingConfig    `yaml:"logging" toml:"logging"`
+	Metrics    MetricsConfig    `yaml:"metrics" toml:"metrics"`
+	Tracing    TracingConfig    `yaml:"tracing" toml:"tracing"`
+	Admin      AdminConfig      `yaml:"admin" toml:"admin"`
+	CORS       CORSConfig       `yaml:"cors" toml:"cors"`
+	Security   SecurityConfig   `yaml:"security" toml:"security"`
+	Trash      TrashConfig      `yaml:"trash" toml:"trash"`
+	Accounts   AccountsConfig   `yaml:"accounts" toml:"accounts"`
+	TwoFactor  TwoFactorConfig  `yaml:"two_factor" toml:"two_factor"`
+	SSO        SSOConfig        `yaml:"sso" toml:"sso"`
+	Password   PasswordConfig   `yaml:"password" toml:"password"`
+}
+
+type ServerConfig struct {
+	Port            string        `yaml:"port" toml:"port" env:"PORT"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+	// DrainPeriod is how long the server keeps serving after /readyz turns
+	// unready on shutdown, giving the load balancer time to stop routing.
+	DrainPeriod time.Duration `yaml:"drain_period" toml:"drain_period" env:"SERVER_DRAIN_PERIOD"`
+	// HealthCheckTimeout bounds each dependency check run by /readyz.
+	HealthCheckTimeout time.Duration `yaml:"health_check_timeout" toml:"health_check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	// IdempotencyKeyTTL is how long the response to a request with an
+	// Idempotency-Key is kept for replay to retries.
+	IdempotencyKeyTTL time.Duration `yaml:"idempotency_key_ttl" toml:"idempotency_key_ttl" env:"IDEMPOTENCY_KEY_TTL"`
+}
+
+type MongoConfig struct {
+	URI            string        `yaml:"uri" toml:"uri" env:"MONGODB_URI" secret:"uri"`
+	Database       string        `yaml:"database" toml:"database" env:"MONGODB_DATABASE"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout" toml:"connect_timeout" env:"MONGODB_CONNECT_TIMEOUT"`
+}
+
+// MigrationsConfig controls schema migrations on server start. AutoApply
+// runs pending migrations under the cluster-wide lock; RequireCurrent makes
+// the server refuse to start while any migration is still pending.
+type MigrationsConfig struct {
+	AutoApply      bool          `yaml:"auto_apply" toml:"auto_apply" env:"MIGRATIONS_AUTO_APPLY"`
+	RequireCurrent bool          `yaml:"require_current" toml:"require_current" env:"MIGRATIONS_REQUIRE_CURRENT"`
+	LockTimeout    time.Duration `yaml:"lock_timeout" toml:"lock_timeout" env:"MIGRATIONS_LOCK_TIMEOUT"`
+}
+
+// JWTConfig controls session tokens. They are signed with the keys managed
+// by the keys command, which every replica reloads each
+// KeyRefreshInterval; until one is added they are signed with Secret.
+// VerifyLegacy keeps accepting tokens signed with Secret after that.
+// KeyEncryptionKey, a base64-encoded 32-byte AES key, encrypts the private
+// keys the keys command stores; without it they are stored in the clear.
+//
+// Access tokens last Expiry and are renewed with a refresh token, which
+// lasts RefreshExpiry, or RememberExpiry if the user asked to be
+// remembered, and is replaced on every renewal.
+type JWTConfig struct {
+	Secret             string        `yaml:"secret" toml:"secret" env:"JWT_SECRET" secret:"true"`
+	Expiry             time.Duration `yaml:"expiry" toml:"expiry" env:"JWT_EXPIRY"`
+	RefreshExpiry      time.Duration `yaml:"refresh_expiry" toml:"refresh_expiry" env:"JWT_REFRESH_EXPIRY"`
+	RememberExpiry     time.Duration `yaml:"remember_expiry" toml:"remember_expiry" env:"JWT_REMEMBER_EXPIRY"`
+	KeyRefreshInterval time.Duration `yaml:"key_refresh_interval" toml:"key_refresh_interval" env:"JWT_KEY_REFRESH_INTERVAL"`
+	VerifyLegacy       bool          `yaml:"verify_legacy" toml:"verify_legacy" env:"JWT_VERIFY_LEGACY"`
+	KeyEncryptionKey   string        `yaml:"key_encryption_key" toml:"key_encryption_key" env:"JWT_KEY_ENCRYPTION_KEY" secret:"true"`
+}
+
+// EncryptionKey decodes KeyEncryptionKey. It returns nil when no key is
+// set.
+func (c JWTConfig) EncryptionKey() ([]byte, error) {
+	if c.KeyEncryptionKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(c.KeyEncryptionKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("jwt.key_encryption_key must be 32 bytes, base64-encoded")
+	}
+	return key, nil
+}
+
+type LoggingConfig struct {
+	Level  string `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT"`
+}
+
+// MetricsConfig controls the Prometheus endpoint. When Addr is set, metrics
+// are served from a separate listener (e.g. ":9090") that is not exposed
+// through ingress; otherwise /metrics is mounted on the main server and
+// should be protected with Token.
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled" env:"METRICS_ENABLED"`
+	Addr    string `yaml:"addr" toml:"addr" env:"METRICS_ADDR"`
+	Token   string `yaml:"token" toml:"token" env:"METRICS_TOKEN" secret:"true"`
+}
+
+// TracingConfig selects the OpenTelemetry exporter: "none", "otlp" (HTTP)
+// or "stdout". The OTLP exporter also honours the standard
+// OTEL_EXPORTER_OTLP_* variables when Endpoint is empty.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"TRACING_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure" env:"TRACING_INSECURE"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+	ServiceName string  `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME"`
+}
+
+type AdminConfig struct {
+	Email    string `yaml:"email" toml:"email" env:"ADMIN_EMAIL"`
+	Password string `yaml:"password" toml:"password" env:"ADMIN_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" toml:"name" env:"ADMIN_NAME"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins" toml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string      `yaml:"allowed_methods" toml:"allowed_methods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string      `yaml:"allowed_headers" toml:"allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	ExposedHeaders   []string      `yaml:"exposed_headers" toml:"exposed_headers" env:"CORS_EXPOSED_HEADERS"`
+	AllowCredentials bool          `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE"`
+}
+
+type SecurityConfig struct {
+	ContentSecurityPolicy string        `yaml:"csp" toml:"csp" env:"SECURITY_CSP"`
+	HSTSMaxAge            time.Duration `yaml:"hsts_max_age" toml:"hsts_max_age" env:"SECURITY_HSTS_MAX_AGE"`
+	HSTSIncludeSubdomains bool          `yaml:"hsts_include_subdomains" toml:"hsts_include_subdomains" env:"SECURITY_HSTS_INCLUDE_SUBDOMAINS"`
+	HSTSPreload           bool          `yaml:"hsts_preload" toml:"hsts_preload" env:"SECURITY_HSTS_PRELOAD"`
+	FrameOptions          string        `yaml:"frame_options" toml:"frame_options" env:"SECURITY_FRAME_OPTIONS"`
+	ReferrerPolicy        string        `yaml:"referrer_policy" toml:"referrer_policy" env:"SECURITY_REFERRER_POLICY"`
+	PermissionsPolicy     string        `yaml:"permissions_policy" toml:"permissions_policy" env:"SECURITY_PERMISSIONS_POLICY"`
+}
+
+// TrashConfig controls how long deleted tasks stay restorable. A background
+// job checks every PurgeInterval for tasks trashed more than Retention ago
+// and removes them permanently.
+type TrashConfig struct {
+	Retention     time.Duration `yaml:"retention" toml:"retention" env:"TRASH_RETENTION"`
+	PurgeInterval time.Duration `yaml:"purge_interval" toml:"purge_interval" env:"TRASH_PURGE_INTERVAL"`
+}
+
+// AccountsConfig controls account deletion. Accounts are deleted with all
+// their data DeletionGrace after the deletion is requested; a background
+// job checks every PurgeInterval for accounts that are due.
+type AccountsConfig struct {
+	DeletionGrace time.Duration `yaml:"deletion_grace" toml:"deletion_grace" env:"ACCOUNT_DELETION_GRACE"`
+	PurgeInterval time.Duration `yaml:"purge_interval" toml:"purge_interval" env:"ACCOUNT_PURGE_INTERVAL"`
+}
+
+// TwoFactorConfig controls TOTP two-factor authentication. EncryptionKey
+// is a base64-encoded 32-byte AES key that encrypts the TOTP secrets
+// stored in the database; without it two-factor authentication cannot be
+// enabled. RequireForAdmins makes admins set it up before they can log in.
+type TwoFactorConfig struct {
+	EncryptionKey    string `yaml:"encryption_key" toml:"encryption_key" env:"TWO_FACTOR_ENCRYPTION_KEY" secret:"true"`
+	Issuer           string `yaml:"issuer" toml:"issuer" env:"TWO_FACTOR_ISSUER"`
+	RequireForAdmins bool   `yaml:"require_for_admins" toml:"require_for_admins" env:"TWO_FACTOR_REQUIRE_FOR_ADMINS"`
+}
+
+// Key decodes EncryptionKey. It returns nil when no key is set.
+func (c TwoFactorConfig) Key() ([]byte, error) {
+	if c.EncryptionKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(c.EncryptionKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("two_factor.encryption_key must be 32 bytes, base64-encoded")
+	}
+	return key, nil
+}
+
+// SSOConfig enables single sign-on with an OpenID Connect provider when
+// IssuerURL is set. RedirectURL is the app's /login/sso/callback URL as
+// registered with the provider. Users whose verified email is in one of
+// AllowedDomains get an account on their first login instead of needing an
+// invite.
+type SSOConfig struct {
+	IssuerURL      string   `yaml:"issuer_url" toml:"issuer_url" env:"SSO_ISSUER_URL"`
+	ClientID       string   `yaml:"client_id" toml:"client_id" env:"SSO_CLIENT_ID"`
+	ClientSecret   string   `yaml:"client_secret" toml:"client_secret" env:"SSO_CLIENT_SECRET" secret:"true"`
+	RedirectURL    string   `yaml:"redirect_url" toml:"redirect_url" env:"SSO_REDIRECT_URL"`
+	AllowedDomains []string `yaml:"allowed_domains" toml:"allowed_domains" env:"SSO_ALLOWED_DOMAINS"`
+	// ButtonLabel is the text of the login page's single sign-on button.
+	ButtonLabel string `yaml:"button_label" toml:"button_label" env:"SSO_BUTTON_LABEL"`
+}
+
+// Enabled reports whether single sign-on is configured.
+func (c SSOConfig) Enabled() bool {
+	return c.IssuerURL != ""
+}
+
+// PasswordConfig is the policy for new passwords: their length in
+// characters, and whether to refuse common passwords, from a built-in list
+// and CommonListFile, and passwords containing the user's email or name.
+type PasswordConfig struct {
+	MinLength      int    `yaml:"min_length" toml:"min_length" env:"PASSWORD_MIN_LENGTH"`
+	MaxLength      int    `yaml:"max_length" toml:"max_length" env:"PASSWORD_MAX_LENGTH"`
+	RejectCommon   bool   `yaml:"reject_common" toml:"reject_common" env:"PASSWORD_REJECT_COMMON"`
+	CommonListFile string `yaml:"common_list_file" toml:"common_list_file" env:"PASSWORD_COMMON_LIST_FILE"`
+	RejectPersonal bool   `yaml:"reject_personal" toml:"reject_personal" env:"PASSWORD_REJECT_PERSONAL"`
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:               "8080",
+			ReadTimeout:        15 * time.Second,
+			WriteTimeout:       15 * time.Second,
+			IdleTimeout:        60 * time.Second,
+			ShutdownTimeout:    10 * time.Second,
+			DrainPeriod:        5 * time.Second,
+			HealthCheckTimeout: 2 * time.Second,
+			IdempotencyKeyTTL:  24 * time.Hour,
+		},
+		Mongo: MongoConfig{
+			URI:            "mongodb://localhost:27017",
+			Database:       "tasksdb",
+			ConnectTimeout: 10 * time.Second,
+		},
+		Migrations: MigrationsConfig{
+			AutoApply:   true,
+			LockTimeout: 2 * time.Minute,
+		},
+		JWT: JWTConfig{
+			Secret:             InsecureJWTSecret,
+			Expiry:             15 * time.Minute,
+			RefreshExpiry:      24 * time.Hour,
+			RememberExpiry:     30 * 24 * time.Hour,
+			KeyRefreshInterval: time.Minute,
+			VerifyLegacy:       true,
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Addr:    ":9090",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+			ServiceName: "tasks-app",
+		},
+		Admin: AdminConfig{
+			Email:    "admin@example.com",
+			Password: InsecureAdminPassword,
+			Name:     "Admin User",
+		},
+		CORS: CORSConfig{
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "If-Match", "Idempotency-Key"},
+			ExposedHeaders: []string{"ETag", "Idempotent-Replayed"},
+			MaxAge:         10 * time.Minute,
+		},
+		Security: SecurityConfig{
+			ContentSecurityPolicy: DefaultContentSecurityPolicy,
+			HSTSMaxAge:            365 * 24 * time.Hour,
+			HSTSIncludeSubdomains: true,
+			FrameOptions:          "DENY",
+			ReferrerPolicy:        "strict-origin-when-cross-origin",
+			PermissionsPolicy:     "camera=(), microphone=(), geolocation=(), payment=()",
+		},
+		Trash: TrashConfig{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Accounts: AccountsConfig{
+			DeletionGrace: 14 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer: "Task Manager",
+		},
+		SSO: SSOConfig{
+			ButtonLabel: "Sign in with SSO",
+		},
+		Password: PasswordConfig{
+			MinLength:      8,
+			MaxLength:      128,
+			RejectCommon:   true,
+			RejectPersonal: true,
+		},
+	}
+}
+
+// Load reads the configuration, as Read does, and validates the settings
+// the server uses.
+func Load(path string) (*Config, error) {
+	cfg, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Read builds the configuration from defaults, the file at path (if not
+// empty) and the environment, without validating it; tools that use only
+// some settings validate those. When path is empty the CONFIG_FILE
+// environment variable is consulted.
+func Read(path string) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return errors.New("config file must be .yaml, .yml or .toml")
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// String renders the effective configuration with secrets redacted, suitable
+// for logging on startup.
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Sprintf("<unprintable config: %v>", err)
+	}
+	return string(out)
+}
+
+// LogValue renders the redacted configuration as nested slog groups.
+func (c *Config) LogValue() slog.Value {
+	return structLogValue(reflect.ValueOf(c.Redacted()).Elem())
+}
+
+func structLogValue(v reflect.Value) slog.Value {
+	t := v.Type()
+	attrs := make([]slog.Attr, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("yaml")
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			attrs = append(attrs, slog.Attr{Key: name, Value: structLogValue(field)})
+		case field.Type() == durationType:
+			attrs = append(attrs, slog.String(name, time.Duration(field.Int()).String()))
+		default:
+			attrs = append(attrs, slog.Any(name, field.Interface()))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,276 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	minJWTSecretLength = 32
+	minPasswordLength  = 8
+	redacted           = "[REDACTED]"
+)
+
+// Validate checks the server-side settings. Insecure defaults are only
+// accepted when DevMode is enabled.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port must be a port number, got %q", c.Server.Port))
+	}
+	errs = append(errs, positiveDurations(
+		namedDuration{"server.read_timeout", c.Server.ReadTimeout},
+		namedDuration{"server.write_timeout", c.Server.WriteTimeout},
+		namedDuration{"server.idle_timeout", c.Server.IdleTimeout},
+		namedDuration{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		namedDuration{"server.health_check_timeout", c.Server.HealthCheckTimeout},
+		namedDuration{"server.idempotency_key_ttl", c.Server.IdempotencyKeyTTL},
+		namedDuration{"jwt.refresh_expiry", c.JWT.RefreshExpiry},
+		namedDuration{"jwt.remember_expiry", c.JWT.RememberExpiry},
+		namedDuration{"trash.retention", c.Trash.Retention},
+		namedDuration{"trash.purge_interval", c.Trash.PurgeInterval},
+		namedDuration{"accounts.deletion_grace", c.Accounts.DeletionGrace},
+		namedDuration{"accounts.purge_interval", c.Accounts.PurgeInterval},
+	)...)
+	errs = append(errs, c.mongoErrors()...)
+	errs = append(errs, c.signingKeyErrors()...)
+	// Access tokens are not revoked, so none may outlive a deleted account.
+	if c.Accounts.DeletionGrace < c.JWT.Expiry {
+		errs = append(errs, errors.New("accounts.deletion_grace must be at least jwt.expiry"))
+	}
+	if c.Server.DrainPeriod < 0 {
+		errs = append(errs, errors.New("server.drain_period must not be negative"))
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("cors.max_age must not be negative"))
+	}
+	if c.Security.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("security.hsts_max_age must not be negative"))
+	}
+
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.Logging.Level)) {
+		errs = append(errs, fmt.Errorf("logging.level must be debug, info, warn or error, got %q", c.Logging.Level))
+	}
+	if !slices.Contains([]string{"json", "text"}, strings.ToLower(c.Logging.Format)) {
+		errs = append(errs, fmt.Errorf("logging.format must be json or text, got %q", c.Logging.Format))
+	}
+
+	switch {
+	case c.JWT.Secret == "":
+		errs = append(errs, errors.New("jwt.secret is required"))
+	case c.DevMode:
+	case c.JWT.Secret == InsecureJWTSecret:
+		errs = append(errs, errors.New("jwt.secret uses the insecure default; set JWT_SECRET or enable DEV_MODE"))
+	case len(c.JWT.Secret) < minJWTSecretLength:
+		errs = append(errs, fmt.Errorf("jwt.secret must be at least %d characters", minJWTSecretLength))
+	}
+
+	if c.Metrics.Enabled && c.Metrics.Addr == "" && c.Metrics.Token == "" && !c.DevMode {
+		errs = append(errs, errors.New("metrics served on the main port require metrics.token; set METRICS_TOKEN or METRICS_ADDR"))
+	}
+
+	if !slices.Contains([]string{"none", "otlp", "stdout"}, strings.ToLower(c.Tracing.Exporter)) {
+		errs = append(errs, fmt.Errorf("tracing.exporter must be none, otlp or stdout, got %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+
+	if _, err := c.TwoFactor.Key(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.TwoFactor.RequireForAdmins && c.TwoFactor.EncryptionKey == "" {
+		errs = append(errs, errors.New("two_factor.require_for_admins needs two_factor.encryption_key; set TWO_FACTOR_ENCRYPTION_KEY"))
+	}
+	if c.TwoFactor.Issuer == "" {
+		errs = append(errs, errors.New("two_factor.issuer is required"))
+	}
+
+	if c.SSO.Enabled() {
+		errs = append(errs, c.SSO.validate()...)
+	}
+
+	errs = append(errs, c.Password.validate()...)
+
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		errs = append(errs, errors.New("cors.allow_credentials cannot be combined with a \"*\" origin"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c SSOConfig) validate() []error {
+	var errs []error
+	if u, err := url.Parse(c.IssuerURL); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		errs = append(errs, errors.New("sso.issuer_url must be an http(s) URL"))
+	}
+	if c.ClientID == "" {
+		errs = append(errs, errors.New("sso.client_id is required"))
+	}
+	if u, err := url.Parse(c.RedirectURL); err != nil || !u.IsAbs() || !strings.HasSuffix(u.Path, "/login/sso/callback") {
+		errs = append(errs, errors.New("sso.redirect_url must be the absolute URL of /login/sso/callback"))
+	}
+	for _, domain := range c.AllowedDomains {
+		if domain == "" || strings.ContainsAny(domain, "@/ ") {
+			errs = append(errs, fmt.Errorf("sso.allowed_domains: %q is not a domain", domain))
+		}
+	}
+	if c.ButtonLabel == "" {
+		errs = append(errs, errors.New("sso.button_label is required"))
+	}
+	return errs
+}
+
+func (c PasswordConfig) validate() []error {
+	var errs []error
+	if c.MinLength < minPasswordLength {
+		errs = append(errs, fmt.Errorf("password.min_length must be at least %d", minPasswordLength))
+	}
+	if c.MaxLength < c.MinLength {
+		errs = append(errs, errors.New("password.max_length must be at least password.min_length"))
+	}
+	return errs
+}
+
+// ValidateMongo checks the database settings, which are all the migrate
+// tool uses.
+func (c *Config) ValidateMongo() error {
+	return errors.Join(c.mongoErrors()...)
+}
+
+func (c *Config) mongoErrors() []error {
+	errs := positiveDurations(
+		namedDuration{"mongo.connect_timeout", c.Mongo.ConnectTimeout},
+		namedDuration{"migrations.lock_timeout", c.Migrations.LockTimeout},
+	)
+	if err := validateMongoURI(c.Mongo.URI); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Mongo.Database == "" {
+		errs = append(errs, errors.New("mongo.database is required"))
+	}
+	return errs
+}
+
+// ValidateSigningKeys checks the settings the keys tool uses besides the
+// database: when replicas pick up a new key, how long tokens signed with a
+// replaced one stay valid, and the key that encrypts them.
+func (c *Config) ValidateSigningKeys() error {
+	return errors.Join(c.signingKeyErrors()...)
+}
+
+func (c *Config) signingKeyErrors() []error {
+	errs := positiveDurations(
+		namedDuration{"jwt.expiry", c.JWT.Expiry},
+		namedDuration{"jwt.key_refresh_interval", c.JWT.KeyRefreshInterval},
+	)
+	if _, err := c.JWT.EncryptionKey(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ValidateAdmin checks the settings used by the seed tool to create the
+// initial admin account. The password has to pass checkPassword, the
+// password policy built from the password settings, unless it is the
+// insecure default in dev mode.
+func (c *Config) ValidateAdmin(checkPassword func(password, email, name string) error) error {
+	var errs []error
+
+	if c.Admin.Email == "" {
+		errs = append(errs, errors.New("admin.email is required"))
+	}
+	if c.Admin.Name == "" {
+		errs = append(errs, errors.New("admin.name is required"))
+	}
+	errs = append(errs, c.Password.validate()...)
+
+	switch {
+	case c.Admin.Password != InsecureAdminPassword:
+		if err := checkPassword(c.Admin.Password, c.Admin.Email, c.Admin.Name); err != nil {
+			errs = append(errs, fmt.Errorf("admin.password: %w", err))
+		}
+	case !c.DevMode:
+		errs = append(errs, errors.New("admin.password uses the insecure default; set ADMIN_PASSWORD or enable DEV_MODE"))
+	}
+
+	return errors.Join(errs...)
+}
+
+type namedDuration struct {
+	name  string
+	value time.Duration
+}
+
+func positiveDurations(durations ...namedDuration) []error {
+	var errs []error
+	for _, d := range durations {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be a positive duration", d.name))
+		}
+	}
+	return errs
+}
+
+func validateMongoURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return errors.New("mongo.uri is not a valid URI")
+	}
+	if u.Scheme != "mongodb" && u.Scheme != "mongodb+srv" {
+		return errors.New("mongo.uri must use the mongodb:// or mongodb+srv:// scheme")
+	}
+	if u.Host == "" {
+		return errors.New("mongo.uri must include a host")
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with every field tagged
+// secret replaced, and credentials stripped from URIs.
+func (c *Config) Redacted() *Config {
+	out := *c
+	redactStruct(reflect.ValueOf(&out).Elem())
+	return &out
+}
+
+func redactStruct(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			redactStruct(field)
+			continue
+		}
+		if field.Kind() != reflect.String || field.String() == "" {
+			continue
+		}
+
+		switch t.Field(i).Tag.Get("secret") {
+		case "true":
+			field.SetString(redacted)
+		case "uri":
+			field.SetString(redactURI(field.String()))
+		}
+	}
+}
+
+func redactURI(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+	}
+	return u.String()
+}
@@ -0,0 +1,303 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TaskOperationKind string
+
+const (
+	TaskCreate TaskOperationKind = "create"
+	TaskUpdate TaskOperationKind = "update"
+	TaskDelete TaskOperationKind = "delete"
+)
+
+// TaskOperation is one step of a bulk write.
+type TaskOperation struct {
+	Kind TaskOperationKind
+	// ID names the task to update or delete.
+	ID string
+	// Task is the task to create; its owner is set to the batch's user.
+	Task *models.Task
+	// Patch holds the validated fields to change on update.
+	Patch *models.TaskPatch
+	// Version, if non-zero, makes an update or delete conditional on the
+	// stored version, as for UpdateByUserID.
+	Version int64
+}
+
+// TaskOperationResult reports the outcome of one TaskOperation.
+type TaskOperationResult struct {
+	// Task is the created or updated task as stored. It is nil for
+	// deletes and failed operations.
+	Task *models.Task
+	Err  error
+}
+
+// BulkWriteByUserID applies ops to userID's tasks with a single BulkWrite.
+// Updates and deletes are checked against the tasks read beforehand and
+// written conditionally on their version, so every operation gets its own
+// result. In ordered mode the first failure stops the batch and later
+// operations fail with ErrNotAttempted. The returned error is only set
+// when the batch as a whole could not be run.
+func (r *TaskRepository) BulkWriteByUserID(ctx context.Context, userID primitive.ObjectID, ops []TaskOperation, ordered bool) ([]TaskOperationResult, error) {
+	defer metrics.ObserveMongo("tasks", "BulkWriteByUserID")()
+
+	current, err := r.findTargets(ctx, userID, ops)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]TaskOperationResult, len(ops))
+	revisions := make([]*models.TaskRevision, len(ops))
+	var writes []mongo.WriteModel
+	var planned []int // index into ops of each write
+	now := time.Now()
+	stopped := false
+	for i, op := range ops {
+		if stopped {
+			results[i].Err = ErrNotAttempted
+			continue
+		}
+		before := current[taskOperationID(op)]
+		write, task, err := planTaskOperation(op, userID, current, now)
+		if err != nil {
+			results[i].Err = err
+			stopped = ordered
+			continue
+		}
+		results[i].Task = task
+		if op.Kind == TaskUpdate {
+			revisions[i] = models.NewTaskRevision(before, task, userID)
+		}
+		writes = append(writes, write)
+		planned = append(planned, i)
+	}
+	if len(writes) == 0 {
+		return results, nil
+	}
+
+	res, err := r.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(ordered))
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return nil, err
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		results[planned[writeErr.Index]] = TaskOperationResult{Err: writeErr}
+		if ordered {
+			for _, i := range planned[writeErr.Index+1:] {
+				results[i] = TaskOperationResult{Err: ErrNotAttempted}
+			}
+		}
+	}
+
+	// BulkWrite only reports totals. If a conditional update or delete
+	// matched nothing, the task changed after it was read; re-read to
+	// find out which.
+	var updates int64
+	for _, i := range planned {
+		if results[i].Err == nil && ops[i].Kind != TaskCreate {
+			updates++
+		}
+	}
+	if res != nil && res.MatchedCount < updates {
+		if err := r.attributeMisses(ctx, userID, ops, planned, results); err != nil {
+			return nil, err
+		}
+	}
+
+	// The trashed task was only kept to check its version.
+	var applied []*models.TaskRevision
+	for i, op := range ops {
+		if op.Kind == TaskDelete {
+			results[i].Task = nil
+		}
+		if results[i].Err == nil {
+			applied = append(applied, revisions[i])
+		}
+	}
+	if err := r.recordRevisions(ctx, applied...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// taskOperationID returns the ID of the task op updates or deletes, or the
+// zero ID if there is none.
+func taskOperationID(op TaskOperation) primitive.ObjectID {
+	if op.Kind == TaskCreate {
+		return primitive.NilObjectID
+	}
+	id, _ := primitive.ObjectIDFromHex(op.ID)
+	return id
+}
+
+// findTargets loads the user's active tasks named by update and delete
+// operations.
+func (r *TaskRepository) findTargets(ctx context.Context, userID primitive.ObjectID, ops []TaskOperation) (map[primitive.ObjectID]*models.Task, error) {
+	var ids []primitive.ObjectID
+	for _, op := range ops {
+		if op.Kind == TaskCreate {
+			continue
+		}
+		if id, err := primitive.ObjectIDFromHex(op.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	current := make(map[primitive.ObjectID]*models.Task)
+	if len(ids) == 0 {
+		return current, nil
+	}
+	cursor, err := r.collection.Find(ctx, active(bson.M{"_id": bson.M{"$in": ids}, "user_id": userID}))
+	if err != nil {
+		return nil, err
+	}
+	var tasks []models.Task
+	if err := cursor.All(ctx, &tasks); err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		current[tasks[i].ID] = &tasks[i]
+	}
+	return current, nil
+}
+
+// planTaskOperation checks op against current, which tracks the effect of
+// earlier operations in the batch, and returns the write for it and the
+// resulting task.
+func planTaskOperation(op TaskOperation, userID primitive.ObjectID, current map[primitive.ObjectID]*models.Task, now time.Time) (mongo.WriteModel, *models.Task, error) {
+	switch op.Kind {
+	case TaskCreate, TaskUpdate, TaskDelete:
+	default:
+		return nil, nil, ErrInvalidOperation
+	}
+
+	if op.Kind == TaskCreate {
+		task := *op.Task
+		task.ID = primitive.NewObjectID()
+		task.UserID = userID
+		task.CreatedAt = now
+		task.UpdatedAt = now
+		task.Version = 1
+		return mongo.NewInsertOneModel().SetDocument(task), &task, nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(op.ID)
+	if err != nil {
+		return nil, nil, ErrInvalidTaskID
+	}
+	stored, ok := current[id]
+	if !ok {
+		return nil, nil, ErrTaskNotFound
+	}
+	if op.Version != 0 && op.Version != stored.Version {
+		return nil, nil, ErrTaskModified
+	}
+	filter := active(bson.M{"_id": id, "user_id": userID, "version": stored.Version})
+	updated := *stored
+	updated.Version = stored.Version + 1
+
+	if op.Kind == TaskUpdate {
+		op.Patch.Apply(&updated)
+		updated.UpdatedAt = now
+		current[id] = &updated
+
+		update := bson.M{
+			"$set": bson.M{
+				"title":       updated.Title,
+				"description": updated.Description,
+				"status":      updated.Status,
+				"due_date":    updated.DueDate,
+				"updated_at":  updated.UpdatedAt,
+			},
+			"$inc": bson.M{"version": 1},
+		}
+		result := updated
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update), &result, nil
+	}
+
+	// Deletes move the task to the trash, like DeleteByUserID.
+	delete(current, id)
+	updated.DeletedAt = &now
+	update := bson.M{
+		"$set": bson.M{"deleted_at": now},
+		"$inc": bson.M{"version": 1},
+	}
+	return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update), &updated, nil
+}
+
+// attributeMisses marks the updates and deletes that did not apply. A task
+// updated several times in the batch is judged by its final version, and
+// all of its updates fail together if that does not match.
+func (r *TaskRepository) attributeMisses(ctx context.Context, userID primitive.ObjectID, ops []TaskOperation, planned []int, results []TaskOperationResult) error {
+	var ids []primitive.ObjectID
+	last := map[primitive.ObjectID]int{} // task ID -> last op index
+	for _, i := range planned {
+		if results[i].Err != nil || ops[i].Kind == TaskCreate {
+			continue
+		}
+		id, _ := primitive.ObjectIDFromHex(ops[i].ID)
+		if _, seen := last[id]; !seen {
+			ids = append(ids, id)
+		}
+		last[id] = i
+	}
+
+	cursor, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "user_id": userID},
+		options.Find().SetProjection(bson.M{"version": 1, "deleted_at": 1}))
+	if err != nil {
+		return err
+	}
+	var docs []struct {
+		ID        primitive.ObjectID `bson:"_id"`
+		Version   int64              `bson:"version"`
+		DeletedAt *time.Time         `bson:"deleted_at"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	type state struct {
+		version int64
+		trashed bool
+	}
+	states := make(map[primitive.ObjectID]state, len(docs))
+	for _, doc := range docs {
+		states[doc.ID] = state{doc.Version, doc.DeletedAt != nil}
+	}
+
+	// The last operation on each task succeeded if the task ended up in
+	// the state it planned; trashed tasks count as gone unless this batch
+	// trashed them.
+	failed := map[primitive.ObjectID]error{}
+	for id, i := range last {
+		got, exists := states[id]
+		want := state{results[i].Task.Version, ops[i].Kind == TaskDelete}
+		switch {
+		case got == want:
+		case !exists || got.trashed:
+			failed[id] = ErrTaskNotFound
+		default:
+			failed[id] = ErrTaskModified
+		}
+	}
+	for _, i := range planned {
+		if results[i].Err != nil || ops[i].Kind == TaskCreate {
+			continue
+		}
+		id, _ := primitive.ObjectIDFromHex(ops[i].ID)
+		if err, ok := failed[id]; ok {
+			results[i] = TaskOperationResult{Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1493 @@
+// Package databasetest contains the contract every database store
+// implementation must satisfy. The same suite runs against the MongoDB
+// repositories and the in-memory stores so the two cannot drift apart.
+package databasetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Stores is one implementation of every store, sharing a database.
+type Stores struct {
+	Tasks   database.TaskStore
+	Users   database.UserStore
+	Invites database.InviteStore
+
+	Idempotency   database.IdempotencyStore
+	SigningKeys   database.SigningKeyStore
+	RefreshTokens database.RefreshTokenStore
+	Settings      database.SettingsStore
+}
+
+// Run runs the contract suite. newStores is called once per subtest and must
+// return stores backed by a fresh, empty database.
+func Run(t *testing.T, newStores func(t *testing.T) Stores) {
+	for _, tc := range []struct {
+		name string
+		fn   func(t *testing.T, s Stores)
+	}{
+		{"Tasks/Create", testTaskCreate},
+		{"Tasks/FindByID", testTaskFindByID},
+		{"Tasks/FindByUserID", testTaskFindByUserID},
+		{"Tasks/EachByUserID", testTaskEachByUserID},
+		{"Tasks/FindAll", testTaskFindAll},
+		{"Tasks/Update", testTaskUpdate},
+		{"Tasks/Version", testTaskVersion},
+		{"Tasks/BulkWrite", testTaskBulkWrite},
+		{"Tasks/BulkWriteOrdered", testTaskBulkWriteOrdered},
+		{"Tasks/Delete", testTaskDelete},
+		{"Tasks/Trash", testTaskTrash},
+		{"Tasks/PurgeDeletedBefore", testTaskPurgeDeletedBefore},
+		{"Tasks/Revisions", testTaskRevisions},
+		{"Tasks/PurgeAllByUserID", testTaskPurgeAllByUserID},
+		{"Tasks/Ownership", testTaskOwnership},
+		{"Tasks/ReturnsCopies", testTaskReturnsCopies},
+		{"Users/CreateAndFind", testUserCreateAndFind},
+		{"Users/UniqueEmail", testUserUniqueEmail},
+		{"Users/Count", testUserCount},
+		{"Users/FindAll", testUserFindAll},
+		{"Users/Deletion", testUserDeletion},
+		{"Users/SetPasswordHash", testUserSetPasswordHash},
+		{"Users/TwoFactor", testUserTwoFactor},
+		{"Users/TwoFactorAttempts", testUserTwoFactorAttempts},
+		{"Users/OIDC", testUserOIDC},
+		{"Invites/CreateAndFind", testInviteCreateAndFind},
+		{"Invites/UniqueToken", testInviteUniqueToken},
+		{"Invites/MarkUsed", testInviteMarkUsed},
+		{"Invites/FindAll", testInviteFindAll},
+		{"Invites/CountActive", testInviteCountActive},
+		{"Invites/ByInviter", testInviteByInviter},
+		{"Idempotency/Lifecycle", testIdempotencyLifecycle},
+		{"Idempotency/Expiry", testIdempotencyExpiry},
+		{"Idempotency/DeleteByUserID", testIdempotencyDeleteByUserID},
+		{"SigningKeys/Lifecycle", testSigningKeyLifecycle},
+		{"RefreshTokens/Lifecycle", testRefreshTokenLifecycle},
+		{"RefreshTokens/Delete", testRefreshTokenDelete},
+		{"Settings", testSettings},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newStores(t))
+		})
+	}
+}
+
+// Stored times lose precision (BSON dates are milliseconds), so compare
+// with a tolerance.
+func sameTime(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d < time.Millisecond && d > -time.Millisecond
+}
+
+func wantError(t *testing.T, err error, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("expected error %q, got %v", want, err)
+	}
+}
+
+func mustCreateTask(t *testing.T, s Stores, userID primitive.ObjectID, title string) *models.Task {
+	t.Helper()
+	task := &models.Task{UserID: userID, Title: title, Status: models.StatusPending}
+	if err := s.Tasks.Create(context.Background(), task); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return task
+}
+
+func testTaskCreate(t *testing.T, s Stores) {
+	ctx := context.Background()
+	due := time.Now().Add(48 * time.Hour).Truncate(time.Millisecond)
+	task := &models.Task{
+		UserID:      primitive.NewObjectID(),
+		Title:       "Write tests",
+		Description: "contract suite",
+		Status:      models.StatusInProgress,
+		DueDate:     &due,
+	}
+
+	before := time.Now()
+	if err := s.Tasks.Create(ctx, task); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if task.ID.IsZero() {
+		t.Fatal("Create did not assign an ID")
+	}
+	if task.CreatedAt.Before(before) || task.UpdatedAt.Before(before) {
+		t.Fatal("Create did not set timestamps")
+	}
+
+	got, err := s.Tasks.FindByID(ctx, task.ID.Hex())
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if got.Title != task.Title || got.Description != task.Description || got.Status != task.Status || got.UserID != task.UserID {
+		t.Fatalf("stored task = %+v, want %+v", got, task)
+	}
+	if got.DueDate == nil || !got.DueDate.Equal(due) {
+		t.Fatalf("DueDate = %v, want %v", got.DueDate, due)
+	}
+	if !sameTime(got.CreatedAt, task.CreatedAt) {
+		t.Fatalf("CreatedAt = %v, want %v", got.CreatedAt, task.CreatedAt)
+	}
+}
+
+func testTaskFindByID(t *testing.T, s Stores) {
+	ctx := context.Background()
+
+	_, err := s.Tasks.FindByID(ctx, "not-an-id")
+	wantError(t, err, database.ErrInvalidTaskID)
+
+	_, err = s.Tasks.FindByID(ctx, primitive.NewObjectID().Hex())
+	wantError(t, err, database.ErrTaskNotFound)
+	// Handlers only test for the generic kind.
+	wantError(t, err, database.ErrNotFound)
+}
+
+func testTaskFindByUserID(t *testing.T, s Stores) {
+	ctx := context.Background()
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+
+	tasks, err := s.Tasks.FindByUserID(ctx, alice)
+	if err != nil {
+		t.Fatalf("FindByUserID: %v", err)
+	}
+	if tasks == nil || len(tasks) != 0 {
+		t.Fatalf("FindByUserID on empty store = %#v, want empty non-nil slice", tasks)
+	}
+
+	mustCreateTask(t, s, alice, "a1")
+	mustCreateTask(t, s, bob, "b1")
+	mustCreateTask(t, s, alice, "a2")
+
+	tasks, err = s.Tasks.FindByUserID(ctx, alice)
+	if err != nil {
+		t.Fatalf("FindByUserID: %v", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("FindByUserID returned %d tasks, want 2", len(tasks))
+	}
+	for _, task := range tasks {
+		if task.UserID != alice {
+			t.Fatalf("FindByUserID returned a task owned by %s", task.UserID.Hex())
+		}
+	}
+}
+
+func testTaskEachByUserID(t *testing.T, s Stores) {
+	ctx := context.Background()
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	mustCreateTask(t, s, alice, "a1")
+	mustCreateTask(t, s, bob, "b1")
+	mustCreateTask(t, s, alice, "a2")
+	trashed := mustCreateTask(t, s, alice, "trashed")
+	mustCreateTask(t, s, alice, "a3")
+	if err := s.Tasks.DeleteByUserID(ctx, trashed.ID.Hex(), alice); err != nil {
+		t.Fatalf("DeleteByUserID: %v", err)
+	}
+
+	var titles []string
+	err := s.Tasks.EachByUserID(ctx, alice, func(task *models.Task) error {
+		titles = append(titles, task.Title)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachByUserID: %v", err)
+	}
+	if fmt.Sprint(titles) != "[a1 a2 a3]" {
+		t.Errorf("EachByUserID visited %v, want [a1 a2 a3]", titles)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = s.Tasks.EachByUserID(ctx, alice, func(*models.Task) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("EachByUserID after an error: %v after %d calls", err, calls)
+	}
+}
+
+func testTaskFindAll(t *testing.T, s Stores) {
+	ctx := context.Background()
+
+	tasks, err := s.Tasks.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if tasks == nil || len(tasks) != 0 {
+		t.Fatalf("FindAll on empty store = %#v, want empty non-nil slice", tasks)
+	}
+
+	mustCreateTask(t, s, primitive.NewObjectID(), "one")
+	mustCreateTask(t, s, primitive.NewObjectID(), "two")
+
+	tasks, err = s.Tasks.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("FindAll returned %d tasks, want 2", len(tasks))
+	}
+}
+
+func testTaskUpdate(t *testing.T, s Stores) {
+	ctx := context.Background()
+	due := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	owner := primitive.NewObjectID()
+	task := &models.Task{UserID: owner, Title: "old", Status: models.StatusPending, DueDate: &due}
+	if err := s.Tasks.Create(ctx, task); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Only title, description, status and due date are updatable; the
+	// owner in the update payload is ignored.
+	update := &models.Task{
+		UserID:      primitive.NewObjectID(),
+		Title:       "new",
+		Description: "changed",
+		Status:      models.StatusCompleted,
+	}
+	if err := s.Tasks.Update(ctx, task.ID.Hex(), update); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if update.UpdatedAt.IsZero() {
+		t.Fatal("Update did not set UpdatedAt on the argument")
+	}
+
+	got, err := s.Tasks.FindByID(ctx, task.ID.Hex())
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if got.Title != "new" || got.Description != "changed" || got.Status != models.StatusCompleted {
+		t.Fatalf("task after update = %+v", got)
+	}
+	if got.DueDate != nil {
+		t.Fatalf("DueDate = %v, want cleared", got.DueDate)
+	}
+	if got.UserID != owner {
+		t.Fatal("Update changed the owner")
+	}
+	if !sameTime(got.CreatedAt, task.CreatedAt) {
+		t.Fatal("Update changed CreatedAt")
+	}
+	if !sameTime(got.UpdatedAt, update.UpdatedAt) {
+		t.Fatalf("UpdatedAt = %v, want %v", got.UpdatedAt, update.UpdatedAt)
+	}
+
+	wantError(t, s.Tasks.Update(ctx, "bad", update), database.ErrInvalidTaskID)
+	wantError(t, s.Tasks.Update(ctx, primitive.NewObjectID().Hex(), update), database.ErrTaskNotFound)
+}
+
+func testTaskVersion(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner := primitive.NewObjectID()
+	task := mustCreateTask(t, s, owner, "v1")
+	if task.Version != 1 {
+		t.Fatalf("Version after Create = %d, want 1", task.Version)
+	}
+
+	// Version 0 updates unconditionally.
+	unconditional := &models.Task{Title: "v2", Status: models.StatusPending}
+	if err := s.Tasks.UpdateByUserID(ctx, task.ID.Hex(), owner, unconditional); err != nil {
+		t.Fatalf("unconditional update: %v", err)
+	}
+	if unconditional.Version != 2 {
+		t.Fatalf("Version after update = %d, want 2", unconditional.Version)
+	}
+
+	// An update based on version 1 lost the race and must not apply.
+	stale := &models.Task{Title: "stale", Status: models.StatusPending, Version: 1}
+	wantError(t, s.Tasks.UpdateByUserID(ctx, task.ID.Hex(), owner, stale), database.ErrTaskModified)
+	if !errors.Is(s.Tasks.Update(ctx, task.ID.Hex(), stale), database.ErrVersionConflict) {
+		t.Fatal("Update with a stale version did not report a version conflict")
+	}
+
+	current := &models.Task{Title: "v3", Status: models.StatusPending, Version: 2}
+	if err := s.Tasks.UpdateByUserID(ctx, task.ID.Hex(), owner, current); err != nil {
+		t.Fatalf("update with current version: %v", err)
+	}
+	got, err := s.Tasks.FindByID(ctx, task.ID.Hex())
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if got.Title != "v3" || got.Version != 3 || current.Version != 3 {
+		t.Fatalf("task = %q version %d (argument %d), want v3 version 3", got.Title, got.Version, current.Version)
+	}
+
+	// A missing task or another user's task is not found, not a conflict.
+	wantError(t, s.Tasks.UpdateByUserID(ctx, primitive.NewObjectID().Hex(), owner, &models.Task{Title: "x", Status: models.StatusPending, Version: 3}), database.ErrTaskNotFound)
+	wantError(t, s.Tasks.UpdateByUserID(ctx, task.ID.Hex(), primitive.NewObjectID(), &models.Task{Title: "x", Status: models.StatusPending, Version: 3}), database.ErrTaskNotFound)
+}
+
+func strPtr(s string) *string { return &s }
+
+func testTaskBulkWrite(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner := primitive.NewObjectID()
+	due := time.Now().Add(24 * time.Hour).Truncate(time.Millisecond)
+	kept := mustCreateTask(t, s, owner, "kept")
+	patched := mustCreateTask(t, s, owner, "patched")
+	doomed := mustCreateTask(t, s, owner, "doomed")
+	foreign := mustCreateTask(t, s, primitive.NewObjectID(), "foreign")
+
+	results, err := s.Tasks.BulkWriteByUserID(ctx, owner, []database.TaskOperation{
+		{Kind: database.TaskCreate, Task: &models.Task{Title: "created", Status: models.StatusPending}},
+		{Kind: database.TaskUpdate, ID: patched.ID.Hex(), Patch: &models.TaskPatch{Status: strPtr(models.StatusCompleted), DueDate: &due}},
+		{Kind: database.TaskUpdate, ID: patched.ID.Hex(), Patch: &models.TaskPatch{Title: strPtr("patched twice")}},
+		{Kind: database.TaskDelete, ID: doomed.ID.Hex()},
+		{Kind: database.TaskUpdate, ID: doomed.ID.Hex(), Patch: &models.TaskPatch{Title: strPtr("too late")}},
+		{Kind: database.TaskDelete, ID: foreign.ID.Hex()},
+		{Kind: database.TaskUpdate, ID: "bad", Patch: &models.TaskPatch{}},
+		{Kind: database.TaskUpdate, ID: kept.ID.Hex(), Version: 7, Patch: &models.TaskPatch{Title: strPtr("stale")}},
+		{Kind: "archive", ID: kept.ID.Hex()},
+	}, false)
+	if err != nil {
+		t.Fatalf("BulkWriteByUserID: %v", err)
+	}
+	if len(results) != 9 {
+		t.Fatalf("got %d results, want 9", len(results))
+	}
+
+	for i, want := range []error{nil, nil, nil, nil,
+		database.ErrTaskNotFound, database.ErrTaskNotFound, database.ErrInvalidTaskID,
+		database.ErrTaskModified, database.ErrInvalidOperation} {
+		if want == nil && results[i].Err != nil {
+			t.Errorf("result %d: unexpected error %v", i, results[i].Err)
+		}
+		if want != nil && !errors.Is(results[i].Err, want) {
+			t.Errorf("result %d: error = %v, want %v", i, results[i].Err, want)
+		}
+	}
+
+	created := results[0].Task
+	if created == nil || created.ID.IsZero() || created.UserID != owner || created.Version != 1 {
+		t.Fatalf("created result = %+v", created)
+	}
+	if got, err := s.Tasks.FindByIDAndUserID(ctx, created.ID.Hex(), owner); err != nil || got.Title != "created" {
+		t.Fatalf("created task = %+v, %v", got, err)
+	}
+
+	// Both updates of the same task apply in order.
+	if r := results[2].Task; r == nil || r.Version != 3 || r.Title != "patched twice" {
+		t.Fatalf("second update result = %+v", r)
+	}
+	got, err := s.Tasks.FindByID(ctx, patched.ID.Hex())
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if got.Title != "patched twice" || got.Status != models.StatusCompleted || got.DueDate == nil || !sameTime(*got.DueDate, due) || got.Version != 3 {
+		t.Fatalf("patched task = %+v", got)
+	}
+
+	_, err = s.Tasks.FindByID(ctx, doomed.ID.Hex())
+	wantError(t, err, database.ErrTaskNotFound)
+	if _, err := s.Tasks.FindByID(ctx, foreign.ID.Hex()); err != nil {
+		t.Fatal("another user's task was deleted")
+	}
+	if got, _ := s.Tasks.FindByID(ctx, kept.ID.Hex()); got.Title != "kept" || got.Version != 1 {
+		t.Fatalf("task changed by failed operations: %+v", got)
+	}
+}
+
+func testTaskBulkWriteOrdered(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner := primitive.NewObjectID()
+	first := mustCreateTask(t, s, owner, "first")
+	second := mustCreateTask(t, s, owner, "second")
+
+	results, err := s.Tasks.BulkWriteByUserID(ctx, owner, []database.TaskOperation{
+		{Kind: database.TaskUpdate, ID: first.ID.Hex(), Patch: &models.TaskPatch{Status: strPtr(models.StatusCompleted)}},
+		{Kind: database.TaskDelete, ID: primitive.NewObjectID().Hex()},
+		{Kind: database.TaskDelete, ID: second.ID.Hex()},
+	}, true)
+	if err != nil {
+		t.Fatalf("BulkWriteByUserID: %v", err)
+	}
+	if results[0].Err != nil {
+		t.Fatalf("first operation failed: %v", results[0].Err)
+	}
+	wantError(t, results[1].Err, database.ErrTaskNotFound)
+	wantError(t, results[2].Err, database.ErrNotAttempted)
+
+	if got, _ := s.Tasks.FindByID(ctx, first.ID.Hex()); got.Status != models.StatusCompleted {
+		t.Fatal("operation before the failure was not applied")
+	}
+	if _, err := s.Tasks.FindByID(ctx, second.ID.Hex()); err != nil {
+		t.Fatal("operation after the failure was applied")
+	}
+}
+
+func testTaskDelete(t *testing.T, s Stores) {
+	ctx := context.Background()
+	task := mustCreateTask(t, s, primitive.NewObjectID(), "doomed")
+
+	if err := s.Tasks.Delete(ctx, task.ID.Hex()); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, err := s.Tasks.FindByID(ctx, task.ID.Hex())
+	wantError(t, err, database.ErrTaskNotFound)
+
+	wantError(t, s.Tasks.Delete(ctx, task.ID.Hex()), database.ErrTaskNotFound)
+	wantError(t, s.Tasks.Delete(ctx, "bad"), database.ErrInvalidTaskID)
+}
+
+func testTaskTrash(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+	task := mustCreateTask(t, s, owner, "trashed")
+	kept := mustCreateTask(t, s, owner, "kept")
+	id := task.ID.Hex()
+
+	if err := s.Tasks.DeleteByUserID(ctx, id, owner); err != nil {
+		t.Fatalf("DeleteByUserID: %v", err)
+	}
+
+	// Trashed tasks are hidden from every other read and write.
+	tasks, err := s.Tasks.FindByUserID(ctx, owner)
+	if err != nil {
+		t.Fatalf("FindByUserID: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != kept.ID {
+		t.Fatalf("FindByUserID = %v, want only the kept task", tasks)
+	}
+	if all, _ := s.Tasks.FindAll(ctx); len(all) != 1 {
+		t.Errorf("FindAll returned %d tasks, want 1", len(all))
+	}
+	_, err = s.Tasks.FindByIDAndUserID(ctx, id, owner)
+	wantError(t, err, database.ErrTaskNotFound)
+	wantError(t, s.Tasks.UpdateByUserID(ctx, id, owner, &models.Task{Title: "x", Status: models.StatusPending}), database.ErrTaskNotFound)
+	wantError(t, s.Tasks.DeleteByUserID(ctx, id, owner), database.ErrTaskNotFound)
+	results, err := s.Tasks.BulkWriteByUserID(ctx, owner, []database.TaskOperation{
+		{Kind: database.TaskUpdate, ID: id, Patch: &models.TaskPatch{Title: strPtr("x")}},
+	}, false)
+	if err != nil {
+		t.Fatalf("BulkWriteByUserID: %v", err)
+	}
+	wantError(t, results[0].Err, database.ErrTaskNotFound)
+
+	trash, err := s.Tasks.FindDeletedByUserID(ctx, owner)
+	if err != nil {
+		t.Fatalf("FindDeletedByUserID: %v", err)
+	}
+	if len(trash) != 1 || trash[0].ID != task.ID || trash[0].DeletedAt == nil || trash[0].Version != 2 {
+		t.Fatalf("FindDeletedByUserID = %+v", trash)
+	}
+	if trash, _ := s.Tasks.FindDeletedByUserID(ctx, other); len(trash) != 0 {
+		t.Errorf("another user's trash = %v, want empty", trash)
+	}
+
+	wantError(t, s.Tasks.RestoreByUserID(ctx, id, other), database.ErrTaskNotFound)
+	wantError(t, s.Tasks.RestoreByUserID(ctx, kept.ID.Hex(), owner), database.ErrTaskNotFound)
+	if err := s.Tasks.RestoreByUserID(ctx, id, owner); err != nil {
+		t.Fatalf("RestoreByUserID: %v", err)
+	}
+	restored, err := s.Tasks.FindByIDAndUserID(ctx, id, owner)
+	if err != nil {
+		t.Fatalf("FindByIDAndUserID after restore: %v", err)
+	}
+	if restored.DeletedAt != nil || restored.Version != 3 || restored.Title != "trashed" {
+		t.Errorf("restored task = %+v", restored)
+	}
+
+	// Only trashed tasks can be purged.
+	wantError(t, s.Tasks.PurgeByUserID(ctx, id, owner), database.ErrTaskNotFound)
+	results, err = s.Tasks.BulkWriteByUserID(ctx, owner, []database.TaskOperation{
+		{Kind: database.TaskDelete, ID: id},
+	}, false)
+	if err != nil || results[0].Err != nil {
+		t.Fatalf("bulk delete: %v, %v", err, results[0].Err)
+	}
+	wantError(t, s.Tasks.PurgeByUserID(ctx, id, other), database.ErrTaskNotFound)
+	if err := s.Tasks.PurgeByUserID(ctx, id, owner); err != nil {
+		t.Fatalf("PurgeByUserID: %v", err)
+	}
+	if trash, _ := s.Tasks.FindDeletedByUserID(ctx, owner); len(trash) != 0 {
+		t.Errorf("trash after purge = %v, want empty", trash)
+	}
+	wantError(t, s.Tasks.RestoreByUserID(ctx, id, owner), database.ErrTaskNotFound)
+	wantError(t, s.Tasks.PurgeByUserID(ctx, "bad", owner), database.ErrInvalidTaskID)
+}
+
+func testTaskPurgeDeletedBefore(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner := primitive.NewObjectID()
+	old := mustCreateTask(t, s, owner, "old")
+	recent := mustCreateTask(t, s, owner, "recent")
+	mustCreateTask(t, s, owner, "active")
+
+	if err := s.Tasks.DeleteByUserID(ctx, old.ID.Hex(), owner); err != nil {
+		t.Fatalf("DeleteByUserID: %v", err)
+	}
+	// BSON dates have millisecond precision.
+	time.Sleep(5 * time.Millisecond)
+	cutoff := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	if err := s.Tasks.DeleteByUserID(ctx, recent.ID.Hex(), owner); err != nil {
+		t.Fatalf("DeleteByUserID: %v", err)
+	}
+
+	purged, err := s.Tasks.PurgeDeletedBefore(ctx, cutoff)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedBefore = %d, %v; want 1", purged, err)
+	}
+	trash, err := s.Tasks.FindDeletedByUserID(ctx, owner)
+	if err != nil {
+		t.Fatalf("FindDeletedByUserID: %v", err)
+	}
+	if len(trash) != 1 || trash[0].ID != recent.ID {
+		t.Errorf("trash after purge = %v, want only the recent task", trash)
+	}
+	if tasks, _ := s.Tasks.FindByUserID(ctx, owner); len(tasks) != 1 {
+		t.Errorf("purge removed active tasks: %v", tasks)
+	}
+}
+
+func testTaskRevisions(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+	task := mustCreateTask(t, s, owner, "draft")
+	id := task.ID.Hex()
+	due := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	update := &models.Task{Title: "final", Description: task.Description, Status: task.Status, DueDate: &due}
+	if err := s.Tasks.UpdateByUserID(ctx, id, owner, update); err != nil {
+		t.Fatalf("UpdateByUserID: %v", err)
+	}
+	// An update that changes nothing records no revision.
+	if err := s.Tasks.UpdateByUserID(ctx, id, owner, update); err != nil {
+		t.Fatalf("UpdateByUserID: %v", err)
+	}
+	results, err := s.Tasks.BulkWriteByUserID(ctx, owner, []database.TaskOperation{
+		{Kind: database.TaskUpdate, ID: id, Patch: &models.TaskPatch{Status: strPtr(models.StatusCompleted), ClearDueDate: true}},
+	}, true)
+	if err != nil || results[0].Err != nil {
+		t.Fatalf("bulk update: %v, %v", err, results[0].Err)
+	}
+	if err := s.Tasks.Update(ctx, id, &models.Task{Title: "admin", Status: models.StatusCompleted}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	revisions, err := s.Tasks.FindRevisionsByUserID(ctx, id, owner)
+	if err != nil {
+		t.Fatalf("FindRevisionsByUserID: %v", err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("got %d revisions, want 3: %+v", len(revisions), revisions)
+	}
+	for i, want := range []struct {
+		version int64
+		editor  primitive.ObjectID
+		fields  []string
+	}{
+		{5, primitive.NilObjectID, []string{"title"}},
+		{4, owner, []string{"status", "due_date"}},
+		{2, owner, []string{"title", "due_date"}},
+	} {
+		rev := revisions[i]
+		var fields []string
+		for _, change := range rev.Changes {
+			fields = append(fields, change.Field)
+		}
+		if rev.ID.IsZero() || rev.TaskID != task.ID || rev.UserID != owner || rev.EditorID != want.editor ||
+			rev.Version != want.version || fmt.Sprint(fields) != fmt.Sprint(want.fields) {
+			t.Errorf("revision %d = %+v, want version %d by %v changing %v", i, rev, want.version, want.editor, want.fields)
+		}
+	}
+	if change := revisions[2].Changes[0]; *change.Old != "draft" || *change.New != "final" {
+		t.Errorf("title change = %q -> %q", *change.Old, *change.New)
+	}
+	if change := revisions[1].Changes[1]; change.Old == nil || change.New != nil {
+		t.Errorf("clearing the due date recorded %v -> %v", change.Old, change.New)
+	}
+
+	// Undoing the later revisions gives the task as it was.
+	current, err := s.Tasks.FindByIDAndUserID(ctx, id, owner)
+	if err != nil {
+		t.Fatalf("FindByIDAndUserID: %v", err)
+	}
+	then, err := models.TaskAtRevision(*current, revisions, 2)
+	if err != nil {
+		t.Fatalf("TaskAtRevision: %v", err)
+	}
+	if then.Title != "final" || then.Status != models.StatusPending || then.DueDate == nil || !then.DueDate.Equal(due) {
+		t.Errorf("task at version 2 = %+v", then)
+	}
+
+	if revisions, _ := s.Tasks.FindRevisionsByUserID(ctx, id, other); len(revisions) != 0 {
+		t.Errorf("another user sees %d revisions", len(revisions))
+	}
+	_, err = s.Tasks.FindRevisionsByUserID(ctx, "bad", owner)
+	wantError(t, err, database.ErrInvalidTaskID)
+
+	// Purging the task deletes its history.
+	if err := s.Tasks.DeleteByUserID(ctx, id, owner); err != nil {
+		t.Fatalf("DeleteByUserID: %v", err)
+	}
+	if err := s.Tasks.PurgeByUserID(ctx, id, owner); err != nil {
+		t.Fatalf("PurgeByUserID: %v", err)
+	}
+	if revisions, _ := s.Tasks.FindRevisionsByUserID(ctx, id, owner); len(revisions) != 0 {
+		t.Errorf("purged task still has %d revisions", len(revisions))
+	}
+}
+
+func testTaskPurgeAllByUserID(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+	first := mustCreateTask(t, s, owner, "first")
+	second := mustCreateTask(t, s, owner, "second")
+	kept := mustCreateTask(t, s, other, "kept")
+	for _, task := range []*models.Task{second, first, kept} {
+		update := &models.Task{Title: task.Title + " edited", Status: task.Status}
+		if err := s.Tasks.UpdateByUserID(ctx, task.ID.Hex(), task.UserID, update); err != nil {
+			t.Fatalf("UpdateByUserID: %v", err)
+		}
+	}
+	if err := s.Tasks.UpdateByUserID(ctx, first.ID.Hex(), owner, &models.Task{Title: "again", Status: first.Status}); err != nil {
+		t.Fatalf("UpdateByUserID: %v", err)
+	}
+	if err := s.Tasks.DeleteByUserID(ctx, second.ID.Hex(), owner); err != nil {
+		t.Fatalf("DeleteByUserID: %v", err)
+	}
+
+	revisions, err := s.Tasks.FindAllRevisionsByUserID(ctx, owner)
+	if err != nil {
+		t.Fatalf("FindAllRevisionsByUserID: %v", err)
+	}
+	var got []string
+	for _, rev := range revisions {
+		got = append(got, fmt.Sprintf("%s/%d", rev.TaskID.Hex(), rev.Version))
+	}
+	want := []string{first.ID.Hex() + "/2", first.ID.Hex() + "/3", second.ID.Hex() + "/2"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("FindAllRevisionsByUserID = %v, want %v (trashed tasks included, by task then version)", got, want)
+	}
+
+	purged, err := s.Tasks.PurgeAllByUserID(ctx, owner)
+	if err != nil {
+		t.Fatalf("PurgeAllByUserID: %v", err)
+	}
+	if purged != 2 {
+		t.Fatalf("PurgeAllByUserID = %d, want 2", purged)
+	}
+	if tasks, _ := s.Tasks.FindByUserID(ctx, owner); len(tasks) != 0 {
+		t.Fatalf("active tasks left: %v", tasks)
+	}
+	if tasks, _ := s.Tasks.FindDeletedByUserID(ctx, owner); len(tasks) != 0 {
+		t.Fatalf("trashed tasks left: %v", tasks)
+	}
+	if revisions, _ := s.Tasks.FindAllRevisionsByUserID(ctx, owner); len(revisions) != 0 {
+		t.Fatalf("revisions left: %v", revisions)
+	}
+
+	if _, err := s.Tasks.FindByIDAndUserID(ctx, kept.ID.Hex(), other); err != nil {
+		t.Fatalf("another user's task was purged: %v", err)
+	}
+	if revisions, _ := s.Tasks.FindAllRevisionsByUserID(ctx, other); len(revisions) != 1 {
+		t.Fatalf("another user's revisions = %v, want 1", revisions)
+	}
+}
+
+func testTaskOwnership(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+	task := mustCreateTask(t, s, owner, "mine")
+	id := task.ID.Hex()
+
+	if _, err := s.Tasks.FindByIDAndUserID(ctx, id, owner); err != nil {
+		t.Fatalf("FindByIDAndUserID as owner: %v", err)
+	}
+	_, err := s.Tasks.FindByIDAndUserID(ctx, id, other)
+	wantError(t, err, database.ErrTaskNotFound)
+	_, err = s.Tasks.FindByIDAndUserID(ctx, "bad", owner)
+	wantError(t, err, database.ErrInvalidTaskID)
+
+	update := &models.Task{Title: "stolen", Status: models.StatusPending}
+	wantError(t, s.Tasks.UpdateByUserID(ctx, id, other, update), database.ErrTaskNotFound)
+	wantError(t, s.Tasks.DeleteByUserID(ctx, id, other), database.ErrTaskNotFound)
+
+	got, err := s.Tasks.FindByID(ctx, id)
+	if err != nil {
+		t.Fatalf("task disappeared after another user's delete: %v", err)
+	}
+	if got.Title != "mine" {
+		t.Fatalf("another user's update changed the title to %q", got.Title)
+	}
+
+	update.Title = "renamed"
+	if err := s.Tasks.UpdateByUserID(ctx, id, owner, update); err != nil {
+		t.Fatalf("UpdateByUserID as owner: %v", err)
+	}
+	if err := s.Tasks.DeleteByUserID(ctx, id, owner); err != nil {
+		t.Fatalf("DeleteByUserID as owner: %v", err)
+	}
+}
+
+func testTaskReturnsCopies(t *testing.T, s Stores) {
+	ctx := context.Background()
+	due := time.Now().Truncate(time.Millisecond)
+	task := &models.Task{UserID: primitive.NewObjectID(), Title: "original", Status: models.StatusPending, DueDate: &due}
+	if err := s.Tasks.Create(ctx, task); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Mutating the argument or a result must not change what is stored.
+	wantDue := due
+	task.Title = "mutated"
+	*task.DueDate = due.Add(time.Hour)
+
+	got, err := s.Tasks.FindByID(ctx, task.ID.Hex())
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	got.Title = "mutated"
+
+	again, err := s.Tasks.FindByID(ctx, task.ID.Hex())
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if again.Title != "original" || !again.DueDate.Equal(wantDue) {
+		t.Fatalf("stored task was mutated: %+v", again)
+	}
+}
+
+func testUserCreateAndFind(t *testing.T, s Stores) {
+	ctx := context.Background()
+	user := &models.User{Email: "ada@example.com", PasswordHash: "hash", Name: "Ada", Role: models.RoleUser}
+	if err := s.Users.Create(ctx, user); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if user.ID.IsZero() || user.CreatedAt.IsZero() {
+		t.Fatal("Create did not assign ID and timestamps")
+	}
+
+	byEmail, err := s.Users.FindByEmail(ctx, "ada@example.com")
+	if err != nil {
+		t.Fatalf("FindByEmail: %v", err)
+	}
+	if byEmail.ID != user.ID || byEmail.PasswordHash != "hash" || byEmail.Name != "Ada" || byEmail.Role != models.RoleUser {
+		t.Fatalf("FindByEmail = %+v", byEmail)
+	}
+
+	byID, err := s.Users.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if byID.Email != user.Email {
+		t.Fatalf("FindByID = %+v", byID)
+	}
+
+	_, err = s.Users.FindByEmail(ctx, "nobody@example.com")
+	wantError(t, err, database.ErrUserNotFound)
+	_, err = s.Users.FindByID(ctx, primitive.NewObjectID())
+	wantError(t, err, database.ErrUserNotFound)
+}
+
+func testUserUniqueEmail(t *testing.T, s Stores) {
+	ctx := context.Background()
+	first := &models.User{Email: "dup@example.com", Name: "First", Role: models.RoleUser}
+	if err := s.Users.Create(ctx, first); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	second := &models.User{Email: "dup@example.com", Name: "Second", Role: models.RoleUser}
+	wantError(t, s.Users.Create(ctx, second), database.ErrEmailExists)
+
+	count, err := s.Users.Count(ctx)
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("Count = %d after rejected duplicate, want 1", count)
+	}
+}
+
+func testUserCount(t *testing.T, s Stores) {
+	ctx := context.Background()
+	for i, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		count, err := s.Users.Count(ctx)
+		if err != nil {
+			t.Fatalf("Count: %v", err)
+		}
+		if count != int64(i) {
+			t.Fatalf("Count = %d, want %d", count, i)
+		}
+		if err := s.Users.Create(ctx, &models.User{Email: email, Name: "U", Role: models.RoleUser}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+}
+
+func testUserFindAll(t *testing.T, s Stores) {
+	ctx := context.Background()
+	users, err := s.Users.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if users == nil || len(users) != 0 {
+		t.Fatalf("FindAll on empty store = %#v, want empty non-nil slice", users)
+	}
+
+	var want []primitive.ObjectID
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		user := &models.User{Email: email, Name: "U", Role: models.RoleUser}
+		if err := s.Users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		want = append(want, user.ID)
+	}
+	users, err = s.Users.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(users) != 2 || users[0].ID != want[0] || users[1].ID != want[1] {
+		t.Fatalf("FindAll = %+v, want users in creation order", users)
+	}
+}
+
+func testUserDeletion(t *testing.T, s Stores) {
+	ctx := context.Background()
+	var users []*models.User
+	for _, email := range []string{"due@example.com", "later@example.com", "kept@example.com"} {
+		user := &models.User{Email: email, Name: "U", Role: models.RoleUser}
+		if err := s.Users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		users = append(users, user)
+	}
+	due, later, kept := users[0], users[1], users[2]
+
+	// A deletion that is due can no longer be cancelled.
+	if err := s.Users.ScheduleDeletion(ctx, due.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("ScheduleDeletion: %v", err)
+	}
+	wantError(t, s.Users.CancelDeletion(ctx, due.ID), database.ErrUserNotFound)
+
+	at := time.Now().Add(time.Hour)
+	if err := s.Users.ScheduleDeletion(ctx, later.ID, at); err != nil {
+		t.Fatalf("ScheduleDeletion: %v", err)
+	}
+	stored, err := s.Users.FindByID(ctx, later.ID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if stored.DeletionScheduledAt == nil || !sameTime(*stored.DeletionScheduledAt, at) || !stored.DeletionPending() {
+		t.Fatalf("DeletionScheduledAt = %v, want %v", stored.DeletionScheduledAt, at)
+	}
+	wantError(t, s.Users.ScheduleDeletion(ctx, primitive.NewObjectID(), at), database.ErrUserNotFound)
+
+	dueUsers, err := s.Users.FindDueForDeletion(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("FindDueForDeletion: %v", err)
+	}
+	if len(dueUsers) != 1 || dueUsers[0].ID != due.ID {
+		t.Fatalf("FindDueForDeletion = %+v, want only the due user", dueUsers)
+	}
+
+	if err := s.Users.CancelDeletion(ctx, later.ID); err != nil {
+		t.Fatalf("CancelDeletion: %v", err)
+	}
+	if stored, _ := s.Users.FindByID(ctx, later.ID); stored.DeletionScheduledAt != nil {
+		t.Fatalf("DeletionScheduledAt = %v after cancelling", stored.DeletionScheduledAt)
+	}
+	wantError(t, s.Users.CancelDeletion(ctx, kept.ID), database.ErrUserNotFound)
+
+	if err := s.Users.Delete(ctx, due.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, err = s.Users.FindByID(ctx, due.ID)
+	wantError(t, err, database.ErrUserNotFound)
+	wantError(t, s.Users.Delete(ctx, due.ID), database.ErrUserNotFound)
+	// The email can be registered again.
+	if err := s.Users.Create(ctx, &models.User{Email: due.Email, Name: "New", Role: models.RoleUser}); err != nil {
+		t.Fatalf("Create with a deleted user's email: %v", err)
+	}
+}
+
+func testUserSetPasswordHash(t *testing.T, s Stores) {
+	ctx := context.Background()
+	user := &models.User{Email: "ada@example.com", Name: "Ada", PasswordHash: "old-hash", Role: models.RoleUser}
+	if err := s.Users.Create(ctx, user); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err := s.Users.SetPasswordHash(ctx, user.ID, "new-hash"); err != nil {
+		t.Fatalf("SetPasswordHash: %v", err)
+	}
+	found, err := s.Users.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if found.PasswordHash != "new-hash" {
+		t.Errorf("PasswordHash = %q, want new-hash", found.PasswordHash)
+	}
+	wantError(t, s.Users.SetPasswordHash(ctx, primitive.NewObjectID(), "x"), database.ErrUserNotFound)
+}
+
+func testUserTwoFactor(t *testing.T, s Stores) {
+	ctx := context.Background()
+	user := &models.User{Email: "totp@example.com", Name: "U", Role: models.RoleUser}
+	if err := s.Users.Create(ctx, user); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	missing := primitive.NewObjectID()
+
+	// Enrolling may restart until a code is confirmed.
+	for _, secret := range []string{"first", "second"} {
+		if err := s.Users.SetTOTPSecret(ctx, user.ID, secret); err != nil {
+			t.Fatalf("SetTOTPSecret: %v", err)
+		}
+	}
+	wantError(t, s.Users.UseTOTPStep(ctx, user.ID, 1), database.ErrCodeUsed)
+	if err := s.Users.EnableTwoFactor(ctx, user.ID, 10, []string{"a", "b"}); err != nil {
+		t.Fatalf("EnableTwoFactor: %v", err)
+	}
+	stored, err := s.Users.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if !stored.TwoFactorEnabled || stored.TOTPSecret != "second" || stored.TOTPLastStep != 10 || len(stored.RecoveryCodes) != 2 {
+		t.Fatalf("after EnableTwoFactor: %+v", stored)
+	}
+	wantError(t, s.Users.SetTOTPSecret(ctx, user.ID, "third"), database.ErrTwoFactorEnabled)
+	wantError(t, s.Users.EnableTwoFactor(ctx, user.ID, 11, nil), database.ErrTwoFactorEnabled)
+	wantError(t, s.Users.SetTOTPSecret(ctx, missing, "secret"), database.ErrUserNotFound)
+
+	// Time steps only move forward.
+	wantError(t, s.Users.UseTOTPStep(ctx, user.ID, 10), database.ErrCodeUsed)
+	if err := s.Users.UseTOTPStep(ctx, user.ID, 11); err != nil {
+		t.Fatalf("UseTOTPStep: %v", err)
+	}
+	wantError(t, s.Users.UseTOTPStep(ctx, user.ID, 11), database.ErrCodeUsed)
+	wantError(t, s.Users.UseTOTPStep(ctx, missing, 12), database.ErrUserNotFound)
+
+	// Recovery codes work once.
+	if err := s.Users.UseRecoveryCode(ctx, user.ID, "a"); err != nil {
+		t.Fatalf("UseRecoveryCode: %v", err)
+	}
+	wantError(t, s.Users.UseRecoveryCode(ctx, user.ID, "a"), database.ErrCodeUsed)
+	if stored, _ := s.Users.FindByID(ctx, user.ID); len(stored.RecoveryCodes) != 1 || stored.RecoveryCodes[0] != "b" {
+		t.Fatalf("RecoveryCodes = %v, want [b]", stored.RecoveryCodes)
+	}
+	if err := s.Users.SetRecoveryCodes(ctx, user.ID, []string{"c"}); err != nil {
+		t.Fatalf("SetRecoveryCodes: %v", err)
+	}
+	wantError(t, s.Users.UseRecoveryCode(ctx, user.ID, "b"), database.ErrCodeUsed)
+
+	if err := s.Users.DisableTwoFactor(ctx, user.ID); err != nil {
+		t.Fatalf("DisableTwoFactor: %v", err)
+	}
+	stored, _ = s.Users.FindByID(ctx, user.ID)
+	if stored.TwoFactorEnabled || stored.TOTPSecret != "" || stored.TOTPLastStep != 0 || len(stored.RecoveryCodes) != 0 {
+		t.Fatalf("after DisableTwoFactor: %+v", stored)
+	}
+	wantError(t, s.Users.UseRecoveryCode(ctx, user.ID, "c"), database.ErrCodeUsed)
+	wantError(t, s.Users.SetRecoveryCodes(ctx, user.ID, []string{"d"}), database.ErrUserNotFound)
+	wantError(t, s.Users.DisableTwoFactor(ctx, missing), database.ErrUserNotFound)
+}
+
+func testUserTwoFactorAttempts(t *testing.T, s Stores) {
+	ctx := context.Background()
+	user := &models.User{Email: "attempts@example.com", Name: "U", Role: models.RoleUser}
+	if err := s.Users.Create(ctx, user); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	now := time.Now().Truncate(time.Millisecond)
+	window := 10 * time.Minute
+
+	for i := range 3 {
+		if err := s.Users.AddTwoFactorAttempt(ctx, user.ID, now.Add(time.Duration(i)*time.Minute), 3, window); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+	}
+	wantError(t, s.Users.AddTwoFactorAttempt(ctx, user.ID, now.Add(5*time.Minute), 3, window), database.ErrTooManyAttempts)
+	if stored, _ := s.Users.FindByID(ctx, user.ID); len(stored.TwoFactorAttempts) != 3 {
+		t.Fatalf("TwoFactorAttempts = %v, want the 3 allowed", stored.TwoFactorAttempts)
+	}
+	// Once the oldest attempt leaves the window there is room for one
+	// more, and only the latest attempts are kept.
+	if err := s.Users.AddTwoFactorAttempt(ctx, user.ID, now.Add(window), 3, window); err != nil {
+		t.Fatalf("attempt after the window: %v", err)
+	}
+	wantError(t, s.Users.AddTwoFactorAttempt(ctx, user.ID, now.Add(window), 3, window), database.ErrTooManyAttempts)
+	if stored, _ := s.Users.FindByID(ctx, user.ID); len(stored.TwoFactorAttempts) != 3 || !sameTime(stored.TwoFactorAttempts[2], now.Add(window)) {
+		t.Fatalf("TwoFactorAttempts = %v, want the latest 3", stored.TwoFactorAttempts)
+	}
+
+	if err := s.Users.ResetTwoFactorAttempts(ctx, user.ID); err != nil {
+		t.Fatalf("ResetTwoFactorAttempts: %v", err)
+	}
+	if err := s.Users.AddTwoFactorAttempt(ctx, user.ID, now.Add(window), 3, window); err != nil {
+		t.Fatalf("attempt after reset: %v", err)
+	}
+
+	missing := primitive.NewObjectID()
+	wantError(t, s.Users.AddTwoFactorAttempt(ctx, missing, now, 3, window), database.ErrUserNotFound)
+	wantError(t, s.Users.ResetTwoFactorAttempts(ctx, missing), database.ErrUserNotFound)
+}
+
+func testUserOIDC(t *testing.T, s Stores) {
+	ctx := context.Background()
+	const issuer = "https://idp.example.com"
+	user := &models.User{Email: "sso@example.com", Name: "U", Role: models.RoleUser}
+	other := &models.User{Email: "other@example.com", Name: "O", Role: models.RoleUser}
+	for _, u := range []*models.User{user, other} {
+		if err := s.Users.Create(ctx, u); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	_, err := s.Users.FindByOIDCSubject(ctx, issuer, "sub-1")
+	wantError(t, err, database.ErrUserNotFound)
+	if err := s.Users.LinkOIDC(ctx, user.ID, issuer, "sub-1"); err != nil {
+		t.Fatalf("LinkOIDC: %v", err)
+	}
+	// Linking again to the same account is a no-op.
+	if err := s.Users.LinkOIDC(ctx, user.ID, issuer, "sub-1"); err != nil {
+		t.Fatalf("LinkOIDC again: %v", err)
+	}
+	found, err := s.Users.FindByOIDCSubject(ctx, issuer, "sub-1")
+	if err != nil {
+		t.Fatalf("FindByOIDCSubject: %v", err)
+	}
+	if found.ID != user.ID || found.OIDCIssuer != issuer || found.OIDCSubject != "sub-1" {
+		t.Fatalf("FindByOIDCSubject = %+v", found)
+	}
+	_, err = s.Users.FindByOIDCSubject(ctx, "https://other.example.com", "sub-1")
+	wantError(t, err, database.ErrUserNotFound)
+
+	// A user is linked to one account, and an account to one user.
+	wantError(t, s.Users.LinkOIDC(ctx, user.ID, issuer, "sub-2"), database.ErrAccountLinked)
+	wantError(t, s.Users.LinkOIDC(ctx, other.ID, issuer, "sub-1"), database.ErrAccountLinked)
+	wantError(t, s.Users.LinkOIDC(ctx, primitive.NewObjectID(), issuer, "sub-3"), database.ErrUserNotFound)
+}
+
+func newInvite(token string, expiresIn time.Duration) *models.Invite {
+	return &models.Invite{
+		Token:     token,
+		Email:     token + "@example.com",
+		InvitedBy: primitive.NewObjectID(),
+		ExpiresAt: time.Now().Add(expiresIn),
+	}
+}
+
+func testInviteCreateAndFind(t *testing.T, s Stores) {
+	ctx := context.Background()
+	invite := newInvite("tok1", time.Hour)
+	if err := s.Invites.Create(ctx, invite); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if invite.ID.IsZero() || invite.CreatedAt.IsZero() {
+		t.Fatal("Create did not assign ID and CreatedAt")
+	}
+
+	got, err := s.Invites.FindByToken(ctx, "tok1")
+	if err != nil {
+		t.Fatalf("FindByToken: %v", err)
+	}
+	if got.ID != invite.ID || got.Email != invite.Email || got.InvitedBy != invite.InvitedBy || got.UsedAt != nil {
+		t.Fatalf("FindByToken = %+v", got)
+	}
+	if !sameTime(got.ExpiresAt, invite.ExpiresAt) {
+		t.Fatalf("ExpiresAt = %v, want %v", got.ExpiresAt, invite.ExpiresAt)
+	}
+	if !got.IsValid() {
+		t.Fatal("fresh invite is not valid")
+	}
+
+	_, err = s.Invites.FindByToken(ctx, "missing")
+	wantError(t, err, database.ErrInviteNotFound)
+}
+
+func testInviteUniqueToken(t *testing.T, s Stores) {
+	ctx := context.Background()
+	if err := s.Invites.Create(ctx, newInvite("same", time.Hour)); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	wantError(t, s.Invites.Create(ctx, newInvite("same", time.Hour)), database.ErrInviteTokenExists)
+}
+
+func testInviteMarkUsed(t *testing.T, s Stores) {
+	ctx := context.Background()
+	if err := s.Invites.Create(ctx, newInvite("use-me", time.Hour)); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err := s.Invites.MarkUsed(ctx, "use-me"); err != nil {
+		t.Fatalf("MarkUsed: %v", err)
+	}
+	got, err := s.Invites.FindByToken(ctx, "use-me")
+	if err != nil {
+		t.Fatalf("FindByToken: %v", err)
+	}
+	if got.UsedAt == nil || got.IsValid() {
+		t.Fatalf("invite still valid after MarkUsed: %+v", got)
+	}
+
+	wantError(t, s.Invites.MarkUsed(ctx, "missing"), database.ErrInviteNotFound)
+}
+
+func testInviteFindAll(t *testing.T, s Stores) {
+	ctx := context.Background()
+
+	invites, err := s.Invites.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if invites == nil || len(invites) != 0 {
+		t.Fatalf("FindAll on empty store = %#v, want empty non-nil slice", invites)
+	}
+
+	for _, token := range []string{"x", "y"} {
+		if err := s.Invites.Create(ctx, newInvite(token, time.Hour)); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	invites, err = s.Invites.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(invites) != 2 {
+		t.Fatalf("FindAll returned %d invites, want 2", len(invites))
+	}
+}
+
+func testInviteCountActive(t *testing.T, s Stores) {
+	ctx := context.Background()
+	for _, invite := range []*models.Invite{
+		newInvite("active", time.Hour),
+		newInvite("expired", -time.Hour),
+		newInvite("used", time.Hour),
+	} {
+		if err := s.Invites.Create(ctx, invite); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	if err := s.Invites.MarkUsed(ctx, "used"); err != nil {
+		t.Fatalf("MarkUsed: %v", err)
+	}
+
+	count, err := s.Invites.CountActive(ctx)
+	if err != nil {
+		t.Fatalf("CountActive: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("CountActive = %d, want 1", count)
+	}
+}
+
+func testInviteByInviter(t *testing.T, s Stores) {
+	ctx := context.Background()
+	inviter, other := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, tc := range []struct {
+		token     string
+		invitedBy primitive.ObjectID
+		email     string
+	}{
+		{"sent-1", inviter, "one@example.com"},
+		{"sent-2", inviter, "two@example.com"},
+		{"received", other, "inviter@example.com"},
+		{"unrelated", other, "three@example.com"},
+	} {
+		invite := newInvite(tc.token, time.Hour)
+		invite.InvitedBy = tc.invitedBy
+		invite.Email = tc.email
+		if err := s.Invites.Create(ctx, invite); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	invites, err := s.Invites.FindByInviter(ctx, inviter)
+	if err != nil {
+		t.Fatalf("FindByInviter: %v", err)
+	}
+	if len(invites) != 2 || invites[0].Token != "sent-1" || invites[1].Token != "sent-2" {
+		t.Fatalf("FindByInviter = %+v, want the two sent invites, oldest first", invites)
+	}
+	if invites, _ := s.Invites.FindByInviter(ctx, primitive.NewObjectID()); invites == nil || len(invites) != 0 {
+		t.Fatalf("FindByInviter without invites = %#v, want empty non-nil slice", invites)
+	}
+
+	deleted, err := s.Invites.DeleteByInviterOrEmail(ctx, inviter, "inviter@example.com")
+	if err != nil {
+		t.Fatalf("DeleteByInviterOrEmail: %v", err)
+	}
+	if deleted != 3 {
+		t.Fatalf("DeleteByInviterOrEmail = %d, want 3", deleted)
+	}
+	if invites, _ := s.Invites.FindAll(ctx); len(invites) != 1 || invites[0].Token != "unrelated" {
+		t.Fatalf("invites left = %+v, want only the unrelated one", invites)
+	}
+}
+
+func newIdempotencyRecord(userID primitive.ObjectID, key string, expiresIn time.Duration) *models.IdempotencyRecord {
+	return &models.IdempotencyRecord{
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: "fp-" + key,
+		ExpiresAt:   time.Now().Add(expiresIn),
+	}
+}
+
+func testIdempotencyLifecycle(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+
+	record := newIdempotencyRecord(owner, "k1", time.Hour)
+	if err := s.Idempotency.Reserve(ctx, record); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	wantError(t, s.Idempotency.Reserve(ctx, newIdempotencyRecord(owner, "k1", time.Hour)), database.ErrConflict)
+	// Keys are scoped to their user.
+	if err := s.Idempotency.Reserve(ctx, newIdempotencyRecord(other, "k1", time.Hour)); err != nil {
+		t.Fatalf("Reserve for another user: %v", err)
+	}
+
+	found, err := s.Idempotency.FindByKey(ctx, owner, "k1")
+	if err != nil {
+		t.Fatalf("FindByKey: %v", err)
+	}
+	if found.Completed() || found.Fingerprint != "fp-k1" {
+		t.Fatalf("reserved record = %+v", found)
+	}
+
+	record.Status = http.StatusCreated
+	record.Header = http.Header{"Content-Type": {"application/json"}, "Etag": {`"1"`}}
+	record.Body = []byte(`{"id":1}`)
+	if err := s.Idempotency.Complete(ctx, record); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	found, err = s.Idempotency.FindByKey(ctx, owner, "k1")
+	if err != nil {
+		t.Fatalf("FindByKey: %v", err)
+	}
+	if found.Status != http.StatusCreated || found.Header.Get("ETag") != `"1"` || !bytes.Equal(found.Body, record.Body) {
+		t.Fatalf("completed record = %+v", found)
+	}
+
+	if err := s.Idempotency.Release(ctx, owner, "k1"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	_, err = s.Idempotency.FindByKey(ctx, owner, "k1")
+	wantError(t, err, database.ErrNotFound)
+	wantError(t, s.Idempotency.Release(ctx, owner, "k1"), database.ErrNotFound)
+	wantError(t, s.Idempotency.Complete(ctx, record), database.ErrNotFound)
+	if err := s.Idempotency.Reserve(ctx, newIdempotencyRecord(owner, "k1", time.Hour)); err != nil {
+		t.Fatalf("Reserve after Release: %v", err)
+	}
+}
+
+func testIdempotencyExpiry(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner := primitive.NewObjectID()
+
+	if err := s.Idempotency.Reserve(ctx, newIdempotencyRecord(owner, "old", -time.Minute)); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	_, err := s.Idempotency.FindByKey(ctx, owner, "old")
+	wantError(t, err, database.ErrNotFound)
+
+	// An expired record the TTL monitor has not removed yet does not
+	// block the key.
+	if err := s.Idempotency.Reserve(ctx, newIdempotencyRecord(owner, "old", time.Hour)); err != nil {
+		t.Fatalf("Reserve over expired record: %v", err)
+	}
+	if _, err := s.Idempotency.FindByKey(ctx, owner, "old"); err != nil {
+		t.Fatalf("FindByKey: %v", err)
+	}
+}
+
+func testIdempotencyDeleteByUserID(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, record := range []*models.IdempotencyRecord{
+		newIdempotencyRecord(owner, "a", time.Hour),
+		newIdempotencyRecord(owner, "b", time.Hour),
+		newIdempotencyRecord(other, "a", time.Hour),
+	} {
+		if err := s.Idempotency.Reserve(ctx, record); err != nil {
+			t.Fatalf("Reserve: %v", err)
+		}
+	}
+
+	if err := s.Idempotency.DeleteByUserID(ctx, owner); err != nil {
+		t.Fatalf("DeleteByUserID: %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		_, err := s.Idempotency.FindByKey(ctx, owner, key)
+		wantError(t, err, database.ErrIdempotencyKeyNotFound)
+	}
+	if _, err := s.Idempotency.FindByKey(ctx, other, "a"); err != nil {
+		t.Fatalf("another user's record was deleted: %v", err)
+	}
+}
+
+func testSigningKeyLifecycle(t *testing.T, s Stores) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Millisecond)
+	next := &models.SigningKey{ID: "next", Algorithm: "ES256", PrivateKey: []byte("next-key"), ActivatesAt: now.Add(time.Hour)}
+	current := &models.SigningKey{ID: "current", Algorithm: "RS256", PrivateKey: []byte("current-key"), Sealed: true, ActivatesAt: now}
+	for _, key := range []*models.SigningKey{next, current} {
+		if err := s.SigningKeys.Create(ctx, key); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if key.CreatedAt.IsZero() {
+			t.Error("Create did not set CreatedAt")
+		}
+	}
+	wantError(t, s.SigningKeys.Create(ctx, &models.SigningKey{ID: "next", Algorithm: "HS256"}), database.ErrSigningKeyExists)
+
+	// Keys are listed in the order they activate.
+	keys, err := s.SigningKeys.FindAll(ctx)
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "current" || keys[1].ID != "next" {
+		t.Fatalf("FindAll = %+v, want current then next", keys)
+	}
+	if keys[0].Algorithm != "RS256" || !bytes.Equal(keys[0].PrivateKey, []byte("current-key")) || !keys[0].Sealed || keys[1].Sealed ||
+		!keys[0].ActivatesAt.Equal(now) {
+		t.Errorf("stored key = %+v", keys[0])
+	}
+
+	if err := s.SigningKeys.Delete(ctx, "current"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	wantError(t, s.SigningKeys.Delete(ctx, "current"), database.ErrSigningKeyNotFound)
+	if keys, _ := s.SigningKeys.FindAll(ctx); len(keys) != 1 || keys[0].ID != "next" {
+		t.Errorf("after Delete: %+v", keys)
+	}
+}
+
+func newRefreshToken(userID, familyID primitive.ObjectID, hash string, expiresIn time.Duration) *models.RefreshToken {
+	return &models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(expiresIn),
+	}
+}
+
+func testRefreshTokenLifecycle(t *testing.T, s Stores) {
+	ctx := context.Background()
+	userID, familyID := primitive.NewObjectID(), primitive.NewObjectID()
+
+	token := newRefreshToken(userID, familyID, "hash-1", time.Hour)
+	token.Persistent = true
+	if err := s.RefreshTokens.Create(ctx, token); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if token.ID.IsZero() || token.CreatedAt.IsZero() {
+		t.Fatalf("Create did not set ID and CreatedAt: %+v", token)
+	}
+
+	found, err := s.RefreshTokens.FindByHash(ctx, "hash-1")
+	if err != nil {
+		t.Fatalf("FindByHash: %v", err)
+	}
+	if found.ID != token.ID || found.FamilyID != familyID || !found.Persistent || found.UsedAt != nil {
+		t.Fatalf("found token = %+v", found)
+	}
+	_, err = s.RefreshTokens.FindByHash(ctx, "hash-2")
+	wantError(t, err, database.ErrRefreshTokenNotFound)
+
+	// A token is used only once; the used token is kept, so that using it
+	// again can be detected.
+	if err := s.RefreshTokens.MarkUsed(ctx, token.ID); err != nil {
+		t.Fatalf("MarkUsed: %v", err)
+	}
+	wantError(t, s.RefreshTokens.MarkUsed(ctx, token.ID), database.ErrRefreshTokenUsed)
+	wantError(t, s.RefreshTokens.MarkUsed(ctx, primitive.NewObjectID()), database.ErrRefreshTokenNotFound)
+	found, err = s.RefreshTokens.FindByHash(ctx, "hash-1")
+	if err != nil {
+		t.Fatalf("FindByHash after MarkUsed: %v", err)
+	}
+	if found.UsedAt == nil {
+		t.Error("MarkUsed did not set UsedAt")
+	}
+
+	// Expired tokens are treated as absent.
+	if err := s.RefreshTokens.Create(ctx, newRefreshToken(userID, familyID, "expired", -time.Minute)); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, err = s.RefreshTokens.FindByHash(ctx, "expired")
+	wantError(t, err, database.ErrRefreshTokenNotFound)
+}
+
+func testRefreshTokenDelete(t *testing.T, s Stores) {
+	ctx := context.Background()
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+	family, otherFamily := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, token := range []*models.RefreshToken{
+		newRefreshToken(owner, family, "a1", time.Hour),
+		newRefreshToken(owner, family, "a2", time.Hour),
+		newRefreshToken(owner, otherFamily, "b1", time.Hour),
+		newRefreshToken(other, primitive.NewObjectID(), "c1", time.Hour),
+	} {
+		if err := s.RefreshTokens.Create(ctx, token); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	if err := s.RefreshTokens.DeleteFamily(ctx, family); err != nil {
+		t.Fatalf("DeleteFamily: %v", err)
+	}
+	for _, hash := range []string{"a1", "a2"} {
+		_, err := s.RefreshTokens.FindByHash(ctx, hash)
+		wantError(t, err, database.ErrRefreshTokenNotFound)
+	}
+	if _, err := s.RefreshTokens.FindByHash(ctx, "b1"); err != nil {
+		t.Fatalf("another family's token was deleted: %v", err)
+	}
+
+	if err := s.RefreshTokens.DeleteByUserID(ctx, owner); err != nil {
+		t.Fatalf("DeleteByUserID: %v", err)
+	}
+	_, err := s.RefreshTokens.FindByHash(ctx, "b1")
+	wantError(t, err, database.ErrRefreshTokenNotFound)
+	if _, err := s.RefreshTokens.FindByHash(ctx, "c1"); err != nil {
+		t.Fatalf("another user's token was deleted: %v", err)
+	}
+}
+
+func testSettings(t *testing.T, s Stores) {
+	ctx := context.Background()
+	settings, err := s.Settings.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if settings.RequireTwoFactorForAdmins || !settings.UpdatedAt.IsZero() {
+		t.Fatalf("before Save: %+v, want the zero settings", settings)
+	}
+
+	for _, require := range []bool{true, false, true} {
+		before := time.Now().Add(-time.Millisecond)
+		if err := s.Settings.Save(ctx, &models.Settings{RequireTwoFactorForAdmins: require}); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		settings, err = s.Settings.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if settings.RequireTwoFactorForAdmins != require || settings.UpdatedAt.Before(before) {
+			t.Fatalf("after saving %v: %+v", require, settings)
+		}
+	}
+
+	// The returned settings are a copy.
+	settings.RequireTwoFactorForAdmins = false
+	if stored, _ := s.Settings.Get(ctx); !stored.RequireTwoFactorForAdmins {
+		t.Error("changing the returned settings changed the stored ones")
+	}
+}
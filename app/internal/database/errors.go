@@ -0,0 +1,55 @@
+package database
+
+import "errors"
+
+// Generic error kinds returned by every store. Handlers test for these with
+// errors.Is to choose a response status.
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrInvalidID = errors.New("invalid ID")
+	ErrConflict  = errors.New("conflict")
+	// ErrVersionConflict means a conditional update lost to a concurrent
+	// one: the stored version no longer matches the expected version.
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrNotAttempted is the result of bulk operations skipped because an
+	// earlier operation in an ordered batch failed.
+	ErrNotAttempted = errors.New("not attempted: an earlier operation in the batch failed")
+	// ErrInvalidOperation is returned for a bulk operation of unknown kind.
+	ErrInvalidOperation = errors.New("invalid operation")
+	// ErrRateLimited means too many attempts were made recently; the
+	// same request may succeed later.
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// Entity-specific errors. Each wraps one of the generic kinds, so
+// errors.Is(err, ErrNotFound) holds for ErrTaskNotFound as well.
+var (
+	ErrTaskNotFound      = &kindError{"task not found", ErrNotFound}
+	ErrInvalidTaskID     = &kindError{"invalid task ID", ErrInvalidID}
+	ErrTaskModified      = &kindError{"task was modified concurrently", ErrVersionConflict}
+	ErrUserNotFound      = &kindError{"user not found", ErrNotFound}
+	ErrEmailExists       = &kindError{"email already exists", ErrConflict}
+	ErrInviteNotFound    = &kindError{"invite not found", ErrNotFound}
+	ErrInviteTokenExists = &kindError{"invite token already exists", ErrConflict}
+	ErrTwoFactorEnabled  = &kindError{"two-factor authentication already enabled", ErrConflict}
+	ErrCodeUsed          = &kindError{"one-time code already used", ErrConflict}
+	ErrTooManyAttempts   = &kindError{"too many two-factor attempts", ErrRateLimited}
+	ErrAccountLinked     = &kindError{"account already linked to another identity", ErrConflict}
+
+	ErrIdempotencyKeyNotFound = &kindError{"idempotency key not found", ErrNotFound}
+	ErrIdempotencyKeyExists   = &kindError{"idempotency key already used", ErrConflict}
+
+	ErrSigningKeyNotFound = &kindError{"signing key not found", ErrNotFound}
+	ErrSigningKeyExists   = &kindError{"signing key ID already used", ErrConflict}
+
+	ErrRefreshTokenNotFound = &kindError{"refresh token not found", ErrNotFound}
+	ErrRefreshTokenUsed     = &kindError{"refresh token already used", ErrConflict}
+)
+
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IdempotencyRepository stores Idempotency-Key records. A unique index on
+// (user_id, key) makes Reserve atomic, and a TTL index on expires_at
+// removes old records.
+type IdempotencyRepository struct {
+	collection *mongo.Collection
+}
+
+func NewIdempotencyRepository(client *mongo.Client, dbName string) *IdempotencyRepository {
+	collection := client.Database(dbName).Collection("idempotency_keys")
+	return &IdempotencyRepository{
+		collection: collection,
+	}
+}
+
+func (r *IdempotencyRepository) Reserve(ctx context.Context, record *models.IdempotencyRecord) error {
+	defer metrics.ObserveMongo("idempotency_keys", "Reserve")()
+
+	record.ID = primitive.NewObjectID()
+	record.CreatedAt = time.Now()
+
+	_, err := r.collection.InsertOne(ctx, record)
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	// The TTL monitor only runs once a minute, so the existing record may
+	// have expired without being removed yet. Remove it and retry once.
+	result, err := r.collection.DeleteOne(ctx, bson.M{
+		"user_id":    record.UserID,
+		"key":        record.Key,
+		"expires_at": bson.M{"$lte": record.CreatedAt},
+	})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrIdempotencyKeyExists
+	}
+	if _, err := r.collection.InsertOne(ctx, record); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrIdempotencyKeyExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *IdempotencyRepository) FindByKey(ctx context.Context, userID primitive.ObjectID, key string) (*models.IdempotencyRecord, error) {
+	defer metrics.ObserveMongo("idempotency_keys", "FindByKey")()
+
+	var record models.IdempotencyRecord
+	err := r.collection.FindOne(ctx, bson.M{
+		"user_id":    userID,
+		"key":        key,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&record)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrIdempotencyKeyNotFound
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *IdempotencyRepository) Complete(ctx context.Context, record *models.IdempotencyRecord) error {
+	defer metrics.ObserveMongo("idempotency_keys", "Complete")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"user_id": record.UserID, "key": record.Key},
+		bson.M{"$set": bson.M{
+			"status": record.Status,
+			"header": record.Header,
+			"body":   record.Body,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrIdempotencyKeyNotFound
+	}
+	return nil
+}
+
+func (r *IdempotencyRepository) Release(ctx context.Context, userID primitive.ObjectID, key string) error {
+	defer metrics.ObserveMongo("idempotency_keys", "Release")()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"user_id": userID, "key": key})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrIdempotencyKeyNotFound
+	}
+	return nil
+}
+
+func (r *IdempotencyRepository) DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error {
+	defer metrics.ObserveMongo("idempotency_keys", "DeleteByUserID")()
+
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -24,10 +25,15 @@ func NewInviteRepository(client *mongo.Client, dbName string) *InviteRepository
 }
 
 func (r *InviteRepository) Create(ctx context.Context, invite *models.Invite) error {
+	defer metrics.ObserveMongo("invites", "Create")()
+
 	invite.CreatedAt = time.Now()
 
 	result, err := r.collection.InsertOne(ctx, invite)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrInviteTokenExists
+		}
 		return err
 	}
 
@@ -36,11 +42,13 @@ func (r *InviteRepository) Create(ctx context.Context, invite *models.Invite) er
 }
 
 func (r *InviteRepository) FindByToken(ctx context.Context, token string) (*models.Invite, error) {
+	defer metrics.ObserveMongo("invites", "FindByToken")()
+
 	var invite models.Invite
 	err := r.collection.FindOne(ctx, bson.M{"token": token}).Decode(&invite)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("invite not found")
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInviteNotFound
 		}
 		return nil, err
 	}
@@ -48,6 +56,8 @@ func (r *InviteRepository) FindByToken(ctx context.Context, token string) (*mode
 }
 
 func (r *InviteRepository) MarkUsed(ctx context.Context, token string) error {
+	defer metrics.ObserveMongo("invites", "MarkUsed")()
+
 	now := time.Now()
 	result, err := r.collection.UpdateOne(
 		ctx,
@@ -58,12 +68,14 @@ func (r *InviteRepository) MarkUsed(ctx context.Context, token string) error {
 		return err
 	}
 	if result.MatchedCount == 0 {
-		return errors.New("invite not found")
+		return ErrInviteNotFound
 	}
 	return nil
 }
 
 func (r *InviteRepository) FindAll(ctx context.Context) ([]models.Invite, error) {
+	defer metrics.ObserveMongo("invites", "FindAll")()
+
 	cursor, err := r.collection.Find(ctx, bson.M{})
 	if err != nil {
 		return nil, err
@@ -82,10 +94,42 @@ func (r *InviteRepository) FindAll(ctx context.Context) ([]models.Invite, error)
 	return invites, nil
 }
 
-func (r *InviteRepository) CreateIndexes(ctx context.Context) error {
-	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "token", Value: 1}},
-		Options: options.Index().SetUnique(true),
+func (r *InviteRepository) CountActive(ctx context.Context) (int64, error) {
+	defer metrics.ObserveMongo("invites", "CountActive")()
+
+	return r.collection.CountDocuments(ctx, bson.M{
+		"used_at":    bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now()},
 	})
-	return err
+}
+
+func (r *InviteRepository) FindByInviter(ctx context.Context, inviterID primitive.ObjectID) ([]models.Invite, error) {
+	defer metrics.ObserveMongo("invites", "FindByInviter")()
+
+	cursor, err := r.collection.Find(ctx,
+		bson.M{"invited_by": inviterID},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	invites := []models.Invite{}
+	if err = cursor.All(ctx, &invites); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+func (r *InviteRepository) DeleteByInviterOrEmail(ctx context.Context, inviterID primitive.ObjectID, email string) (int64, error) {
+	defer metrics.ObserveMongo("invites", "DeleteByInviterOrEmail")()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"$or": bson.A{
+		bson.M{"invited_by": inviterID},
+		bson.M{"email": email},
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
 }
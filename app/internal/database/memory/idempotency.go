@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type idempotencyKey struct {
+	userID primitive.ObjectID
+	key    string
+}
+
+type IdempotencyRepository struct {
+	mu      sync.Mutex
+	records map[idempotencyKey]models.IdempotencyRecord
+}
+
+var _ database.IdempotencyStore = (*IdempotencyRepository)(nil)
+
+func NewIdempotencyRepository() *IdempotencyRepository {
+	return &IdempotencyRepository{
+		records: make(map[idempotencyKey]models.IdempotencyRecord),
+	}
+}
+
+func (r *IdempotencyRepository) Reserve(ctx context.Context, record *models.IdempotencyRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record.CreatedAt = time.Now()
+	// Mirrors the unique index on (user_id, key); expired records are
+	// replaced as if the TTL index had removed them.
+	k := idempotencyKey{record.UserID, record.Key}
+	if existing, ok := r.records[k]; ok && existing.ExpiresAt.After(record.CreatedAt) {
+		return database.ErrIdempotencyKeyExists
+	}
+	record.ID = primitive.NewObjectID()
+
+	r.records[k] = copyIdempotencyRecord(*record)
+	return nil
+}
+
+func (r *IdempotencyRepository) FindByKey(ctx context.Context, userID primitive.ObjectID, key string) (*models.IdempotencyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.records[idempotencyKey{userID, key}]
+	if !ok || !record.ExpiresAt.After(time.Now()) {
+		return nil, database.ErrIdempotencyKeyNotFound
+	}
+	record = copyIdempotencyRecord(record)
+	return &record, nil
+}
+
+func (r *IdempotencyRepository) Complete(ctx context.Context, record *models.IdempotencyRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := idempotencyKey{record.UserID, record.Key}
+	stored, ok := r.records[k]
+	if !ok {
+		return database.ErrIdempotencyKeyNotFound
+	}
+	stored.Status = record.Status
+	stored.Header = record.Header
+	stored.Body = record.Body
+
+	r.records[k] = copyIdempotencyRecord(stored)
+	return nil
+}
+
+func (r *IdempotencyRepository) Release(ctx context.Context, userID primitive.ObjectID, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := idempotencyKey{userID, key}
+	if _, ok := r.records[k]; !ok {
+		return database.ErrIdempotencyKeyNotFound
+	}
+	delete(r.records, k)
+	return nil
+}
+
+func (r *IdempotencyRepository) DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for k := range r.records {
+		if k.userID == userID {
+			delete(r.records, k)
+		}
+	}
+	return nil
+}
+
+func copyIdempotencyRecord(record models.IdempotencyRecord) models.IdempotencyRecord {
+	record.Header = record.Header.Clone()
+	record.Body = bytes.Clone(record.Body)
+	return record
+}
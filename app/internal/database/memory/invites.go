@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type InviteRepository struct {
+	mu      sync.RWMutex
+	invites map[string]models.Invite // keyed by token
+}
+
+var _ database.InviteStore = (*InviteRepository)(nil)
+
+func NewInviteRepository() *InviteRepository {
+	return &InviteRepository{
+		invites: make(map[string]models.Invite),
+	}
+}
+
+func (r *InviteRepository) Create(ctx context.Context, invite *models.Invite) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Mirrors the unique index on token.
+	if _, exists := r.invites[invite.Token]; exists {
+		return database.ErrInviteTokenExists
+	}
+
+	invite.CreatedAt = time.Now()
+	if invite.ID.IsZero() {
+		invite.ID = primitive.NewObjectID()
+	}
+
+	r.invites[invite.Token] = copyInvite(*invite)
+	return nil
+}
+
+func (r *InviteRepository) FindByToken(ctx context.Context, token string) (*models.Invite, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	invite, ok := r.invites[token]
+	if !ok {
+		return nil, database.ErrInviteNotFound
+	}
+	invite = copyInvite(invite)
+	return &invite, nil
+}
+
+func (r *InviteRepository) MarkUsed(ctx context.Context, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invite, ok := r.invites[token]
+	if !ok {
+		return database.ErrInviteNotFound
+	}
+	now := time.Now()
+	invite.UsedAt = &now
+	r.invites[token] = invite
+	return nil
+}
+
+func (r *InviteRepository) FindAll(ctx context.Context) ([]models.Invite, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	invites := make([]models.Invite, 0, len(r.invites))
+	for _, i := range r.invites {
+		invites = append(invites, copyInvite(i))
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		return bytes.Compare(invites[i].ID[:], invites[j].ID[:]) < 0
+	})
+	return invites, nil
+}
+
+func (r *InviteRepository) CountActive(ctx context.Context) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	var count int64
+	for _, i := range r.invites {
+		if i.UsedAt == nil && i.ExpiresAt.After(now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *InviteRepository) FindByInviter(ctx context.Context, inviterID primitive.ObjectID) ([]models.Invite, error) {
+	invites, _ := r.FindAll(ctx)
+	return slices.DeleteFunc(invites, func(i models.Invite) bool { return i.InvitedBy != inviterID }), nil
+}
+
+func (r *InviteRepository) DeleteByInviterOrEmail(ctx context.Context, inviterID primitive.ObjectID, email string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for token, i := range r.invites {
+		if i.InvitedBy == inviterID || i.Email == email {
+			delete(r.invites, token)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func copyInvite(i models.Invite) models.Invite {
+	i.UsedAt = copyTime(i.UsedAt)
+	return i
+}
@@ -0,0 +1,22 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/databasetest"
+)
+
+func TestContract(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) databasetest.Stores {
+		return databasetest.Stores{
+			Tasks:   NewTaskRepository(),
+			Users:   NewUserRepository(),
+			Invites: NewInviteRepository(),
+
+			Idempotency:   NewIdempotencyRepository(),
+			SigningKeys:   NewSigningKeyRepository(),
+			RefreshTokens: NewRefreshTokenRepository(),
+			Settings:      NewSettingsRepository(),
+		}
+	})
+}
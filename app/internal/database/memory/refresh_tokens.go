@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RefreshTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[primitive.ObjectID]models.RefreshToken
+}
+
+var _ database.RefreshTokenStore = (*RefreshTokenRepository)(nil)
+
+func NewRefreshTokenRepository() *RefreshTokenRepository {
+	return &RefreshTokenRepository{
+		tokens: make(map[primitive.ObjectID]models.RefreshToken),
+	}
+}
+
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token.ID = primitive.NewObjectID()
+	token.CreatedAt = time.Now()
+	r.tokens[token.ID] = copyRefreshToken(*token)
+	return nil
+}
+
+func (r *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range r.tokens {
+		if token.TokenHash == hash && token.ExpiresAt.After(now) {
+			token = copyRefreshToken(token)
+			return &token, nil
+		}
+	}
+	return nil, database.ErrRefreshTokenNotFound
+}
+
+func (r *RefreshTokenRepository) MarkUsed(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[id]
+	if !ok {
+		return database.ErrRefreshTokenNotFound
+	}
+	if token.UsedAt != nil {
+		return database.ErrRefreshTokenUsed
+	}
+	now := time.Now()
+	token.UsedAt = &now
+	r.tokens[id] = token
+	return nil
+}
+
+func (r *RefreshTokenRepository) DeleteFamily(ctx context.Context, familyID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if token.FamilyID == familyID {
+			delete(r.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if token.UserID == userID {
+			delete(r.tokens, id)
+		}
+	}
+	return nil
+}
+
+func copyRefreshToken(token models.RefreshToken) models.RefreshToken {
+	if token.UsedAt != nil {
+		usedAt := *token.UsedAt
+		token.UsedAt = &usedAt
+	}
+	return token
+}
@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+)
+
+type SettingsRepository struct {
+	mu       sync.RWMutex
+	settings models.Settings
+}
+
+var _ database.SettingsStore = (*SettingsRepository)(nil)
+
+func NewSettingsRepository() *SettingsRepository {
+	return &SettingsRepository{}
+}
+
+func (r *SettingsRepository) Get(ctx context.Context) (*models.Settings, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	settings := r.settings
+	return &settings, nil
+}
+
+func (r *SettingsRepository) Save(ctx context.Context, settings *models.Settings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	settings.UpdatedAt = time.Now()
+	r.settings = *settings
+	return nil
+}
@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+)
+
+type SigningKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[string]models.SigningKey
+}
+
+var _ database.SigningKeyStore = (*SigningKeyRepository)(nil)
+
+func NewSigningKeyRepository() *SigningKeyRepository {
+	return &SigningKeyRepository{
+		keys: make(map[string]models.SigningKey),
+	}
+}
+
+func (r *SigningKeyRepository) Create(ctx context.Context, key *models.SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.keys[key.ID]; exists {
+		return database.ErrSigningKeyExists
+	}
+
+	key.CreatedAt = time.Now()
+	stored := *key
+	stored.PrivateKey = bytes.Clone(key.PrivateKey)
+	r.keys[key.ID] = stored
+	return nil
+}
+
+func (r *SigningKeyRepository) FindAll(ctx context.Context) ([]models.SigningKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]models.SigningKey, 0, len(r.keys))
+	for _, k := range r.keys {
+		k.PrivateKey = bytes.Clone(k.PrivateKey)
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].ActivatesAt.Equal(keys[j].ActivatesAt) {
+			return keys[i].ActivatesAt.Before(keys[j].ActivatesAt)
+		}
+		return strings.Compare(keys[i].ID, keys[j].ID) < 0
+	})
+	return keys, nil
+}
+
+func (r *SigningKeyRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.keys[id]; !ok {
+		return database.ErrSigningKeyNotFound
+	}
+	delete(r.keys, id)
+	return nil
+}
@@ -0,0 +1,390 @@
+// Package memory provides thread-safe in-memory implementations of the
+// database stores with the same semantics as the MongoDB repositories. It is
+// intended for tests and local experiments; nothing is persisted.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TaskRepository struct {
+	mu        sync.RWMutex
+	tasks     map[primitive.ObjectID]models.Task
+	revisions []models.TaskRevision
+}
+
+var _ database.TaskStore = (*TaskRepository)(nil)
+
+func NewTaskRepository() *TaskRepository {
+	return &TaskRepository{
+		tasks: make(map[primitive.ObjectID]models.Task),
+	}
+}
+
+func (r *TaskRepository) Create(ctx context.Context, task *models.Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	task.CreatedAt = time.Now()
+	task.UpdatedAt = time.Now()
+	task.Version = 1
+	if task.ID.IsZero() {
+		task.ID = primitive.NewObjectID()
+	}
+
+	r.tasks[task.ID] = copyTask(*task)
+	return nil
+}
+
+func (r *TaskRepository) FindAll(ctx context.Context) ([]models.Task, error) {
+	return r.filter(func(t models.Task) bool { return t.DeletedAt == nil }), nil
+}
+
+func (r *TaskRepository) FindByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Task, error) {
+	return r.filter(func(t models.Task) bool { return t.UserID == userID && t.DeletedAt == nil }), nil
+}
+
+func (r *TaskRepository) EachByUserID(ctx context.Context, userID primitive.ObjectID, fn func(*models.Task) error) error {
+	for _, task := range r.filter(func(t models.Task) bool { return t.UserID == userID && t.DeletedAt == nil }) {
+		if err := fn(&task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *TaskRepository) FindByID(ctx context.Context, id string) (*models.Task, error) {
+	return r.find(id, nil)
+}
+
+func (r *TaskRepository) FindByIDAndUserID(ctx context.Context, id string, userID primitive.ObjectID) (*models.Task, error) {
+	return r.find(id, &userID)
+}
+
+func (r *TaskRepository) Update(ctx context.Context, id string, task *models.Task) error {
+	return r.update(id, nil, task, primitive.NilObjectID)
+}
+
+func (r *TaskRepository) UpdateByUserID(ctx context.Context, id string, userID primitive.ObjectID, task *models.Task) error {
+	return r.update(id, &userID, task, userID)
+}
+
+func (r *TaskRepository) Delete(ctx context.Context, id string) error {
+	return r.delete(id, nil)
+}
+
+func (r *TaskRepository) DeleteByUserID(ctx context.Context, id string, userID primitive.ObjectID) error {
+	return r.delete(id, &userID)
+}
+
+// filter returns copies of matching tasks in creation order.
+func (r *TaskRepository) filter(match func(models.Task) bool) []models.Task {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tasks := []models.Task{}
+	for _, t := range r.tasks {
+		if match(t) {
+			tasks = append(tasks, copyTask(t))
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return bytes.Compare(tasks[i].ID[:], tasks[j].ID[:]) < 0
+	})
+	return tasks
+}
+
+// lookup returns the active task with id, optionally owned by userID. The
+// caller must hold the lock.
+func (r *TaskRepository) lookup(id string, userID *primitive.ObjectID) (models.Task, error) {
+	return r.lookupIn(id, userID, false)
+}
+
+// lookupIn is lookup for tasks in the trash (trashed) or out of it.
+func (r *TaskRepository) lookupIn(id string, userID *primitive.ObjectID, trashed bool) (models.Task, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return models.Task{}, database.ErrInvalidTaskID
+	}
+
+	task, ok := r.tasks[objectID]
+	if !ok || (userID != nil && task.UserID != *userID) || (task.DeletedAt != nil) != trashed {
+		return models.Task{}, database.ErrTaskNotFound
+	}
+	return task, nil
+}
+
+func (r *TaskRepository) find(id string, userID *primitive.ObjectID) (*models.Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	task, err := r.lookup(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	task = copyTask(task)
+	return &task, nil
+}
+
+func (r *TaskRepository) update(id string, userID *primitive.ObjectID, task *models.Task, editorID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.lookup(id, userID)
+	if err != nil {
+		return err
+	}
+	if task.Version != 0 && task.Version != stored.Version {
+		return database.ErrTaskModified
+	}
+
+	task.UpdatedAt = time.Now()
+	task.Version = stored.Version + 1
+
+	// Same fields as the $set in the Mongo repository.
+	before := stored
+	stored.Title = task.Title
+	stored.Description = task.Description
+	stored.Status = task.Status
+	stored.DueDate = copyTime(task.DueDate)
+	stored.UpdatedAt = task.UpdatedAt
+	stored.Version = task.Version
+
+	r.tasks[stored.ID] = stored
+	r.record(models.NewTaskRevision(&before, &stored, editorID))
+	return nil
+}
+
+// record stores revision unless it is nil; r.mu must be held.
+func (r *TaskRepository) record(revision *models.TaskRevision) {
+	if revision == nil {
+		return
+	}
+	revision.ID = primitive.NewObjectID()
+	r.revisions = append(r.revisions, *revision)
+}
+
+func (r *TaskRepository) FindRevisionsByUserID(ctx context.Context, id string, userID primitive.ObjectID) ([]models.TaskRevision, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, database.ErrInvalidTaskID
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revisions := []models.TaskRevision{}
+	for i := len(r.revisions) - 1; i >= 0; i-- {
+		if rev := r.revisions[i]; rev.TaskID == objectID && rev.UserID == userID {
+			rev.Changes = slices.Clone(rev.Changes)
+			revisions = append(revisions, rev)
+		}
+	}
+	return revisions, nil
+}
+
+func (r *TaskRepository) FindAllRevisionsByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.TaskRevision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revisions := []models.TaskRevision{}
+	for _, rev := range r.revisions {
+		if rev.UserID == userID {
+			rev.Changes = slices.Clone(rev.Changes)
+			revisions = append(revisions, rev)
+		}
+	}
+	sort.SliceStable(revisions, func(i, j int) bool {
+		if c := bytes.Compare(revisions[i].TaskID[:], revisions[j].TaskID[:]); c != 0 {
+			return c < 0
+		}
+		return revisions[i].Version < revisions[j].Version
+	})
+	return revisions, nil
+}
+
+// deleteRevisions removes the history of a purged task; r.mu must be held.
+func (r *TaskRepository) deleteRevisions(taskID primitive.ObjectID) {
+	r.revisions = slices.DeleteFunc(r.revisions, func(rev models.TaskRevision) bool {
+		return rev.TaskID == taskID
+	})
+}
+
+func (r *TaskRepository) delete(id string, userID *primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	task, err := r.lookup(id, userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	task.DeletedAt = &now
+	task.Version++
+	r.tasks[task.ID] = task
+	return nil
+}
+
+func (r *TaskRepository) FindDeletedByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Task, error) {
+	tasks := r.filter(func(t models.Task) bool { return t.UserID == userID && t.DeletedAt != nil })
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].DeletedAt.After(*tasks[j].DeletedAt)
+	})
+	return tasks, nil
+}
+
+func (r *TaskRepository) RestoreByUserID(ctx context.Context, id string, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	task, err := r.lookupIn(id, &userID, true)
+	if err != nil {
+		return err
+	}
+	task.DeletedAt = nil
+	task.Version++
+	r.tasks[task.ID] = task
+	return nil
+}
+
+func (r *TaskRepository) PurgeByUserID(ctx context.Context, id string, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	task, err := r.lookupIn(id, &userID, true)
+	if err != nil {
+		return err
+	}
+	delete(r.tasks, task.ID)
+	r.deleteRevisions(task.ID)
+	return nil
+}
+
+func (r *TaskRepository) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged int64
+	for id, task := range r.tasks {
+		if task.DeletedAt != nil && task.DeletedAt.Before(cutoff) {
+			delete(r.tasks, id)
+			r.deleteRevisions(id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (r *TaskRepository) PurgeAllByUserID(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged int64
+	for id, task := range r.tasks {
+		if task.UserID == userID {
+			delete(r.tasks, id)
+			purged++
+		}
+	}
+	r.revisions = slices.DeleteFunc(r.revisions, func(rev models.TaskRevision) bool {
+		return rev.UserID == userID
+	})
+	return purged, nil
+}
+
+// BulkWriteByUserID applies ops one after another under a single lock,
+// with the same per-operation results as the Mongo repository.
+func (r *TaskRepository) BulkWriteByUserID(ctx context.Context, userID primitive.ObjectID, ops []database.TaskOperation, ordered bool) ([]database.TaskOperationResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]database.TaskOperationResult, len(ops))
+	now := time.Now()
+	stopped := false
+	for i, op := range ops {
+		if stopped {
+			results[i].Err = database.ErrNotAttempted
+			continue
+		}
+		task, err := r.apply(op, userID, now)
+		if err != nil {
+			results[i].Err = err
+			stopped = ordered
+			continue
+		}
+		results[i].Task = task
+	}
+	return results, nil
+}
+
+// apply runs one bulk operation; r.mu must be held.
+func (r *TaskRepository) apply(op database.TaskOperation, userID primitive.ObjectID, now time.Time) (*models.Task, error) {
+	switch op.Kind {
+	case database.TaskCreate, database.TaskUpdate, database.TaskDelete:
+	default:
+		return nil, database.ErrInvalidOperation
+	}
+
+	if op.Kind == database.TaskCreate {
+		task := copyTask(*op.Task)
+		task.ID = primitive.NewObjectID()
+		task.UserID = userID
+		task.CreatedAt = now
+		task.UpdatedAt = now
+		task.Version = 1
+		r.tasks[task.ID] = task
+		result := copyTask(task)
+		return &result, nil
+	}
+
+	stored, err := r.lookup(op.ID, &userID)
+	if err != nil {
+		return nil, err
+	}
+	if op.Version != 0 && op.Version != stored.Version {
+		return nil, database.ErrTaskModified
+	}
+
+	if op.Kind == database.TaskUpdate {
+		before := stored
+		op.Patch.Apply(&stored)
+		stored.DueDate = copyTime(stored.DueDate)
+		stored.UpdatedAt = now
+		stored.Version++
+		r.tasks[stored.ID] = stored
+		r.record(models.NewTaskRevision(&before, &stored, userID))
+		result := copyTask(stored)
+		return &result, nil
+	}
+
+	// Deletes move the task to the trash, like DeleteByUserID.
+	stored.DeletedAt = &now
+	stored.Version++
+	r.tasks[stored.ID] = stored
+	return nil, nil
+}
+
+// copyTask detaches a task from the caller so later mutations through
+// pointer fields cannot change the stored value.
+func copyTask(t models.Task) models.Task {
+	t.DueDate = copyTime(t.DueDate)
+	t.DeletedAt = copyTime(t.DeletedAt)
+	return t
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
@@ -0,0 +1,289 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UserRepository struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]models.User
+}
+
+var _ database.UserStore = (*UserRepository)(nil)
+
+func NewUserRepository() *UserRepository {
+	return &UserRepository{
+		users: make(map[primitive.ObjectID]models.User),
+	}
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Mirrors the unique index on email.
+	for _, u := range r.users {
+		if u.Email == user.Email {
+			return database.ErrEmailExists
+		}
+	}
+
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+
+	r.users[user.ID] = copyUser(*user)
+	return nil
+}
+
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			u = copyUser(u)
+			return &u, nil
+		}
+	}
+	return nil, database.ErrUserNotFound
+}
+
+func (r *UserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, database.ErrUserNotFound
+	}
+	user = copyUser(user)
+	return &user, nil
+}
+
+func (r *UserRepository) Count(ctx context.Context) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return int64(len(r.users)), nil
+}
+
+func (r *UserRepository) FindAll(ctx context.Context) ([]models.User, error) {
+	return r.filter(func(models.User) bool { return true }), nil
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[id]; !ok {
+		return database.ErrUserNotFound
+	}
+	delete(r.users, id)
+	return nil
+}
+
+func (r *UserRepository) SetPasswordHash(ctx context.Context, id primitive.ObjectID, hash string) error {
+	return r.update(id, func(u *models.User) error {
+		u.PasswordHash = hash
+		return nil
+	})
+}
+
+func (r *UserRepository) ScheduleDeletion(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	user.DeletionScheduledAt = &at
+	user.UpdatedAt = time.Now()
+	r.users[id] = user
+	return nil
+}
+
+func (r *UserRepository) CancelDeletion(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || !user.DeletionPending() {
+		return database.ErrUserNotFound
+	}
+	user.DeletionScheduledAt = nil
+	user.UpdatedAt = time.Now()
+	r.users[id] = user
+	return nil
+}
+
+func (r *UserRepository) FindDueForDeletion(ctx context.Context, now time.Time) ([]models.User, error) {
+	return r.filter(func(u models.User) bool {
+		return u.DeletionDueAt(now)
+	}), nil
+}
+
+// filter returns copies of matching users in creation order.
+func (r *UserRepository) filter(match func(models.User) bool) []models.User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := []models.User{}
+	for _, u := range r.users {
+		if match(u) {
+			users = append(users, copyUser(u))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return bytes.Compare(users[i].ID[:], users[j].ID[:]) < 0
+	})
+	return users
+}
+
+func copyUser(u models.User) models.User {
+	u.DeletionScheduledAt = copyTime(u.DeletionScheduledAt)
+	u.RecoveryCodes = slices.Clone(u.RecoveryCodes)
+	u.TwoFactorAttempts = slices.Clone(u.TwoFactorAttempts)
+	return u
+}
+
+func (r *UserRepository) SetTOTPSecret(ctx context.Context, id primitive.ObjectID, secret string) error {
+	return r.update(id, func(u *models.User) error {
+		if u.TwoFactorEnabled {
+			return database.ErrTwoFactorEnabled
+		}
+		u.TOTPSecret = secret
+		return nil
+	})
+}
+
+func (r *UserRepository) EnableTwoFactor(ctx context.Context, id primitive.ObjectID, step int64, recoveryCodes []string) error {
+	return r.update(id, func(u *models.User) error {
+		if u.TwoFactorEnabled {
+			return database.ErrTwoFactorEnabled
+		}
+		u.TwoFactorEnabled = true
+		u.TOTPLastStep = step
+		u.RecoveryCodes = slices.Clone(recoveryCodes)
+		return nil
+	})
+}
+
+func (r *UserRepository) DisableTwoFactor(ctx context.Context, id primitive.ObjectID) error {
+	return r.update(id, func(u *models.User) error {
+		u.TwoFactorEnabled = false
+		u.TOTPSecret = ""
+		u.TOTPLastStep = 0
+		u.RecoveryCodes = nil
+		return nil
+	})
+}
+
+func (r *UserRepository) SetRecoveryCodes(ctx context.Context, id primitive.ObjectID, recoveryCodes []string) error {
+	return r.update(id, func(u *models.User) error {
+		if !u.TwoFactorEnabled {
+			return database.ErrUserNotFound
+		}
+		u.RecoveryCodes = slices.Clone(recoveryCodes)
+		return nil
+	})
+}
+
+func (r *UserRepository) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	return r.update(id, func(u *models.User) error {
+		if !u.TwoFactorEnabled || step <= u.TOTPLastStep {
+			return database.ErrCodeUsed
+		}
+		u.TOTPLastStep = step
+		return nil
+	})
+}
+
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, recoveryCode string) error {
+	return r.update(id, func(u *models.User) error {
+		i := slices.Index(u.RecoveryCodes, recoveryCode)
+		if !u.TwoFactorEnabled || i < 0 {
+			return database.ErrCodeUsed
+		}
+		u.RecoveryCodes = slices.Delete(slices.Clone(u.RecoveryCodes), i, i+1)
+		return nil
+	})
+}
+
+func (r *UserRepository) AddTwoFactorAttempt(ctx context.Context, id primitive.ObjectID, now time.Time, limit int, window time.Duration) error {
+	return r.update(id, func(u *models.User) error {
+		attempts := u.TwoFactorAttempts
+		if len(attempts) >= limit && attempts[len(attempts)-limit].After(now.Add(-window)) {
+			return database.ErrTooManyAttempts
+		}
+		attempts = append(slices.Clone(attempts), now)
+		u.TwoFactorAttempts = attempts[max(len(attempts)-limit, 0):]
+		return nil
+	})
+}
+
+func (r *UserRepository) ResetTwoFactorAttempts(ctx context.Context, id primitive.ObjectID) error {
+	return r.update(id, func(u *models.User) error {
+		u.TwoFactorAttempts = nil
+		return nil
+	})
+}
+
+func (r *UserRepository) FindByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.OIDCIssuer == issuer && u.OIDCSubject == subject {
+			u = copyUser(u)
+			return &u, nil
+		}
+	}
+	return nil, database.ErrUserNotFound
+}
+
+func (r *UserRepository) LinkOIDC(ctx context.Context, id primitive.ObjectID, issuer, subject string) error {
+	return r.update(id, func(u *models.User) error {
+		if u.OIDCSubject != "" && (u.OIDCIssuer != issuer || u.OIDCSubject != subject) {
+			return database.ErrAccountLinked
+		}
+		for _, other := range r.users {
+			if other.ID != id && other.OIDCIssuer == issuer && other.OIDCSubject == subject {
+				return database.ErrAccountLinked
+			}
+		}
+		u.OIDCIssuer = issuer
+		u.OIDCSubject = subject
+		return nil
+	})
+}
+
+// update applies fn to the stored user under the write lock and keeps the
+// change unless fn fails.
+func (r *UserRepository) update(id primitive.ObjectID, fn func(*models.User) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	if err := fn(&user); err != nil {
+		return err
+	}
+	user.UpdatedAt = time.Now()
+	r.users[id] = user
+	return nil
+}
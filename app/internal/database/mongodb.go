@@ -5,7 +5,9 @@ import (
 	"errors"
 	"time"
 
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/tracing"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -14,18 +16,23 @@ import (
 
 type TaskRepository struct {
 	collection *mongo.Collection
+	revisions  *mongo.Collection
 }
 
 func NewTaskRepository(client *mongo.Client, dbName string) *TaskRepository {
-	collection := client.Database(dbName).Collection("tasks")
+	db := client.Database(dbName)
 	return &TaskRepository{
-		collection: collection,
+		collection: db.Collection("tasks"),
+		revisions:  db.Collection("task_revisions"),
 	}
 }
 
 func (r *TaskRepository) Create(ctx context.Context, task *models.Task) error {
+	defer metrics.ObserveMongo("tasks", "Create")()
+
 	task.CreatedAt = time.Now()
 	task.UpdatedAt = time.Now()
+	task.Version = 1
 
 	result, err := r.collection.InsertOne(ctx, task)
 	if err != nil {
@@ -37,7 +44,9 @@ func (r *TaskRepository) Create(ctx context.Context, task *models.Task) error {
 }
 
 func (r *TaskRepository) FindAll(ctx context.Context) ([]models.Task, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{})
+	defer metrics.ObserveMongo("tasks", "FindAll")()
+
+	cursor, err := r.collection.Find(ctx, active(bson.M{}))
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +65,9 @@ func (r *TaskRepository) FindAll(ctx context.Context) ([]models.Task, error) {
 }
 
 func (r *TaskRepository) FindByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Task, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID})
+	defer metrics.ObserveMongo("tasks", "FindByUserID")()
+
+	cursor, err := r.collection.Find(ctx, active(bson.M{"user_id": userID}))
 	if err != nil {
 		return nil, err
 	}
@@ -74,17 +85,41 @@ func (r *TaskRepository) FindByUserID(ctx context.Context, userID primitive.Obje
 	return tasks, nil
 }
 
+func (r *TaskRepository) EachByUserID(ctx context.Context, userID primitive.ObjectID, fn func(*models.Task) error) error {
+	defer metrics.ObserveMongo("tasks", "EachByUserID")()
+
+	cursor, err := r.collection.Find(ctx, active(bson.M{"user_id": userID}),
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var task models.Task
+		if err := cursor.Decode(&task); err != nil {
+			return err
+		}
+		if err := fn(&task); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
 func (r *TaskRepository) FindByID(ctx context.Context, id string) (*models.Task, error) {
+	defer metrics.ObserveMongo("tasks", "FindByID")()
+
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, errors.New("invalid task ID")
+		return nil, ErrInvalidTaskID
 	}
 
 	var task models.Task
-	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&task)
+	err = r.collection.FindOne(ctx, active(bson.M{"_id": objectID})).Decode(&task)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("task not found")
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrTaskNotFound
 		}
 		return nil, err
 	}
@@ -93,16 +128,18 @@ func (r *TaskRepository) FindByID(ctx context.Context, id string) (*models.Task,
 }
 
 func (r *TaskRepository) FindByIDAndUserID(ctx context.Context, id string, userID primitive.ObjectID) (*models.Task, error) {
+	defer metrics.ObserveMongo("tasks", "FindByIDAndUserID")()
+
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, errors.New("invalid task ID")
+		return nil, ErrInvalidTaskID
 	}
 
 	var task models.Task
-	err = r.collection.FindOne(ctx, bson.M{"_id": objectID, "user_id": userID}).Decode(&task)
+	err = r.collection.FindOne(ctx, active(bson.M{"_id": objectID, "user_id": userID})).Decode(&task)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("task not found")
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrTaskNotFound
 		}
 		return nil, err
 	}
@@ -111,41 +148,31 @@ func (r *TaskRepository) FindByIDAndUserID(ctx context.Context, id string, userI
 }
 
 func (r *TaskRepository) Update(ctx context.Context, id string, task *models.Task) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return errors.New("invalid task ID")
-	}
-
-	task.UpdatedAt = time.Now()
-
-	update := bson.M{
-		"$set": bson.M{
-			"title":       task.Title,
-			"description": task.Description,
-			"status":      task.Status,
-			"due_date":    task.DueDate,
-			"updated_at":  task.UpdatedAt,
-		},
-	}
+	defer metrics.ObserveMongo("tasks", "Update")()
 
-	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": objectID}, update)
+	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return err
-	}
-
-	if result.MatchedCount == 0 {
-		return errors.New("task not found")
+		return ErrInvalidTaskID
 	}
 
-	return nil
+	return r.update(ctx, active(bson.M{"_id": objectID}), task, primitive.NilObjectID)
 }
 
 func (r *TaskRepository) UpdateByUserID(ctx context.Context, id string, userID primitive.ObjectID, task *models.Task) error {
+	defer metrics.ObserveMongo("tasks", "UpdateByUserID")()
+
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return errors.New("invalid task ID")
+		return ErrInvalidTaskID
 	}
 
+	return r.update(ctx, active(bson.M{"_id": objectID, "user_id": userID}), task, userID)
+}
+
+// update writes the editable fields of task to the document matching
+// filter and bumps its version, conditionally on task.Version if set. The
+// change is recorded as a revision by editorID.
+func (r *TaskRepository) update(ctx context.Context, filter bson.M, task *models.Task, editorID primitive.ObjectID) error {
 	task.UpdatedAt = time.Now()
 
 	update := bson.M{
@@ -156,58 +183,96 @@ func (r *TaskRepository) UpdateByUserID(ctx context.Context, id string, userID p
 			"due_date":    task.DueDate,
 			"updated_at":  task.UpdatedAt,
 		},
+		"$inc": bson.M{"version": 1},
 	}
 
-	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": objectID, "user_id": userID}, update)
-	if err != nil {
-		return err
+	conditional := bson.M{}
+	for k, v := range filter {
+		conditional[k] = v
 	}
-
-	if result.MatchedCount == 0 {
-		return errors.New("task not found")
+	if task.Version != 0 {
+		conditional["version"] = task.Version
 	}
 
-	return nil
-}
-
-func (r *TaskRepository) Delete(ctx context.Context, id string) error {
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return errors.New("invalid task ID")
+	// The document before the update is the revision's baseline.
+	var before models.Task
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := r.collection.FindOneAndUpdate(ctx, conditional, update, opts).Decode(&before)
+	switch {
+	case err == nil:
+		task.Version = before.Version + 1
+		after := before
+		after.Title, after.Description, after.Status, after.DueDate = task.Title, task.Description, task.Status, task.DueDate
+		after.UpdatedAt, after.Version = task.UpdatedAt, task.Version
+		return r.recordRevisions(ctx, models.NewTaskRevision(&before, &after, editorID))
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return err
+	case task.Version == 0:
+		return ErrTaskNotFound
 	}
 
-	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	// The conditional update matched nothing: either the task is gone or
+	// its version moved on.
+	count, err := r.collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
 	if err != nil {
 		return err
 	}
+	if count == 0 {
+		return ErrTaskNotFound
+	}
+	return ErrTaskModified
+}
+
+func (r *TaskRepository) Delete(ctx context.Context, id string) error {
+	defer metrics.ObserveMongo("tasks", "Delete")()
 
-	if result.DeletedCount == 0 {
-		return errors.New("task not found")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidTaskID
 	}
 
-	return nil
+	return r.trash(ctx, bson.M{"_id": objectID})
 }
 
 func (r *TaskRepository) DeleteByUserID(ctx context.Context, id string, userID primitive.ObjectID) error {
+	defer metrics.ObserveMongo("tasks", "DeleteByUserID")()
+
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return errors.New("invalid task ID")
+		return ErrInvalidTaskID
 	}
 
-	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID, "user_id": userID})
+	return r.trash(ctx, bson.M{"_id": objectID, "user_id": userID})
+}
+
+// trash moves the task matching filter to the trash.
+func (r *TaskRepository) trash(ctx context.Context, filter bson.M) error {
+	result, err := r.collection.UpdateOne(ctx, active(filter), bson.M{
+		"$set": bson.M{"deleted_at": time.Now()},
+		"$inc": bson.M{"version": 1},
+	})
 	if err != nil {
 		return err
 	}
 
-	if result.DeletedCount == 0 {
-		return errors.New("task not found")
+	if result.MatchedCount == 0 {
+		return ErrTaskNotFound
 	}
 
 	return nil
 }
 
+// active restricts filter to tasks that are not in the trash. A null
+// filter value also matches documents without the field.
+func active(filter bson.M) bson.M {
+	filter["deleted_at"] = nil
+	return filter
+}
+
 func Connect(ctx context.Context, uri string) (*mongo.Client, error) {
-	clientOptions := options.Client().ApplyURI(uri)
+	clientOptions := options.Client().
+		ApplyURI(uri).
+		SetMonitor(tracing.CommandMonitor())
 
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
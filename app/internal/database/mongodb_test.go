@@ -0,0 +1,53 @@
+package database_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/databasetest"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/migrations"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestContract runs the store contract against a real MongoDB. Set
+// MONGODB_TEST_URI (e.g. mongodb://localhost:27017) to enable it; every
+// subtest gets its own database, dropped afterwards.
+func TestContract(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := database.Connect(ctx, uri)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	databasetest.Run(t, func(t *testing.T) databasetest.Stores {
+		dbName := "tasks_test_" + primitive.NewObjectID().Hex()
+		t.Cleanup(func() { client.Database(dbName).Drop(context.Background()) })
+
+		// Unique indexes come from the migrations.
+		if _, err := migrations.New(client, dbName, time.Minute).Up(context.Background(), 0); err != nil {
+			t.Fatalf("migrations: %v", err)
+		}
+
+		return databasetest.Stores{
+			Tasks:   database.NewTaskRepository(client, dbName),
+			Users:   database.NewUserRepository(client, dbName),
+			Invites: database.NewInviteRepository(client, dbName),
+
+			Idempotency:   database.NewIdempotencyRepository(client, dbName),
+			SigningKeys:   database.NewSigningKeyRepository(client, dbName),
+			RefreshTokens: database.NewRefreshTokenRepository(client, dbName),
+			Settings:      database.NewSettingsRepository(client, dbName),
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RefreshTokenRepository stores session refresh tokens. A unique index on
+// token_hash finds them, and a TTL index on expires_at removes old ones.
+type RefreshTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRefreshTokenRepository(client *mongo.Client, dbName string) *RefreshTokenRepository {
+	collection := client.Database(dbName).Collection("refresh_tokens")
+	return &RefreshTokenRepository{
+		collection: collection,
+	}
+}
+
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	defer metrics.ObserveMongo("refresh_tokens", "Create")()
+
+	token.ID = primitive.NewObjectID()
+	token.CreatedAt = time.Now()
+
+	_, err := r.collection.InsertOne(ctx, token)
+	return err
+}
+
+func (r *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	defer metrics.ObserveMongo("refresh_tokens", "FindByHash")()
+
+	var token models.RefreshToken
+	err := r.collection.FindOne(ctx, bson.M{
+		"token_hash": hash,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *RefreshTokenRepository) MarkUsed(ctx context.Context, id primitive.ObjectID) error {
+	defer metrics.ObserveMongo("refresh_tokens", "MarkUsed")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		n, err := r.collection.CountDocuments(ctx, bson.M{"_id": id}, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrRefreshTokenNotFound
+		}
+		return ErrRefreshTokenUsed
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) DeleteFamily(ctx context.Context, familyID primitive.ObjectID) error {
+	defer metrics.ObserveMongo("refresh_tokens", "DeleteFamily")()
+
+	_, err := r.collection.DeleteMany(ctx, bson.M{"family_id": familyID})
+	return err
+}
+
+func (r *RefreshTokenRepository) DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error {
+	defer metrics.ObserveMongo("refresh_tokens", "DeleteByUserID")()
+
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
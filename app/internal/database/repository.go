@@ -0,0 +1,204 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TaskStore is implemented by TaskRepository and the in-memory store in
+// package memory. The *ByUserID variants only match tasks owned by userID
+// and report another user's task as not found.
+//
+// Delete and DeleteByUserID move a task to the trash by setting DeletedAt.
+// Trashed tasks are invisible to every other method until restored, and are
+// removed for good by PurgeByUserID or PurgeDeletedBefore.
+//
+// Create sets Version to 1 and every update increments it, as do moving a
+// task to the trash and restoring it. When the task passed to an update has
+// a non-zero Version, the update only applies if it equals the stored
+// version and fails with ErrTaskModified otherwise. On success task.Version
+// holds the new version.
+//
+// Updates that change an editable field, including bulk updates, record a
+// TaskRevision. UpdateByUserID and BulkWriteByUserID credit the change to
+// userID. Purging a task deletes its revisions.
+//
+// PurgeAllByUserID removes everything a user owns, for account deletion.
+type TaskStore interface {
+	Create(ctx context.Context, task *models.Task) error
+	FindAll(ctx context.Context) ([]models.Task, error)
+	FindByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Task, error)
+	// EachByUserID calls fn with each of the user's tasks in creation
+	// order, reading them one at a time instead of loading the whole list.
+	// It stops at and returns the first error from fn.
+	EachByUserID(ctx context.Context, userID primitive.ObjectID, fn func(*models.Task) error) error
+	FindByID(ctx context.Context, id string) (*models.Task, error)
+	FindByIDAndUserID(ctx context.Context, id string, userID primitive.ObjectID) (*models.Task, error)
+	Update(ctx context.Context, id string, task *models.Task) error
+	UpdateByUserID(ctx context.Context, id string, userID primitive.ObjectID, task *models.Task) error
+	Delete(ctx context.Context, id string) error
+	DeleteByUserID(ctx context.Context, id string, userID primitive.ObjectID) error
+	BulkWriteByUserID(ctx context.Context, userID primitive.ObjectID, ops []TaskOperation, ordered bool) ([]TaskOperationResult, error)
+
+	// FindDeletedByUserID lists the user's trashed tasks, most recently
+	// deleted first.
+	FindDeletedByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Task, error)
+	RestoreByUserID(ctx context.Context, id string, userID primitive.ObjectID) error
+	PurgeByUserID(ctx context.Context, id string, userID primitive.ObjectID) error
+	// PurgeDeletedBefore permanently removes tasks trashed before cutoff
+	// and returns how many there were.
+	PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// FindRevisionsByUserID lists the revisions of the user's task, newest
+	// first. It does not check that the task exists.
+	FindRevisionsByUserID(ctx context.Context, id string, userID primitive.ObjectID) ([]models.TaskRevision, error)
+	// FindAllRevisionsByUserID lists the revisions of all the user's
+	// tasks, trashed ones included, ordered by task and then version.
+	FindAllRevisionsByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.TaskRevision, error)
+
+	// PurgeAllByUserID permanently removes all the user's tasks, in the
+	// trash or not, with their revisions, and returns how many tasks there
+	// were.
+	PurgeAllByUserID(ctx context.Context, userID primitive.ObjectID) (int64, error)
+}
+
+// UserStore is implemented by UserRepository and the in-memory store in
+// package memory. Emails are unique.
+type UserStore interface {
+	Create(ctx context.Context, user *models.User) error
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+	// FindAll lists every user in creation order.
+	FindAll(ctx context.Context) ([]models.User, error)
+	Count(ctx context.Context) (int64, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// SetPasswordHash replaces the user's password hash.
+	SetPasswordHash(ctx context.Context, id primitive.ObjectID, hash string) error
+
+	// ScheduleDeletion sets the user's DeletionScheduledAt to at.
+	ScheduleDeletion(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// CancelDeletion clears DeletionScheduledAt. Once the scheduled time
+	// has passed the deletion can no longer be cancelled, and like a user
+	// without a scheduled deletion this fails with ErrUserNotFound.
+	CancelDeletion(ctx context.Context, id primitive.ObjectID) error
+	// FindDueForDeletion lists the users scheduled for deletion at or
+	// before now.
+	FindDueForDeletion(ctx context.Context, now time.Time) ([]models.User, error)
+
+	// SetTOTPSecret stores an unconfirmed TOTP secret, replacing any
+	// earlier one. It fails with ErrTwoFactorEnabled if two-factor
+	// authentication is already on.
+	SetTOTPSecret(ctx context.Context, id primitive.ObjectID, secret string) error
+	// EnableTwoFactor turns on two-factor authentication with the stored
+	// secret. step is the time step of the code that confirmed it, and
+	// recoveryCodes the hashed recovery codes. It fails with
+	// ErrTwoFactorEnabled if it is already on.
+	EnableTwoFactor(ctx context.Context, id primitive.ObjectID, step int64, recoveryCodes []string) error
+	// DisableTwoFactor turns two-factor authentication off and removes the
+	// secret and recovery codes.
+	DisableTwoFactor(ctx context.Context, id primitive.ObjectID) error
+	// SetRecoveryCodes replaces the hashed recovery codes. Like a missing
+	// user, a user without two-factor authentication fails with
+	// ErrUserNotFound.
+	SetRecoveryCodes(ctx context.Context, id primitive.ObjectID, recoveryCodes []string) error
+	// UseTOTPStep records step as the last accepted time step. It fails
+	// with ErrCodeUsed unless step is later than the previous one, so a
+	// code cannot be replayed.
+	UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) error
+	// UseRecoveryCode removes a hashed recovery code. It fails with
+	// ErrCodeUsed if the user does not hold it.
+	UseRecoveryCode(ctx context.Context, id primitive.ObjectID, recoveryCode string) error
+	// AddTwoFactorAttempt records a second login step made at now. It
+	// fails with ErrTooManyAttempts, and records nothing, if the user
+	// already made limit attempts in the window before now.
+	AddTwoFactorAttempt(ctx context.Context, id primitive.ObjectID, now time.Time, limit int, window time.Duration) error
+	// ResetTwoFactorAttempts forgets the recorded attempts, after a
+	// successful second step.
+	ResetTwoFactorAttempts(ctx context.Context, id primitive.ObjectID) error
+
+	// FindByOIDCSubject returns the user linked to the single sign-on
+	// account subject at issuer.
+	FindByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error)
+	// LinkOIDC links the user to the single sign-on account subject at
+	// issuer. It fails with ErrAccountLinked if the user is already linked
+	// to a different account.
+	LinkOIDC(ctx context.Context, id primitive.ObjectID, issuer, subject string) error
+}
+
+// InviteStore is implemented by InviteRepository and the in-memory store in
+// package memory. Tokens are unique.
+type InviteStore interface {
+	Create(ctx context.Context, invite *models.Invite) error
+	FindByToken(ctx context.Context, token string) (*models.Invite, error)
+	MarkUsed(ctx context.Context, token string) error
+	FindAll(ctx context.Context) ([]models.Invite, error)
+	CountActive(ctx context.Context) (int64, error)
+	// FindByInviter lists the invites a user created, oldest first.
+	FindByInviter(ctx context.Context, inviterID primitive.ObjectID) ([]models.Invite, error)
+	// DeleteByInviterOrEmail removes the invites a user created or was sent,
+	// and returns how many there were.
+	DeleteByInviterOrEmail(ctx context.Context, inviterID primitive.ObjectID, email string) (int64, error)
+}
+
+// IdempotencyStore is implemented by IdempotencyRepository and the
+// in-memory store in package memory. Keys are unique per user, and records
+// past their ExpiresAt are treated as absent.
+type IdempotencyStore interface {
+	// Reserve stores a record that has no response yet. It fails with
+	// ErrIdempotencyKeyExists if the user already holds the key.
+	Reserve(ctx context.Context, record *models.IdempotencyRecord) error
+	FindByKey(ctx context.Context, userID primitive.ObjectID, key string) (*models.IdempotencyRecord, error)
+	// Complete stores the response of a reserved record.
+	Complete(ctx context.Context, record *models.IdempotencyRecord) error
+	// Release deletes a record, so the key can be used again.
+	Release(ctx context.Context, userID primitive.ObjectID, key string) error
+	// DeleteByUserID removes all the user's records.
+	DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// SigningKeyStore is implemented by SigningKeyRepository and the in-memory
+// store in package memory. IDs are unique.
+type SigningKeyStore interface {
+	// Create fails with ErrSigningKeyExists if the ID is taken.
+	Create(ctx context.Context, key *models.SigningKey) error
+	// FindAll lists every key in order of ActivatesAt.
+	FindAll(ctx context.Context) ([]models.SigningKey, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// RefreshTokenStore is implemented by RefreshTokenRepository and the
+// in-memory store in package memory. Token hashes are unique, and tokens
+// past their ExpiresAt are treated as absent.
+type RefreshTokenStore interface {
+	Create(ctx context.Context, token *models.RefreshToken) error
+	FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	// MarkUsed sets UsedAt. It fails with ErrRefreshTokenUsed if the token
+	// was used already, so only one renewal can use it.
+	MarkUsed(ctx context.Context, id primitive.ObjectID) error
+	// DeleteFamily removes every token of a session.
+	DeleteFamily(ctx context.Context, familyID primitive.ObjectID) error
+	// DeleteByUserID removes all the user's tokens, ending their sessions.
+	DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// SettingsStore is implemented by SettingsRepository and the in-memory
+// store in package memory. There is one set of settings.
+type SettingsStore interface {
+	// Get returns the settings, or the zero Settings if none were saved.
+	Get(ctx context.Context) (*models.Settings, error)
+	// Save replaces the settings.
+	Save(ctx context.Context, settings *models.Settings) error
+}
+
+var (
+	_ TaskStore         = (*TaskRepository)(nil)
+	_ UserStore         = (*UserRepository)(nil)
+	_ InviteStore       = (*InviteRepository)(nil)
+	_ IdempotencyStore  = (*IdempotencyRepository)(nil)
+	_ SigningKeyStore   = (*SigningKeyRepository)(nil)
+	_ RefreshTokenStore = (*RefreshTokenRepository)(nil)
+	_ SettingsStore     = (*SettingsRepository)(nil)
+)
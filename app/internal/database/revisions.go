@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (r *TaskRepository) FindRevisionsByUserID(ctx context.Context, id string, userID primitive.ObjectID) ([]models.TaskRevision, error) {
+	defer metrics.ObserveMongo("task_revisions", "FindRevisionsByUserID")()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidTaskID
+	}
+
+	cursor, err := r.revisions.Find(ctx,
+		bson.M{"task_id": objectID, "user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "version", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []models.TaskRevision{}
+	if err = cursor.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (r *TaskRepository) FindAllRevisionsByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.TaskRevision, error) {
+	defer metrics.ObserveMongo("task_revisions", "FindAllRevisionsByUserID")()
+
+	cursor, err := r.revisions.Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "task_id", Value: 1}, {Key: "version", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []models.TaskRevision{}
+	if err = cursor.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// recordRevisions stores the revisions of updates that have been applied,
+// skipping nil ones for updates that changed nothing. Tasks and revisions
+// are not written atomically: if this fails, the update stands without
+// its revision.
+func (r *TaskRepository) recordRevisions(ctx context.Context, revisions ...*models.TaskRevision) error {
+	var docs []any
+	for _, revision := range revisions {
+		if revision != nil {
+			docs = append(docs, revision)
+		}
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	defer metrics.ObserveMongo("task_revisions", "Insert")()
+	result, err := r.revisions.InsertMany(ctx, docs)
+	if err != nil {
+		return err
+	}
+	for i, revision := range docs {
+		revision.(*models.TaskRevision).ID = result.InsertedIDs[i].(primitive.ObjectID)
+	}
+	return nil
+}
+
+// deleteRevisions removes the history of purged tasks.
+func (r *TaskRepository) deleteRevisions(ctx context.Context, taskIDs []primitive.ObjectID) error {
+	if len(taskIDs) == 0 {
+		return nil
+	}
+	_, err := r.revisions.DeleteMany(ctx, bson.M{"task_id": bson.M{"$in": taskIDs}})
+	return err
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// settingsID is the _id of the one settings document.
+const settingsID = "app"
+
+// SettingsRepository stores the app-wide settings in a single document.
+type SettingsRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSettingsRepository(client *mongo.Client, dbName string) *SettingsRepository {
+	collection := client.Database(dbName).Collection("settings")
+	return &SettingsRepository{
+		collection: collection,
+	}
+}
+
+func (r *SettingsRepository) Get(ctx context.Context) (*models.Settings, error) {
+	defer metrics.ObserveMongo("settings", "Get")()
+
+	var settings models.Settings
+	err := r.collection.FindOne(ctx, bson.M{"_id": settingsID}).Decode(&settings)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &models.Settings{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (r *SettingsRepository) Save(ctx context.Context, settings *models.Settings) error {
+	defer metrics.ObserveMongo("settings", "Save")()
+
+	settings.UpdatedAt = time.Now()
+
+	_, err := r.collection.ReplaceOne(ctx, bson.M{"_id": settingsID}, settings, options.Replace().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SigningKeyRepository stores the keys that sign session tokens. There are
+// only ever a few, so the collection needs no index beyond _id.
+type SigningKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSigningKeyRepository(client *mongo.Client, dbName string) *SigningKeyRepository {
+	collection := client.Database(dbName).Collection("signing_keys")
+	return &SigningKeyRepository{
+		collection: collection,
+	}
+}
+
+func (r *SigningKeyRepository) Create(ctx context.Context, key *models.SigningKey) error {
+	defer metrics.ObserveMongo("signing_keys", "Create")()
+
+	key.CreatedAt = time.Now()
+
+	if _, err := r.collection.InsertOne(ctx, key); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrSigningKeyExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *SigningKeyRepository) FindAll(ctx context.Context) ([]models.SigningKey, error) {
+	defer metrics.ObserveMongo("signing_keys", "FindAll")()
+
+	opts := options.Find().SetSort(bson.D{{Key: "activates_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.SigningKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *SigningKeyRepository) Delete(ctx context.Context, id string) error {
+	defer metrics.ObserveMongo("signing_keys", "Delete")()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrSigningKeyNotFound
+	}
+	return nil
+}
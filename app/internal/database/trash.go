@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// trashed matches tasks in the trash.
+var trashed = bson.M{"$ne": nil}
+
+func (r *TaskRepository) FindDeletedByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Task, error) {
+	defer metrics.ObserveMongo("tasks", "FindDeletedByUserID")()
+
+	cursor, err := r.collection.Find(ctx,
+		bson.M{"user_id": userID, "deleted_at": trashed},
+		options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tasks := []models.Task{}
+	if err = cursor.All(ctx, &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (r *TaskRepository) RestoreByUserID(ctx context.Context, id string, userID primitive.ObjectID) error {
+	defer metrics.ObserveMongo("tasks", "RestoreByUserID")()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidTaskID
+	}
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": objectID, "user_id": userID, "deleted_at": trashed},
+		bson.M{
+			"$unset": bson.M{"deleted_at": ""},
+			"$inc":   bson.M{"version": 1},
+		})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
+
+func (r *TaskRepository) PurgeByUserID(ctx context.Context, id string, userID primitive.ObjectID) error {
+	defer metrics.ObserveMongo("tasks", "PurgeByUserID")()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidTaskID
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID, "user_id": userID, "deleted_at": trashed})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrTaskNotFound
+	}
+	return r.deleteRevisions(ctx, []primitive.ObjectID{objectID})
+}
+
+func (r *TaskRepository) PurgeDeletedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	defer metrics.ObserveMongo("tasks", "PurgeDeletedBefore")()
+
+	filter := bson.M{"deleted_at": bson.M{"$lt": cutoff}}
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+	ids := make([]primitive.ObjectID, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+
+	// The filter is applied again in case a task was restored meanwhile,
+	// and such tasks keep their revisions.
+	filter["_id"] = bson.M{"$in": ids}
+	result, err := r.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	if result.DeletedCount < int64(len(ids)) {
+		restored, err := r.collection.Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return result.DeletedCount, err
+		}
+		kept := make(map[primitive.ObjectID]bool, len(restored))
+		for _, id := range restored {
+			kept[id.(primitive.ObjectID)] = true
+		}
+		ids = slices.DeleteFunc(ids, func(id primitive.ObjectID) bool { return kept[id] })
+	}
+	return result.DeletedCount, r.deleteRevisions(ctx, ids)
+}
+
+func (r *TaskRepository) PurgeAllByUserID(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	defer metrics.ObserveMongo("tasks", "PurgeAllByUserID")()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	// Revisions are matched by owner rather than task, so if this fails
+	// a retry still finds them.
+	if _, err := r.revisions.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return result.DeletedCount, err
+	}
+	return result.DeletedCount, nil
+}
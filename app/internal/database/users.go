@@ -3,8 +3,10 @@ package database
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -24,13 +26,15 @@ func NewUserRepository(client *mongo.Client, dbName string) *UserRepository {
 }
 
 func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	defer metrics.ObserveMongo("users", "Create")()
+
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 
 	result, err := r.collection.InsertOne(ctx, user)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
-			return errors.New("email already exists")
+			return ErrEmailExists
 		}
 		return err
 	}
@@ -40,11 +44,13 @@ func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 }
 
 func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	defer metrics.ObserveMongo("users", "FindByEmail")()
+
 	var user models.User
 	err := r.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("user not found")
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -52,11 +58,13 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models
 }
 
 func (r *UserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	defer metrics.ObserveMongo("users", "FindByID")()
+
 	var user models.User
 	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("user not found")
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -64,13 +72,285 @@ func (r *UserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*
 }
 
 func (r *UserRepository) Count(ctx context.Context) (int64, error) {
+	defer metrics.ObserveMongo("users", "Count")()
+
 	return r.collection.CountDocuments(ctx, bson.M{})
 }
 
-func (r *UserRepository) CreateIndexes(ctx context.Context) error {
-	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "email", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
+func (r *UserRepository) FindAll(ctx context.Context) ([]models.User, error) {
+	defer metrics.ObserveMongo("users", "FindAll")()
+
+	cursor, err := r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []models.User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer metrics.ObserveMongo("users", "Delete")()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) SetPasswordHash(ctx context.Context, id primitive.ObjectID, hash string) error {
+	defer metrics.ObserveMongo("users", "SetPasswordHash")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"password_hash": hash, "updated_at": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) ScheduleDeletion(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	defer metrics.ObserveMongo("users", "ScheduleDeletion")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"deletion_scheduled_at": at, "updated_at": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) CancelDeletion(ctx context.Context, id primitive.ObjectID) error {
+	defer metrics.ObserveMongo("users", "CancelDeletion")()
+
+	now := time.Now()
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "deletion_scheduled_at": bson.M{"$gt": now}},
+		bson.M{
+			"$unset": bson.M{"deletion_scheduled_at": ""},
+			"$set":   bson.M{"updated_at": now},
+		})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) FindDueForDeletion(ctx context.Context, now time.Time) ([]models.User, error) {
+	defer metrics.ObserveMongo("users", "FindDueForDeletion")()
+
+	cursor, err := r.collection.Find(ctx, bson.M{"deletion_scheduled_at": bson.M{"$lte": now}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []models.User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *UserRepository) SetTOTPSecret(ctx context.Context, id primitive.ObjectID, secret string) error {
+	defer metrics.ObserveMongo("users", "SetTOTPSecret")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "two_factor_enabled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"totp_secret": secret, "updated_at": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return r.missingOr(ctx, id, ErrTwoFactorEnabled)
+	}
+	return nil
+}
+
+func (r *UserRepository) EnableTwoFactor(ctx context.Context, id primitive.ObjectID, step int64, recoveryCodes []string) error {
+	defer metrics.ObserveMongo("users", "EnableTwoFactor")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "two_factor_enabled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{
+			"two_factor_enabled": true,
+			"totp_last_step":     step,
+			"recovery_codes":     recoveryCodes,
+			"updated_at":         time.Now(),
+		}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return r.missingOr(ctx, id, ErrTwoFactorEnabled)
+	}
+	return nil
+}
+
+func (r *UserRepository) DisableTwoFactor(ctx context.Context, id primitive.ObjectID) error {
+	defer metrics.ObserveMongo("users", "DisableTwoFactor")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$set":   bson.M{"two_factor_enabled": false, "updated_at": time.Now()},
+			"$unset": bson.M{"totp_secret": "", "totp_last_step": "", "recovery_codes": ""},
+		})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) SetRecoveryCodes(ctx context.Context, id primitive.ObjectID, recoveryCodes []string) error {
+	defer metrics.ObserveMongo("users", "SetRecoveryCodes")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "two_factor_enabled": true},
+		bson.M{"$set": bson.M{"recovery_codes": recoveryCodes, "updated_at": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	defer metrics.ObserveMongo("users", "UseTOTPStep")()
+
+	// The filter makes check and update one atomic step, so two requests
+	// with the same code cannot both succeed.
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "two_factor_enabled": true, "totp_last_step": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"totp_last_step": step}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return r.missingOr(ctx, id, ErrCodeUsed)
+	}
+	return nil
+}
+
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, recoveryCode string) error {
+	defer metrics.ObserveMongo("users", "UseRecoveryCode")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "two_factor_enabled": true, "recovery_codes": recoveryCode},
+		bson.M{"$pull": bson.M{"recovery_codes": recoveryCode}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return r.missingOr(ctx, id, ErrCodeUsed)
+	}
+	return nil
+}
+
+func (r *UserRepository) AddTwoFactorAttempt(ctx context.Context, id primitive.ObjectID, now time.Time, limit int, window time.Duration) error {
+	defer metrics.ObserveMongo("users", "AddTwoFactorAttempt")()
+
+	// Only the latest limit attempts are kept, so the user is below the
+	// limit if there are fewer, or the oldest is outside the window. As
+	// with UseTOTPStep, the filter makes check and update atomic.
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{fmt.Sprintf("two_factor_attempts.%d", limit-1): bson.M{"$exists": false}},
+			bson.M{"two_factor_attempts.0": bson.M{"$lte": now.Add(-window)}},
+		}},
+		bson.M{"$push": bson.M{"two_factor_attempts": bson.M{"$each": bson.A{now}, "$slice": -limit}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return r.missingOr(ctx, id, ErrTooManyAttempts)
+	}
+	return nil
+}
+
+func (r *UserRepository) ResetTwoFactorAttempts(ctx context.Context, id primitive.ObjectID) error {
+	defer metrics.ObserveMongo("users", "ResetTwoFactorAttempts")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$unset": bson.M{"two_factor_attempts": ""}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) FindByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error) {
+	defer metrics.ObserveMongo("users", "FindByOIDCSubject")()
+
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"oidc_issuer": issuer, "oidc_subject": subject}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) LinkOIDC(ctx context.Context, id primitive.ObjectID, issuer, subject string) error {
+	defer metrics.ObserveMongo("users", "LinkOIDC")()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{"oidc_subject": bson.M{"$exists": false}},
+			bson.M{"oidc_issuer": issuer, "oidc_subject": subject},
+		}},
+		bson.M{"$set": bson.M{"oidc_issuer": issuer, "oidc_subject": subject, "updated_at": time.Now()}})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrAccountLinked
+		}
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return r.missingOr(ctx, id, ErrAccountLinked)
+	}
+	return nil
+}
+
+// missingOr tells why a conditional update matched nothing: the user does
+// not exist, or the condition did not hold and err applies.
+func (r *UserRepository) missingOr(ctx context.Context, id primitive.ObjectID, err error) error {
+	n, countErr := r.collection.CountDocuments(ctx, bson.M{"_id": id}, options.Count().SetLimit(1))
+	if countErr != nil {
+		return countErr
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
 	return err
 }
@@ -0,0 +1,198 @@
+// Package flash carries one-time messages across a redirect in a short-lived
+// signed cookie. A handler queues a message with Success, Error or Info and
+// redirects; the next page that renders the Layout consumes it.
+package flash
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CookieName is the cookie that holds pending messages.
+const CookieName = "flash"
+
+const (
+	// maxAge bounds how long a message waits for the next page view.
+	maxAge = time.Minute
+	// maxMessages and maxTextLength keep the cookie well below the 4 KB
+	// browser limit.
+	maxMessages   = 5
+	maxTextLength = 500
+)
+
+type Level string
+
+const (
+	LevelSuccess Level = "success"
+	LevelError   Level = "error"
+	LevelInfo    Level = "info"
+)
+
+func (l Level) valid() bool {
+	switch l {
+	case LevelSuccess, LevelError, LevelInfo:
+		return true
+	}
+	return false
+}
+
+type Message struct {
+	Level Level  `json:"l"`
+	Text  string `json:"t"`
+}
+
+// Store signs and verifies flash cookies.
+type Store struct {
+	key []byte
+}
+
+// NewStore derives the signing key from secret, so the application secret
+// can be shared without the flash cookie and session tokens using the same
+// key.
+func NewStore(secret string) *Store {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("flash"))
+	return &Store{key: mac.Sum(nil)}
+}
+
+type contextKey struct{}
+
+// state is the per-request flash state kept in the context.
+type state struct {
+	store    *Store
+	w        http.ResponseWriter
+	incoming []Message // read from the request cookie
+	pending  []Message // queued during this request
+	consumed bool
+}
+
+// Middleware reads and verifies the flash cookie and makes the messages
+// available to Consume. A cookie with a bad signature is ignored and
+// cleared.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := &state{store: s, w: w}
+		if cookie, err := r.Cookie(CookieName); err == nil {
+			messages, ok := s.decode(cookie.Value)
+			if ok {
+				st.incoming = messages
+			} else {
+				s.clear(w)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, st)))
+	})
+}
+
+// Add queues a message for the next rendered page. It sets a cookie, so it
+// must be called before the response header is written. Without the
+// middleware it does nothing.
+func Add(ctx context.Context, level Level, text string) {
+	st, ok := ctx.Value(contextKey{}).(*state)
+	if !ok || !level.valid() || text == "" {
+		return
+	}
+	if len(text) > maxTextLength {
+		text = text[:maxTextLength]
+	}
+	st.pending = append(st.pending, Message{Level: level, Text: text})
+
+	// Messages that arrived with the request but were not shown yet are
+	// carried over, so a redirect chain does not lose them.
+	var messages []Message
+	if !st.consumed {
+		messages = append(messages, st.incoming...)
+	}
+	messages = append(messages, st.pending...)
+	if len(messages) > maxMessages {
+		messages = messages[len(messages)-maxMessages:]
+	}
+	st.store.write(st.w, messages)
+}
+
+func Success(ctx context.Context, text string) { Add(ctx, LevelSuccess, text) }
+func Error(ctx context.Context, text string)   { Add(ctx, LevelError, text) }
+func Info(ctx context.Context, text string)    { Add(ctx, LevelInfo, text) }
+
+// Consume returns the messages carried by the request together with any
+// queued during it, and clears the cookie so they are shown only once. The
+// cookie is cleared through the response header, so pages must be rendered
+// into a buffer before the header is written.
+func Consume(ctx context.Context) []Message {
+	st, ok := ctx.Value(contextKey{}).(*state)
+	if !ok || st.consumed {
+		return nil
+	}
+	st.consumed = true
+
+	messages := append(st.incoming, st.pending...)
+	if len(messages) > 0 {
+		st.store.clear(st.w)
+	}
+	st.incoming, st.pending = nil, nil
+	return messages
+}
+
+func (s *Store) write(w http.ResponseWriter, messages []Message) {
+	payload, err := json.Marshal(messages)
+	if err != nil {
+		return
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    value + "." + s.sign(value),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(maxAge.Seconds()),
+	})
+}
+
+func (s *Store) clear(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+func (s *Store) sign(value string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// decode verifies the signature and returns the messages, dropping any with
+// an unknown level.
+func (s *Store) decode(cookie string) ([]Message, bool) {
+	value, signature, ok := strings.Cut(cookie, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(value))) {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, false
+	}
+	var messages []Message
+	if err := json.Unmarshal(payload, &messages); err != nil {
+		return nil, false
+	}
+
+	valid := messages[:0]
+	for _, m := range messages {
+		if m.Level.valid() && m.Text != "" {
+			valid = append(valid, m)
+		}
+	}
+	return valid, true
+}
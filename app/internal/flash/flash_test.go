@@ -0,0 +1,94 @@
+package flash
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serve runs h behind the middleware with the given request cookies and
+// returns the flash cookie it set, if any.
+func serve(t *testing.T, s *Store, cookies []*http.Cookie, h http.HandlerFunc) *http.Cookie {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	s.Middleware(h).ServeHTTP(rec, req)
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == CookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := NewStore("secret")
+
+	cookie := serve(t, s, nil, func(w http.ResponseWriter, r *http.Request) {
+		Success(r.Context(), "Task created.")
+		Add(r.Context(), Level("bogus"), "ignored")
+	})
+	if cookie == nil || cookie.MaxAge <= 0 {
+		t.Fatalf("expected a flash cookie, got %+v", cookie)
+	}
+
+	var got []Message
+	cleared := serve(t, s, []*http.Cookie{cookie}, func(w http.ResponseWriter, r *http.Request) {
+		got = Consume(r.Context())
+		if again := Consume(r.Context()); again != nil {
+			t.Errorf("second Consume = %v, want nil", again)
+		}
+	})
+	if len(got) != 1 || got[0] != (Message{Level: LevelSuccess, Text: "Task created."}) {
+		t.Fatalf("Consume = %v", got)
+	}
+	if cleared == nil || cleared.MaxAge >= 0 {
+		t.Errorf("expected the cookie to be cleared, got %+v", cleared)
+	}
+}
+
+func TestRejectsForgedCookie(t *testing.T) {
+	s := NewStore("secret")
+
+	// A cookie signed with another key, as an attacker without the
+	// secret would have to produce.
+	forged := serve(t, NewStore("other"), nil, func(w http.ResponseWriter, r *http.Request) {
+		Error(r.Context(), "Your account is locked, call 555-0100")
+	})
+
+	var got []Message
+	cleared := serve(t, s, []*http.Cookie{forged}, func(w http.ResponseWriter, r *http.Request) {
+		got = Consume(r.Context())
+	})
+	if len(got) != 0 {
+		t.Fatalf("forged messages were accepted: %v", got)
+	}
+	if cleared == nil || cleared.MaxAge >= 0 {
+		t.Errorf("expected the forged cookie to be cleared, got %+v", cleared)
+	}
+}
+
+func TestAddKeepsUnreadMessages(t *testing.T) {
+	s := NewStore("secret")
+
+	first := serve(t, s, nil, func(w http.ResponseWriter, r *http.Request) {
+		Info(r.Context(), "first")
+	})
+	// A second redirect before any page is rendered must not drop the
+	// first message.
+	second := serve(t, s, []*http.Cookie{first}, func(w http.ResponseWriter, r *http.Request) {
+		Info(r.Context(), "second")
+	})
+
+	var got []Message
+	serve(t, s, []*http.Cookie{second}, func(w http.ResponseWriter, r *http.Request) {
+		got = Consume(r.Context())
+	})
+	if len(got) != 2 || got[0].Text != "first" || got[1].Text != "second" {
+		t.Fatalf("Consume = %v", got)
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/account"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/flash"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/web/templates"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AccountHandler serves the account page, where users export their data
+// and delete their account, and the admin tools doing the same for any
+// user.
+type AccountHandler struct {
+	accounts   *account.Service
+	userRepo   database.UserStore
+	authConfig *auth.Config
+}
+
+func NewAccountHandler(accounts *account.Service, userRepo database.UserStore, authConfig *auth.Config) *AccountHandler {
+	return &AccountHandler{
+		accounts:   accounts,
+		userRepo:   userRepo,
+		authConfig: authConfig,
+	}
+}
+
+func (h *AccountHandler) ShowAccount(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := h.userRepo.FindByID(r.Context(), claims.UserID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+
+	render(w, r, "Account", templates.Account(claims.Email, user, h.accounts.Grace()))
+}
+
+func (h *AccountHandler) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := h.userRepo.FindByID(r.Context(), claims.UserID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	h.export(w, r, user)
+}
+
+// DeleteAccount schedules the user's account for deletion and logs them
+// out. The password, or a fresh single sign-on login, is asked for again,
+// so an unattended session cannot delete the account.
+func (h *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := h.userRepo.FindByID(r.Context(), claims.UserID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	if !confirmIdentity(w, r, h.authConfig, user, "/account", "your account was not deleted") {
+		return
+	}
+
+	at, err := h.accounts.ScheduleDeletion(r.Context(), user.ID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("account deletion scheduled", "user_id", user.ID.Hex(), "at", at)
+
+	auth.ClearSessionCookies(w)
+	flash.Info(r.Context(), fmt.Sprintf("Your account will be deleted on %s. Log in before then to keep it.", at.Format("Jan 02, 2006")))
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (h *AccountHandler) CancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if !h.cancelDeletion(w, r, claims.UserID) {
+		return
+	}
+	flash.Success(r.Context(), "Your account will not be deleted.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (h *AccountHandler) ShowUsers(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	admin, err := h.userRepo.FindByID(r.Context(), claims.UserID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	users, err := h.userRepo.FindAll(r.Context())
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+
+	render(w, r, "Users", templates.Users(claims.Email, users, h.accounts.Grace(), admin.PasswordHash != ""))
+}
+
+func (h *AccountHandler) ExportUser(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := h.findUser(r)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("account exported by admin", "user_id", user.ID.Hex(), "admin_id", claims.UserID.Hex())
+	h.export(w, r, user)
+}
+
+// DeleteUser schedules another account for deletion, with the same grace
+// period as a user deleting their own. The admin confirms it is them as
+// for deleting their own account.
+func (h *AccountHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := h.findUser(r)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	admin, err := h.userRepo.FindByID(r.Context(), claims.UserID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	if !confirmIdentity(w, r, h.authConfig, admin, "/admin/users", "the account was not deleted") {
+		return
+	}
+
+	at, err := h.accounts.ScheduleDeletion(r.Context(), user.ID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("account deletion scheduled by admin",
+		"user_id", user.ID.Hex(), "admin_id", claims.UserID.Hex(), "at", at)
+
+	flash.Success(r.Context(), fmt.Sprintf("%s's account will be deleted on %s.", user.Email, at.Format("Jan 02, 2006")))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (h *AccountHandler) CancelUserDeletion(w http.ResponseWriter, r *http.Request) {
+	user, err := h.findUser(r)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+
+	if !h.cancelDeletion(w, r, user.ID) {
+		return
+	}
+	flash.Success(r.Context(), fmt.Sprintf("%s's account will not be deleted.", user.Email))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// cancelDeletion cancels a scheduled deletion. On failure it has already
+// responded and returns false.
+func (h *AccountHandler) cancelDeletion(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID) bool {
+	err := h.accounts.CancelDeletion(r.Context(), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writePageError(w, r, errorStatus(http.StatusConflict, "This account is not scheduled for deletion."))
+		return false
+	}
+	if err != nil {
+		writePageError(w, r, err)
+		return false
+	}
+	logging.FromContext(r.Context()).Info("account deletion cancelled", "user_id", userID.Hex())
+	return true
+}
+
+func (h *AccountHandler) findUser(r *http.Request) (*models.User, error) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		return nil, database.ErrUserNotFound
+	}
+	return h.userRepo.FindByID(r.Context(), id)
+}
+
+// export downloads the user's data as a zip archive, written as it is read.
+func (h *AccountHandler) export(w http.ResponseWriter, r *http.Request, user *models.User) {
+	filename := fmt.Sprintf("account-%s-%s.zip", user.ID.Hex(), time.Now().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	if err := h.accounts.Export(r.Context(), w, user); err != nil {
+		// As with task exports, abort rather than end a truncated archive.
+		logging.FromContext(r.Context()).Error("account export failed", "user_id", user.ID.Hex(), "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/account"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/twofactor"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAccountDeletion(t *testing.T) {
+	users, invites := memory.NewUserRepository(), memory.NewInviteRepository()
+	grace := 48 * time.Hour
+	accounts := account.NewService(users, memory.NewTaskRepository(), invites, memory.NewIdempotencyRepository(), memory.NewRefreshTokenRepository(), grace)
+	h := NewAccountHandler(accounts, users, newTestAuthConfig(users))
+	twoFactor, err := twofactor.NewService(users, memory.NewSettingsRepository(), nil, "Task Manager", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := NewAuthHandler(users, invites, twoFactor, nil, newTestPasswordPolicy(t), newTestAuthConfig(users))
+
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Email: "ada@example.com", Name: "Ada", PasswordHash: hash, Role: models.RoleUser}
+	if err := users.Create(t.Context(), user); err != nil {
+		t.Fatal(err)
+	}
+	scheduled := func() *time.Time {
+		stored, err := users.FindByID(t.Context(), user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored.DeletionScheduledAt
+	}
+	post := func(handler http.HandlerFunc, path string, form url.Values, userID primitive.ObjectID) *httptest.ResponseRecorder {
+		req := formRequest(path, form, userID)
+		req.SetPathValue("id", user.ID.Hex())
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// Deleting needs the password again.
+	rec := post(h.DeleteAccount, "/account/delete", url.Values{"password": {"wrong"}}, user.ID)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/account" || scheduled() != nil {
+		t.Fatalf("wrong password: status = %d, scheduled = %v", rec.Code, scheduled())
+	}
+	rec = post(h.DeleteAccount, "/account/delete", url.Values{"password": {"correct horse"}}, user.ID)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Fatalf("delete: status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if at := scheduled(); at == nil || time.Until(*at) < grace-time.Minute {
+		t.Fatalf("DeletionScheduledAt = %v, want after the grace period", at)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.MaxAge >= 0 {
+			t.Errorf("the session was not ended: %v", cookie)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ShowAccount(rec, apiRequest(http.MethodGet, "/account", "", user.ID))
+	if !strings.Contains(rec.Body.String(), "Keep my account") {
+		t.Error("account page does not offer to keep the account")
+	}
+
+	// Logging in during the grace period works, with a session that ends
+	// before the account is deleted.
+	rec = post(login.HandleLogin, "/login", url.Values{"email": {user.Email}, "password": {"correct horse"}}, primitive.NilObjectID)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("login during the grace period: status = %d", rec.Code)
+	}
+
+	if rec := post(h.CancelAccountDeletion, "/account/cancel-deletion", nil, user.ID); rec.Code != http.StatusSeeOther || scheduled() != nil {
+		t.Fatalf("cancel: status = %d, scheduled = %v", rec.Code, scheduled())
+	}
+	if rec := post(h.CancelAccountDeletion, "/account/cancel-deletion", nil, user.ID); rec.Code != http.StatusConflict {
+		t.Errorf("cancelling twice: status = %d, want 409", rec.Code)
+	}
+
+	// Admins delete with their own password.
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", PasswordHash: hash, Role: models.RoleAdmin}
+	if err := users.Create(t.Context(), admin); err != nil {
+		t.Fatal(err)
+	}
+	if rec := post(h.DeleteUser, "/admin/users/"+user.ID.Hex()+"/delete", url.Values{"password": {"correct horse"}}, admin.ID); rec.Code != http.StatusSeeOther || scheduled() == nil {
+		t.Fatalf("admin delete: status = %d, scheduled = %v", rec.Code, scheduled())
+	}
+	rec = httptest.NewRecorder()
+	h.ShowUsers(rec, apiRequest(http.MethodGet, "/admin/users", "", admin.ID))
+	if !strings.Contains(rec.Body.String(), "/admin/users/"+user.ID.Hex()+"/cancel-deletion") {
+		t.Error("users page does not offer to cancel the deletion")
+	}
+
+	// Once the grace period is over the account cannot be logged into,
+	// even before it is purged.
+	if err := users.ScheduleDeletion(t.Context(), user.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	rec = post(login.HandleLogin, "/login", url.Values{"email": {user.Email}, "password": {"correct horse"}}, primitive.NilObjectID)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("login after the grace period: status = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/api"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+)
+
+// APIPrefix is the base path of the current API version.
+const APIPrefix = "/api/v1"
+
+// legacyAPIPrefix serves the same routes as APIPrefix for clients written
+// before the API was versioned.
+const legacyAPIPrefix = "/api"
+
+// legacyDeprecation is the RFC 9745 Deprecation header value sent on
+// legacy routes: the date they were deprecated, as a Unix timestamp.
+const legacyDeprecation = "@1792281600" // 2026-10-18
+
+type apiRoute struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+}
+
+// NewAPIRouter returns the JSON API routes under APIPrefix, plus the same
+// routes under the deprecated /api/tasks paths. Authentication and CORS
+// are left to the caller. Unknown paths and methods get problem+json
+// responses rather than the ServeMux's plain-text ones. Routes that create
+// resources accept an Idempotency-Key.
+func NewAPIRouter(tasks *TaskHandler, idempotency *Idempotency) http.Handler {
+	idempotent := func(h http.HandlerFunc) http.HandlerFunc {
+		return idempotency.API(h).ServeHTTP
+	}
+	routes := []apiRoute{
+		{http.MethodGet, "/tasks", tasks.ListTasks},
+		{http.MethodPost, "/tasks", idempotent(tasks.CreateTask)},
+		{http.MethodPost, "/tasks/bulk", idempotent(tasks.BulkTasks)},
+		{http.MethodGet, "/tasks/{id}", tasks.GetTask},
+		{http.MethodPut, "/tasks/{id}", tasks.UpdateTask},
+		{http.MethodPatch, "/tasks/{id}", tasks.PatchTask},
+		{http.MethodDelete, "/tasks/{id}", tasks.DeleteTask},
+		{http.MethodGet, "/tasks/{id}/revisions", tasks.ListRevisions},
+	}
+
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		mux.HandleFunc(route.method+" "+APIPrefix+route.path, route.handler)
+		mux.Handle(route.method+" "+legacyAPIPrefix+route.path, deprecated(route.handler))
+	}
+	mux.HandleFunc(legacyAPIPrefix+"/", apiFallback(mux))
+	return mux
+}
+
+// deprecated marks responses from a legacy route and points clients at
+// its versioned successor.
+func deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := APIPrefix + strings.TrimPrefix(r.URL.Path, legacyAPIPrefix)
+		w.Header().Set("Deprecation", legacyDeprecation)
+		w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+		logging.AddAttrs(r.Context(), "deprecated_route", true)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiMethods are the methods probed when building an Allow header.
+var apiMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// apiFallback handles requests no route matched. If the path matches a
+// route for another method it responds 405 with an Allow header, and 404
+// otherwise. Method-less patterns per path would do the same but conflict
+// with literal segments such as /tasks/bulk.
+func apiFallback(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allow []string
+		for _, method := range apiMethods {
+			probe := r.WithContext(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != legacyAPIPrefix+"/" {
+				allow = append(allow, method)
+			}
+		}
+		if len(allow) == 0 {
+			writeAPIError(w, r, errorStatus(http.StatusNotFound, "No API route matches this path"))
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		writeAPIError(w, r, errorStatus(http.StatusMethodNotAllowed, "Method not allowed"))
+	}
+}
+
+// OpenAPISpec serves the embedded OpenAPI document.
+func OpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(api.Spec)
+}
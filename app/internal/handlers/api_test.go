@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/api"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const specURL = "openapi.json"
+
+// openAPI checks requests and responses against the embedded spec. Only
+// the parts of OpenAPI this API uses are understood: JSON bodies, $ref to
+// components, and per-status or default responses.
+type openAPI struct {
+	doc      any
+	compiler *jsonschema.Compiler
+	schemas  map[string]*jsonschema.Schema
+	covered  map[string]bool
+}
+
+func loadSpec(t *testing.T) *openAPI {
+	t.Helper()
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(api.Spec))
+	if err != nil {
+		t.Fatalf("spec is not valid JSON: %v", err)
+	}
+	c := jsonschema.NewCompiler()
+	c.AssertFormat()
+	if err := c.AddResource(specURL, doc); err != nil {
+		t.Fatal(err)
+	}
+	return &openAPI{doc: doc, compiler: c, schemas: map[string]*jsonschema.Schema{}, covered: map[string]bool{}}
+}
+
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+// lookup returns the node at the JSON pointer ptr, or nil.
+func (s *openAPI) lookup(ptr string) any {
+	node := s.doc
+	for _, tok := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil
+		}
+		node = m[tok]
+	}
+	return node
+}
+
+// follow resolves a non-schema $ref such as a shared response.
+func (s *openAPI) follow(ptr string) string {
+	if m, ok := s.lookup(ptr).(map[string]any); ok {
+		if ref, ok := m["$ref"].(string); ok {
+			return strings.TrimPrefix(ref, "#")
+		}
+	}
+	return ptr
+}
+
+func (s *openAPI) validate(t *testing.T, schemaPtr string, body []byte, what string) {
+	t.Helper()
+	if s.lookup(schemaPtr) == nil {
+		t.Fatalf("%s: spec has no schema at %s", what, schemaPtr)
+	}
+	sch, ok := s.schemas[schemaPtr]
+	if !ok {
+		var err error
+		if sch, err = s.compiler.Compile(specURL + "#" + schemaPtr); err != nil {
+			t.Fatalf("compile %s: %v", schemaPtr, err)
+		}
+		s.schemas[schemaPtr] = sch
+	}
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("%s is not JSON: %v\n%s", what, err, body)
+	}
+	if err := sch.Validate(inst); err != nil {
+		t.Errorf("%s does not match the spec: %v\n%s", what, err, body)
+	}
+}
+
+// call sends a request through router and checks the request body, the
+// response body and the documented response headers against the operation
+// for method and the spec path template. The request is sent with the
+// content type the operation documents. The operation is recorded as
+// covered.
+func (s *openAPI) call(t *testing.T, router http.Handler, method, template, path, body string, header http.Header, userID primitive.ObjectID) *httptest.ResponseRecorder {
+	t.Helper()
+	op := "/paths/" + escapePointer(template) + "/" + strings.ToLower(method)
+	if s.lookup(op) == nil {
+		t.Fatalf("spec does not document %s %s", method, template)
+	}
+	s.covered[method+" "+template] = true
+
+	req := apiRequest(method, APIPrefix+path, body, userID)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	var requestSchema string
+	if body != "" {
+		requestBody := s.follow(op + "/requestBody")
+		content, _ := s.lookup(requestBody + "/content").(map[string]any)
+		if len(content) != 1 {
+			t.Fatalf("%s %s: expected one request content type, got %d", method, template, len(content))
+		}
+		for contentType := range content {
+			req.Header.Set("Content-Type", contentType)
+			requestSchema = requestBody + "/content/" + escapePointer(contentType) + "/schema"
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	// A body the server accepted must be valid per the spec; rejected
+	// bodies are expected not to be.
+	if requestSchema != "" && rec.Code < 300 {
+		s.validate(t, requestSchema, []byte(body), "request")
+	}
+
+	status := op + "/responses/" + strconv.Itoa(rec.Code)
+	if s.lookup(status) == nil {
+		status = op + "/responses/default"
+	}
+	resp := s.follow(status)
+	contentType := rec.Header().Get("Content-Type")
+	what := method + " " + path + " response"
+	s.validate(t, resp+"/content/"+escapePointer(contentType)+"/schema", rec.Body.Bytes(), what+" ("+contentType+")")
+
+	headers, _ := s.lookup(resp + "/headers").(map[string]any)
+	for name := range headers {
+		value := rec.Header().Get(name)
+		if value == "" {
+			t.Errorf("%s is missing the %s header", what, name)
+			continue
+		}
+		encoded, _ := json.Marshal(value)
+		s.validate(t, s.follow(resp+"/headers/"+escapePointer(name))+"/schema", encoded, what+" "+name+" header")
+	}
+	return rec
+}
+
+func TestAPIMatchesSpec(t *testing.T) {
+	spec := loadSpec(t)
+	router := newTestAPIRouter(memory.NewTaskRepository())
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+
+	rec := spec.call(t, router, http.MethodPost, "/tasks", "/tasks",
+		`{"title":"Write spec","description":"OpenAPI 3.1","due_date":"2026-12-31T00:00:00Z"}`, nil, owner)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+	}
+	var created models.Task
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	id := "/tasks/" + created.ID.Hex()
+
+	if rec := spec.call(t, router, http.MethodPost, "/tasks", "/tasks", `{"title":"","status":"later"}`, nil, owner); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid create: status %d", rec.Code)
+	}
+	key := http.Header{"Idempotency-Key": {"create-1"}}
+	for range 2 {
+		if rec := spec.call(t, router, http.MethodPost, "/tasks", "/tasks", `{"title":"Once"}`, key, owner); rec.Code != http.StatusCreated {
+			t.Errorf("idempotent create: status %d", rec.Code)
+		}
+	}
+	if rec := spec.call(t, router, http.MethodPost, "/tasks", "/tasks", `{"title":"Twice"}`, key, owner); rec.Code != http.StatusConflict {
+		t.Errorf("reused idempotency key: status %d", rec.Code)
+	}
+	spec.call(t, router, http.MethodGet, "/tasks", "/tasks", "", nil, owner)
+	spec.call(t, router, http.MethodGet, "/tasks/{id}", id, "", nil, owner)
+	if rec := spec.call(t, router, http.MethodGet, "/tasks/{id}", id, "", nil, other); rec.Code != http.StatusNotFound {
+		t.Errorf("get as other user: status %d", rec.Code)
+	}
+	spec.call(t, router, http.MethodPut, "/tasks/{id}", id, `{"title":"Publish spec","status":"completed","due_date":null}`, nil, owner)
+	spec.call(t, router, http.MethodPatch, "/tasks/{id}", id, `{"description":null}`, http.Header{"If-Match": {`"2"`}}, owner)
+	if rec := spec.call(t, router, http.MethodPatch, "/tasks/{id}", id, `{"status":"done"}`, nil, owner); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid patch: status %d", rec.Code)
+	}
+	if rec := spec.call(t, router, http.MethodPut, "/tasks/{id}", id, `{"title":"Too late"}`, http.Header{"If-Match": {`"2"`}}, owner); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("put with stale If-Match: status %d", rec.Code)
+	}
+	bulk := `{"ordered":false,"operations":[` +
+		`{"op":"create","task":{"title":"Batch"}},` +
+		`{"op":"update","id":"` + created.ID.Hex() + `","patch":{"status":"pending","due_date":null}},` +
+		`{"op":"update","id":"` + created.ID.Hex() + `","version":1,"patch":{"title":"Stale"}},` +
+		`{"op":"delete","id":"` + primitive.NewObjectID().Hex() + `"}]}`
+	if rec := spec.call(t, router, http.MethodPost, "/tasks/bulk", "/tasks/bulk", bulk, nil, owner); rec.Code != http.StatusOK {
+		t.Errorf("bulk: status %d", rec.Code)
+	}
+	if rec := spec.call(t, router, http.MethodPost, "/tasks/bulk", "/tasks/bulk", `{"operations":[]}`, nil, owner); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty bulk: status %d", rec.Code)
+	}
+	if rec := spec.call(t, router, http.MethodGet, "/tasks/{id}/revisions", id+"/revisions", "", nil, owner); rec.Code != http.StatusOK {
+		t.Errorf("revisions: status %d", rec.Code)
+	}
+	if rec := spec.call(t, router, http.MethodGet, "/tasks/{id}/revisions", id+"/revisions", "", nil, other); rec.Code != http.StatusNotFound {
+		t.Errorf("revisions as other user: status %d", rec.Code)
+	}
+	spec.call(t, router, http.MethodDelete, "/tasks/{id}", id, "", nil, owner)
+	if rec := spec.call(t, router, http.MethodDelete, "/tasks/{id}", id, "", nil, owner); rec.Code != http.StatusNotFound {
+		t.Errorf("second delete: status %d", rec.Code)
+	}
+
+	// Every documented operation must have been exercised above.
+	for path, item := range spec.lookup("/paths").(map[string]any) {
+		for method := range item.(map[string]any) {
+			if method == "parameters" {
+				continue
+			}
+			if key := strings.ToUpper(method) + " " + path; !spec.covered[key] {
+				t.Errorf("%s is documented but not tested", key)
+			}
+		}
+	}
+}
+
+// jsonFields returns the JSON names of v's fields and the subset without
+// omitempty or omitzero.
+func jsonFields(v any) (all, required []string) {
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		name, opts, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		all = append(all, name)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(all)
+	return all, required
+}
+
+func (s *openAPI) properties(schema string) []string {
+	var names []string
+	for name := range s.lookup("/components/schemas/" + schema + "/properties").(map[string]any) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestSpecMatchesModels fails when a field is added to or removed from the
+// Go types without updating the spec.
+func TestSpecMatchesModels(t *testing.T) {
+	spec := loadSpec(t)
+
+	fields, required := jsonFields(models.Task{})
+	if got := spec.properties("Task"); !reflect.DeepEqual(got, fields) {
+		t.Errorf("Task schema properties = %v, models.Task has %v", got, fields)
+	}
+	specRequired := map[string]bool{}
+	for _, name := range spec.lookup("/components/schemas/Task/required").([]any) {
+		specRequired[name.(string)] = true
+	}
+	for _, name := range required {
+		if !specRequired[name] {
+			t.Errorf("Task.%s is always serialized but not required in the spec", name)
+		}
+	}
+
+	inputs, _ := jsonFields(taskPayload{})
+	if got := spec.properties("TaskInput"); !reflect.DeepEqual(got, inputs) {
+		t.Errorf("TaskInput schema properties = %v, taskPayload has %v", got, inputs)
+	}
+
+	problem, _ := jsonFields(Problem{})
+	if got := spec.properties("Problem"); !reflect.DeepEqual(got, problem) {
+		t.Errorf("Problem schema properties = %v, Problem has %v", got, problem)
+	}
+
+	results, _ := jsonFields(bulkResult{})
+	if got := spec.properties("BulkResult"); !reflect.DeepEqual(got, results) {
+		t.Errorf("BulkResult schema properties = %v, bulkResult has %v", got, results)
+	}
+
+	revision, _ := jsonFields(models.TaskRevision{})
+	if got := spec.properties("TaskRevision"); !reflect.DeepEqual(got, revision) {
+		t.Errorf("TaskRevision schema properties = %v, models.TaskRevision has %v", got, revision)
+	}
+	change, _ := jsonFields(models.FieldChange{})
+	if got := spec.properties("FieldChange"); !reflect.DeepEqual(got, change) {
+		t.Errorf("FieldChange schema properties = %v, models.FieldChange has %v", got, change)
+	}
+
+	var statuses []string
+	for _, s := range spec.lookup("/components/schemas/TaskStatus/enum").([]any) {
+		statuses = append(statuses, s.(string))
+	}
+	want := []string{models.StatusPending, models.StatusInProgress, models.StatusCompleted}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("TaskStatus enum = %v, want %v", statuses, want)
+	}
+}
+
+func TestLegacyAPIRoutes(t *testing.T) {
+	router := newTestAPIRouter(memory.NewTaskRepository())
+	owner := primitive.NewObjectID()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, apiRequest(http.MethodGet, "/api/tasks/"+primitive.NewObjectID().Hex(), "", owner))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("legacy get: status %d", rec.Code)
+	}
+	if got := rec.Header().Get("Deprecation"); got != legacyDeprecation {
+		t.Errorf("Deprecation = %q", got)
+	}
+	if got := rec.Header().Get("Link"); !strings.HasPrefix(got, "</api/v1/tasks/") || !strings.Contains(got, `rel="successor-version"`) {
+		t.Errorf("Link = %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, apiRequest(http.MethodGet, APIPrefix+"/tasks", "", owner))
+	if rec.Header().Get("Deprecation") != "" {
+		t.Error("versioned route is marked deprecated")
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, apiRequest(http.MethodPatch, APIPrefix+"/tasks", "", owner))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("PATCH /tasks: status %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("405 Content-Type = %q", ct)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, apiRequest(http.MethodDelete, "/api/tasks", "", owner))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("legacy DELETE /tasks: status %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, apiRequest(http.MethodGet, APIPrefix+"/projects", "", owner))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown path: status %d", rec.Code)
+	}
+}
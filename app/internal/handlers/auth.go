@@ -1,181 +1,429 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/flash"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/sso"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/twofactor"
+	"github.com/cfegela/azure-aca-go-templ-mongo/web/templates"
+)
+
+// pendingLoginCookie holds the token of a login waiting for its second
+// step, which has to be completed within pendingLoginExpiry.
+const (
+	pendingLoginCookie = "login_2fa"
+	pendingLoginExpiry = 5 * time.Minute
 )
 
 type AuthHandler struct {
-	userRepo   *database.UserRepository
-	inviteRepo *database.InviteRepository
+	userRepo   database.UserStore
+	inviteRepo database.InviteStore
+	twoFactor  *twofactor.Service
+	sso        *sso.Service
+	passwords  *auth.PasswordPolicy
 	authConfig *auth.Config
-	jwtExpiry  time.Duration
 }
 
-func NewAuthHandler(userRepo *database.UserRepository, inviteRepo *database.InviteRepository, authConfig *auth.Config, jwtExpiry time.Duration) *AuthHandler {
+// NewAuthHandler serves logging in and registering. sso is nil when single
+// sign-on is not configured; passwords is the policy new passwords are
+// checked against; authConfig.Sessions must be set.
+func NewAuthHandler(userRepo database.UserStore, inviteRepo database.InviteStore, twoFactor *twofactor.Service, sso *sso.Service, passwords *auth.PasswordPolicy, authConfig *auth.Config) *AuthHandler {
 	return &AuthHandler{
 		userRepo:   userRepo,
 		inviteRepo: inviteRepo,
+		twoFactor:  twoFactor,
+		sso:        sso,
+		passwords:  passwords,
 		authConfig: authConfig,
-		jwtExpiry:  jwtExpiry,
 	}
 }
 
-func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func (h *AuthHandler) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	render(w, r, "Login", templates.Login(nil, h.sso.ButtonLabel()))
+}
 
+func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
 	password := r.FormValue("password")
 
-	if email == "" || password == "" {
-		http.Redirect(w, r, "/login?error=missing_fields", http.StatusSeeOther)
+	var errs models.ValidationErrors
+	if email == "" {
+		errs.Add("email", "email is required")
+	}
+	if password == "" {
+		errs.Add("password", "password is required")
+	}
+	if len(errs) > 0 {
+		metrics.LoginFailed("missing_fields")
+		renderStatus(w, r, http.StatusBadRequest, "Login", templates.Login(templates.NewFormState(r.PostForm, errs), h.sso.ButtonLabel()))
 		return
 	}
 
 	user, err := h.userRepo.FindByEmail(r.Context(), email)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		writePageError(w, r, err)
+		return
+	}
+	if err == nil && user.DeletionDue() {
+		err = database.ErrUserNotFound
+	}
 	if err != nil {
-		http.Redirect(w, r, "/login?error=invalid_credentials", http.StatusSeeOther)
+		logging.FromContext(r.Context()).Info("login failed", "email_hash", emailHash(email), "reason", "unknown_user")
+		metrics.LoginFailed("unknown_user")
+		h.invalidCredentials(w, r)
 		return
 	}
 
 	if !auth.CheckPassword(user.PasswordHash, password) {
-		http.Redirect(w, r, "/login?error=invalid_credentials", http.StatusSeeOther)
+		logging.FromContext(r.Context()).Info("login failed", "user_id", user.ID.Hex(), "reason", "wrong_password")
+		metrics.LoginFailed("wrong_password")
+		h.invalidCredentials(w, r)
 		return
 	}
+	h.upgradePasswordHash(r, user, password)
 
-	token, err := auth.GenerateToken(user.ID, user.Email, user.Role, h.authConfig.JWTSecret, h.jwtExpiry)
+	// "Remember me" keeps the session when the browser is closed.
+	next, err := h.completeLogin(w, r, user, r.FormValue("remember") != "")
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writePageError(w, r, err)
 		return
 	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   int(h.jwtExpiry.Seconds()),
-	})
-
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, next, http.StatusSeeOther)
 }
 
-func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// upgradePasswordHash replaces the user's password hash after a
+// successful login if it is a bcrypt hash or has outdated parameters. The
+// login goes ahead if that fails; it is tried again on the next one.
+func (h *AuthHandler) upgradePasswordHash(r *http.Request, user *models.User, password string) {
+	if !auth.NeedsRehash(user.PasswordHash) {
+		return
+	}
+	hash, err := auth.HashPassword(password)
+	if err == nil {
+		err = h.userRepo.SetPasswordHash(r.Context(), user.ID, hash)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to upgrade password hash", "error", err, "user_id", user.ID.Hex())
 		return
 	}
+	user.PasswordHash = hash
+	logging.FromContext(r.Context()).Info("password hash upgraded", "user_id", user.ID.Hex())
+}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:   "token",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
-	})
+// completeLogin follows a successful first login step, with a password or
+// single sign-on. It starts the session, persistent if the user asked to be
+// remembered, or, with two-factor authentication, the second step, and
+// returns where to send the user next.
+func (h *AuthHandler) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, remember bool) (string, error) {
+	// With two-factor authentication the session is only issued after the
+	// second step; until then the browser holds a short-lived token that
+	// is good for nothing else.
+	required, err := h.twoFactor.Required(r.Context(), user)
+	if err != nil {
+		return "", err
+	}
+	if user.TwoFactorEnabled || required {
+		token, err := auth.GeneratePendingToken(user.ID, remember, h.authConfig.JWTSecret, pendingLoginExpiry)
+		if err != nil {
+			return "", err
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     pendingLoginCookie,
+			Value:    token,
+			Path:     "/login",
+			HttpOnly: true,
+			Secure:   false,
+			SameSite: http.SameSiteStrictMode,
+			MaxAge:   int(pendingLoginExpiry.Seconds()),
+		})
+		return "/login/2fa", nil
+	}
 
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	if err := h.startSession(w, r, user, remember); err != nil {
+		return "", err
+	}
+	return "/", nil
 }
 
-func (h *AuthHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// ShowLoginTwoFactor asks for the second login step: a code, or setting
+// up two-factor authentication if the user is required to and has not.
+func (h *AuthHandler) ShowLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := h.pendingUser(w, r)
+	if !ok {
 		return
 	}
+	render(w, r, "Two-Factor Authentication", templates.LoginTwoFactor(!user.TwoFactorEnabled, templates.NewFormState(nil, nil)))
+}
 
-	token := strings.TrimPrefix(r.URL.Path, "/register/")
-	if token == "" {
-		http.Error(w, "Invalid invite token", http.StatusBadRequest)
+func (h *AuthHandler) HandleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, remember, ok := h.pendingUser(w, r)
+	if !ok {
 		return
 	}
 
-	invite, err := h.inviteRepo.FindByToken(r.Context(), token)
+	recovery, err := h.twoFactor.Verify(r.Context(), user, r.FormValue("code"))
+	if errors.Is(err, twofactor.ErrTooManyAttempts) {
+		logging.FromContext(r.Context()).Warn("login failed", "user_id", user.ID.Hex(), "reason", "too_many_codes")
+		metrics.LoginFailed("too_many_codes")
+		clearPendingLoginCookie(w)
+		w.Header().Set("Retry-After", strconv.Itoa(int(twofactor.AttemptWindow.Seconds())))
+		flash.Error(r.Context(), fmt.Sprintf("Too many wrong codes. Please wait %d minutes and log in again.",
+			int(twofactor.AttemptWindow.Minutes())))
+		renderStatus(w, r, http.StatusTooManyRequests, "Login",
+			templates.Login(templates.NewFormState(nil, nil), h.sso.ButtonLabel()))
+		return
+	}
+	if errors.Is(err, twofactor.ErrInvalidCode) {
+		logging.FromContext(r.Context()).Info("login failed", "user_id", user.ID.Hex(), "reason", "wrong_code")
+		metrics.LoginFailed("wrong_code")
+		var errs models.ValidationErrors
+		errs.Add("code", "the code is wrong or was already used")
+		renderStatus(w, r, http.StatusUnauthorized, "Two-Factor Authentication",
+			templates.LoginTwoFactor(false, templates.NewFormState(r.PostForm, errs)))
+		return
+	}
 	if err != nil {
-		http.Redirect(w, r, "/register/"+token+"?error=invalid_invite", http.StatusSeeOther)
+		writePageError(w, r, err)
 		return
 	}
+	if recovery {
+		logging.FromContext(r.Context()).Info("recovery code used", "user_id", user.ID.Hex())
+		flash.Info(r.Context(), fmt.Sprintf("You used a recovery code; %d are left. You can get new ones from your account page.",
+			len(user.RecoveryCodes)-1))
+	}
 
-	if !invite.IsValid() {
-		http.Redirect(w, r, "/register/"+token+"?error=invite_expired", http.StatusSeeOther)
+	if err := h.startSession(w, r, user, remember); err != nil {
+		writePageError(w, r, err)
 		return
 	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
 
-	name := r.FormValue("name")
-	email := r.FormValue("email")
-	password := r.FormValue("password")
-	confirmPassword := r.FormValue("confirm_password")
+// SetupLoginTwoFactor starts enrolling a user who has to set up
+// two-factor authentication before logging in.
+func (h *AuthHandler) SetupLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := h.pendingUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled {
+		http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return
+	}
 
-	if name == "" || email == "" || password == "" {
-		http.Redirect(w, r, "/register/"+token+"?error=missing_fields", http.StatusSeeOther)
+	enrollment, err := h.twoFactor.Begin(r.Context(), user)
+	if err != nil {
+		writePageError(w, r, err)
 		return
 	}
+	render(w, r, "Set Up Two-Factor Authentication",
+		templates.TwoFactorSetup("", "/login/2fa/confirm", enrollment, templates.NewFormState(nil, nil)))
+}
 
-	if email != invite.Email {
-		http.Redirect(w, r, "/register/"+token+"?error=email_mismatch", http.StatusSeeOther)
+// ConfirmLoginTwoFactor finishes enrolling during login, then logs the
+// user in and shows their recovery codes.
+func (h *AuthHandler) ConfirmLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, remember, ok := h.pendingUser(w, r)
+	if !ok {
 		return
 	}
 
-	if password != confirmPassword {
-		http.Redirect(w, r, "/register/"+token+"?error=password_mismatch", http.StatusSeeOther)
+	codes, ok := confirmTwoFactor(w, r, h.twoFactor, user, "", "/login/2fa/confirm")
+	if !ok {
 		return
 	}
+	logging.FromContext(r.Context()).Info("two-factor authentication enabled", "user_id", user.ID.Hex())
 
-	if err := models.ValidatePassword(password); err != nil {
-		http.Redirect(w, r, "/register/"+token+"?error="+err.Error(), http.StatusSeeOther)
+	// Sessions started without it end.
+	if err := h.authConfig.Sessions.RevokeAll(r.Context(), user.ID); err != nil {
+		writePageError(w, r, err)
 		return
 	}
+	if err := h.startSession(w, r, user, remember); err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	render(w, r, "Recovery Codes", templates.RecoveryCodes("", codes, "/"))
+}
 
-	hashedPassword, err := auth.HashPassword(password)
+// pendingUser returns the user whose login is waiting for its second step,
+// and whether they asked to be remembered. If there is none, or it has
+// expired, it sends them back to the login form and returns false.
+func (h *AuthHandler) pendingUser(w http.ResponseWriter, r *http.Request) (*models.User, bool, bool) {
+	if cookie, err := r.Cookie(pendingLoginCookie); err == nil {
+		if claims, err := auth.ValidatePendingToken(cookie.Value, h.authConfig.JWTSecret); err == nil {
+			user, err := h.userRepo.FindByID(r.Context(), claims.UserID)
+			if err == nil {
+				return user, claims.Remember, true
+			}
+			if !errors.Is(err, database.ErrNotFound) {
+				writePageError(w, r, err)
+				return nil, false, false
+			}
+		}
+	}
+
+	clearPendingLoginCookie(w)
+	flash.Info(r.Context(), "Your login has expired. Please log in again.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	return nil, false, false
+}
+
+// startSession sets the session cookies for user and ends any login
+// waiting for its second step.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user *models.User, persistent bool) error {
+	if user.DeletionPending() {
+		flash.Info(r.Context(), fmt.Sprintf("Your account will be deleted on %s. You can keep it from your account page.",
+			user.DeletionScheduledAt.Format("Jan 02, 2006")))
+	}
+
+	if err := h.authConfig.Sessions.Start(r.Context(), w, user, persistent); err != nil {
+		return err
+	}
+	clearPendingLoginCookie(w)
+
+	logging.FromContext(r.Context()).Info("login succeeded", "user_id", user.ID.Hex(), "persistent", persistent)
+	metrics.LoginSucceeded()
+	return nil
+}
+
+// invalidCredentials shows the login form again with the email kept. The
+// message does not reveal whether the email or the password was wrong.
+func (h *AuthHandler) invalidCredentials(w http.ResponseWriter, r *http.Request) {
+	flash.Error(r.Context(), "Invalid email or password.")
+	renderStatus(w, r, http.StatusUnauthorized, "Login",
+		templates.Login(templates.NewFormState(r.PostForm, nil), h.sso.ButtonLabel()))
+}
+
+// HandleLogout revokes the session, so its refresh token cannot renew it
+// even if it was copied.
+func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := h.authConfig.Sessions.End(r.Context(), w, r); err != nil {
+		logging.FromContext(r.Context()).Error("failed to revoke session", "error", err)
+	}
+
+	flash.Info(r.Context(), "You have been logged out.")
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (h *AuthHandler) ShowRegister(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	invite, err := h.inviteRepo.FindByToken(r.Context(), token)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && !invite.IsValid()) {
+		writePageError(w, r, errorStatus(http.StatusBadRequest, "This invite link is invalid or has expired."))
+		return
+	}
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writePageError(w, r, err)
+		return
+	}
+
+	render(w, r, "Register", templates.Register(token, invite.Email, h.passwords.MinLength(), nil))
+}
+
+func (h *AuthHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	invite, err := h.inviteRepo.FindByToken(r.Context(), token)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		writePageError(w, r, err)
 		return
 	}
+	if err != nil || !invite.IsValid() {
+		writePageError(w, r, errorStatus(http.StatusBadRequest, "This invite link is invalid or has expired."))
+		return
+	}
+
+	name := r.FormValue("name")
+	email := r.FormValue("email")
+	password := r.FormValue("password")
+	confirmPassword := r.FormValue("confirm_password")
 
 	user := &models.User{
-		Email:        email,
-		PasswordHash: hashedPassword,
-		Name:         name,
-		Role:         models.RoleUser,
+		Email: email,
+		Name:  name,
+		Role:  models.RoleUser,
+	}
+
+	var errs models.ValidationErrors
+	errs.Merge(user.Validate())
+	if email != "" && email != invite.Email {
+		errs.Add("email", "email must match the invited email")
+	}
+	errs.Merge(h.passwords.Validate(password, email, name))
+	if password != confirmPassword {
+		errs.Add("confirm_password", "passwords do not match")
+	}
+	if len(errs) > 0 {
+		h.showRegister(w, r, http.StatusBadRequest, token, invite.Email, errs)
+		return
 	}
 
-	if err := user.Validate(); err != nil {
-		http.Redirect(w, r, "/register/"+token+"?error="+err.Error(), http.StatusSeeOther)
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		writePageError(w, r, err)
 		return
 	}
+	user.PasswordHash = hashedPassword
 
 	if err := h.userRepo.Create(r.Context(), user); err != nil {
-		http.Redirect(w, r, "/register/"+token+"?error="+err.Error(), http.StatusSeeOther)
+		if errors.Is(err, database.ErrEmailExists) {
+			errs.Add("email", "an account with this email already exists")
+			h.showRegister(w, r, http.StatusConflict, token, invite.Email, errs)
+			return
+		}
+		writePageError(w, r, err)
 		return
 	}
 
 	if err := h.inviteRepo.MarkUsed(r.Context(), token); err != nil {
 		// User created but invite not marked - log this but continue
+		logging.FromContext(r.Context()).Error("failed to mark invite used",
+			"error", err, "invite_id", invite.ID.Hex(), "user_id", user.ID.Hex())
 	}
 
-	jwtToken, err := auth.GenerateToken(user.ID, user.Email, user.Role, h.authConfig.JWTSecret, h.jwtExpiry)
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if err := h.authConfig.Sessions.Start(r.Context(), w, user, false); err != nil {
+		writePageError(w, r, err)
 		return
 	}
 
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// emailHash identifies a submitted email in the logs without recording
+// it, which may be someone else's address or a mistyped password. Repeated
+// attempts with the same email share a hash.
+func emailHash(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:8])
+}
+
+func clearPendingLoginCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    jwtToken,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   int(h.jwtExpiry.Seconds()),
+		Name:   pendingLoginCookie,
+		Value:  "",
+		Path:   "/login",
+		MaxAge: -1,
 	})
+}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+// showRegister renders the registration form again with the submitted
+// values and field errors.
+func (h *AuthHandler) showRegister(w http.ResponseWriter, r *http.Request, status int, token, inviteEmail string, errs models.ValidationErrors) {
+	renderStatus(w, r, status, "Register",
+		templates.Register(token, inviteEmail, h.passwords.MinLength(), templates.NewFormState(r.PostForm, errs)))
 }
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/twofactor"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestAuthConfig returns an auth.Config that signs with a secret and
+// keeps sessions in memory.
+func newTestAuthConfig(users database.UserStore) *auth.Config {
+	keys := auth.NewKeyset(nil, "test-secret", true, nil)
+	return &auth.Config{
+		JWTSecret: "test-secret",
+		Keys:      keys,
+		Sessions: auth.NewSessions(users, memory.NewRefreshTokenRepository(), keys, auth.SessionConfig{
+			AccessExpiry:   15 * time.Minute,
+			RefreshExpiry:  time.Hour,
+			RememberExpiry: 30 * 24 * time.Hour,
+		}),
+	}
+}
+
+// newTestPasswordPolicy returns the default password policy.
+func newTestPasswordPolicy(t *testing.T) *auth.PasswordPolicy {
+	t.Helper()
+	policy, err := auth.NewPasswordPolicy(auth.PasswordPolicyConfig{
+		MinLength:      8,
+		MaxLength:      128,
+		RejectCommon:   true,
+		RejectPersonal: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestSessionRenewal(t *testing.T) {
+	users := memory.NewUserRepository()
+	twoFactor, err := twofactor.NewService(users, memory.NewSettingsRepository(), nil, "Task Manager", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Access tokens expire as soon as they are issued, so every request
+	// has to renew its session.
+	keys := auth.NewKeyset(nil, "test-secret", true, nil)
+	authConfig := &auth.Config{
+		JWTSecret: "test-secret",
+		Keys:      keys,
+		Sessions: auth.NewSessions(users, memory.NewRefreshTokenRepository(), keys, auth.SessionConfig{
+			AccessExpiry:   -time.Second,
+			RefreshExpiry:  time.Hour,
+			RememberExpiry: 30 * 24 * time.Hour,
+		}),
+	}
+	h := NewAuthHandler(users, memory.NewInviteRepository(), twoFactor, nil, newTestPasswordPolicy(t), authConfig)
+
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Email: "ada@example.com", Name: "Ada", PasswordHash: hash, Role: models.RoleUser}
+	if err := users.Create(t.Context(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	cookie := func(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+	login := func(form url.Values) *http.Cookie {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.HandleLogin(rec, formRequest("/login", form, primitive.NilObjectID))
+		refresh := cookie(rec, auth.RefreshCookie)
+		if rec.Code != http.StatusSeeOther || refresh == nil || cookie(rec, auth.AccessCookie) == nil {
+			t.Fatalf("login: status = %d, cookies = %v", rec.Code, rec.Result().Cookies())
+		}
+		return refresh
+	}
+	protected := auth.RequireAuth(authConfig)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := auth.GetUserFromContext(r.Context()); !ok || claims.UserID != user.ID {
+			t.Errorf("claims = %+v", claims)
+		}
+	}))
+	get := func(refresh *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(refresh)
+		rec := httptest.NewRecorder()
+		protected.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Without "remember me" the cookies go when the browser is closed.
+	form := url.Values{"email": {user.Email}, "password": {"correct horse"}}
+	if refresh := login(form); refresh.MaxAge != 0 {
+		t.Errorf("session cookie has Max-Age %d", refresh.MaxAge)
+	}
+	form.Set("remember", "on")
+	first := login(form)
+	if first.MaxAge < 29*24*60*60 {
+		t.Errorf("remembered session cookie has Max-Age %d, want 30 days", first.MaxAge)
+	}
+
+	// An expired access token is renewed, and the refresh token replaced
+	// by one that is still persistent.
+	rec := get(first)
+	second := cookie(rec, auth.RefreshCookie)
+	if rec.Code != http.StatusOK || second == nil || second.Value == first.Value || second.MaxAge <= 0 {
+		t.Fatalf("renewal: status = %d, refresh cookie = %v", rec.Code, second)
+	}
+
+	// A request that raced the renewal with the same refresh token gets an
+	// access token, but no refresh token of its own.
+	rec = get(first)
+	if rec.Code != http.StatusOK || cookie(rec, auth.AccessCookie) == nil || cookie(rec, auth.RefreshCookie) != nil {
+		t.Fatalf("concurrent renewal: status = %d, cookies = %v", rec.Code, rec.Result().Cookies())
+	}
+
+	// Logging out revokes the session.
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(second)
+	h.HandleLogout(httptest.NewRecorder(), req)
+	rec = get(second)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("after logout: status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestLoginUpgradesBcryptHash(t *testing.T) {
+	users := memory.NewUserRepository()
+	twoFactor, err := twofactor.NewService(users, memory.NewSettingsRepository(), nil, "Task Manager", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewAuthHandler(users, memory.NewInviteRepository(), twoFactor, nil, newTestPasswordPolicy(t), newTestAuthConfig(users))
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Email: "ada@example.com", Name: "Ada", PasswordHash: string(legacy), Role: models.RoleUser}
+	if err := users.Create(t.Context(), user); err != nil {
+		t.Fatal(err)
+	}
+	login := func(password string) int {
+		rec := httptest.NewRecorder()
+		h.HandleLogin(rec, formRequest("/login", url.Values{"email": {user.Email}, "password": {password}}, primitive.NilObjectID))
+		return rec.Code
+	}
+
+	// A wrong password leaves the hash alone.
+	if code := login("wrong horse"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status = %d", code)
+	}
+	if stored, _ := users.FindByID(t.Context(), user.ID); stored.PasswordHash != string(legacy) {
+		t.Fatal("hash changed after a failed login")
+	}
+
+	if code := login("correct horse"); code != http.StatusSeeOther {
+		t.Fatalf("login: status = %d", code)
+	}
+	stored, err := users.FindByID(t.Context(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored.PasswordHash, "$argon2id$") || auth.NeedsRehash(stored.PasswordHash) {
+		t.Fatalf("hash after login = %q, want argon2id", stored.PasswordHash)
+	}
+
+	// The new hash works, and is kept.
+	if code := login("correct horse"); code != http.StatusSeeOther {
+		t.Fatalf("second login: status = %d", code)
+	}
+	if again, _ := users.FindByID(t.Context(), user.ID); again.PasswordHash != stored.PasswordHash {
+		t.Error("current hash was replaced")
+	}
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	users, invites := memory.NewUserRepository(), memory.NewInviteRepository()
+	twoFactor, err := twofactor.NewService(users, memory.NewSettingsRepository(), nil, "Task Manager", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewAuthHandler(users, invites, twoFactor, nil, newTestPasswordPolicy(t), newTestAuthConfig(users))
+	invite := &models.Invite{Token: "invite-token", Email: "grace@example.com", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := invites.Create(t.Context(), invite); err != nil {
+		t.Fatal(err)
+	}
+
+	register := func(password string) *httptest.ResponseRecorder {
+		req := formRequest("/register/"+invite.Token, url.Values{
+			"name":             {"Grace Hopper"},
+			"email":            {invite.Email},
+			"password":         {password},
+			"confirm_password": {password},
+		}, primitive.NilObjectID)
+		req.SetPathValue("token", invite.Token)
+		rec := httptest.NewRecorder()
+		h.HandleRegister(rec, req)
+		return rec
+	}
+
+	for password, want := range map[string]string{
+		"short":              "at least 8 characters",
+		"iloveyou":           "too common",
+		"GraceHopper1906":    "must not contain your email or name",
+		"grace@example.com!": "must not contain your email or name",
+	} {
+		rec := register(password)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("password %q: status = %d, want 400 with %q", password, rec.Code, want)
+		}
+	}
+	if n, _ := users.Count(t.Context()); n != 0 {
+		t.Fatalf("%d users created with rejected passwords", n)
+	}
+
+	if rec := register("quiet harbour lamp"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("register: status = %d", rec.Code)
+	}
+	user, err := users.FindByEmail(t.Context(), invite.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(user.PasswordHash, "$argon2id$") {
+		t.Errorf("hash = %q, want argon2id", user.PasswordHash)
+	}
+}
+
+func TestLoginFailureLogsNoEmail(t *testing.T) {
+	users := memory.NewUserRepository()
+	twoFactor, err := twofactor.NewService(users, memory.NewSettingsRepository(), nil, "Task Manager", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewAuthHandler(users, memory.NewInviteRepository(), twoFactor, nil, newTestPasswordPolicy(t), newTestAuthConfig(users))
+	user := &models.User{Email: "ada@example.com", Name: "Ada", Role: models.RoleUser}
+	if err := users.Create(t.Context(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	logger, err := logging.New(&logs, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Someone types their password into the email field, then gets the
+	// password wrong.
+	for _, email := range []string{"correct horse", user.Email} {
+		req := formRequest("/login", url.Values{"email": {email}, "password": {"wrong horse"}}, primitive.NilObjectID)
+		h.HandleLogin(httptest.NewRecorder(), req.WithContext(logging.NewContext(req.Context(), logger)))
+	}
+
+	out := logs.String()
+	for _, leaked := range []string{"correct horse", "ada@example.com", "wrong horse"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("logs contain %q:\n%s", leaked, out)
+		}
+	}
+	if !strings.Contains(out, emailHash("Correct Horse ")) || !strings.Contains(out, user.ID.Hex()) {
+		t.Errorf("logs identify neither attempt:\n%s", out)
+	}
+}
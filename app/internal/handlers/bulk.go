@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+)
+
+// MaxBulkOperations is the largest batch BulkTasks accepts.
+const MaxBulkOperations = 100
+
+type bulkRequest struct {
+	// Ordered defaults to true, as for MongoDB bulk writes.
+	Ordered    *bool           `json:"ordered"`
+	Operations []bulkOperation `json:"operations"`
+}
+
+type bulkOperation struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id"`
+	Version int64           `json:"version"`
+	Task    json.RawMessage `json:"task"`
+	Patch   json.RawMessage `json:"patch"`
+}
+
+type bulkResponse struct {
+	Results []bulkResult `json:"results"`
+}
+
+// bulkResult is the outcome of one operation. Status is the HTTP status
+// the operation would have had as a single request.
+type bulkResult struct {
+	Index  int          `json:"index"`
+	Status int          `json:"status"`
+	Task   *models.Task `json:"task,omitempty"`
+	Error  *Problem     `json:"error,omitempty"`
+}
+
+// BulkTasks applies a batch of create, update and delete operations in one
+// database round trip. The response is 200 with a result per operation
+// whenever the batch itself is well formed, even if operations failed. In
+// ordered mode (the default) the first failure stops the batch.
+func (h *TaskHandler) BulkTasks(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, r, errorStatus(http.StatusUnauthorized, "Authentication required"))
+		return
+	}
+
+	defer r.Body.Close()
+	var req bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, r, errorStatus(http.StatusBadRequest, "Invalid request payload"))
+		return
+	}
+	if len(req.Operations) == 0 {
+		writeAPIError(w, r, errorStatus(http.StatusBadRequest, "operations must not be empty"))
+		return
+	}
+	if len(req.Operations) > MaxBulkOperations {
+		writeAPIError(w, r, errorStatus(http.StatusBadRequest,
+			fmt.Sprintf("A batch may contain at most %d operations", MaxBulkOperations)))
+		return
+	}
+	ordered := req.Ordered == nil || *req.Ordered
+
+	// Operations that fail validation never reach the store. In ordered
+	// mode nothing after the first of them is sent either.
+	errs := make([]error, len(req.Operations))
+	var ops []database.TaskOperation
+	var sent []int // index into req.Operations of each op
+	for i, item := range req.Operations {
+		op, err := parseBulkOperation(item)
+		if err != nil {
+			errs[i] = err
+			if ordered {
+				for j := i + 1; j < len(errs); j++ {
+					errs[j] = database.ErrNotAttempted
+				}
+				break
+			}
+			continue
+		}
+		ops = append(ops, op)
+		sent = append(sent, i)
+	}
+
+	tasks := make([]*models.Task, len(req.Operations))
+	if len(ops) > 0 {
+		results, err := h.repo.BulkWriteByUserID(r.Context(), claims.UserID, ops, ordered)
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		for j, result := range results {
+			tasks[sent[j]], errs[sent[j]] = result.Task, result.Err
+		}
+	}
+
+	resp := bulkResponse{Results: make([]bulkResult, len(req.Operations))}
+	for i, item := range req.Operations {
+		result := bulkResult{Index: i, Task: tasks[i]}
+		if errs[i] != nil {
+			p := problemFor(errs[i])
+			logError(r, p, errs[i])
+			result.Status, result.Task, result.Error = p.Status, nil, &p
+		} else {
+			result.Status = bulkStatus(database.TaskOperationKind(item.Op))
+		}
+		resp.Results[i] = result
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// bulkStatus is the status of a successful operation.
+func bulkStatus(kind database.TaskOperationKind) int {
+	switch kind {
+	case database.TaskCreate:
+		return http.StatusCreated
+	case database.TaskDelete:
+		return http.StatusNoContent
+	default:
+		return http.StatusOK
+	}
+}
+
+// parseBulkOperation validates one operation of a batch.
+func parseBulkOperation(item bulkOperation) (database.TaskOperation, error) {
+	op := database.TaskOperation{
+		Kind:    database.TaskOperationKind(item.Op),
+		ID:      item.ID,
+		Version: item.Version,
+	}
+	switch op.Kind {
+	case database.TaskCreate:
+		if len(item.Task) == 0 {
+			return op, errorStatus(http.StatusBadRequest, "create requires a task")
+		}
+		task, err := parseTask(bytes.NewReader(item.Task))
+		if err != nil {
+			return op, err
+		}
+		op.Task = task
+	case database.TaskUpdate:
+		if len(item.Patch) == 0 {
+			return op, errorStatus(http.StatusBadRequest, "update requires a patch")
+		}
+		patch, err := parseTaskPatch(item.Patch)
+		if err != nil {
+			return op, err
+		}
+		op.Patch = patch
+	case database.TaskDelete:
+	default:
+		return op, errorStatus(http.StatusBadRequest, "op must be create, update, or delete")
+	}
+	return op, nil
+}
+
+// parseTaskPatch reads a merge patch of the task fields, as accepted by
+// PatchTask: missing fields are kept and null clears a field. Unknown
+// fields are ignored.
+func parseTaskPatch(raw json.RawMessage) (*models.TaskPatch, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return nil, errorStatus(http.StatusBadRequest, "The patch must be a JSON object")
+	}
+
+	var patch models.TaskPatch
+	var errs models.ValidationErrors
+	patch.Title = patchString(fields, "title", &errs)
+	patch.Description = patchString(fields, "description", &errs)
+	patch.Status = patchString(fields, "status", &errs)
+	if value, ok := fields["due_date"]; ok {
+		if string(value) == "null" {
+			patch.ClearDueDate = true
+		} else {
+			var dueDate time.Time
+			if err := json.Unmarshal(value, &dueDate); err != nil {
+				errs.Add("due_date", "due_date must be an RFC 3339 timestamp")
+			} else {
+				patch.DueDate = &dueDate
+			}
+		}
+	}
+
+	errs.Merge(patch.Validate())
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return &patch, nil
+}
+
+// patchString reads a string field of a merge patch. Null sets it to the
+// empty string, which validation treats as for a full update.
+func patchString(fields map[string]json.RawMessage, name string, errs *models.ValidationErrors) *string {
+	value, ok := fields[name]
+	if !ok {
+		return nil
+	}
+	var s string
+	if string(value) != "null" {
+		if err := json.Unmarshal(value, &s); err != nil {
+			errs.Add(name, name+" must be a string")
+			return nil
+		}
+	}
+	return &s
+}
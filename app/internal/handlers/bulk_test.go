@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func postBulk(t *testing.T, h http.Handler, body string, userID primitive.ObjectID) bulkResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, apiRequest(http.MethodPost, APIPrefix+"/tasks/bulk", body, userID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bulk: status %d: %s", rec.Code, rec.Body)
+	}
+	var resp bulkResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func checkStatuses(t *testing.T, resp bulkResponse, want ...int) {
+	t.Helper()
+	if len(resp.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(resp.Results), len(want))
+	}
+	for i, result := range resp.Results {
+		if result.Index != i || result.Status != want[i] {
+			t.Errorf("result %d = index %d status %d, want status %d (%+v)", i, result.Index, result.Status, want[i], result.Error)
+		}
+	}
+}
+
+func TestBulkTasks(t *testing.T) {
+	repo := memory.NewTaskRepository()
+	h := newTestAPIRouter(repo)
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+
+	task := &models.Task{UserID: owner, Title: "Existing"}
+	if err := repo.Create(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+	theirs := &models.Task{UserID: other, Title: "Not yours"}
+	if err := repo.Create(context.Background(), theirs); err != nil {
+		t.Fatal(err)
+	}
+	id := task.ID.Hex()
+
+	resp := postBulk(t, h, `{"ordered":false,"operations":[
+		{"op":"create","task":{"title":"New","due_date":"2026-12-31T00:00:00Z"}},
+		{"op":"create","task":{"title":""}},
+		{"op":"update","id":"`+id+`","patch":{"status":"completed","description":null}},
+		{"op":"update","id":"`+id+`","version":1,"patch":{"title":"Stale"}},
+		{"op":"update","id":"`+theirs.ID.Hex()+`","patch":{"title":"Mine now"}},
+		{"op":"update","id":"`+id+`","patch":{"due_date":"soon"}},
+		{"op":"archive","id":"`+id+`"}
+	]}`, owner)
+	checkStatuses(t, resp, 201, 400, 200, 412, 404, 400, 400)
+	if got := resp.Results[0].Task; got == nil || got.Title != "New" || got.DueDate == nil {
+		t.Errorf("created task = %+v", got)
+	}
+	if got := resp.Results[2].Task; got == nil || got.Status != models.StatusCompleted || got.Version != 2 {
+		t.Errorf("updated task = %+v", got)
+	}
+	if errs := resp.Results[5].Error.Errors; len(errs) != 1 || errs[0].Field != "due_date" {
+		t.Errorf("invalid patch errors = %+v", errs)
+	}
+
+	// Ordered batches stop at the first failure, whether it is caught by
+	// validation or by the store.
+	resp = postBulk(t, h, `{"operations":[
+		{"op":"delete","id":"`+id+`","version":2},
+		{"op":"update","id":"`+id+`","patch":{"title":"Gone"}},
+		{"op":"create","task":{"title":"Skipped"}}
+	]}`, owner)
+	checkStatuses(t, resp, 204, 404, 424)
+
+	resp = postBulk(t, h, `{"operations":[
+		{"op":"create","task":{"title":"Kept"}},
+		{"op":"create","task":{"status":"later"}},
+		{"op":"create","task":{"title":"Skipped"}}
+	]}`, owner)
+	checkStatuses(t, resp, 201, 400, 424)
+
+	tasks, err := repo.FindByUserID(context.Background(), owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 {
+		t.Errorf("owner has %d tasks after the batches, want 2", len(tasks))
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, apiRequest(http.MethodPost, APIPrefix+"/tasks/bulk", `{"operations":{}}`, owner))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed batch: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/middleware"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/web/templates"
+)
+
+// Problem is an RFC 7807 problem details object. Errors and RequestID are
+// extension members.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError is one invalid field in a validation problem.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// statusError is a failure with an explicit HTTP status that is not a
+// domain error, such as a malformed payload or a missing session.
+type statusError struct {
+	status int
+	detail string
+}
+
+func (e *statusError) Error() string { return e.detail }
+
+func errorStatus(status int, detail string) error {
+	return &statusError{status: status, detail: detail}
+}
+
+// problemFor maps an error to the problem reported to the client. Errors
+// that are not recognised become a 500 without leaking their message.
+func problemFor(err error) Problem {
+	var statusErr *statusError
+
+	p := Problem{Type: "about:blank"}
+	if validationErrs, ok := models.AsValidationErrors(err); ok {
+		p.Status = http.StatusBadRequest
+		p.Title = http.StatusText(p.Status)
+		p.Detail = "The request contains invalid fields"
+		for _, e := range validationErrs {
+			p.Errors = append(p.Errors, FieldError{Field: e.Field, Message: e.Message})
+		}
+		return p
+	}
+
+	switch {
+	case errors.As(err, &statusErr):
+		p.Status = statusErr.status
+		p.Detail = statusErr.detail
+	case errors.Is(err, database.ErrNotFound), errors.Is(err, database.ErrInvalidID):
+		// Malformed IDs are reported as not found too, so IDs cannot be
+		// probed for validity.
+		p.Status = http.StatusNotFound
+		p.Detail = err.Error()
+	case errors.Is(err, database.ErrConflict):
+		p.Status = http.StatusConflict
+		p.Detail = err.Error()
+	case errors.Is(err, database.ErrVersionConflict):
+		// Only conditional requests (If-Match) update with a version.
+		p.Status = http.StatusPreconditionFailed
+		p.Detail = err.Error()
+	case errors.Is(err, database.ErrNotAttempted):
+		p.Status = http.StatusFailedDependency
+		p.Detail = err.Error()
+	case errors.Is(err, database.ErrInvalidOperation):
+		p.Status = http.StatusBadRequest
+		p.Detail = err.Error()
+	case errors.Is(err, database.ErrRateLimited):
+		p.Status = http.StatusTooManyRequests
+		p.Detail = err.Error()
+	default:
+		p.Status = http.StatusInternalServerError
+		p.Detail = "An unexpected error occurred"
+	}
+	p.Title = http.StatusText(p.Status)
+	return p
+}
+
+// logError records server-side failures; client errors are not logged.
+func logError(r *http.Request, p Problem, err error) {
+	if p.Status >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("request failed", "status", p.Status, "error", err)
+	}
+}
+
+// writeAPIError responds with application/problem+json.
+func writeAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problemFor(err)
+	logError(r, p, err)
+
+	p.Instance = r.URL.Path
+	p.RequestID = middleware.RequestIDFromContext(r.Context())
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
+
+// writePageError renders the HTML error page with the mapped status.
+func writePageError(w http.ResponseWriter, r *http.Request, err error) {
+	p := problemFor(err)
+	logError(r, p, err)
+
+	userName := ""
+	claims, isAuthenticated := auth.GetUserFromContext(r.Context())
+	if isAuthenticated {
+		userName = claims.Email
+	}
+
+	renderStatus(w, r, p.Status, "ErrorPage", templates.ErrorPage(p.Status, p.Title, p.Detail,
+		middleware.RequestIDFromContext(r.Context()), isAuthenticated, userName))
+}
+
+// NotFound renders the HTML 404 page.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writePageError(w, r, errorStatus(http.StatusNotFound, "The page you requested does not exist."))
+}
+
+// MethodNotAllowed renders the HTML 405 page.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writePageError(w, r, errorStatus(http.StatusMethodNotAllowed, "Method not allowed"))
+}
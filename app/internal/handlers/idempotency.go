@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+)
+
+const (
+	// IdempotencyKeyHeader names the client-chosen key that makes a retried
+	// POST safe.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed from storage.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// IdempotencyKeyField is the form field HTML forms carry the key in.
+	IdempotencyKeyField = "idempotency_key"
+
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodyBytes bounds the body read to fingerprint a request,
+	// before the handler applies its own limit. It allows the largest
+	// request any wrapped handler accepts, an import.
+	maxIdempotentBodyBytes = maxImportBodyBytes
+)
+
+var (
+	errIdempotencyKeyReused = errorStatus(http.StatusConflict,
+		"This Idempotency-Key was already used with a different request")
+	errIdempotencyInProgress = errorStatus(http.StatusConflict,
+		"A request with this Idempotency-Key is still being processed; retry later")
+)
+
+// Idempotency makes POST handlers safe to retry. The first response to a
+// request with an Idempotency-Key is stored for the user and key, and later
+// requests with the same key and body get that response replayed verbatim
+// instead of running the handler again. Requests without a key are passed
+// through.
+type Idempotency struct {
+	store database.IdempotencyStore
+	ttl   time.Duration
+}
+
+// NewIdempotency stores responses for ttl, after which a key may be reused.
+func NewIdempotency(store database.IdempotencyStore, ttl time.Duration) *Idempotency {
+	return &Idempotency{store: store, ttl: ttl}
+}
+
+// API wraps a JSON API handler. Errors are problem+json responses.
+func (i *Idempotency) API(next http.Handler) http.Handler {
+	return i.wrap(next, writeAPIError, false)
+}
+
+// Form wraps an HTML form handler. Browsers cannot set the header, so the
+// key may also be sent in the IdempotencyKeyField form field; a form that
+// renders a fresh key is then safe against double submission.
+func (i *Idempotency) Form(next http.Handler) http.Handler {
+	return i.wrap(next, writePageError, true)
+}
+
+func (i *Idempotency) wrap(next http.Handler, writeError func(http.ResponseWriter, *http.Request, error), formField bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := auth.GetUserFromContext(r.Context())
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if !ok || (key == "" && !formField) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The body is read up front to fingerprint the request, then
+		// handed on to the handler.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+		r.Body.Close()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, errorStatus(http.StatusRequestEntityTooLarge, "The request body is too large"))
+			return
+		}
+		if err != nil {
+			writeError(w, r, errorStatus(http.StatusBadRequest, "Could not read the request body"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if key == "" {
+			key = formKey(r, body)
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, r, errorStatus(http.StatusBadRequest, "Idempotency-Key must be at most 255 characters"))
+			return
+		}
+
+		record := &models.IdempotencyRecord{
+			UserID:      claims.UserID,
+			Key:         key,
+			Fingerprint: fingerprint(r, body),
+			ExpiresAt:   time.Now().Add(i.ttl),
+		}
+		err = i.store.Reserve(r.Context(), record)
+		if errors.Is(err, database.ErrConflict) {
+			i.replay(w, r, record, writeError)
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Unless the response is stored, release the key so a retry runs
+		// the handler again. That covers server errors, which a retry may
+		// not repeat, and panics.
+		stored := false
+		defer func() {
+			if !stored {
+				ctx := context.WithoutCancel(r.Context())
+				if err := i.store.Release(ctx, record.UserID, record.Key); err != nil {
+					logging.FromContext(ctx).Error("failed to release idempotency key", "error", err)
+				}
+			}
+		}()
+
+		rec := &responseCapture{ResponseWriter: w, before: w.Header().Clone()}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			return
+		}
+
+		record.Status, record.Header, record.Body = rec.status, rec.header, rec.body.Bytes()
+		if err := i.store.Complete(context.WithoutCancel(r.Context()), record); err != nil {
+			logging.FromContext(r.Context()).Error("failed to store idempotent response", "error", err)
+			return
+		}
+		stored = true
+	})
+}
+
+// replay writes the response stored for the record's key, provided it was
+// for the same request and is complete.
+func (i *Idempotency) replay(w http.ResponseWriter, r *http.Request, record *models.IdempotencyRecord, writeError func(http.ResponseWriter, *http.Request, error)) {
+	stored, err := i.store.FindByKey(r.Context(), record.UserID, record.Key)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		// Released since Reserve failed: the first request just failed.
+		writeError(w, r, errIdempotencyInProgress)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	case stored.Fingerprint != record.Fingerprint:
+		writeError(w, r, errIdempotencyKeyReused)
+		return
+	case !stored.Completed():
+		writeError(w, r, errIdempotencyInProgress)
+		return
+	}
+
+	logging.AddAttrs(r.Context(), "idempotent_replay", true)
+	// Only the handler's own headers are stored; the ones middleware set
+	// around it, such as the request ID, stay this request's.
+	for name, values := range stored.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+// fingerprint identifies a request by method, path and body.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// formKey returns the key from a URL-encoded form body.
+func formKey(r *http.Request, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		return ""
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+	return values.Get(IdempotencyKeyField)
+}
+
+// responseCapture passes a response through while keeping a copy of its
+// status, headers and body. Only the headers the handler set or changed,
+// compared with before, are copied; the rest, such as the request ID,
+// nonce-bearing CSP and CORS headers, come from middleware and belong to
+// each request. Cookies are left out too: they belong to the session that
+// made the first request.
+type responseCapture struct {
+	http.ResponseWriter
+	before http.Header
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rc *responseCapture) WriteHeader(code int) {
+	if rc.status == 0 {
+		rc.status = code
+		rc.header = make(http.Header)
+		for name, values := range rc.Header() {
+			if name != "Set-Cookie" && !slices.Equal(values, rc.before[name]) {
+				rc.header[name] = slices.Clone(values)
+			}
+		}
+	}
+	rc.ResponseWriter.WriteHeader(code)
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	if rc.status == 0 {
+		rc.WriteHeader(http.StatusOK)
+	}
+	rc.body.Write(b)
+	return rc.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rc *responseCapture) Unwrap() http.ResponseWriter {
+	return rc.ResponseWriter
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/middleware"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestIdempotentCreate(t *testing.T) {
+	tasks := memory.NewTaskRepository()
+	keys := memory.NewIdempotencyRepository()
+	router := NewAPIRouter(NewTaskHandler(tasks), NewIdempotency(keys, time.Hour))
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+
+	post := func(body, key string, userID primitive.ObjectID) *httptest.ResponseRecorder {
+		req := apiRequest(http.MethodPost, APIPrefix+"/tasks", body, userID)
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post(`{"title":"Once"}`, "k1", owner)
+	if first.Code != http.StatusCreated || first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("first: status %d, headers %v", first.Code, first.Header())
+	}
+	retry := post(`{"title":"Once"}`, "k1", owner)
+	if retry.Code != http.StatusCreated || retry.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("retry: status %d, headers %v", retry.Code, retry.Header())
+	}
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Errorf("retry was not replayed verbatim:\n%s\n%s", first.Body, retry.Body)
+	}
+
+	if rec := post(`{"title":"Different"}`, "k1", owner); rec.Code != http.StatusConflict {
+		t.Errorf("reused key: status %d", rec.Code)
+	}
+	// Keys belong to the user who sent them.
+	if rec := post(`{"title":"Once"}`, "k1", other); rec.Code != http.StatusCreated || rec.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Errorf("same key for another user: status %d", rec.Code)
+	}
+
+	// A retry while the first request is still running must not run the
+	// handler again.
+	pending := &models.IdempotencyRecord{UserID: owner, Key: "k2", ExpiresAt: time.Now().Add(time.Hour)}
+	pending.Fingerprint = fingerprint(apiRequest(http.MethodPost, APIPrefix+"/tasks", "", owner), []byte(`{"title":"Slow"}`))
+	if err := keys.Reserve(t.Context(), pending); err != nil {
+		t.Fatal(err)
+	}
+	if rec := post(`{"title":"Slow"}`, "k2", owner); rec.Code != http.StatusConflict {
+		t.Errorf("in-progress key: status %d", rec.Code)
+	}
+
+	stored, err := tasks.FindByUserID(t.Context(), owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 {
+		t.Errorf("owner has %d tasks, want 1", len(stored))
+	}
+}
+
+func TestIdempotentReplayKeepsRequestHeaders(t *testing.T) {
+	h := middleware.RequestLogger(slog.New(slog.DiscardHandler))(
+		NewIdempotency(memory.NewIdempotencyRepository(), time.Hour).API(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", APIPrefix+"/tasks/1")
+			w.WriteHeader(http.StatusCreated)
+		})))
+	owner := primitive.NewObjectID()
+
+	for _, requestID := range []string{"first-request", "retried-request"} {
+		req := apiRequest(http.MethodPost, APIPrefix+"/tasks", `{}`, owner)
+		req.Header.Set(IdempotencyKeyHeader, "k")
+		req.Header.Set(middleware.RequestIDHeader, requestID)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get(middleware.RequestIDHeader); got != requestID {
+			t.Errorf("X-Request-ID = %q, want %q", got, requestID)
+		}
+		if rec.Code != http.StatusCreated || rec.Header().Get("Location") != APIPrefix+"/tasks/1" {
+			t.Errorf("status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestIdempotencyLimitsBody(t *testing.T) {
+	called := false
+	h := NewIdempotency(memory.NewIdempotencyRepository(), time.Hour).API(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := apiRequest(http.MethodPost, APIPrefix+"/tasks", strings.Repeat("x", maxIdempotentBodyBytes+1), primitive.NewObjectID())
+	req.Header.Set(IdempotencyKeyHeader, "k")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge || called {
+		t.Errorf("status %d, handler called: %v; want 413 without calling it", rec.Code, called)
+	}
+}
+
+func TestIdempotencyReleasesKeyOnServerError(t *testing.T) {
+	keys := memory.NewIdempotencyRepository()
+	calls := 0
+	h := NewIdempotency(keys, time.Hour).API(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	owner := primitive.NewObjectID()
+
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusCreated, http.StatusCreated} {
+		req := apiRequest(http.MethodPost, APIPrefix+"/tasks", `{}`, owner)
+		req.Header.Set(IdempotencyKeyHeader, "k")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("status %d, want %d", rec.Code, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestCreateInviteFormIsIdempotent(t *testing.T) {
+	invites := memory.NewInviteRepository()
+	pages := NewPageHandler(memory.NewTaskRepository(), memory.NewUserRepository(), invites, time.Hour)
+	h := NewIdempotency(memory.NewIdempotencyRepository(), time.Hour).Form(http.HandlerFunc(pages.CreateInvite))
+	admin := primitive.NewObjectID()
+
+	form := url.Values{"email": {"new@example.com"}, IdempotencyKeyField: {"rendered-key"}}
+	for range 2 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, formRequest("/admin/invites", form, admin))
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("status %d, want 303", rec.Code)
+		}
+	}
+
+	all, err := invites.FindAll(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Errorf("double submission created %d invites, want 1", len(all))
+	}
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/flash"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/taskio"
+	"github.com/cfegela/azure-aca-go-templ-mongo/web/templates"
+)
+
+const (
+	// MaxImportRows and MaxImportBytes bound an import file.
+	MaxImportRows  = 1000
+	MaxImportBytes = 1 << 20
+
+	// maxImportBodyBytes bounds the whole request. A file carried in a form
+	// field is URL-encoded, which can triple its size.
+	maxImportBodyBytes = 3*MaxImportBytes + 64<<10
+)
+
+// ExportTasks downloads the user's tasks as CSV, JSON or NDJSON, chosen
+// by the format query parameter (CSV by default). Tasks are written as they
+// are read from the database.
+func (h *PageHandler) ExportTasks(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	format := taskio.CSV
+	if value := r.URL.Query().Get("format"); value != "" {
+		var err error
+		if format, err = taskio.ParseFormat(value); err != nil {
+			writePageError(w, r, errorStatus(http.StatusBadRequest, "Export format must be csv, json or ndjson."))
+			return
+		}
+	}
+
+	filename := fmt.Sprintf("tasks-%s.%s", time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	writer := taskio.NewWriter(w, format)
+	err := h.taskRepo.EachByUserID(r.Context(), claims.UserID, writer.Write)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// The response has started, so the status cannot change. Abort
+		// the connection rather than end a truncated file cleanly.
+		logging.FromContext(r.Context()).Error("task export failed", "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (h *PageHandler) ShowImport(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	render(w, r, "ImportUpload", templates.ImportUpload(claims.Email, MaxImportRows))
+}
+
+// PreviewImport is the import's dry run: it reads the uploaded file, or
+// the one carried by a previous preview, and shows the outcome of every
+// row without storing anything.
+func (h *PageHandler) PreviewImport(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	form, ok := h.planImport(w, r)
+	if !ok {
+		return
+	}
+	form.IdempotencyKey = rand.Text()
+
+	render(w, r, "ImportPreview", templates.ImportPreview(claims.Email, form))
+}
+
+// ImportTasks confirms a preview: the file is planned again, as tasks may
+// have changed since, and the importable rows are created.
+func (h *PageHandler) ImportTasks(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	form, ok := h.planImport(w, r)
+	if !ok {
+		return
+	}
+
+	var ops []database.TaskOperation
+	invalid, duplicates := 0, 0
+	for _, row := range form.Rows {
+		switch {
+		case !row.Valid():
+			invalid++
+		case row.Duplicate && !form.IncludeDuplicates:
+			duplicates++
+		default:
+			task := row.Task
+			ops = append(ops, database.TaskOperation{Kind: database.TaskCreate, Task: &task})
+		}
+	}
+	results, err := h.taskRepo.BulkWriteByUserID(r.Context(), claims.UserID, ops, false)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+
+	imported := 0
+	for _, result := range results {
+		if result.Err == nil {
+			imported++
+		}
+	}
+	var skipped []string
+	if invalid > 0 {
+		skipped = append(skipped, fmt.Sprintf("%d invalid", invalid))
+	}
+	if duplicates > 0 {
+		skipped = append(skipped, fmt.Sprintf("%d duplicate", duplicates))
+	}
+	message := "Imported " + pluralTasks(imported) + "."
+	if len(skipped) > 0 {
+		message = fmt.Sprintf("Imported %s; skipped %s.", pluralTasks(imported), strings.Join(skipped, " and "))
+	}
+	if failed := len(results) - imported; failed > 0 {
+		flash.Error(r.Context(), fmt.Sprintf("%s could not be saved.", pluralTasks(failed)))
+	}
+	flash.Success(r.Context(), message)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// planImport reads the import form and plans every row against the user's
+// tasks. On failure it has already responded and returns false.
+func (h *PageHandler) planImport(w http.ResponseWriter, r *http.Request) (*templates.ImportForm, bool) {
+	claims, _ := auth.GetUserFromContext(r.Context())
+
+	form, err := readImportForm(w, r)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		flash.Error(r.Context(), statusErr.detail)
+		http.Redirect(w, r, "/tasks/import", http.StatusSeeOther)
+		return nil, false
+	}
+	if err != nil {
+		writePageError(w, r, err)
+		return nil, false
+	}
+
+	table, err := taskio.Read(strings.NewReader(form.Data), form.Format, MaxImportRows)
+	if errors.Is(err, taskio.ErrTooManyRows) {
+		err = fmt.Errorf("the file has more than %d rows", MaxImportRows)
+	}
+	if err != nil {
+		flash.Error(r.Context(), "Could not read the file: "+err.Error()+".")
+		http.Redirect(w, r, "/tasks/import", http.StatusSeeOther)
+		return nil, false
+	}
+	form.Columns = table.Columns
+	if form.Mapping == nil {
+		form.Mapping = taskio.DefaultMapping(table.Columns)
+	}
+
+	index := taskio.NewIndex()
+	err = h.taskRepo.EachByUserID(r.Context(), claims.UserID, func(task *models.Task) error {
+		index.Add(task)
+		return nil
+	})
+	if err != nil {
+		writePageError(w, r, err)
+		return nil, false
+	}
+	form.Rows = taskio.Plan(table, form.Mapping, index)
+	return form, true
+}
+
+// readImportForm reads the file from an upload, or from the data field of
+// a preview form, along with the mapping chosen on the preview.
+func readImportForm(w http.ResponseWriter, r *http.Request) (*templates.ImportForm, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBodyBytes)
+	tooLarge := errorStatus(http.StatusRequestEntityTooLarge, fmt.Sprintf("Files can be at most %d MB.", MaxImportBytes>>20))
+	unknownFormat := errorStatus(http.StatusBadRequest, "Choose the file's format: csv, json or ndjson.")
+
+	form := &templates.ImportForm{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(MaxImportBytes); err != nil {
+			return nil, tooLarge
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, errorStatus(http.StatusBadRequest, "Choose a file to import.")
+		}
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, MaxImportBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > MaxImportBytes {
+			return nil, tooLarge
+		}
+		form.Data = string(data)
+		if form.Format, err = taskio.FormatFromFilename(header.Filename); err != nil && r.FormValue("format") == "" {
+			return nil, unknownFormat
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, tooLarge
+		}
+		form.Data = r.PostFormValue("data")
+		if len(form.Data) > MaxImportBytes {
+			return nil, tooLarge
+		}
+	}
+
+	if value := r.FormValue("format"); value != "" {
+		format, err := taskio.ParseFormat(value)
+		if err != nil {
+			return nil, unknownFormat
+		}
+		form.Format = format
+	}
+
+	// Only a preview form carries a mapping; a new upload gets the
+	// default one.
+	for _, field := range taskio.Fields {
+		if column, ok := r.PostForm["map_"+field]; ok {
+			if form.Mapping == nil {
+				form.Mapping = taskio.Mapping{}
+			}
+			if column[0] != "" {
+				form.Mapping[field] = column[0]
+			}
+		}
+	}
+	form.IncludeDuplicates = r.PostFormValue("include_duplicates") != ""
+	return form, nil
+}
@@ -0,0 +1,23 @@
+package handlers
+
+// mergePatch applies an RFC 7396 JSON Merge Patch to target. Objects are
+// merged recursively, null removes a member and any other value replaces
+// it. Both arguments are values produced by encoding/json.
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for name, value := range patchObj {
+		if value == nil {
+			delete(targetObj, name)
+		} else {
+			targetObj[name] = mergePatch(targetObj[name], value)
+		}
+	}
+	return targetObj
+}
@@ -1,182 +1,428 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"net/http"
-	"strings"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/flash"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
 	"github.com/cfegela/azure-aca-go-templ-mongo/web/templates"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type PageHandler struct {
-	taskRepo   *database.TaskRepository
-	userRepo   *database.UserRepository
-	inviteRepo *database.InviteRepository
+	taskRepo       database.TaskStore
+	userRepo       database.UserStore
+	inviteRepo     database.InviteStore
+	trashRetention time.Duration
 }
 
-func NewPageHandler(taskRepo *database.TaskRepository, userRepo *database.UserRepository, inviteRepo *database.InviteRepository) *PageHandler {
+// NewPageHandler serves the HTML pages. trashRetention is how long deleted
+// tasks stay in the trash, shown on the trash page.
+func NewPageHandler(taskRepo database.TaskStore, userRepo database.UserStore, inviteRepo database.InviteStore, trashRetention time.Duration) *PageHandler {
 	return &PageHandler{
-		taskRepo:   taskRepo,
-		userRepo:   userRepo,
-		inviteRepo: inviteRepo,
+		taskRepo:       taskRepo,
+		userRepo:       userRepo,
+		inviteRepo:     inviteRepo,
+		trashRetention: trashRetention,
 	}
 }
 
-func (h *PageHandler) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	errorMsg := r.URL.Query().Get("error")
-	templates.Login(errorMsg).Render(r.Context(), w)
-}
+func (h *PageHandler) ShowDashboard(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	tasks, err := h.taskRepo.FindByUserID(r.Context(), claims.UserID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
 
-func (h *PageHandler) ShowRegister(w http.ResponseWriter, r *http.Request) {
-	token := strings.TrimPrefix(r.URL.Path, "/register/")
-	errorMsg := r.URL.Query().Get("error")
+	render(w, r, "Dashboard", templates.Dashboard(claims.Email, tasks))
+}
 
-	invite, err := h.inviteRepo.FindByToken(r.Context(), token)
-	if err != nil || !invite.IsValid() {
-		http.Error(w, "Invalid or expired invite", http.StatusBadRequest)
+func (h *PageHandler) ShowTaskForm(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	templates.Register(token, invite.Email, errorMsg).Render(r.Context(), w)
+	render(w, r, "TaskForm", templates.TaskForm(claims.Email, nil, false, nil))
 }
 
-func (h *PageHandler) ShowDashboard(w http.ResponseWriter, r *http.Request) {
+func (h *PageHandler) ShowEditForm(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	tasks, err := h.taskRepo.FindByUserID(r.Context(), claims.UserID)
+	id := r.PathValue("id")
+
+	task, err := h.taskRepo.FindByIDAndUserID(r.Context(), id, claims.UserID)
 	if err != nil {
-		http.Error(w, "Failed to load tasks", http.StatusInternalServerError)
+		writePageError(w, r, err)
 		return
 	}
 
-	templates.Dashboard(claims.Email, tasks).Render(r.Context(), w)
+	render(w, r, "TaskForm", templates.TaskForm(claims.Email, task, true, nil))
 }
 
-func (h *PageHandler) ShowTaskForm(w http.ResponseWriter, r *http.Request) {
+func (h *PageHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	templates.TaskForm(claims.Email, nil, false).Render(r.Context(), w)
+	task, errs := taskFromForm(r)
+	if len(errs) > 0 {
+		renderStatus(w, r, http.StatusBadRequest, "TaskForm",
+			templates.TaskForm(claims.Email, nil, false, templates.NewFormState(r.PostForm, errs)))
+		return
+	}
+	task.UserID = claims.UserID
+
+	if err := h.taskRepo.Create(r.Context(), task); err != nil {
+		writePageError(w, r, err)
+		return
+	}
+
+	flash.Success(r.Context(), "Task created.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (h *PageHandler) ShowEditForm(w http.ResponseWriter, r *http.Request) {
+func (h *PageHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	id := r.PathValue("id")
+
+	task, errs := taskFromForm(r)
+	if len(errs) > 0 {
+		// Reload the task so the form posts back to it, which also
+		// confirms it exists and belongs to the user.
+		existing, err := h.taskRepo.FindByIDAndUserID(r.Context(), id, claims.UserID)
+		if err != nil {
+			writePageError(w, r, err)
+			return
+		}
+		renderStatus(w, r, http.StatusBadRequest, "TaskForm",
+			templates.TaskForm(claims.Email, existing, true, templates.NewFormState(r.PostForm, errs)))
+		return
+	}
+
+	// The form carries the version it was loaded with, so an edit made
+	// in the meantime is not silently overwritten. Forms without one
+	// (e.g. from a page cached before versioning) update unconditionally.
+	task.Version, _ = strconv.ParseInt(r.FormValue("version"), 10, 64)
+
+	if err := h.taskRepo.UpdateByUserID(r.Context(), id, claims.UserID, task); err != nil {
+		if !errors.Is(err, database.ErrVersionConflict) {
+			writePageError(w, r, err)
+			return
+		}
+		current, err := h.taskRepo.FindByIDAndUserID(r.Context(), id, claims.UserID)
+		if err != nil {
+			writePageError(w, r, err)
+			return
+		}
+		form := templates.NewFormState(r.PostForm, nil)
+		form.Stale = true
+		renderStatus(w, r, http.StatusConflict, "TaskForm",
+			templates.TaskForm(claims.Email, current, true, form))
+		return
+	}
+
+	flash.Success(r.Context(), "Task updated.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ShowTask shows a task's details, or its history with ?tab=history.
+func (h *PageHandler) ShowTask(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	id := strings.TrimPrefix(r.URL.Path, "/tasks/")
-	id = strings.TrimSuffix(id, "/edit")
+	id := r.PathValue("id")
 
 	task, err := h.taskRepo.FindByIDAndUserID(r.Context(), id, claims.UserID)
 	if err != nil {
-		http.Error(w, "Task not found", http.StatusNotFound)
+		writePageError(w, r, err)
 		return
 	}
 
-	templates.TaskForm(claims.Email, task, true).Render(r.Context(), w)
+	showHistory := r.URL.Query().Get("tab") == "history"
+	var revisions []models.TaskRevision
+	editors := map[primitive.ObjectID]string{claims.UserID: "you"}
+	if showHistory {
+		revisions, err = h.taskRepo.FindRevisionsByUserID(r.Context(), id, claims.UserID)
+		if err != nil {
+			writePageError(w, r, err)
+			return
+		}
+		for _, rev := range revisions {
+			if _, seen := editors[rev.EditorID]; seen || rev.EditorID.IsZero() {
+				continue
+			}
+			if user, err := h.userRepo.FindByID(r.Context(), rev.EditorID); err == nil {
+				editors[rev.EditorID] = user.Email
+			}
+		}
+	}
+
+	render(w, r, "TaskDetail", templates.TaskDetail(claims.Email, task, showHistory, revisions, editors))
 }
 
-func (h *PageHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
+// RevertTask restores the editable fields of a task to how they were right
+// after the given revision. The revert is itself recorded as a revision.
+func (h *PageHandler) RevertTask(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
+	id := r.PathValue("id")
+	version, err := strconv.ParseInt(r.PathValue("version"), 10, 64)
+	if err != nil {
+		writePageError(w, r, errorStatus(http.StatusNotFound, "Revision not found"))
+		return
+	}
+
+	task, err := h.taskRepo.FindByIDAndUserID(r.Context(), id, claims.UserID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	revisions, err := h.taskRepo.FindRevisionsByUserID(r.Context(), id, claims.UserID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	if !slices.ContainsFunc(revisions, func(rev models.TaskRevision) bool { return rev.Version == version }) {
+		writePageError(w, r, errorStatus(http.StatusNotFound, "Revision not found"))
+		return
+	}
+
+	reverted, err := models.TaskAtRevision(*task, revisions, version)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	// The version the history was shown at: if the task changed since, the
+	// user has not seen that change and the revert is refused.
+	reverted.Version, _ = strconv.ParseInt(r.FormValue("version"), 10, 64)
+
+	historyURL := "/tasks/" + id + "?tab=history"
+	err = h.taskRepo.UpdateByUserID(r.Context(), id, claims.UserID, &reverted)
+	if errors.Is(err, database.ErrVersionConflict) {
+		flash.Error(r.Context(), "The task changed since you opened its history. Review the changes and try again.")
+		http.Redirect(w, r, historyURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+
+	flash.Success(r.Context(), fmt.Sprintf("Task reverted to version %d.", version))
+	http.Redirect(w, r, historyURL, http.StatusSeeOther)
+}
+
+// taskFromForm reads the task form and validates it. An unparseable due
+// date is reported as a field error instead of being dropped.
+func taskFromForm(r *http.Request) (*models.Task, models.ValidationErrors) {
 	task := &models.Task{
-		UserID:      claims.UserID,
 		Title:       r.FormValue("title"),
 		Description: r.FormValue("description"),
 		Status:      r.FormValue("status"),
 	}
 
-	dueDateStr := r.FormValue("due_date")
-	if dueDateStr != "" {
+	var errs models.ValidationErrors
+	if dueDateStr := r.FormValue("due_date"); dueDateStr != "" {
 		dueDate, err := time.Parse("2006-01-02", dueDateStr)
-		if err == nil {
+		if err != nil {
+			errs.Add("due_date", "due date must be a valid date (YYYY-MM-DD)")
+		} else {
 			task.DueDate = &dueDate
 		}
 	}
 
-	if err := task.Validate(); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	errs.Merge(task.Validate())
+	return task, errs
+}
+
+func (h *PageHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	if err := h.taskRepo.Create(r.Context(), task); err != nil {
-		http.Error(w, "Failed to create task", http.StatusInternalServerError)
+	id := r.PathValue("id")
+
+	if err := h.taskRepo.DeleteByUserID(r.Context(), id, claims.UserID); err != nil {
+		writePageError(w, r, err)
 		return
 	}
 
+	flash.Success(r.Context(), "Task moved to trash.")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (h *PageHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
+func (h *PageHandler) ShowTrash(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	id := strings.TrimPrefix(r.URL.Path, "/tasks/")
+	tasks, err := h.taskRepo.FindDeletedByUserID(r.Context(), claims.UserID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
 
-	task := &models.Task{
-		Title:       r.FormValue("title"),
-		Description: r.FormValue("description"),
-		Status:      r.FormValue("status"),
+	render(w, r, "Trash", templates.Trash(claims.Email, tasks, h.trashRetention))
+}
+
+func (h *PageHandler) RestoreTask(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
 	}
 
-	dueDateStr := r.FormValue("due_date")
-	if dueDateStr != "" {
-		dueDate, err := time.Parse("2006-01-02", dueDateStr)
-		if err == nil {
-			task.DueDate = &dueDate
-		}
+	if err := h.taskRepo.RestoreByUserID(r.Context(), r.PathValue("id"), claims.UserID); err != nil {
+		writePageError(w, r, err)
+		return
 	}
 
-	if err := task.Validate(); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	flash.Success(r.Context(), "Task restored.")
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// PurgeTask permanently deletes a task that is already in the trash.
+func (h *PageHandler) PurgeTask(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	if err := h.taskRepo.UpdateByUserID(r.Context(), id, claims.UserID, task); err != nil {
-		http.Error(w, "Failed to update task", http.StatusInternalServerError)
+	if err := h.taskRepo.PurgeByUserID(r.Context(), r.PathValue("id"), claims.UserID); err != nil {
+		writePageError(w, r, err)
 		return
 	}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	flash.Success(r.Context(), "Task deleted permanently.")
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
 }
 
-func (h *PageHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
+// BulkTasks applies the dashboard's bulk action to the selected tasks. The
+// batch is unordered, so one missing task does not stop the others.
+func (h *PageHandler) BulkTasks(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
-	id := strings.TrimPrefix(r.URL.Path, "/tasks/")
-	id = strings.TrimSuffix(id, "/delete")
+	if err := r.ParseForm(); err != nil {
+		writePageError(w, r, errorStatus(http.StatusBadRequest, "Invalid form submission"))
+		return
+	}
+	ids := r.PostForm["ids"]
+	if len(ids) == 0 {
+		flash.Info(r.Context(), "Select at least one task first.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if len(ids) > MaxBulkOperations {
+		flash.Error(r.Context(), fmt.Sprintf("Select at most %d tasks at a time.", MaxBulkOperations))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-	if err := h.taskRepo.DeleteByUserID(r.Context(), id, claims.UserID); err != nil {
-		http.Error(w, "Failed to delete task", http.StatusInternalServerError)
+	var base database.TaskOperation
+	var done string
+	switch r.PostFormValue("action") {
+	case "complete":
+		status := models.StatusCompleted
+		base = database.TaskOperation{Kind: database.TaskUpdate, Patch: &models.TaskPatch{Status: &status}}
+		done = "marked completed"
+	case "due_date":
+		patch := &models.TaskPatch{ClearDueDate: true}
+		done = "cleared of their due date"
+		if value := r.PostFormValue("due_date"); value != "" {
+			dueDate, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				flash.Error(r.Context(), "Due date must be a valid date (YYYY-MM-DD).")
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+			patch = &models.TaskPatch{DueDate: &dueDate}
+			done = "given a due date of " + dueDate.Format("Jan 02, 2006")
+		}
+		base = database.TaskOperation{Kind: database.TaskUpdate, Patch: patch}
+	case "delete":
+		base = database.TaskOperation{Kind: database.TaskDelete}
+		done = "moved to trash"
+	default:
+		writePageError(w, r, errorStatus(http.StatusBadRequest, "Unknown bulk action"))
 		return
 	}
 
+	ops := make([]database.TaskOperation, len(ids))
+	for i, id := range ids {
+		ops[i] = base
+		ops[i].ID = id
+	}
+	results, err := h.taskRepo.BulkWriteByUserID(r.Context(), claims.UserID, ops, false)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if succeeded := len(results) - failed; succeeded > 0 {
+		flash.Success(r.Context(), fmt.Sprintf("%s %s.", pluralTasks(succeeded), done))
+	}
+	if failed > 0 {
+		flash.Error(r.Context(), fmt.Sprintf("%s could not be updated; they may have been deleted.", pluralTasks(failed)))
+	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+func pluralTasks(n int) string {
+	if n == 1 {
+		return "1 task"
+	}
+	return strconv.Itoa(n) + " tasks"
+}
+
 func (h *PageHandler) ShowInvites(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
@@ -186,12 +432,11 @@ func (h *PageHandler) ShowInvites(w http.ResponseWriter, r *http.Request) {
 
 	invites, err := h.inviteRepo.FindAll(r.Context())
 	if err != nil {
-		http.Error(w, "Failed to load invites", http.StatusInternalServerError)
+		writePageError(w, r, err)
 		return
 	}
 
-	successMsg := r.URL.Query().Get("success")
-	templates.Invites(claims.Email, invites, successMsg).Render(r.Context(), w)
+	render(w, r, "Invites", templates.Invites(claims.Email, invites, rand.Text()))
 }
 
 func (h *PageHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
@@ -203,13 +448,14 @@ func (h *PageHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
 
 	email := r.FormValue("email")
 	if email == "" {
-		http.Redirect(w, r, "/admin/invites?error=missing_email", http.StatusSeeOther)
+		flash.Error(r.Context(), "Enter an email address to invite.")
+		http.Redirect(w, r, "/admin/invites", http.StatusSeeOther)
 		return
 	}
 
 	token, err := models.GenerateInviteToken()
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		writePageError(w, r, err)
 		return
 	}
 
@@ -221,9 +467,10 @@ func (h *PageHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.inviteRepo.Create(r.Context(), invite); err != nil {
-		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		writePageError(w, r, err)
 		return
 	}
 
-	http.Redirect(w, r, "/admin/invites?success=invite_created", http.StatusSeeOther)
+	flash.Success(r.Context(), "Invite created for "+email+".")
+	http.Redirect(w, r, "/admin/invites", http.StatusSeeOther)
 }
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func formRequest(path string, form url.Values, userID primitive.ObjectID) *http.Request {
+	req := apiRequest(http.MethodPost, path, form.Encode(), userID)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestCreateTaskFormRerendersWithErrors(t *testing.T) {
+	tasks := memory.NewTaskRepository()
+	h := NewPageHandler(tasks, memory.NewUserRepository(), memory.NewInviteRepository(), time.Hour)
+	owner := primitive.NewObjectID()
+
+	rec := httptest.NewRecorder()
+	h.CreateTask(rec, formRequest("/tasks", url.Values{
+		"title":       {""},
+		"description": {"keep me"},
+		"status":      {"in_progress"},
+		"due_date":    {"31/12/2026"},
+	}, owner))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"title is required",
+		"due date must be a valid date",
+		">keep me</textarea>",
+		`value="31/12/2026"`,
+		`value="in_progress" selected`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("re-rendered form is missing %q", want)
+		}
+	}
+
+	stored, _ := tasks.FindByUserID(t.Context(), owner)
+	if len(stored) != 0 {
+		t.Fatalf("invalid task was stored: %+v", stored)
+	}
+
+	rec = httptest.NewRecorder()
+	h.CreateTask(rec, formRequest("/tasks", url.Values{
+		"title":    {"Valid"},
+		"status":   {"pending"},
+		"due_date": {"2026-12-31"},
+	}, owner))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("valid submission: status = %d, want 303", rec.Code)
+	}
+	stored, _ = tasks.FindByUserID(t.Context(), owner)
+	if len(stored) != 1 || stored[0].DueDate == nil {
+		t.Fatalf("stored tasks = %+v", stored)
+	}
+}
+
+func TestUpdateTaskFormDetectsStaleEdit(t *testing.T) {
+	tasks := memory.NewTaskRepository()
+	h := NewPageHandler(tasks, memory.NewUserRepository(), memory.NewInviteRepository(), time.Hour)
+	owner := primitive.NewObjectID()
+
+	task := &models.Task{UserID: owner, Title: "Original", Status: models.StatusPending}
+	if err := tasks.Create(t.Context(), task); err != nil {
+		t.Fatal(err)
+	}
+	// Someone else saves after the form was loaded at version 1.
+	if err := tasks.UpdateByUserID(t.Context(), task.ID.Hex(), owner,
+		&models.Task{Title: "Changed elsewhere", Status: models.StatusCompleted}); err != nil {
+		t.Fatal(err)
+	}
+
+	submit := func(version string) *httptest.ResponseRecorder {
+		req := formRequest("/tasks/"+task.ID.Hex(), url.Values{
+			"title":   {"My edit"},
+			"status":  {"in_progress"},
+			"version": {version},
+		}, owner)
+		req.SetPathValue("id", task.ID.Hex())
+		rec := httptest.NewRecorder()
+		h.UpdateTask(rec, req)
+		return rec
+	}
+
+	rec := submit("1")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("stale submit: status %d, want 409", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"This task was changed after you opened it",
+		"Title: Changed elsewhere",
+		`value="My edit"`,
+		`name="version" value="2"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stale form is missing %q", want)
+		}
+	}
+	if stored, _ := tasks.FindByID(t.Context(), task.ID.Hex()); stored.Title != "Changed elsewhere" {
+		t.Fatalf("stale edit was saved: %+v", stored)
+	}
+
+	// Submitting again from the warning overwrites deliberately.
+	if rec := submit("2"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("resubmit: status %d", rec.Code)
+	}
+	if stored, _ := tasks.FindByID(t.Context(), task.ID.Hex()); stored.Title != "My edit" || stored.Version != 3 {
+		t.Fatalf("after resubmit: %+v", stored)
+	}
+}
+
+func TestBulkTasksForm(t *testing.T) {
+	tasks := memory.NewTaskRepository()
+	h := NewPageHandler(tasks, memory.NewUserRepository(), memory.NewInviteRepository(), time.Hour)
+	owner := primitive.NewObjectID()
+
+	var ids []string
+	for _, title := range []string{"One", "Two", "Three"} {
+		task := &models.Task{UserID: owner, Title: title}
+		if err := tasks.Create(t.Context(), task); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, task.ID.Hex())
+	}
+
+	post := func(form url.Values) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.BulkTasks(rec, formRequest("/tasks/bulk", form, owner))
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("%v: status = %d, want 303", form, rec.Code)
+		}
+	}
+	// A missing task does not stop the rest of the batch.
+	post(url.Values{"action": {"complete"}, "ids": {ids[0], primitive.NewObjectID().Hex(), ids[1]}})
+	post(url.Values{"action": {"due_date"}, "due_date": {"2026-12-31"}, "ids": {ids[1], ids[2]}})
+	post(url.Values{"action": {"delete"}, "ids": {ids[2]}})
+
+	stored, err := tasks.FindByUserID(t.Context(), owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("owner has %d tasks, want 2", len(stored))
+	}
+	for _, task := range stored {
+		if task.Status != models.StatusCompleted {
+			t.Errorf("%s: status = %q, want completed", task.Title, task.Status)
+		}
+		if hasDue := task.DueDate != nil; hasDue != (task.Title == "Two") {
+			t.Errorf("%s: due date = %v", task.Title, task.DueDate)
+		}
+	}
+}
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	tasks := memory.NewTaskRepository()
+	h := NewPageHandler(tasks, memory.NewUserRepository(), memory.NewInviteRepository(), 30*24*time.Hour)
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+
+	var ids []string
+	for _, title := range []string{"Keep", "Drop"} {
+		task := &models.Task{UserID: owner, Title: title}
+		if err := tasks.Create(t.Context(), task); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, task.ID.Hex())
+	}
+
+	post := func(handler http.HandlerFunc, path, id string, userID primitive.ObjectID) int {
+		t.Helper()
+		req := formRequest(path, url.Values{}, userID)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+	for _, id := range ids {
+		if code := post(h.DeleteTask, "/tasks/"+id+"/delete", id, owner); code != http.StatusSeeOther {
+			t.Fatalf("delete: status = %d, want 303", code)
+		}
+	}
+	if stored, _ := tasks.FindByUserID(t.Context(), owner); len(stored) != 0 {
+		t.Fatalf("trashed tasks are still listed: %+v", stored)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ShowTrash(rec, apiRequest(http.MethodGet, "/trash", "", owner))
+	for _, want := range []string{"Keep", "Drop", "30 days", "/trash/" + ids[0] + "/restore"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("trash page is missing %q", want)
+		}
+	}
+
+	// Other users can neither restore nor purge the owner's tasks.
+	if code := post(h.RestoreTask, "/trash/"+ids[0]+"/restore", ids[0], other); code != http.StatusNotFound {
+		t.Errorf("restore by another user: status = %d, want 404", code)
+	}
+	if code := post(h.RestoreTask, "/trash/"+ids[0]+"/restore", ids[0], owner); code != http.StatusSeeOther {
+		t.Fatalf("restore: status = %d, want 303", code)
+	}
+	if code := post(h.PurgeTask, "/trash/"+ids[0]+"/delete", ids[0], owner); code != http.StatusNotFound {
+		t.Errorf("purging a task outside the trash: status = %d, want 404", code)
+	}
+	if code := post(h.PurgeTask, "/trash/"+ids[1]+"/delete", ids[1], owner); code != http.StatusSeeOther {
+		t.Fatalf("purge: status = %d, want 303", code)
+	}
+
+	if stored, _ := tasks.FindByUserID(t.Context(), owner); len(stored) != 1 || stored[0].Title != "Keep" {
+		t.Errorf("active tasks = %+v, want the restored one", stored)
+	}
+	if trashed, _ := tasks.FindDeletedByUserID(t.Context(), owner); len(trashed) != 0 {
+		t.Errorf("trash = %+v, want empty", trashed)
+	}
+}
+
+func TestTaskHistoryAndRevert(t *testing.T) {
+	tasks := memory.NewTaskRepository()
+	h := NewPageHandler(tasks, memory.NewUserRepository(), memory.NewInviteRepository(), time.Hour)
+	owner := primitive.NewObjectID()
+
+	task := &models.Task{UserID: owner, Title: "First", Description: "original", Status: models.StatusPending}
+	if err := tasks.Create(t.Context(), task); err != nil {
+		t.Fatal(err)
+	}
+	id := task.ID.Hex()
+	for _, edit := range []models.Task{
+		{Title: "Second", Description: "original", Status: models.StatusPending},
+		{Title: "Second", Description: "rewritten", Status: models.StatusCompleted},
+	} {
+		if err := tasks.UpdateByUserID(t.Context(), id, owner, &edit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := apiRequest(http.MethodGet, "/tasks/"+id+"?tab=history", "", owner)
+	req.SetPathValue("id", id)
+	rec := httptest.NewRecorder()
+	h.ShowTask(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("history: status = %d", rec.Code)
+	}
+	for _, want := range []string{"Version 3", "<del>original</del>", "<ins>rewritten</ins>", "<del>First</del>", "/revisions/2/revert"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("history is missing %q", want)
+		}
+	}
+
+	revert := func(version string, form url.Values) int {
+		t.Helper()
+		req := formRequest("/tasks/"+id+"/revisions/"+version+"/revert", form, owner)
+		req.SetPathValue("id", id)
+		req.SetPathValue("version", version)
+		rec := httptest.NewRecorder()
+		h.RevertTask(rec, req)
+		return rec.Code
+	}
+	if code := revert("7", url.Values{}); code != http.StatusNotFound {
+		t.Errorf("unknown revision: status = %d, want 404", code)
+	}
+	// A page showing an outdated history is not acted on.
+	if code := revert("2", url.Values{"version": {"2"}}); code != http.StatusSeeOther {
+		t.Fatalf("stale revert: status = %d, want 303", code)
+	}
+	if stored, _ := tasks.FindByID(t.Context(), id); stored.Version != 3 {
+		t.Fatalf("stale revert was applied: %+v", stored)
+	}
+	if code := revert("2", url.Values{"version": {"3"}}); code != http.StatusSeeOther {
+		t.Fatalf("revert: status = %d, want 303", code)
+	}
+
+	stored, err := tasks.FindByID(t.Context(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Second" || stored.Description != "original" || stored.Status != models.StatusPending || stored.Version != 4 {
+		t.Errorf("after revert: %+v", stored)
+	}
+	revisions, _ := tasks.FindRevisionsByUserID(t.Context(), id, owner)
+	if len(revisions) != 3 || revisions[0].Version != 4 || revisions[0].EditorID != owner {
+		t.Errorf("the revert was not recorded: %+v", revisions)
+	}
+}
+
+func TestExportAndImportTasks(t *testing.T) {
+	tasks := memory.NewTaskRepository()
+	h := NewPageHandler(tasks, memory.NewUserRepository(), memory.NewInviteRepository(), time.Hour)
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+	for _, task := range []*models.Task{{UserID: owner, Title: "Buy milk"}, {UserID: other, Title: "Not mine"}} {
+		if err := tasks.Create(t.Context(), task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ExportTasks(rec, apiRequest(http.MethodGet, "/tasks/export?format=ndjson", "", owner))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" ||
+		!strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("export: status = %d, headers = %v", rec.Code, rec.Header())
+	}
+	if lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"title":"Buy milk"`) {
+		t.Errorf("export = %q, want only the owner's task", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.ExportTasks(rec, apiRequest(http.MethodGet, "/tasks/export?format=xml", "", owner))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown export format: status = %d, want 400", rec.Code)
+	}
+
+	file := "Name,Due\nBuy milk,\nWrite report,2026-11-01\n,2026-11-02\n"
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "tasks.csv")
+	part.Write([]byte(file))
+	mw.Close()
+	req := apiRequest(http.MethodPost, "/tasks/import/preview", body.String(), owner)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec = httptest.NewRecorder()
+	h.PreviewImport(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("preview: status = %d", rec.Code)
+	}
+	for _, want := range []string{"Duplicate, skipped", "Ready", "title is required", "Import 1 task"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+	if stored, _ := tasks.FindByUserID(t.Context(), owner); len(stored) != 1 {
+		t.Fatalf("preview stored tasks: %+v", stored)
+	}
+
+	// Confirming sends the file back with the mapping from the preview.
+	form := url.Values{"format": {"csv"}, "data": {file}, "map_title": {"Name"}, "map_due_date": {"Due"}}
+	rec = httptest.NewRecorder()
+	h.ImportTasks(rec, formRequest("/tasks/import", form, owner))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("import: status = %d, want 303", rec.Code)
+	}
+	stored, _ := tasks.FindByUserID(t.Context(), owner)
+	if len(stored) != 2 || !slices.ContainsFunc(stored, func(task models.Task) bool {
+		return task.Title == "Write report" && task.DueDate != nil && task.Status == models.StatusPending
+	}) {
+		t.Errorf("tasks after import = %+v", stored)
+	}
+
+	// With the title unmapped every row is invalid and nothing is imported.
+	form.Set("map_title", "")
+	rec = httptest.NewRecorder()
+	h.ImportTasks(rec, formRequest("/tasks/import", form, owner))
+	if stored, _ := tasks.FindByUserID(t.Context(), owner); len(stored) != 2 {
+		t.Errorf("import without titles stored tasks: %+v", stored)
+	}
+}
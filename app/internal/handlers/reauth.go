@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/flash"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/web/templates"
+)
+
+// reauthCookie holds the token of a user without a password who has
+// confirmed who they are with single sign-on. Changes that ask for the
+// password accept it instead for reauthExpiry.
+const (
+	reauthCookie = "reauth"
+	reauthExpiry = 5 * time.Minute
+)
+
+// reauthPages are the pages with changes that ask for the password, where
+// a reauthentication may return to.
+var reauthPages = []string{"/account", "/account/2fa", "/admin/users"}
+
+// StartReauth sends a user without a password to the identity provider to
+// log in again, and then back to the page named by the next parameter.
+func (h *AuthHandler) StartReauth(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if h.sso == nil {
+		writePageError(w, r, errorStatus(http.StatusNotFound, "Single sign-on is not configured."))
+		return
+	}
+
+	next := r.URL.Query().Get("next")
+	if !slices.Contains(reauthPages, next) {
+		next = "/account"
+	}
+	authURL, state, err := h.sso.StartReauth(r.Context(), claims.UserID, next)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	setSSOStateCookie(w, state)
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// finishReauth handles the identity provider's redirect back from
+// StartReauth.
+func (h *AuthHandler) finishReauth(w http.ResponseWriter, r *http.Request, state string) {
+	user, next, err := h.sso.FinishReauth(r.Context(), state, r.URL.Query())
+	if err != nil {
+		_, message := ssoFailure(err)
+		if message == "" {
+			writePageError(w, r, err)
+			return
+		}
+		logging.FromContext(r.Context()).Info("reauthentication failed", "error", err)
+		flash.Error(r.Context(), message)
+		render(w, r, "Logging In", templates.ContinueLogin("/account"))
+		return
+	}
+
+	token, err := auth.GenerateReauthToken(user.ID, h.authConfig.JWTSecret, reauthExpiry)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     reauthCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(reauthExpiry.Seconds()),
+	})
+	logging.FromContext(r.Context()).Info("reauthenticated", "user_id", user.ID.Hex())
+	flash.Success(r.Context(), "Thanks for confirming it is you. Please try again.")
+	// As after a login, the session cookie would not come along on a
+	// redirect.
+	render(w, r, "Logging In", templates.ContinueLogin(next))
+}
+
+// confirmIdentity checks, before a change that weakens an account or
+// deletes one, that user is still the one at the keyboard: with their
+// password, or, for users without one, with a recent single sign-on login
+// from StartReauth. Otherwise it redirects, to next after a wrong password
+// with a message ending in notDone, or to single sign-on, and returns
+// false.
+func confirmIdentity(w http.ResponseWriter, r *http.Request, authConfig *auth.Config, user *models.User, next, notDone string) bool {
+	if user.PasswordHash != "" {
+		if auth.CheckPassword(user.PasswordHash, r.FormValue("password")) {
+			return true
+		}
+		flash.Error(r.Context(), "Your password was incorrect; "+notDone+".")
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return false
+	}
+
+	if cookie, err := r.Cookie(reauthCookie); err == nil {
+		if id, err := auth.ValidateReauthToken(cookie.Value, authConfig.JWTSecret); err == nil && id == user.ID {
+			return true
+		}
+	}
+	flash.Info(r.Context(), "Please confirm it is you with single sign-on first; "+notDone+".")
+	http.Redirect(w, r, "/account/reauth?"+url.Values{"next": {next}}.Encode(), http.StatusSeeOther)
+	return false
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/a-h/templ"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// render writes a templ component inside its own span, so slow template
+// rendering can be told apart from slow queries in a trace.
+func render(w http.ResponseWriter, r *http.Request, name string, component templ.Component) {
+	renderStatus(w, r, http.StatusOK, name, component)
+}
+
+// renderStatus writes component with the given status, e.g. a form shown
+// again with validation errors. The page is rendered into a buffer first:
+// the Layout consumes flash messages, which clears their cookie, and a
+// failed render can still become a 500 page.
+func renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, component templ.Component) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "templ.render "+name)
+	defer span.End()
+
+	var buf bytes.Buffer
+	if err := component.Render(ctx, &buf); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logging.FromContext(ctx).Error("failed to render template", "template", name, "error", err)
+		if name != "ErrorPage" {
+			writePageError(w, r, err)
+		} else {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
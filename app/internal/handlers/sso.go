@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/flash"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/sso"
+	"github.com/cfegela/azure-aca-go-templ-mongo/web/templates"
+)
+
+// ssoStateCookie holds the state of a single sign-on login while the user
+// is at the identity provider.
+const ssoStateCookie = "login_sso"
+
+// StartSSO sends the user to the identity provider to log in.
+func (h *AuthHandler) StartSSO(w http.ResponseWriter, r *http.Request) {
+	if h.sso == nil {
+		writePageError(w, r, errorStatus(http.StatusNotFound, "Single sign-on is not configured."))
+		return
+	}
+
+	authURL, state, err := h.sso.Start(r.Context())
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	setSSOStateCookie(w, state)
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+func setSSOStateCookie(w http.ResponseWriter, state string) {
+	// The identity provider redirects back with a cross-site navigation,
+	// which Lax cookies survive and Strict ones do not.
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     "/login/sso",
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(sso.StateExpiry.Seconds()),
+	})
+}
+
+// HandleSSOCallback finishes a single sign-on login, or a
+// reauthentication, when the identity provider redirects back.
+func (h *AuthHandler) HandleSSOCallback(w http.ResponseWriter, r *http.Request) {
+	if h.sso == nil {
+		writePageError(w, r, errorStatus(http.StatusNotFound, "Single sign-on is not configured."))
+		return
+	}
+
+	var state string
+	if cookie, err := r.Cookie(ssoStateCookie); err == nil {
+		state = cookie.Value
+	}
+	http.SetCookie(w, &http.Cookie{Name: ssoStateCookie, Value: "", Path: "/login/sso", MaxAge: -1})
+	if h.sso.Reauthenticating(state) {
+		h.finishReauth(w, r, state)
+		return
+	}
+
+	user, err := h.sso.Finish(r.Context(), state, r.URL.Query())
+	if err == nil && user.DeletionDue() {
+		err = sso.ErrNotProvisioned
+	}
+	if err != nil {
+		reason, message := ssoFailure(err)
+		if reason == "" {
+			writePageError(w, r, err)
+			return
+		}
+		logging.FromContext(r.Context()).Info("login failed", "reason", reason, "error", err)
+		metrics.LoginFailed(reason)
+		flash.Error(r.Context(), message)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	next, err := h.completeLogin(w, r, user, false)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	// The session cookie is Strict, so a redirect from here, still part of
+	// the navigation from the identity provider, would arrive without it.
+	// The page moves on with a navigation of its own instead.
+	render(w, r, "Logging In", templates.ContinueLogin(next))
+}
+
+// ssoFailure returns the metrics reason and the message to show for a
+// failed single sign-on login, or "" for an error that is not the user's.
+func ssoFailure(err error) (reason, message string) {
+	switch {
+	case errors.Is(err, sso.ErrInvalidState):
+		return "sso_invalid_state", "Your single sign-on login expired. Please try again."
+	case errors.Is(err, sso.ErrDenied):
+		return "sso_denied", "Single sign-on was cancelled or refused by your identity provider."
+	case errors.Is(err, sso.ErrInvalidToken):
+		return "sso_invalid_token", "Single sign-on failed. Please try again."
+	case errors.Is(err, sso.ErrEmailNotVerified):
+		return "sso_email_not_verified", "Your identity provider has not verified your email, so it cannot be matched to an account."
+	case errors.Is(err, database.ErrAccountLinked):
+		return "sso_account_linked", "Your account is linked to a different single sign-on identity."
+	case errors.Is(err, sso.ErrNotProvisioned):
+		return "unknown_user", "There is no account for you here. Ask an admin for an invite."
+	case errors.Is(err, sso.ErrNotReauthenticated):
+		return "sso_not_reauthenticated", "Your identity provider did not confirm it is you; nothing was changed."
+	}
+	return "", ""
+}
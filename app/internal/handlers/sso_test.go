@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/account"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/sso"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/sso/ssotest"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/twofactor"
+)
+
+func TestSSOLogin(t *testing.T) {
+	provider := ssotest.NewProvider(t)
+	users := memory.NewUserRepository()
+	twoFactor, err := twofactor.NewService(users, memory.NewSettingsRepository(), nil, "Task Manager", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig := newTestAuthConfig(users)
+	singleSignOn := sso.NewService(users, sso.Config{
+		IssuerURL:      provider.URL,
+		ClientID:       ssotest.ClientID,
+		ClientSecret:   ssotest.ClientSecret,
+		RedirectURL:    "http://app.test/login/sso/callback",
+		AllowedDomains: []string{"example.com"},
+		ButtonLabel:    "Sign in with Example ID",
+	}, authConfig.JWTSecret)
+	h := NewAuthHandler(users, memory.NewInviteRepository(), twoFactor, singleSignOn, newTestPasswordPolicy(t), authConfig)
+
+	rec := httptest.NewRecorder()
+	h.ShowLogin(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	if !strings.Contains(rec.Body.String(), "Sign in with Example ID") {
+		t.Fatal("the login page has no single sign-on button")
+	}
+
+	// login goes to the identity provider as identity and back, and
+	// returns the callback's response.
+	login := func(identity ssotest.Identity) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.StartSSO(rec, httptest.NewRequest(http.MethodGet, "/login/sso", nil))
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("start: status = %d: %s", rec.Code, rec.Body)
+		}
+		req := httptest.NewRequest(http.MethodGet, provider.Authorize(rec.Header().Get("Location"), identity), nil)
+		for _, c := range rec.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		rec = httptest.NewRecorder()
+		h.HandleSSOCallback(rec, req)
+		return rec
+	}
+	session := func(rec *httptest.ResponseRecorder) *auth.Claims {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == "token" && c.Value != "" {
+				claims, err := auth.ValidateToken(c.Value, authConfig.Keys)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return claims
+			}
+		}
+		return nil
+	}
+
+	// A new user in an allowed domain gets an account and a session. The
+	// page moves on by itself rather than redirecting.
+	rec = login(ssotest.Identity{Subject: "sub-ada", Email: "ada@example.com", EmailVerified: true, Name: "Ada"})
+	claims := session(rec)
+	if rec.Code != http.StatusOK || claims == nil || claims.Email != "ada@example.com" ||
+		!strings.Contains(rec.Body.String(), `http-equiv="refresh"`) {
+		t.Fatalf("callback: status = %d, claims = %+v: %s", rec.Code, claims, rec.Body)
+	}
+
+	// Anyone else is sent back to the login form.
+	rec = login(ssotest.Identity{Subject: "sub-eve", Email: "eve@elsewhere.test", EmailVerified: true})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" || session(rec) != nil {
+		t.Errorf("unknown user: status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// So is a callback without the state cookie.
+	rec = httptest.NewRecorder()
+	h.HandleSSOCallback(rec, httptest.NewRequest(http.MethodGet, "/login/sso/callback?code=x&state=y", nil))
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("no state: status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestSSOAccountDeletion(t *testing.T) {
+	provider := ssotest.NewProvider(t)
+	users := memory.NewUserRepository()
+	twoFactor, err := twofactor.NewService(users, memory.NewSettingsRepository(), nil, "Task Manager", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig := newTestAuthConfig(users)
+	singleSignOn := sso.NewService(users, sso.Config{
+		IssuerURL:      provider.URL,
+		ClientID:       ssotest.ClientID,
+		ClientSecret:   ssotest.ClientSecret,
+		RedirectURL:    "http://app.test/login/sso/callback",
+		AllowedDomains: []string{"example.com"},
+	}, authConfig.JWTSecret)
+	h := NewAuthHandler(users, memory.NewInviteRepository(), twoFactor, singleSignOn, newTestPasswordPolicy(t), authConfig)
+	accounts := account.NewService(users, memory.NewTaskRepository(), memory.NewInviteRepository(), memory.NewIdempotencyRepository(), memory.NewRefreshTokenRepository(), time.Hour)
+	accountHandler := NewAccountHandler(accounts, users, authConfig)
+
+	// The account is provisioned by a single sign-on login, so it has no
+	// password.
+	ada := ssotest.Identity{Subject: "sub-ada", Email: "ada@example.com", EmailVerified: true, Name: "Ada"}
+	rec := httptest.NewRecorder()
+	h.StartSSO(rec, httptest.NewRequest(http.MethodGet, "/login/sso", nil))
+	req := httptest.NewRequest(http.MethodGet, provider.Authorize(rec.Header().Get("Location"), ada), nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	h.HandleSSOCallback(httptest.NewRecorder(), req)
+	user, err := users.FindByEmail(t.Context(), ada.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordHash != "" {
+		t.Fatal("the provisioned account has a password")
+	}
+
+	rec = httptest.NewRecorder()
+	accountHandler.ShowAccount(rec, apiRequest(http.MethodGet, "/account", "", user.ID))
+	if body := rec.Body.String(); strings.Contains(body, `name="password"`) || !strings.Contains(body, "single sign-on") {
+		t.Errorf("the account page asks for a password: %s", body)
+	}
+
+	// reauth goes to the identity provider as identity and back, and
+	// returns the reauthentication cookie, if any.
+	reauth := func(identity ssotest.Identity) *http.Cookie {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.StartReauth(rec, apiRequest(http.MethodGet, "/account/reauth?next=%2Faccount", "", user.ID))
+		authURL := rec.Header().Get("Location")
+		if rec.Code != http.StatusSeeOther || !strings.Contains(authURL, "prompt=login") || !strings.Contains(authURL, "max_age=0") {
+			t.Fatalf("start: status = %d, location = %q", rec.Code, authURL)
+		}
+		req := httptest.NewRequest(http.MethodGet, provider.Authorize(authURL, identity), nil)
+		for _, c := range rec.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		rec = httptest.NewRecorder()
+		h.HandleSSOCallback(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("callback: status = %d: %s", rec.Code, rec.Body)
+		}
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == reauthCookie && c.Value != "" {
+				return c
+			}
+		}
+		return nil
+	}
+	deleteAccount := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := formRequest("/account/delete", nil, user.ID)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		accountHandler.DeleteAccount(rec, req)
+		return rec
+	}
+	scheduled := func() bool {
+		stored, err := users.FindByID(t.Context(), user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored.DeletionScheduledAt != nil
+	}
+
+	// Deleting sends the user to log in again first.
+	rec = deleteAccount(nil)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/account/reauth?next=%2Faccount" || scheduled() {
+		t.Fatalf("delete without reauthenticating: status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// A login at the provider from before the reauthentication started,
+	// or as someone else, does not count.
+	if cookie := reauth(ssotest.Identity{Subject: ada.Subject, Email: ada.Email, EmailVerified: true, AuthTime: time.Now().Add(-time.Hour)}); cookie != nil {
+		t.Error("a stale login at the identity provider was accepted")
+	}
+	if cookie := reauth(ssotest.Identity{Subject: "sub-bob", Email: "bob@example.com", EmailVerified: true}); cookie != nil {
+		t.Error("a login as someone else was accepted")
+	}
+
+	cookie := reauth(ada)
+	if cookie == nil {
+		t.Fatal("reauthenticating set no cookie")
+	}
+	rec = deleteAccount(cookie)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" || !scheduled() {
+		t.Fatalf("delete after reauthenticating: status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
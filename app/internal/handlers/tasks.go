@@ -1,52 +1,41 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
 	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type TaskHandler struct {
-	repo *database.TaskRepository
+	repo database.TaskStore
 }
 
-func NewTaskHandler(repo *database.TaskRepository) *TaskHandler {
+func NewTaskHandler(repo database.TaskStore) *TaskHandler {
 	return &TaskHandler{repo: repo}
 }
 
-func (h *TaskHandler) HandleTasks(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		if strings.HasPrefix(r.URL.Path, "/api/tasks/") {
-			h.GetTask(w, r)
-		} else {
-			h.ListTasks(w, r)
-		}
-	case http.MethodPost:
-		h.CreateTask(w, r)
-	case http.MethodPut:
-		h.UpdateTask(w, r)
-	case http.MethodDelete:
-		h.DeleteTask(w, r)
-	default:
-		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
-	}
-}
-
 func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		writeAPIError(w, r, errorStatus(http.StatusUnauthorized, "Authentication required"))
 		return
 	}
 
 	tasks, err := h.repo.FindByUserID(r.Context(), claims.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		writeAPIError(w, r, err)
 		return
 	}
 
@@ -56,124 +45,283 @@ func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
 func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		writeAPIError(w, r, errorStatus(http.StatusUnauthorized, "Authentication required"))
 		return
 	}
 
-	id := strings.TrimPrefix(r.URL.Path, "/api/tasks/")
-	if id == "" {
-		respondWithError(w, http.StatusBadRequest, "Task ID is required")
-		return
-	}
+	id := r.PathValue("id")
 
 	task, err := h.repo.FindByIDAndUserID(r.Context(), id, claims.UserID)
 	if err != nil {
-		if err.Error() == "task not found" || err.Error() == "invalid task ID" {
-			respondWithError(w, http.StatusNotFound, err.Error())
-		} else {
-			respondWithError(w, http.StatusInternalServerError, err.Error())
-		}
+		writeAPIError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", taskETag(task))
 	respondWithJSON(w, http.StatusOK, task)
 }
 
 func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		writeAPIError(w, r, errorStatus(http.StatusUnauthorized, "Authentication required"))
 		return
 	}
 
-	var task models.Task
-	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	task, err := decodeTask(r)
+	if err != nil {
+		writeAPIError(w, r, err)
 		return
 	}
-	defer r.Body.Close()
 
 	task.UserID = claims.UserID
 
-	if err := task.Validate(); err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-
-	if err := h.repo.Create(r.Context(), &task); err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	if err := h.repo.Create(r.Context(), task); err != nil {
+		writeAPIError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", taskETag(task))
 	respondWithJSON(w, http.StatusCreated, task)
 }
 
+// UpdateTask replaces the task's editable fields. With an If-Match header
+// the update only applies to the version named there.
 func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		writeAPIError(w, r, errorStatus(http.StatusUnauthorized, "Authentication required"))
 		return
 	}
 
-	id := strings.TrimPrefix(r.URL.Path, "/api/tasks/")
-	if id == "" {
-		respondWithError(w, http.StatusBadRequest, "Task ID is required")
+	id := r.PathValue("id")
+
+	task, err := decodeTask(r)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	if r.Header.Get("If-Match") != "" {
+		current, err := h.repo.FindByIDAndUserID(r.Context(), id, claims.UserID)
+		if err != nil {
+			writeAPIError(w, r, err)
+			return
+		}
+		if !ifMatch(r, current) {
+			writeAPIError(w, r, errTaskChanged)
+			return
+		}
+		task.Version = current.Version
+	}
+
+	h.saveTask(w, r, id, claims.UserID, task)
+}
+
+// PatchTask applies a JSON Merge Patch (RFC 7396) to the task: fields
+// missing from the patch are kept and null clears them. The update is
+// conditional on the version the patch was applied to, and on If-Match
+// when present.
+func (h *TaskHandler) PatchTask(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, r, errorStatus(http.StatusUnauthorized, "Authentication required"))
 		return
 	}
 
-	var task models.Task
-	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, _ := mime.ParseMediaType(ct)
+		if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+			writeAPIError(w, r, errorStatus(http.StatusUnsupportedMediaType,
+				"PATCH requires Content-Type application/merge-patch+json"))
+			return
+		}
+	}
+
+	id := r.PathValue("id")
+
+	current, err := h.repo.FindByIDAndUserID(r.Context(), id, claims.UserID)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if !ifMatch(r, current) {
+		writeAPIError(w, r, errTaskChanged)
 		return
 	}
+
 	defer r.Body.Close()
+	var patch map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeAPIError(w, r, errorStatus(http.StatusBadRequest, "The patch must be a JSON object"))
+		return
+	}
 
-	if err := task.Validate(); err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
+	merged, err := json.Marshal(mergePatch(taskDocument(current), patch))
+	if err != nil {
+		writeAPIError(w, r, err)
 		return
 	}
+	task, err := parseTask(bytes.NewReader(merged))
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	task.Version = current.Version
 
-	if err := h.repo.UpdateByUserID(r.Context(), id, claims.UserID, &task); err != nil {
-		if err.Error() == "task not found" || err.Error() == "invalid task ID" {
-			respondWithError(w, http.StatusNotFound, err.Error())
-		} else {
-			respondWithError(w, http.StatusInternalServerError, err.Error())
+	h.saveTask(w, r, id, claims.UserID, task)
+}
+
+// saveTask stores an update and responds with the task as stored.
+func (h *TaskHandler) saveTask(w http.ResponseWriter, r *http.Request, id string, userID primitive.ObjectID, task *models.Task) {
+	if err := h.repo.UpdateByUserID(r.Context(), id, userID, task); err != nil {
+		if errors.Is(err, database.ErrVersionConflict) {
+			err = errTaskChanged
 		}
+		writeAPIError(w, r, err)
 		return
 	}
 
-	updatedTask, err := h.repo.FindByIDAndUserID(r.Context(), id, claims.UserID)
+	updatedTask, err := h.repo.FindByIDAndUserID(r.Context(), id, userID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		writeAPIError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", taskETag(updatedTask))
 	respondWithJSON(w, http.StatusOK, updatedTask)
 }
 
 func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 	claims, ok := auth.GetUserFromContext(r.Context())
 	if !ok {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		writeAPIError(w, r, errorStatus(http.StatusUnauthorized, "Authentication required"))
 		return
 	}
 
-	id := strings.TrimPrefix(r.URL.Path, "/api/tasks/")
-	if id == "" {
-		respondWithError(w, http.StatusBadRequest, "Task ID is required")
+	id := r.PathValue("id")
+
+	if err := h.repo.DeleteByUserID(r.Context(), id, claims.UserID); err != nil {
+		writeAPIError(w, r, err)
 		return
 	}
 
-	if err := h.repo.DeleteByUserID(r.Context(), id, claims.UserID); err != nil {
-		if err.Error() == "task not found" || err.Error() == "invalid task ID" {
-			respondWithError(w, http.StatusNotFound, err.Error())
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Task moved to trash"})
+}
+
+// ListRevisions returns the task's history, newest revision first.
+func (h *TaskHandler) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, r, errorStatus(http.StatusUnauthorized, "Authentication required"))
+		return
+	}
+
+	id := r.PathValue("id")
+
+	// Revisions outlive a trip to the trash, but a trashed task is not
+	// found through the API.
+	if _, err := h.repo.FindByIDAndUserID(r.Context(), id, claims.UserID); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	revisions, err := h.repo.FindRevisionsByUserID(r.Context(), id, claims.UserID)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, revisions)
+}
+
+// taskPayload holds the writable task fields. DueDate stays raw so a bad
+// date is reported as a field error rather than a malformed payload.
+type taskPayload struct {
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Status      string          `json:"status"`
+	DueDate     json.RawMessage `json:"due_date"`
+}
+
+// decodeTask reads a task from the JSON body and validates it.
+func decodeTask(r *http.Request) (*models.Task, error) {
+	defer r.Body.Close()
+	return parseTask(r.Body)
+}
+
+// parseTask decodes and validates a task payload. Type mismatches and
+// validation failures are all returned together as models.ValidationErrors.
+func parseTask(body io.Reader) (*models.Task, error) {
+	var payload taskPayload
+	var errs models.ValidationErrors
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) || typeErr.Field == "" {
+			return nil, errorStatus(http.StatusBadRequest, "Invalid request payload")
+		}
+		errs.Add(typeErr.Field, fmt.Sprintf("%s must be a %s", typeErr.Field, typeErr.Type))
+	}
+
+	task := &models.Task{
+		Title:       payload.Title,
+		Description: payload.Description,
+		Status:      payload.Status,
+	}
+	if len(payload.DueDate) > 0 && string(payload.DueDate) != "null" {
+		var dueDate time.Time
+		if err := json.Unmarshal(payload.DueDate, &dueDate); err != nil {
+			errs.Add("due_date", "due_date must be an RFC 3339 timestamp")
 		} else {
-			respondWithError(w, http.StatusInternalServerError, err.Error())
+			task.DueDate = &dueDate
 		}
-		return
 	}
 
-	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Task deleted successfully"})
+	errs.Merge(task.Validate())
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// taskDocument is the patchable JSON form of a task, matching taskPayload.
+func taskDocument(task *models.Task) map[string]any {
+	doc := map[string]any{
+		"title":       task.Title,
+		"description": task.Description,
+		"status":      task.Status,
+		"due_date":    nil,
+	}
+	if task.DueDate != nil {
+		doc["due_date"] = task.DueDate.Format(time.RFC3339Nano)
+	}
+	return doc
+}
+
+// errTaskChanged is returned when a conditional update names a version
+// that is no longer current.
+var errTaskChanged = errorStatus(http.StatusPreconditionFailed,
+	"The task has changed since it was read; fetch it again and retry")
+
+// taskETag is the strong entity tag for a task version.
+func taskETag(task *models.Task) string {
+	return `"` + strconv.FormatInt(task.Version, 10) + `"`
+}
+
+// ifMatch reports whether the request's If-Match header, if any, names
+// the task's current version. Weak tags never match.
+func ifMatch(r *http.Request, task *models.Task) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	etag := taskETag(task)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -188,7 +336,3 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(response)
 }
-
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
-}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func apiRequest(method, path, body string, userID primitive.ObjectID) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	claims := &auth.Claims{UserID: userID, Email: "user@example.com", Role: models.RoleUser}
+	return req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, claims))
+}
+
+func newTestAPIRouter(tasks database.TaskStore) http.Handler {
+	return NewAPIRouter(NewTaskHandler(tasks), NewIdempotency(memory.NewIdempotencyRepository(), time.Hour))
+}
+
+func TestTaskAPI(t *testing.T) {
+	h := newTestAPIRouter(memory.NewTaskRepository())
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, apiRequest(http.MethodPost, "/api/v1/tasks", `{"title":"Buy milk"}`, owner))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+	}
+	var created models.Task
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Status != models.StatusPending || created.UserID != owner {
+		t.Fatalf("created task = %+v", created)
+	}
+	path := "/api/v1/tasks/" + created.ID.Hex()
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, apiRequest(http.MethodPost, "/api/v1/tasks", `{"status":"done","due_date":"tomorrow"}`, owner))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("create with invalid fields: status %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("error Content-Type = %q", ct)
+	}
+	var problem Problem
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]bool{}
+	for _, e := range problem.Errors {
+		fields[e.Field] = true
+	}
+	if problem.Status != http.StatusBadRequest || len(fields) != 3 || !fields["title"] || !fields["status"] || !fields["due_date"] {
+		t.Fatalf("problem = %+v, want errors for title, status and due_date", problem)
+	}
+
+	// Other users see neither the task nor the list entry.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, apiRequest(http.MethodGet, path, "", other))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("get as other user: status %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, apiRequest(http.MethodGet, "/api/v1/tasks", "", other))
+	if strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Fatalf("list as other user = %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, apiRequest(http.MethodPut, path, `{"title":"Buy oat milk","status":"completed"}`, owner))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: status %d: %s", rec.Code, rec.Body)
+	}
+	var updated models.Task
+	if err := json.NewDecoder(rec.Body).Decode(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != "Buy oat milk" || updated.Status != models.StatusCompleted {
+		t.Fatalf("updated task = %+v", updated)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, apiRequest(http.MethodDelete, path, "", other))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("delete as other user: status %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, apiRequest(http.MethodDelete, path, "", owner))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: status %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, apiRequest(http.MethodGet, path, "", owner))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("get after delete: status %d", rec.Code)
+	}
+}
+
+func TestPatchTaskAndETags(t *testing.T) {
+	h := newTestAPIRouter(memory.NewTaskRepository())
+	owner := primitive.NewObjectID()
+
+	send := func(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := apiRequest(method, path, body, owner)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) models.Task {
+		t.Helper()
+		var task models.Task
+		if err := json.NewDecoder(rec.Body).Decode(&task); err != nil {
+			t.Fatal(err)
+		}
+		return task
+	}
+
+	rec := send(http.MethodPost, "/api/v1/tasks", `{"title":"Plan","description":"draft","due_date":"2026-12-31T00:00:00Z"}`, nil)
+	if rec.Code != http.StatusCreated || rec.Header().Get("ETag") != `"1"` {
+		t.Fatalf("create: status %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	path := "/api/v1/tasks/" + decode(rec).ID.Hex()
+	mergePatch := http.Header{"Content-Type": {"application/merge-patch+json"}}
+
+	// Omitted fields are kept, unlike PUT.
+	rec = send(http.MethodPatch, path, `{"status":"in_progress"}`, mergePatch)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch: status %d: %s", rec.Code, rec.Body)
+	}
+	patched := decode(rec)
+	if patched.Status != models.StatusInProgress || patched.Title != "Plan" || patched.Description != "draft" || patched.DueDate == nil {
+		t.Fatalf("patched task = %+v", patched)
+	}
+	if patched.Version != 2 || rec.Header().Get("ETag") != `"2"` {
+		t.Fatalf("version %d, ETag %q after patch", patched.Version, rec.Header().Get("ETag"))
+	}
+
+	// null clears a field.
+	rec = send(http.MethodPatch, path, `{"due_date":null}`, mergePatch)
+	if cleared := decode(rec); cleared.DueDate != nil || cleared.Description != "draft" {
+		t.Fatalf("task after clearing due_date = %+v", cleared)
+	}
+
+	// If-Match with an outdated or weak tag is refused and changes nothing.
+	for _, tag := range []string{`"2"`, `W/"3"`} {
+		rec = send(http.MethodPatch, path, `{"title":"Lost update"}`, http.Header{
+			"Content-Type": {"application/merge-patch+json"},
+			"If-Match":     {tag},
+		})
+		if rec.Code != http.StatusPreconditionFailed {
+			t.Fatalf("patch with If-Match %s: status %d", tag, rec.Code)
+		}
+	}
+	rec = send(http.MethodPut, path, `{"title":"Lost update"}`, http.Header{"If-Match": {`"1"`}})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("put with stale If-Match: status %d", rec.Code)
+	}
+	rec = send(http.MethodGet, path, "", nil)
+	if got := decode(rec); got.Title != "Plan" || rec.Header().Get("ETag") != `"3"` {
+		t.Fatalf("after refused updates: title %q, ETag %q", got.Title, rec.Header().Get("ETag"))
+	}
+
+	rec = send(http.MethodPut, path, `{"title":"Final"}`, http.Header{"If-Match": {`"7", "3"`}})
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"4"` {
+		t.Fatalf("put with current If-Match: status %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	rec = send(http.MethodPatch, path, `title=x`, http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("patch with form body: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/flash"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/twofactor"
+	"github.com/cfegela/azure-aca-go-templ-mongo/web/templates"
+)
+
+// TwoFactorHandler serves the account pages for setting up and managing
+// two-factor authentication, and the admin page that requires it for
+// admins. The second login step is part of AuthHandler.
+type TwoFactorHandler struct {
+	twoFactor  *twofactor.Service
+	userRepo   database.UserStore
+	authConfig *auth.Config
+}
+
+func NewTwoFactorHandler(twoFactor *twofactor.Service, userRepo database.UserStore, authConfig *auth.Config) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactor:  twoFactor,
+		userRepo:   userRepo,
+		authConfig: authConfig,
+	}
+}
+
+func (h *TwoFactorHandler) ShowTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	required, err := h.twoFactor.Required(r.Context(), user)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	render(w, r, "Two-Factor Authentication",
+		templates.TwoFactor(claims.Email, user, h.twoFactor.Available(), required))
+}
+
+func (h *TwoFactorHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled {
+		flash.Info(r.Context(), "Two-factor authentication is already on.")
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	enrollment, err := h.twoFactor.Begin(r.Context(), user)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	render(w, r, "Set Up Two-Factor Authentication",
+		templates.TwoFactorSetup(claims.Email, "/account/2fa/confirm", enrollment, templates.NewFormState(nil, nil)))
+}
+
+func (h *TwoFactorHandler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	codes, ok := confirmTwoFactor(w, r, h.twoFactor, user, claims.Email, "/account/2fa/confirm")
+	if !ok {
+		return
+	}
+	logging.FromContext(r.Context()).Info("two-factor authentication enabled", "user_id", user.ID.Hex())
+	// Sessions started without it end; this one carries on.
+	if err := h.authConfig.Sessions.Restart(r.Context(), w, r, user); err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	render(w, r, "Recovery Codes", templates.RecoveryCodes(claims.Email, codes, "/account/2fa"))
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. Like turning
+// two-factor authentication off, it asks for the password again.
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	claims, user, ok := h.currentUser(w, r)
+	if !ok || !h.checkPassword(w, r, user) {
+		return
+	}
+
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("recovery codes replaced", "user_id", user.ID.Hex())
+	render(w, r, "Recovery Codes", templates.RecoveryCodes(claims.Email, codes, "/account/2fa"))
+}
+
+func (h *TwoFactorHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	required, err := h.twoFactor.Required(r.Context(), user)
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	if required {
+		writePageError(w, r, errorStatus(http.StatusForbidden, "Two-factor authentication is required for admins."))
+		return
+	}
+	if !h.checkPassword(w, r, user) {
+		return
+	}
+
+	if err := h.twoFactor.Disable(r.Context(), user.ID); err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("two-factor authentication disabled", "user_id", user.ID.Hex())
+	// Sessions started with it end too, in case it was turned off by
+	// someone else; this one carries on.
+	if err := h.authConfig.Sessions.Restart(r.Context(), w, r, user); err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	flash.Success(r.Context(), "Two-factor authentication is off.")
+	http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+}
+
+// ShowAdminTwoFactor shows whether admins must use two-factor
+// authentication, and which admins have not set it up.
+func (h *TwoFactorHandler) ShowAdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	required, err := h.twoFactor.RequiredForAdmins(r.Context())
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	users, err := h.userRepo.FindAll(r.Context())
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+	var unenrolled []models.User
+	for _, user := range users {
+		if user.Role == models.RoleAdmin && !user.TwoFactorEnabled && user.DeletionScheduledAt == nil {
+			unenrolled = append(unenrolled, user)
+		}
+	}
+
+	render(w, r, "Two-Factor Authentication for Admins", templates.AdminTwoFactor(claims.Email, required,
+		h.twoFactor.RequiredByConfig(), h.twoFactor.Available(), unenrolled))
+}
+
+// SetAdminTwoFactor turns the requirement for admins on or off. It takes
+// effect at each admin's next login or session renewal, so within
+// JWT_EXPIRY for admins already logged in.
+func (h *TwoFactorHandler) SetAdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	required := r.FormValue("required") == "1"
+	if !required && h.twoFactor.RequiredByConfig() {
+		writePageError(w, r, errorStatus(http.StatusConflict, "Two-factor authentication is required for admins by the server configuration."))
+		return
+	}
+	err := h.twoFactor.SetRequiredForAdmins(r.Context(), required)
+	if errors.Is(err, twofactor.ErrUnavailable) {
+		writePageError(w, r, errorStatus(http.StatusConflict, "Two-factor authentication is not configured on this server."))
+		return
+	}
+	if err != nil {
+		writePageError(w, r, err)
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("two-factor requirement for admins changed", "required", required, "admin_id", claims.UserID.Hex())
+	if required {
+		flash.Success(r.Context(), "Two-factor authentication is now required for admins.")
+	} else {
+		flash.Success(r.Context(), "Two-factor authentication is no longer required for admins.")
+	}
+	http.Redirect(w, r, "/admin/2fa", http.StatusSeeOther)
+}
+
+// currentUser loads the logged-in user. On failure it has already
+// responded and returns false.
+func (h *TwoFactorHandler) currentUser(w http.ResponseWriter, r *http.Request) (*auth.Claims, *models.User, bool) {
+	claims, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return nil, nil, false
+	}
+	user, err := h.userRepo.FindByID(r.Context(), claims.UserID)
+	if err != nil {
+		writePageError(w, r, err)
+		return nil, nil, false
+	}
+	return claims, user, true
+}
+
+// checkPassword asks for the password, or a fresh single sign-on login,
+// again before a change that weakens the account's protection. Otherwise it
+// redirects and returns false.
+func (h *TwoFactorHandler) checkPassword(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	return confirmIdentity(w, r, h.authConfig, user, "/account/2fa", "nothing was changed")
+}
+
+// confirmTwoFactor finishes enrolling with the submitted code and returns
+// the recovery codes. A wrong code shows the setup page, posting to action,
+// again. On failure it has already responded and returns false.
+func confirmTwoFactor(w http.ResponseWriter, r *http.Request, twoFactor *twofactor.Service, user *models.User, userName, action string) ([]string, bool) {
+	codes, err := twoFactor.Confirm(r.Context(), user, r.FormValue("code"))
+	if errors.Is(err, twofactor.ErrInvalidCode) {
+		enrollment, err := twoFactor.Pending(user)
+		if err != nil {
+			writePageError(w, r, err)
+			return nil, false
+		}
+		var errs models.ValidationErrors
+		errs.Add("code", "the code is wrong; check that your device's clock is correct")
+		renderStatus(w, r, http.StatusBadRequest, "Set Up Two-Factor Authentication",
+			templates.TwoFactorSetup(userName, action, enrollment, templates.NewFormState(r.PostForm, errs)))
+		return nil, false
+	}
+	if err != nil {
+		writePageError(w, r, err)
+		return nil, false
+	}
+	return codes, true
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/auth"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/twofactor"
+	"github.com/pquerna/otp/totp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLoginTwoFactor(t *testing.T) {
+	users := memory.NewUserRepository()
+	twoFactor, err := twofactor.NewService(users, memory.NewSettingsRepository(), bytes.Repeat([]byte{1}, 32), "Task Manager", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig := newTestAuthConfig(users)
+	h := NewAuthHandler(users, memory.NewInviteRepository(), twoFactor, nil, newTestPasswordPolicy(t), authConfig)
+
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", PasswordHash: hash, Role: models.RoleAdmin}
+	if err := users.Create(t.Context(), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	// post sends a form with the cookies set by earlier responses.
+	var cookies []*http.Cookie
+	post := func(handler http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+		req := formRequest(path, form, primitive.NilObjectID)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		for _, c := range rec.Result().Cookies() {
+			if c.MaxAge >= 0 {
+				cookies = append(cookies, c)
+			}
+		}
+		return rec
+	}
+	sessionCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == "token" && c.Value != "" {
+				return c
+			}
+		}
+		return nil
+	}
+	login := func() {
+		t.Helper()
+		cookies = nil
+		rec := post(h.HandleLogin, "/login", url.Values{"email": {admin.Email}, "password": {"correct horse"}})
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login/2fa" || sessionCookie(rec) != nil {
+			t.Fatalf("login: status = %d, location = %q; want the second step without a session", rec.Code, rec.Header().Get("Location"))
+		}
+	}
+
+	// The admin has to enroll before logging in.
+	login()
+	if _, err := auth.ValidateToken(cookies[0].Value, authConfig.Keys); err == nil {
+		t.Fatal("the second-step token is accepted as a session")
+	}
+	rec := post(h.SetupLoginTwoFactor, "/login/2fa/setup", nil)
+	secret := regexp.MustCompile(`<code class="totp-secret">([A-Z2-7]+)</code>`).FindStringSubmatch(rec.Body.String())
+	if rec.Code != http.StatusOK || secret == nil || !bytes.Contains(rec.Body.Bytes(), []byte(`src="data:image/png;base64,`)) {
+		t.Fatalf("setup: status = %d: %s", rec.Code, rec.Body)
+	}
+	code, err := totp.GenerateCode(secret[1], time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = post(h.ConfirmLoginTwoFactor, "/login/2fa/confirm", url.Values{"code": {code}})
+	if rec.Code != http.StatusOK || sessionCookie(rec) == nil {
+		t.Fatalf("confirm: status = %d, want a session", rec.Code)
+	}
+	var recoveryCodes []string
+	for _, m := range regexp.MustCompile(`<li><code>([a-z2-7-]+)</code></li>`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		recoveryCodes = append(recoveryCodes, m[1])
+	}
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("recovery codes shown: %v", recoveryCodes)
+	}
+
+	// Logging in again asks for a code, and a used one is refused.
+	login()
+	if rec := post(h.HandleLoginTwoFactor, "/login/2fa", url.Values{"code": {code}}); rec.Code != http.StatusUnauthorized || sessionCookie(rec) != nil {
+		t.Fatalf("replayed code: status = %d", rec.Code)
+	}
+	rec = post(h.HandleLoginTwoFactor, "/login/2fa", url.Values{"code": {recoveryCodes[0]}})
+	if rec.Code != http.StatusSeeOther || sessionCookie(rec) == nil {
+		t.Fatalf("recovery code: status = %d, want a session", rec.Code)
+	}
+
+	// Once the attempts are used up the right code is refused too, and
+	// the login has to start over.
+	login()
+	for i := range twofactor.MaxAttempts {
+		if rec := post(h.HandleLoginTwoFactor, "/login/2fa", url.Values{"code": {"wrong-code"}}); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: status = %d", i+1, rec.Code)
+		}
+	}
+	rec = post(h.HandleLoginTwoFactor, "/login/2fa", url.Values{"code": {recoveryCodes[1]}})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != strconv.Itoa(int(twofactor.AttemptWindow.Seconds())) || sessionCookie(rec) != nil {
+		t.Fatalf("right code after too many wrong ones: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if c := rec.Result().Cookies(); len(c) != 1 || c[0].Name != "login_2fa" || c[0].MaxAge >= 0 {
+		t.Errorf("cookies = %v, want the second-step token cleared", c)
+	}
+	login()
+	if rec := post(h.HandleLoginTwoFactor, "/login/2fa", url.Values{"code": {recoveryCodes[1]}}); sessionCookie(rec) != nil {
+		t.Errorf("logging in again within %s: status = %d, want no session", twofactor.AttemptWindow, rec.Code)
+	}
+
+	// Without a password first there is no second step.
+	cookies = nil
+	if rec := post(h.HandleLoginTwoFactor, "/login/2fa", url.Values{"code": {recoveryCodes[1]}}); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("second step without a password: status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// Admins cannot turn it off while it is required.
+	rec = httptest.NewRecorder()
+	NewTwoFactorHandler(twoFactor, users, authConfig).DisableTwoFactor(rec, formRequest("/account/2fa/disable", url.Values{"password": {"correct horse"}}, admin.ID))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("disable for an admin: status = %d, want 403", rec.Code)
+	}
+}
+
+func TestAdminTwoFactorSetting(t *testing.T) {
+	users := memory.NewUserRepository()
+	twoFactor, err := twofactor.NewService(users, memory.NewSettingsRepository(), bytes.Repeat([]byte{1}, 32), "Task Manager", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig := newTestAuthConfig(users)
+	auths := NewAuthHandler(users, memory.NewInviteRepository(), twoFactor, nil, newTestPasswordPolicy(t), authConfig)
+	h := NewTwoFactorHandler(twoFactor, users, authConfig)
+
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &models.User{Email: "admin@example.com", Name: "Admin", PasswordHash: hash, Role: models.RoleAdmin}
+	if err := users.Create(t.Context(), admin); err != nil {
+		t.Fatal(err)
+	}
+	set := func(required string) int {
+		rec := httptest.NewRecorder()
+		h.SetAdminTwoFactor(rec, formRequest("/admin/2fa", url.Values{"required": {required}}, admin.ID))
+		return rec.Code
+	}
+	loginTo := func() string {
+		rec := httptest.NewRecorder()
+		auths.HandleLogin(rec, formRequest("/login", url.Values{"email": {admin.Email}, "password": {"correct horse"}}, primitive.NilObjectID))
+		return rec.Header().Get("Location")
+	}
+
+	rec := httptest.NewRecorder()
+	h.ShowAdminTwoFactor(rec, apiRequest(http.MethodGet, "/admin/2fa", "", admin.ID))
+	if body := rec.Body.String(); rec.Code != http.StatusOK || !strings.Contains(body, "optional") || !strings.Contains(body, admin.Email) {
+		t.Fatalf("page: status = %d: %s", rec.Code, body)
+	}
+	if to := loginTo(); to != "/" {
+		t.Fatalf("login before requiring: redirected to %q", to)
+	}
+
+	// Requiring it sends admins without it to set it up at their next
+	// login.
+	if code := set("1"); code != http.StatusSeeOther {
+		t.Fatalf("require: status = %d", code)
+	}
+	if to := loginTo(); to != "/login/2fa" {
+		t.Errorf("login after requiring: redirected to %q, want the second step", to)
+	}
+	if code := set("0"); code != http.StatusSeeOther {
+		t.Fatalf("stop requiring: status = %d", code)
+	}
+	if to := loginTo(); to != "/" {
+		t.Errorf("login after no longer requiring: redirected to %q", to)
+	}
+
+	// The configuration cannot be overridden.
+	configured, err := twofactor.NewService(users, memory.NewSettingsRepository(), bytes.Repeat([]byte{1}, 32), "Task Manager", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	NewTwoFactorHandler(configured, users, newTestAuthConfig(users)).SetAdminTwoFactor(rec, formRequest("/admin/2fa", url.Values{"required": {"0"}}, admin.ID))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("stop requiring against the configuration: status = %d, want 409", rec.Code)
+	}
+}
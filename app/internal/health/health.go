@@ -0,0 +1,157 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// CheckFunc reports whether a dependency is usable.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// Checker backs the liveness and readiness probes. Liveness only reports
+// that the process is serving; readiness runs every registered dependency
+// check and turns unready as soon as draining starts.
+type Checker struct {
+	timeout  time.Duration
+	checks   []check
+	draining atomic.Bool
+
+	mu         sync.RWMutex
+	migrations string
+}
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status     string                 `json:"status"`
+	Draining   bool                   `json:"draining,omitempty"`
+	Checks     map[string]CheckResult `json:"checks,omitempty"`
+	Migrations string                 `json:"migrations,omitempty"`
+}
+
+// NewChecker creates a checker whose dependency checks each get timeout to
+// complete.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{
+		timeout: timeout,
+	}
+}
+
+// AddCheck registers a readiness check. It must be called before serving.
+func (c *Checker) AddCheck(name string, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+// SetMigrationStatus records the schema migration state, e.g. "current",
+// "2 pending" or why applying them failed. Migrations create the indexes,
+// so this is also where a failed index build shows. It is reported by the
+// readiness probe but does not make the instance unready, as queries work
+// without indexes, only slower; use migrations.require_current to block
+// startup instead.
+func (c *Checker) SetMigrationStatus(status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.migrations = status
+}
+
+// StartDraining makes the readiness probe fail from now on so the load
+// balancer stops routing new traffic to this instance.
+func (c *Checker) StartDraining() {
+	c.draining.Store(true)
+}
+
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Ready runs all checks concurrently and returns the aggregated report.
+func (c *Checker) Ready(ctx context.Context) Report {
+	report := Report{
+		Status:   StatusOK,
+		Draining: c.Draining(),
+		Checks:   make(map[string]CheckResult, len(c.checks)),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, chk := range c.checks {
+		wg.Add(1)
+		go func(chk check) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := chk.fn(ctx)
+			result := CheckResult{
+				Status:    StatusOK,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = StatusFailed
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[chk.name] = result
+			if err != nil {
+				report.Status = StatusFailed
+			}
+		}(chk)
+	}
+	wg.Wait()
+
+	c.mu.RLock()
+	report.Migrations = c.migrations
+	c.mu.RUnlock()
+
+	if report.Draining {
+		report.Status = "draining"
+	}
+
+	return report
+}
+
+// LivenessHandler reports that the process is up and serving requests.
+func (c *Checker) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: StatusOK})
+}
+
+// ReadinessHandler returns 200 when every check passes and the instance is
+// not draining, 503 otherwise.
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := c.Ready(r.Context())
+
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeReport(w, code, report)
+}
+
+func writeReport(w http.ResponseWriter, code int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
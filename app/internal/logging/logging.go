@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+type contextKey string
+
+const scopeContextKey contextKey = "logger"
+
+// scope holds the request-scoped logger. It is stored by pointer so that
+// attributes added deep in the handler chain (e.g. the user ID set by
+// auth.RequireAuth) are visible to the access log written by the outermost
+// middleware.
+type scope struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+}
+
+// New creates a logger writing to w. Format is "json" or "text"; level is
+// one of debug, info, warn or error.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// NewContext returns a context carrying a new request scope for logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, scopeContextKey, &scope{logger: logger})
+}
+
+// FromContext returns the request-scoped logger, or slog.Default() when the
+// context has none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if s, ok := ctx.Value(scopeContextKey).(*scope); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.logger
+	}
+	return slog.Default()
+}
+
+// AddAttrs enriches the request-scoped logger in place. It is a no-op when
+// the context has no scope.
+func AddAttrs(ctx context.Context, args ...any) {
+	if s, ok := ctx.Value(scopeContextKey).(*scope); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.logger = s.logger.With(args...)
+	}
+}
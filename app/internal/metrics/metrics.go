@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tasks"
+
+// Registry holds every application metric plus the Go runtime and process
+// collectors. It is separate from prometheus.DefaultRegisterer so that only
+// what is registered here is exposed.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route pattern and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	mongoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_operation_duration_seconds",
+		Help:      "MongoDB operation latency by repository and method.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"repository", "method"})
+
+	loginAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_attempts_total",
+		Help:      "Login attempts by result (success or failure) and failure reason.",
+	}, []string{"result", "reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		mongoDuration,
+		loginAttempts,
+	)
+}
+
+var standardMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// ObserveHTTPRequest records a finished HTTP request. Route should be the
+// ServeMux pattern rather than the raw path to keep cardinality bounded.
+// Methods outside the standard set are recorded as "OTHER", as clients can
+// send any method.
+func ObserveHTTPRequest(method, route string, status int, duration time.Duration) {
+	if !slices.Contains(standardMethods, method) {
+		method = "OTHER"
+	}
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(method, route, code).Inc()
+	httpDuration.WithLabelValues(method, route, code).Observe(duration.Seconds())
+}
+
+// ObserveMongo starts timing a repository call. Use it as
+//
+//	defer metrics.ObserveMongo("tasks", "FindByUserID")()
+func ObserveMongo(repository, method string) func() {
+	start := time.Now()
+	return func() {
+		mongoDuration.WithLabelValues(repository, method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// LoginSucceeded counts a successful login.
+func LoginSucceeded() {
+	loginAttempts.WithLabelValues("success", "").Inc()
+}
+
+// LoginFailed counts a failed login with a short machine-readable reason.
+func LoginFailed(reason string) {
+	loginAttempts.WithLabelValues("failure", reason).Inc()
+}
+
+// RegisterActiveInvites exposes the number of unused, unexpired invites.
+// count is called on every scrape.
+func RegisterActiveInvites(count func(ctx context.Context) (int64, error)) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "invites_active",
+		Help:      "Invites that are neither used nor expired.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		n, err := count(ctx)
+		if err != nil {
+			slog.Warn("failed to count active invites", "error", err)
+			return 0
+		}
+		return float64(n)
+	}))
+}
+
+// Handler serves the registry in the Prometheus text format. When token is
+// not empty, requests must carry it as a bearer token.
+func Handler(token string) http.Handler {
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return handler
+	}
+
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveHTTPRequestMethods(t *testing.T) {
+	for _, tt := range []struct{ method, label string }{
+		{http.MethodGet, "GET"},
+		{http.MethodPatch, "PATCH"},
+		{"PROPFIND", "OTHER"},
+		{"get", "OTHER"},
+	} {
+		before := testutil.ToFloat64(httpRequests.WithLabelValues(tt.label, "GET /test", "200"))
+		ObserveHTTPRequest(tt.method, "GET /test", http.StatusOK, time.Millisecond)
+		if got := testutil.ToFloat64(httpRequests.WithLabelValues(tt.label, "GET /test", "200")); got != before+1 {
+			t.Errorf("%s: %s count = %v, want %v", tt.method, tt.label, got, before+1)
+		}
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	for _, tt := range []struct {
+		name, token, header string
+		want                int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"correct token", "s3cret", "Bearer s3cret", http.StatusOK},
+		{"missing token", "s3cret", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"token without scheme", "s3cret", "s3cret", http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			Handler(tt.token).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+		})
+	}
+}
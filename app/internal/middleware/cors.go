@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy describes which cross-origin requests a route accepts. A nil
+// policy or one without allowed origins emits no CORS headers, which keeps
+// the route same-origin only.
+type CORSPolicy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORS applies the policy to every request passed through the returned
+// middleware. Wrap individual routes to give them different policies.
+//
+// Origins are matched exactly. A "*" entry allows any origin, but only
+// without credentials; credentialed responses are reserved for origins that
+// are listed explicitly.
+func CORS(policy *CORSPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if policy == nil || len(policy.AllowedOrigins) == 0 {
+			return next
+		}
+
+		methods := strings.Join(policy.AllowedMethods, ", ")
+		headers := strings.Join(policy.AllowedHeaders, ", ")
+		exposed := strings.Join(policy.ExposedHeaders, ", ")
+		maxAge := ""
+		if policy.MaxAge > 0 {
+			maxAge = strconv.Itoa(int(policy.MaxAge.Seconds()))
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			allowOrigin, credentials := policy.match(origin)
+			if allowOrigin == "" {
+				if preflight {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			if credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if preflight {
+				if methods != "" {
+					w.Header().Set("Access-Control-Allow-Methods", methods)
+				}
+				if headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				if maxAge != "" {
+					w.Header().Set("Access-Control-Max-Age", maxAge)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if exposed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", exposed)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// match returns the Access-Control-Allow-Origin value for origin and whether
+// credentials may be allowed. An empty value means the origin is rejected.
+func (p *CORSPolicy) match(origin string) (string, bool) {
+	if origin == "" {
+		return "", false
+	}
+
+	wildcard := false
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			wildcard = true
+			continue
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin, p.AllowCredentials
+		}
+	}
+
+	if wildcard {
+		return "*", false
+	}
+	return "", false
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/logging"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey string
+
+const requestIDContextKey contextKey = "request_id"
+
+const RequestIDHeader = "X-Request-ID"
+
+// RequestLogger assigns each request an ID, taken from an incoming
+// X-Request-ID header when it looks sane or generated otherwise, echoes it
+// on the response and stores a request-scoped logger in the context. When
+// the request finishes an access log entry is written through that logger,
+// so attributes added with logging.AddAttrs (such as user_id) are included.
+func RequestLogger(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			requestID := r.Header.Get(RequestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = generateRequestID()
+			}
+			w.Header().Set(RequestIDHeader, requestID)
+
+			reqLogger := logger.With("request_id", requestID)
+			if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+				reqLogger = reqLogger.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+			}
+
+			ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+			ctx = logging.NewContext(ctx, reqLogger)
+
+			rec := newResponseRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			level := slog.LevelInfo
+			if rec.status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logging.FromContext(ctx).LogAttrs(ctx, level, "request completed",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.status),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.Int("bytes", rec.bytes),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("user_agent", r.UserAgent()),
+			)
+		})
+	}
+}
+
+// RequestIDFromContext returns the ID assigned by RequestLogger.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func generateRequestID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(bytes)
+}
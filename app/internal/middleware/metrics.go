@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+)
+
+// Metrics records request counts and latency labelled by route pattern. It
+// must wrap the ServeMux directly: the mux stores the matched pattern on the
+// *http.Request it receives, which is only visible here when no middleware
+// in between replaces the request with r.WithContext.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newResponseRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveHTTPRequest(r.Method, route, rec.status, time.Since(start))
+	})
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/metrics"
+)
+
+func TestMetricsLabels(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics-test/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := Metrics(mux)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/metrics-test/1", nil),
+		httptest.NewRequest(http.MethodGet, "/metrics-test/2", nil),
+		httptest.NewRequest("BREW", "/metrics-test/3", nil),
+		httptest.NewRequest(http.MethodGet, "/no-such-route-for-metrics", nil),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.Handler("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	scrape := string(body)
+
+	for _, want := range []string{
+		// Both IDs are counted under the one pattern.
+		`tasks_http_requests_total{method="GET",route="/metrics-test/{id}",status="418"} 2`,
+		`tasks_http_requests_total{method="OTHER",route="/metrics-test/{id}",status="418"} 1`,
+		`tasks_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+	} {
+		if !strings.Contains(scrape, want) {
+			t.Errorf("scrape lacks %s", want)
+		}
+	}
+	for _, leaked := range []string{"BREW", "/metrics-test/1"} {
+		if strings.Contains(scrape, leaked) {
+			t.Errorf("scrape contains %q", leaked)
+		}
+	}
+}
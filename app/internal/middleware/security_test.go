@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        SecurityConfig
+		wantHeader map[string]string
+	}{
+		{
+			name: "all headers",
+			cfg: SecurityConfig{
+				ContentSecurityPolicy: "default-src 'self'",
+				HSTSMaxAge:            365 * 24 * time.Hour,
+				HSTSIncludeSubdomains: true,
+				HSTSPreload:           true,
+				FrameOptions:          "DENY",
+				ReferrerPolicy:        "strict-origin-when-cross-origin",
+				PermissionsPolicy:     "camera=()",
+			},
+			wantHeader: map[string]string{
+				"Content-Security-Policy":   "default-src 'self'",
+				"Strict-Transport-Security": "max-age=31536000; includeSubDomains; preload",
+				"X-Frame-Options":           "DENY",
+				"Referrer-Policy":           "strict-origin-when-cross-origin",
+				"Permissions-Policy":        "camera=()",
+				"X-Content-Type-Options":    "nosniff",
+			},
+		},
+		{
+			name: "empty settings are omitted",
+			cfg:  SecurityConfig{},
+			wantHeader: map[string]string{
+				"Content-Security-Policy":   "",
+				"Strict-Transport-Security": "",
+				"X-Frame-Options":           "",
+				"Referrer-Policy":           "",
+				"Permissions-Policy":        "",
+				"X-Content-Type-Options":    "nosniff",
+			},
+		},
+		{
+			name: "HSTS without options",
+			cfg:  SecurityConfig{HSTSMaxAge: time.Hour},
+			wantHeader: map[string]string{
+				"Strict-Transport-Security": "max-age=3600",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			SecurityHeaders(&tt.cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+				ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			for name, want := range tt.wantHeader {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersNonce(t *testing.T) {
+	handler := SecurityHeaders(&SecurityConfig{ContentSecurityPolicy: "script-src 'self' 'nonce-" + NoncePlaceholder + "'"})
+	nonces := map[string]bool{}
+	for range 2 {
+		var nonce string
+		rec := httptest.NewRecorder()
+		handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce = templ.GetNonce(r.Context())
+		})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		csp := rec.Header().Get("Content-Security-Policy")
+		if nonce == "" || !strings.Contains(csp, "'nonce-"+nonce+"'") {
+			t.Fatalf("nonce %q not in CSP %q", nonce, csp)
+		}
+		if strings.Contains(csp, NoncePlaceholder) {
+			t.Errorf("CSP = %q", csp)
+		}
+		nonces[nonce] = true
+	}
+	if len(nonces) != 2 {
+		t.Error("the nonce was reused across requests")
+	}
+}
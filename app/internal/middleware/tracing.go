@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceRoute renames the server span started by otelhttp to
+// "<method> <route pattern>" once the ServeMux has matched the request. Like
+// Metrics, it must wrap the ServeMux directly.
+func TraceRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if r.Pattern == "" {
+			return
+		}
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + r.Pattern)
+		span.SetAttributes(semconv.HTTPRoute(r.Pattern))
+	})
+}
@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Unique indexes that used to be created by the server on every start.
+// Creating an index that already exists is a no-op, so this is safe on
+// databases that predate migrations.
+func init() {
+	register(Migration{
+		Version:     1,
+		Description: "initial indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "email", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = db.Collection("invites").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "token", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if _, err := db.Collection("users").Indexes().DropOne(ctx, "email_1"); err != nil {
+				return err
+			}
+			_, err := db.Collection("invites").Indexes().DropOne(ctx, "token_1")
+			return err
+		},
+	})
+}
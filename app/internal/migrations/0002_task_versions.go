@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Tasks created before optimistic concurrency have no version. Starting
+// them at 1 matches what Create assigns to new tasks.
+func init() {
+	register(Migration{
+		Version:     2,
+		Description: "task versions",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("tasks").UpdateMany(ctx,
+				bson.M{"version": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"version": 1}})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("tasks").UpdateMany(ctx,
+				bson.M{},
+				bson.M{"$unset": bson.M{"version": ""}})
+			return err
+		},
+	})
+}
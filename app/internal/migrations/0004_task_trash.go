@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Indexes for soft-deleted tasks: the per-user trash listing and the purge
+// of tasks past the retention period. Both only cover trashed tasks.
+func init() {
+	trashed := bson.M{"deleted_at": bson.M{"$exists": true}}
+
+	register(Migration{
+		Version:     4,
+		Description: "task trash",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("tasks").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "deleted_at", Value: -1}},
+					Options: options.Index().SetPartialFilterExpression(trashed),
+				},
+				{
+					Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+					Options: options.Index().SetPartialFilterExpression(trashed),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			indexes := db.Collection("tasks").Indexes()
+			if _, err := indexes.DropOne(ctx, "user_id_1_deleted_at_-1"); err != nil {
+				return err
+			}
+			_, err := indexes.DropOne(ctx, "deleted_at_1")
+			return err
+		},
+	})
+}
@@ -0,0 +1,26 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Task history is read per task, newest revision first, and deleted per
+// task when the task is purged.
+func init() {
+	register(Migration{
+		Version:     5,
+		Description: "task revisions",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("task_revisions").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "task_id", Value: 1}, {Key: "version", Value: -1}},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("task_revisions").Drop(ctx)
+		},
+	})
+}
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Indexes for exporting and deleting an account: the purge of users past
+// their deletion date, a user's invites, and the revisions of all a user's
+// tasks.
+func init() {
+	register(Migration{
+		Version:     6,
+		Description: "account deletion",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "deletion_scheduled_at", Value: 1}},
+				Options: options.Index().SetPartialFilterExpression(
+					bson.M{"deletion_scheduled_at": bson.M{"$exists": true}}),
+			})
+			if err != nil {
+				return err
+			}
+			_, err = db.Collection("invites").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "invited_by", Value: 1}},
+			})
+			if err != nil {
+				return err
+			}
+			_, err = db.Collection("task_revisions").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "task_id", Value: 1}, {Key: "version", Value: 1}},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			if _, err := db.Collection("users").Indexes().DropOne(ctx, "deletion_scheduled_at_1"); err != nil {
+				return err
+			}
+			if _, err := db.Collection("invites").Indexes().DropOne(ctx, "invited_by_1"); err != nil {
+				return err
+			}
+			_, err := db.Collection("task_revisions").Indexes().DropOne(ctx, "user_id_1_task_id_1_version_1")
+			return err
+		},
+	})
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A unique index on the single sign-on account users are linked to, so one
+// identity cannot log in to two users.
+func init() {
+	register(Migration{
+		Version:     7,
+		Description: "single sign-on",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "oidc_issuer", Value: 1}, {Key: "oidc_subject", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(
+					bson.M{"oidc_subject": bson.M{"$exists": true}}),
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").Indexes().DropOne(ctx, "oidc_issuer_1_oidc_subject_1")
+			return err
+		},
+	})
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Refresh tokens are found by their hash, revoked by family or user, and
+// removed by MongoDB once their expires_at has passed.
+func init() {
+	register(Migration{
+		Version:     8,
+		Description: "refresh tokens",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("refresh_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+				{
+					Keys:    bson.D{{Key: "token_hash", Value: 1}},
+					Options: options.Index().SetUnique(true),
+				},
+				{Keys: bson.D{{Key: "family_id", Value: 1}}},
+				{Keys: bson.D{{Key: "user_id", Value: 1}}},
+				{
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetExpireAfterSeconds(0),
+				},
+			})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("refresh_tokens").Drop(ctx)
+		},
+	})
+}
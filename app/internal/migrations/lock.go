@@ -0,0 +1,148 @@
+package migrations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	lockCollectionName = "migration_locks"
+	lockID             = "migrations"
+
+	// defaultLockTTL is how long a lock survives its holder. A crashed
+	// instance blocks migrations for at most this long; a running one
+	// renews its lock every third of it.
+	defaultLockTTL    = time.Minute
+	lockRetryInterval = time.Second
+)
+
+var (
+	// ErrLocked is returned when another instance holds the migration lock
+	// for longer than the configured lock timeout.
+	ErrLocked = errors.New("migrations are locked by another instance")
+	// ErrLockLost is returned when the lock could not be renewed, and may
+	// have been taken by another instance, while migrations were running.
+	// The migration that was running is not recorded as applied.
+	ErrLockLost = errors.New("lost the migration lock")
+)
+
+// lock acquires the cluster-wide migration lock, waiting up to lockTimeout
+// for the current holder. The lock is a single document whose expiry lets a
+// new holder take over after a crash; uniqueness of _id makes acquisition
+// atomic.
+//
+// The lock is renewed until unlock is called. If a renewal fails, before
+// the lock expires, the returned context is cancelled with ErrLockLost as
+// its cause, so a migration that outlives the lock cannot run alongside
+// another instance's.
+func (m *Migrator) lock(ctx context.Context) (context.Context, func(), error) {
+	owner, err := lockOwner()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	acquireCtx, cancel := context.WithTimeout(ctx, m.lockTimeout)
+	defer cancel()
+
+	var expires time.Time
+	for {
+		now := time.Now().UTC()
+		expires = now.Add(m.lockTTL)
+		_, err := m.locks.UpdateOne(acquireCtx,
+			bson.M{"_id": lockID, "expires_at": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": owner, "locked_at": now, "expires_at": expires}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, nil, err
+		}
+
+		select {
+		case <-acquireCtx.Done():
+			return nil, nil, ErrLocked
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	lockedCtx, lost := context.WithCancelCause(ctx)
+	stop := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		m.renewLock(lockedCtx, owner, expires, stop, lost)
+	}()
+
+	return lockedCtx, func() {
+		close(stop)
+		<-renewed
+		lost(nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		m.locks.DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner})
+	}, nil
+}
+
+// renewLock extends the lock held by owner every third of its TTL until
+// stop is closed. Failed attempts are retried until the lock would expire,
+// then lost is called.
+func (m *Migrator) renewLock(ctx context.Context, owner string, expires time.Time, stop <-chan struct{}, lost context.CancelCauseFunc) {
+	ticker := time.NewTicker(m.lockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().UTC()
+		if !now.Before(expires) {
+			lost(ErrLockLost)
+			return
+		}
+		renewCtx, cancel := context.WithDeadline(context.Background(), expires)
+		result, err := m.locks.UpdateOne(renewCtx,
+			bson.M{"_id": lockID, "owner": owner},
+			bson.M{"$set": bson.M{"expires_at": now.Add(m.lockTTL)}},
+		)
+		cancel()
+		switch {
+		case err != nil:
+			// Try again on the next tick, while the lock still holds.
+		case result.MatchedCount == 0:
+			// Another instance took over the lock.
+			lost(ErrLockLost)
+			return
+		default:
+			expires = now.Add(m.lockTTL)
+		}
+	}
+}
+
+func lockOwner() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,264 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CollectionName is the collection recording applied migrations, one
+// document per version.
+const CollectionName = "migrations"
+
+// Func applies or reverts one migration step against the application
+// database.
+type Func func(ctx context.Context, db *mongo.Database) error
+
+// Migration is a single versioned schema change. Versions are applied in
+// ascending order and must be unique; Down reverts exactly what Up did.
+type Migration struct {
+	Version     int
+	Description string
+	Up          Func
+	Down        Func
+}
+
+var registry = map[int]Migration{}
+
+// register adds a migration to the set compiled into the binary. It is
+// called from init functions in the numbered migration files.
+func register(m Migration) {
+	if _, exists := registry[m.Version]; exists {
+		panic(fmt.Sprintf("migrations: duplicate version %d", m.Version))
+	}
+	if m.Up == nil || m.Down == nil {
+		panic(fmt.Sprintf("migrations: version %d must define Up and Down", m.Version))
+	}
+	registry[m.Version] = m
+}
+
+// All returns every registered migration in version order.
+func All() []Migration {
+	all := make([]Migration, 0, len(registry))
+	for _, m := range registry {
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all
+}
+
+type record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Status describes one migration, known to the binary or found applied in
+// the database. AppliedAt is nil while the migration is pending.
+type Status struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+	// Unknown marks a migration recorded in the database that this binary
+	// does not contain, e.g. after rolling back to an older release.
+	Unknown bool
+}
+
+// Migrator applies migrations to a database. Up and Down hold a
+// cluster-wide lock so concurrent replicas never run migrations twice.
+type Migrator struct {
+	db          *mongo.Database
+	applied     *mongo.Collection
+	locks       *mongo.Collection
+	migrations  []Migration
+	lockTimeout time.Duration
+	lockTTL     time.Duration
+}
+
+// New creates a migrator for every registered migration. lockTimeout bounds
+// how long Up and Down wait for a lock held by another instance.
+func New(client *mongo.Client, dbName string, lockTimeout time.Duration) *Migrator {
+	db := client.Database(dbName)
+	return &Migrator{
+		db:          db,
+		applied:     db.Collection(CollectionName),
+		locks:       db.Collection(lockCollectionName),
+		migrations:  All(),
+		lockTimeout: lockTimeout,
+		lockTTL:     defaultLockTTL,
+	}
+}
+
+func (m *Migrator) appliedRecords(ctx context.Context) (map[int]record, error) {
+	cursor, err := m.applied.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// Status lists every known migration with its applied time, followed by
+// any applied migrations the binary does not know about.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.appliedRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		status := Status{Version: mig.Version, Description: mig.Description}
+		if r, ok := applied[mig.Version]; ok {
+			appliedAt := r.AppliedAt
+			status.AppliedAt = &appliedAt
+			delete(applied, mig.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, r := range applied {
+		appliedAt := r.AppliedAt
+		statuses = append(statuses, Status{
+			Version:     r.Version,
+			Description: r.Description,
+			AppliedAt:   &appliedAt,
+			Unknown:     true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// Pending returns the migrations not yet applied, in version order.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.appliedRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies pending migrations in order, at most steps of them when steps
+// is positive. It returns the migrations applied before any error.
+func (m *Migrator) Up(ctx context.Context, steps int) ([]Migration, error) {
+	ctx, unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Re-read under the lock: another instance may have just finished.
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if steps > 0 && steps < len(pending) {
+		pending = pending[:steps]
+	}
+
+	var done []Migration
+	for _, mig := range pending {
+		if err := mig.Up(ctx, m.db); err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", mig.Version, mig.Description, lockError(ctx, err))
+		}
+		// A migration that finished after the lock was lost may have run
+		// alongside another instance; leave it unrecorded so it is checked.
+		if err := context.Cause(ctx); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Description, err)
+		}
+		_, err := m.applied.InsertOne(ctx, record{
+			Version:     mig.Version,
+			Description: mig.Description,
+			AppliedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			return done, fmt.Errorf("failed to record migration %d: %w", mig.Version, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Down reverts the most recently applied migrations, steps of them (at
+// least one). It refuses to revert migrations this binary does not contain.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps < 1 {
+		steps = 1
+	}
+
+	ctx, unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := m.appliedRecords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	if steps < len(versions) {
+		versions = versions[:steps]
+	}
+
+	known := make(map[int]Migration, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = mig
+	}
+
+	var done []Migration
+	for _, v := range versions {
+		mig, ok := known[v]
+		if !ok {
+			return done, fmt.Errorf("migration %d is applied but unknown to this binary", v)
+		}
+		if err := mig.Down(ctx, m.db); err != nil {
+			return done, fmt.Errorf("reverting migration %d (%s) failed: %w", mig.Version, mig.Description, lockError(ctx, err))
+		}
+		if err := context.Cause(ctx); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Description, err)
+		}
+		if _, err := m.applied.DeleteOne(ctx, bson.M{"_id": mig.Version}); err != nil {
+			return done, fmt.Errorf("failed to unrecord migration %d: %w", mig.Version, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// lockError returns ErrLockLost in place of the cancellation error a
+// migration fails with when the lock is lost.
+func lockError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, ErrLockLost) {
+		return cause
+	}
+	return err
+}
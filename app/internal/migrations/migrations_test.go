@@ -0,0 +1,218 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestAllInVersionOrder(t *testing.T) {
+	all := All()
+	if len(all) == 0 {
+		t.Fatal("no migrations registered")
+	}
+	for i, m := range all {
+		if i > 0 && m.Version <= all[i-1].Version {
+			t.Errorf("migration %d follows %d", m.Version, all[i-1].Version)
+		}
+		if m.Description == "" {
+			t.Errorf("migration %d has no description", m.Version)
+		}
+	}
+}
+
+// testMigrator returns a migrator for migs on a fresh database, dropped
+// afterwards. Set MONGODB_TEST_URI to run the tests that need one.
+func testMigrator(t *testing.T, migs []Migration) (*Migrator, func(lockTimeout time.Duration) *Migrator) {
+	t.Helper()
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	dbName := "migrations_test_" + primitive.NewObjectID().Hex()
+	t.Cleanup(func() {
+		client.Database(dbName).Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	// Another instance on the same database, as a second replica would be.
+	instance := func(lockTimeout time.Duration) *Migrator {
+		m := New(client, dbName, lockTimeout)
+		m.migrations = migs
+		m.lockTTL = 300 * time.Millisecond
+		return m
+	}
+	return instance(time.Second), instance
+}
+
+// journal records the order migrations ran in.
+type journal struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (j *journal) migration(version int) Migration {
+	record := func(direction string) Func {
+		return func(ctx context.Context, db *mongo.Database) error {
+			j.mu.Lock()
+			defer j.mu.Unlock()
+			j.entries = append(j.entries, fmt.Sprintf("%s %d", direction, version))
+			return nil
+		}
+	}
+	return Migration{Version: version, Description: fmt.Sprintf("step %d", version), Up: record("up"), Down: record("down")}
+}
+
+func (j *journal) list() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return slices.Clone(j.entries)
+}
+
+func versions(migs []Migration) []int {
+	var vs []int
+	for _, m := range migs {
+		vs = append(vs, m.Version)
+	}
+	return vs
+}
+
+func TestUpAndDown(t *testing.T) {
+	var j journal
+	m, _ := testMigrator(t, []Migration{j.migration(1), j.migration(2), j.migration(3)})
+	ctx := context.Background()
+
+	done, err := m.Up(ctx, 2)
+	if err != nil || !slices.Equal(versions(done), []int{1, 2}) {
+		t.Fatalf("Up(2) = %v, %v", versions(done), err)
+	}
+	// Applied migrations are skipped.
+	done, err = m.Up(ctx, 0)
+	if err != nil || !slices.Equal(versions(done), []int{3}) {
+		t.Fatalf("Up = %v, %v", versions(done), err)
+	}
+	if done, err := m.Up(ctx, 0); err != nil || len(done) != 0 {
+		t.Fatalf("Up with nothing pending = %v, %v", versions(done), err)
+	}
+
+	done, err = m.Down(ctx, 2)
+	if err != nil || !slices.Equal(versions(done), []int{3, 2}) {
+		t.Fatalf("Down(2) = %v, %v", versions(done), err)
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil || !slices.Equal(versions(pending), []int{2, 3}) {
+		t.Fatalf("Pending = %v, %v", versions(pending), err)
+	}
+
+	want := []string{"up 1", "up 2", "up 3", "down 3", "down 2"}
+	if got := j.list(); !slices.Equal(got, want) {
+		t.Errorf("ran %v, want %v", got, want)
+	}
+
+	// A version applied by a newer release is listed but not reverted.
+	if _, err := m.applied.InsertOne(ctx, record{Version: 9, Description: "newer", AppliedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil || len(statuses) != 4 || !statuses[3].Unknown || statuses[1].AppliedAt != nil {
+		t.Fatalf("Status = %+v, %v", statuses, err)
+	}
+	if _, err := m.Down(ctx, 1); err == nil {
+		t.Error("Down reverted a migration unknown to the binary")
+	}
+}
+
+func TestLockIsRenewedWhileMigrating(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var j journal
+	slow := j.migration(1)
+	slow.Up = func(ctx context.Context, db *mongo.Database) error {
+		close(started)
+		<-release
+		return nil
+	}
+	m, instance := testMigrator(t, []Migration{slow})
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := m.Up(context.Background(), 0)
+		result <- err
+	}()
+	<-started
+
+	// Long after the lock's TTL, another instance still cannot take it.
+	time.Sleep(3 * m.lockTTL)
+	other := instance(200 * time.Millisecond)
+	if _, err := other.Up(context.Background(), 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("second instance: Up = %v, want ErrLocked", err)
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Fatalf("Up = %v", err)
+	}
+	// Once the lock is released, the other instance finds nothing to do.
+	other = instance(time.Second)
+	if done, err := other.Up(context.Background(), 0); err != nil || len(done) != 0 {
+		t.Errorf("second instance after release: Up = %v, %v", versions(done), err)
+	}
+}
+
+func TestLostLockStopsMigrating(t *testing.T) {
+	started := make(chan struct{})
+	var j journal
+	stuck := j.migration(1)
+	stuck.Up = func(ctx context.Context, db *mongo.Database) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	m, _ := testMigrator(t, []Migration{stuck, j.migration(2)})
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := m.Up(context.Background(), 0)
+		result <- err
+	}()
+	<-started
+
+	// Another instance takes over, as it could after the lock expired.
+	_, err := m.locks.UpdateOne(context.Background(), bson.M{"_id": lockID},
+		bson.M{"$set": bson.M{"owner": "other", "expires_at": time.Now().Add(time.Hour)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrLockLost) {
+			t.Fatalf("Up = %v, want ErrLockLost", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Up kept running after losing the lock")
+	}
+	pending, err := m.Pending(context.Background())
+	if err != nil || !slices.Equal(versions(pending), []int{1, 2}) {
+		t.Errorf("Pending = %v, %v; the interrupted migration must stay pending", versions(pending), err)
+	}
+	if got := j.list(); len(got) != 0 {
+		t.Errorf("ran %v after losing the lock", got)
+	}
+}
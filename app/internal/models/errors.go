@@ -0,0 +1,81 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// ValidationError reports an invalid field value. Message is suitable for
+// showing to the user.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors collects every invalid field found while validating a
+// value, in the order they were found.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add records an error for field.
+func (e *ValidationErrors) Add(field, message string) {
+	*e = append(*e, &ValidationError{Field: field, Message: message})
+}
+
+// Merge appends the validation errors contained in err and reports whether
+// err consisted only of validation errors. A nil err merges nothing and
+// returns true.
+func (e *ValidationErrors) Merge(err error) bool {
+	if err == nil {
+		return true
+	}
+	errs, ok := AsValidationErrors(err)
+	if !ok {
+		return false
+	}
+	*e = append(*e, errs...)
+	return true
+}
+
+// For returns the first message recorded for field, or "".
+func (e ValidationErrors) For(field string) string {
+	for _, err := range e {
+		if err.Field == field {
+			return err.Message
+		}
+	}
+	return ""
+}
+
+// Err returns e as an error, or nil when there are no errors.
+func (e ValidationErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// AsValidationErrors extracts the field errors from err, which may be a
+// single *ValidationError or a ValidationErrors collection.
+func AsValidationErrors(err error) (ValidationErrors, bool) {
+	var errs ValidationErrors
+	if errors.As(err, &errs) {
+		return errs, true
+	}
+	var single *ValidationError
+	if errors.As(err, &single) {
+		return ValidationErrors{single}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key. Keys are scoped to the user who sent them. Status is
+// zero while the first request is still being handled.
+type IdempotencyRecord struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	UserID primitive.ObjectID `bson:"user_id"`
+	Key    string             `bson:"key"`
+	// Fingerprint identifies the request the key was first used with, so
+	// reuse with a different payload can be refused.
+	Fingerprint string      `bson:"fingerprint"`
+	Status      int         `bson:"status"`
+	Header      http.Header `bson:"header,omitempty"`
+	Body        []byte      `bson:"body,omitempty"`
+	CreatedAt   time.Time   `bson:"created_at"`
+	// ExpiresAt backs a TTL index; expired records are treated as absent
+	// even before MongoDB removes them.
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Completed reports whether the response has been stored.
+func (r *IdempotencyRecord) Completed() bool {
+	return r.Status != 0
+}
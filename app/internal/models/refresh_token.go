@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken renews a session once its access token has expired. Each
+// renewal uses up the token and issues the next one in the same family, so
+// a used token presented again means it was copied, and the family is
+// revoked. Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	FamilyID  primitive.ObjectID `bson:"family_id"`
+	TokenHash string             `bson:"token_hash"`
+	// Persistent sessions, from logins with "remember me", keep their
+	// cookies when the browser is closed.
+	Persistent bool       `bson:"persistent"`
+	CreatedAt  time.Time  `bson:"created_at"`
+	UsedAt     *time.Time `bson:"used_at,omitempty"`
+	// ExpiresAt backs a TTL index; expired tokens are treated as absent
+	// even before MongoDB removes them.
+	ExpiresAt time.Time `bson:"expires_at"`
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TaskRevision records one update of a task: the editable fields it
+// changed, with their values before and after. Version is the task version
+// the update produced.
+type TaskRevision struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TaskID primitive.ObjectID `json:"task_id" bson:"task_id"`
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+	// EditorID is the user who made the change. It is unset for updates
+	// made outside a user's request.
+	EditorID  primitive.ObjectID `json:"editor_id,omitzero" bson:"editor_id,omitempty"`
+	Version   int64              `json:"version" bson:"version"`
+	Changes   []FieldChange      `json:"changes" bson:"changes"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// FieldChange is the change to one field. Values are the field's JSON
+// value as a string; due dates are RFC 3339 and nil when unset.
+type FieldChange struct {
+	Field string  `json:"field" bson:"field"`
+	Old   *string `json:"old" bson:"old"`
+	New   *string `json:"new" bson:"new"`
+}
+
+// NewTaskRevision returns the revision for updating before to after, or
+// nil if no editable field changed.
+func NewTaskRevision(before, after *Task, editorID primitive.ObjectID) *TaskRevision {
+	var changes []FieldChange
+	diff := func(field string, old, new *string) {
+		if (old == nil) != (new == nil) || (old != nil && *old != *new) {
+			changes = append(changes, FieldChange{Field: field, Old: old, New: new})
+		}
+	}
+	diff("title", stringPtr(before.Title), stringPtr(after.Title))
+	diff("description", stringPtr(before.Description), stringPtr(after.Description))
+	diff("status", stringPtr(before.Status), stringPtr(after.Status))
+	diff("due_date", formatDueDate(before.DueDate), formatDueDate(after.DueDate))
+	if len(changes) == 0 {
+		return nil
+	}
+	return &TaskRevision{
+		TaskID:    before.ID,
+		UserID:    before.UserID,
+		EditorID:  editorID,
+		Version:   after.Version,
+		Changes:   changes,
+		CreatedAt: after.UpdatedAt,
+	}
+}
+
+// Undo sets the fields the revision changed back to their old values.
+func (r *TaskRevision) Undo(t *Task) error {
+	for _, change := range r.Changes {
+		switch change.Field {
+		case "title":
+			t.Title = deref(change.Old)
+		case "description":
+			t.Description = deref(change.Old)
+		case "status":
+			t.Status = deref(change.Old)
+		case "due_date":
+			t.DueDate = nil
+			if change.Old != nil {
+				due, err := time.Parse(time.RFC3339Nano, *change.Old)
+				if err != nil {
+					return err
+				}
+				t.DueDate = &due
+			}
+		}
+	}
+	return nil
+}
+
+// TaskAtRevision returns task as it was right after revision version,
+// undoing the later revisions in revisions, which must be newest first.
+func TaskAtRevision(task Task, revisions []TaskRevision, version int64) (Task, error) {
+	for i := range revisions {
+		if revisions[i].Version <= version {
+			break
+		}
+		if err := revisions[i].Undo(&task); err != nil {
+			return Task{}, err
+		}
+	}
+	return task, nil
+}
+
+func formatDueDate(due *time.Time) *string {
+	if due == nil {
+		return nil
+	}
+	return stringPtr(due.UTC().Format(time.RFC3339Nano))
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
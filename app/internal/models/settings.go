@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Settings are the app-wide settings admins change while the app runs, as
+// opposed to the configuration, which operators set at startup.
+type Settings struct {
+	// RequireTwoFactorForAdmins makes every admin set up two-factor
+	// authentication before they can log in.
+	RequireTwoFactorForAdmins bool      `json:"require_two_factor_for_admins" bson:"require_two_factor_for_admins"`
+	UpdatedAt                 time.Time `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// SigningKey signs and verifies session tokens, which name it by ID in
+// their kid header. The newest key whose ActivatesAt has passed signs new
+// tokens, and every stored key verifies them, so a key can be published
+// before it is used and kept after it is replaced.
+type SigningKey struct {
+	ID        string `bson:"_id"`
+	Algorithm string `bson:"algorithm"`
+	// PrivateKey is a PKCS #8 DER private key, or the secret for HS256.
+	// If Sealed, it is encrypted with AES-GCM under jwt.key_encryption_key.
+	PrivateKey  []byte    `bson:"private_key"`
+	Sealed      bool      `bson:"sealed,omitempty"`
+	CreatedAt   time.Time `bson:"created_at"`
+	ActivatesAt time.Time `bson:"activates_at"`
+}
@@ -1,21 +1,29 @@
 package models
 
 import (
-	"errors"
+	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Task struct {
-	ID          primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
-	UserID      primitive.ObjectID  `json:"user_id" bson:"user_id"`
-	Title       string              `json:"title" bson:"title"`
-	Description string              `json:"description" bson:"description"`
-	Status      string              `json:"status" bson:"status"`
-	DueDate     *time.Time          `json:"due_date,omitempty" bson:"due_date,omitempty"`
-	CreatedAt   time.Time           `json:"created_at" bson:"created_at"`
-	UpdatedAt   time.Time           `json:"updated_at" bson:"updated_at"`
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Title       string             `json:"title" bson:"title"`
+	Description string             `json:"description" bson:"description"`
+	Status      string             `json:"status" bson:"status"`
+	DueDate     *time.Time         `json:"due_date,omitempty" bson:"due_date,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	// Version starts at 1 and is incremented by every update. It backs the
+	// API's ETags and detects concurrent edits.
+	Version int64 `json:"version" bson:"version"`
+	// DeletedAt is set while the task is in the trash. Trashed tasks are
+	// hidden from every read except the trash listing.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
 }
 
 const (
@@ -24,15 +32,93 @@ const (
 	StatusCompleted  = "completed"
 )
 
+const (
+	MaxTitleLength       = 200
+	MaxDescriptionLength = 5000
+)
+
+// Validate checks every field and returns ValidationErrors listing all
+// problems found, or nil.
 func (t *Task) Validate() error {
-	if t.Title == "" {
-		return errors.New("title is required")
-	}
+	var errs ValidationErrors
 	if t.Status == "" {
 		t.Status = StatusPending
 	}
-	if t.Status != StatusPending && t.Status != StatusInProgress && t.Status != StatusCompleted {
-		return errors.New("status must be pending, in_progress, or completed")
+	validateTitle(&errs, t.Title)
+	validateDescription(&errs, t.Description)
+	validateStatus(&errs, t.Status)
+	return errs.Err()
+}
+
+// TaskPatch is a partial update of a task's editable fields. Nil fields are
+// left unchanged; ClearDueDate removes the due date.
+type TaskPatch struct {
+	Title        *string
+	Description  *string
+	Status       *string
+	DueDate      *time.Time
+	ClearDueDate bool
+}
+
+// Validate checks the fields that are set, with the same rules as
+// Task.Validate. An empty status becomes pending.
+func (p *TaskPatch) Validate() error {
+	var errs ValidationErrors
+	if p.Title != nil {
+		validateTitle(&errs, *p.Title)
+	}
+	if p.Description != nil {
+		validateDescription(&errs, *p.Description)
+	}
+	if p.Status != nil {
+		if *p.Status == "" {
+			pending := StatusPending
+			p.Status = &pending
+		}
+		validateStatus(&errs, *p.Status)
+	}
+	if p.DueDate != nil && p.ClearDueDate {
+		errs.Add("due_date", "due_date cannot be both set and cleared")
+	}
+	return errs.Err()
+}
+
+// Apply writes the patched fields to t.
+func (p *TaskPatch) Apply(t *Task) {
+	if p.Title != nil {
+		t.Title = *p.Title
+	}
+	if p.Description != nil {
+		t.Description = *p.Description
+	}
+	if p.Status != nil {
+		t.Status = *p.Status
+	}
+	if p.DueDate != nil {
+		due := *p.DueDate
+		t.DueDate = &due
+	}
+	if p.ClearDueDate {
+		t.DueDate = nil
+	}
+}
+
+func validateTitle(errs *ValidationErrors, title string) {
+	if strings.TrimSpace(title) == "" {
+		errs.Add("title", "title is required")
+	} else if utf8.RuneCountInString(title) > MaxTitleLength {
+		errs.Add("title", fmt.Sprintf("title must be at most %d characters", MaxTitleLength))
+	}
+}
+
+func validateDescription(errs *ValidationErrors, description string) {
+	if utf8.RuneCountInString(description) > MaxDescriptionLength {
+		errs.Add("description", fmt.Sprintf("description must be at most %d characters", MaxDescriptionLength))
+	}
+}
+
+func validateStatus(errs *ValidationErrors, status string) {
+	if status != StatusPending && status != StatusInProgress && status != StatusCompleted {
+		errs.Add("status", "status must be pending, in_progress, or completed")
 	}
-	return nil
 }
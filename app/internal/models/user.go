@@ -1,8 +1,8 @@
 package models
 
 import (
-	"errors"
 	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,6 +16,28 @@ type User struct {
 	Role         string             `json:"role" bson:"role"`
 	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+	// DeletionScheduledAt is when the account and all its data will be
+	// deleted. It is set during the grace period after a deletion request.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty" bson:"deletion_scheduled_at,omitempty"`
+
+	// TwoFactorEnabled is set once the user has confirmed a TOTP code
+	// from TOTPSecret, which is encrypted and may hold an unconfirmed
+	// secret while the user is enrolling.
+	TwoFactorEnabled bool   `json:"two_factor_enabled" bson:"two_factor_enabled"`
+	TOTPSecret       string `json:"-" bson:"totp_secret,omitempty"`
+	// TOTPLastStep is the time step of the last accepted code, so no code
+	// is accepted twice.
+	TOTPLastStep int64 `json:"-" bson:"totp_last_step,omitempty"`
+	// RecoveryCodes holds SHA-256 hashes of the unused recovery codes.
+	RecoveryCodes []string `json:"-" bson:"recovery_codes,omitempty"`
+	// TwoFactorAttempts holds the times of the latest second login steps
+	// since the last successful one, oldest first, to limit guessing.
+	TwoFactorAttempts []time.Time `json:"-" bson:"two_factor_attempts,omitempty"`
+
+	// OIDCIssuer and OIDCSubject identify the single sign-on account the
+	// user is linked to. Users created by single sign-on have no password.
+	OIDCIssuer  string `json:"-" bson:"oidc_issuer,omitempty"`
+	OIDCSubject string `json:"-" bson:"oidc_subject,omitempty"`
 }
 
 const (
@@ -23,30 +45,43 @@ const (
 	RoleUser  = "user"
 )
 
+// DeletionPending reports whether the account is scheduled for deletion
+// and can still be restored.
+func (u *User) DeletionPending() bool {
+	return u.DeletionScheduledAt != nil && u.DeletionScheduledAt.After(time.Now())
+}
+
+// DeletionDue reports whether the account is past its deletion date. Such
+// an account is as good as deleted, even if the purge has not run yet.
+func (u *User) DeletionDue() bool {
+	return u.DeletionDueAt(time.Now())
+}
+
+// DeletionDueAt reports whether the account is past its deletion date as of
+// now.
+func (u *User) DeletionDueAt(now time.Time) bool {
+	return u.DeletionScheduledAt != nil && !u.DeletionScheduledAt.After(now)
+}
+
 var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 
+// Validate checks every field and returns ValidationErrors listing all
+// problems found, or nil.
 func (u *User) Validate() error {
+	var errs ValidationErrors
 	if u.Email == "" {
-		return errors.New("email is required")
-	}
-	if !emailRegex.MatchString(u.Email) {
-		return errors.New("invalid email format")
+		errs.Add("email", "email is required")
+	} else if !emailRegex.MatchString(u.Email) {
+		errs.Add("email", "invalid email format")
 	}
-	if u.Name == "" {
-		return errors.New("name is required")
+	if strings.TrimSpace(u.Name) == "" {
+		errs.Add("name", "name is required")
 	}
 	if u.Role == "" {
 		u.Role = RoleUser
 	}
 	if u.Role != RoleAdmin && u.Role != RoleUser {
-		return errors.New("role must be admin or user")
-	}
-	return nil
-}
-
-func ValidatePassword(password string) error {
-	if len(password) < 8 {
-		return errors.New("password must be at least 8 characters")
+		errs.Add("role", "role must be admin or user")
 	}
-	return nil
+	return errs.Err()
 }
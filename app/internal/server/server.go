@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/health"
+)
+
+// Worker is a background job that runs until its context is cancelled.
+type Worker func(ctx context.Context)
+
+type httpServer struct {
+	name     string
+	server   *http.Server
+	listener net.Listener
+}
+
+type worker struct {
+	name string
+	run  Worker
+}
+
+type closer struct {
+	name  string
+	close func(ctx context.Context) error
+}
+
+// Server owns the process lifecycle: HTTP listeners, background workers and
+// the resources they depend on. Run serves until its context is cancelled
+// and then shuts down in order:
+//
+//  1. mark the readiness probe as draining
+//  2. wait DrainPeriod so the load balancer stops sending traffic
+//  3. gracefully shut down every HTTP server, letting in-flight requests finish
+//  4. cancel background workers and wait for them to return
+//  5. run closers (e.g. disconnect MongoDB) in registration order
+type Server struct {
+	logger          *slog.Logger
+	health          *health.Checker
+	drainPeriod     time.Duration
+	shutdownTimeout time.Duration
+
+	servers []httpServer
+	workers []worker
+	closers []closer
+}
+
+func New(logger *slog.Logger, checker *health.Checker, drainPeriod, shutdownTimeout time.Duration) *Server {
+	return &Server{
+		logger:          logger,
+		health:          checker,
+		drainPeriod:     drainPeriod,
+		shutdownTimeout: shutdownTimeout,
+	}
+}
+
+// AddHTTPServer registers an HTTP server. When listener is nil, srv.Addr is
+// bound when Run starts.
+func (s *Server) AddHTTPServer(name string, srv *http.Server, listener net.Listener) {
+	s.servers = append(s.servers, httpServer{name: name, server: srv, listener: listener})
+}
+
+func (s *Server) AddWorker(name string, run Worker) {
+	s.workers = append(s.workers, worker{name: name, run: run})
+}
+
+// AddCloser registers a function run after all servers and workers have
+// stopped. Closers run in the order they were added.
+func (s *Server) AddCloser(name string, close func(ctx context.Context) error) {
+	s.closers = append(s.closers, closer{name: name, close: close})
+}
+
+// Run starts all servers and workers, blocks until ctx is cancelled or a
+// server fails, then performs the shutdown sequence.
+func (s *Server) Run(ctx context.Context) error {
+	for i := range s.servers {
+		srv := &s.servers[i]
+		if srv.listener != nil {
+			continue
+		}
+		ln, err := net.Listen("tcp", srv.server.Addr)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		srv.listener = ln
+	}
+
+	serveErr := make(chan error, len(s.servers))
+	for _, srv := range s.servers {
+		go func(srv httpServer) {
+			s.logger.Info("server starting", "server", srv.name, "addr", srv.listener.Addr().String())
+			if err := srv.server.Serve(srv.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}(srv)
+	}
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	var workers sync.WaitGroup
+	for _, w := range s.workers {
+		workers.Add(1)
+		go func(w worker) {
+			defer workers.Done()
+			s.logger.Info("worker starting", "worker", w.name)
+			w.run(workerCtx)
+			s.logger.Info("worker stopped", "worker", w.name)
+		}(w)
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		s.logger.Info("shutdown requested")
+	case runErr = <-serveErr:
+		s.logger.Error("server failed", "error", runErr)
+	}
+
+	s.health.StartDraining()
+	if runErr == nil && s.drainPeriod > 0 {
+		s.logger.Info("draining", "period", s.drainPeriod.String())
+		time.Sleep(s.drainPeriod)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	var servers sync.WaitGroup
+	for _, srv := range s.servers {
+		servers.Add(1)
+		go func(srv httpServer) {
+			defer servers.Done()
+			if err := srv.server.Shutdown(shutdownCtx); err != nil {
+				s.logger.Error("server forced to shutdown", "server", srv.name, "error", err)
+			}
+		}(srv)
+	}
+	servers.Wait()
+	s.logger.Info("http servers stopped")
+
+	stopWorkers()
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		s.logger.Error("timed out waiting for workers to stop")
+	}
+
+	// Closers get their own budget so a slow HTTP shutdown cannot leave
+	// MongoDB without time to disconnect cleanly.
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancelClose()
+	for _, c := range s.closers {
+		if err := c.close(closeCtx); err != nil {
+			s.logger.Error("failed to close", "resource", c.name, "error", err)
+		}
+	}
+
+	s.logger.Info("server exited")
+	return runErr
+}
+
+func (s *Server) closeListeners() {
+	for _, srv := range s.servers {
+		if srv.listener != nil {
+			srv.listener.Close()
+		}
+	}
+}
+
+// SignalContext returns a context cancelled on SIGINT or SIGTERM. Container
+// Apps and Docker send SIGTERM before killing the container. Only the first
+// signal is caught: after it the default behaviour is restored, so a second
+// one, such as another Ctrl-C, kills the process instead of waiting for the
+// shutdown sequence to finish.
+func SignalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
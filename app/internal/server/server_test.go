@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/health"
+)
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *eventLog) list() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+func TestShutdownSequence(t *testing.T) {
+	const drainPeriod = 200 * time.Millisecond
+
+	var log eventLog
+	checker := health.NewChecker(time.Second)
+
+	requestStarted := make(chan struct{})
+	releaseRequest := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(requestStarted)
+		<-releaseRequest
+		log.add("request finished")
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", checker.ReadinessHandler)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseURL := "http://" + ln.Addr().String()
+
+	srv := New(slog.New(slog.NewTextHandler(io.Discard, nil)), checker, drainPeriod, 5*time.Second)
+	srv.AddHTTPServer("http", &http.Server{Handler: mux}, ln)
+	srv.AddWorker("worker", func(ctx context.Context) {
+		<-ctx.Done()
+		log.add("worker stopped")
+	})
+	srv.AddCloser("mongo", func(ctx context.Context) error {
+		log.add("mongo disconnected")
+		return nil
+	})
+
+	ctx, stop := SignalContext(context.Background())
+	defer stop()
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- srv.Run(ctx) }()
+
+	waitFor(t, func() bool { return get(baseURL+"/readyz") == http.StatusOK })
+
+	slowStatus := make(chan int, 1)
+	go func() { slowStatus <- get(baseURL + "/slow") }()
+	<-requestStarted
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, checker.Draining)
+	log.add("draining")
+
+	// During the drain period the instance reports unready but still
+	// serves new requests that were routed before the probe failed.
+	if code := get(baseURL + "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz while draining = %d, want 503", code)
+	}
+	if code := get(baseURL + "/fast"); code != http.StatusOK {
+		t.Errorf("request during drain period = %d, want 200", code)
+	}
+
+	time.Sleep(drainPeriod + 100*time.Millisecond)
+	if got := log.list(); len(got) != 1 {
+		t.Fatalf("shutdown progressed past the in-flight request: %v", got)
+	}
+
+	close(releaseRequest)
+	if code := <-slowStatus; code != http.StatusOK {
+		t.Errorf("in-flight request = %d, want 200", code)
+	}
+
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("Run returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after shutdown")
+	}
+
+	want := []string{"draining", "request finished", "worker stopped", "mongo disconnected"}
+	got := log.list()
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	}
+
+	if code := get(baseURL + "/fast"); code != 0 {
+		t.Errorf("server still accepting connections after shutdown (status %d)", code)
+	}
+}
+
+func TestRunReturnsListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	closed := false
+	srv := New(slog.New(slog.NewTextHandler(io.Discard, nil)), health.NewChecker(time.Second), 0, time.Second)
+	srv.AddHTTPServer("http", &http.Server{Addr: ln.Addr().String()}, nil)
+	srv.AddCloser("mongo", func(ctx context.Context) error {
+		closed = true
+		return nil
+	})
+
+	if err := srv.Run(context.Background()); err == nil {
+		t.Fatal("expected an error binding an address in use")
+	}
+	if closed {
+		t.Error("closers ran although the server never started")
+	}
+}
+
+// client opens a connection per request. A pooled transport may dial spare
+// connections that never send a request, and Shutdown waits several seconds
+// before closing those.
+var client = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+// get returns the response status, or 0 when the request fails.
+func get(url string) int {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestSecondSignalKills runs a process that takes its time shutting down
+// and checks that a second SIGTERM ends it without waiting.
+func TestSecondSignalKills(t *testing.T) {
+	if os.Getenv("SERVER_TEST_SLOW_SHUTDOWN") == "1" {
+		ctx, stop := SignalContext(context.Background())
+		defer stop()
+		fmt.Println("ready")
+		<-ctx.Done()
+		fmt.Println("shutting down")
+		time.Sleep(time.Minute)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSecondSignalKills$")
+	cmd.Env = append(os.Environ(), "SERVER_TEST_SLOW_SHUTDOWN=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	lines := bufio.NewScanner(stdout)
+	waitLine := func(want string) {
+		t.Helper()
+		for lines.Scan() {
+			if lines.Text() == want {
+				return
+			}
+		}
+		t.Fatalf("child never printed %q", want)
+	}
+
+	waitLine("ready")
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	waitLine("shutting down")
+	// The handler is reset just after the context is cancelled.
+	time.Sleep(100 * time.Millisecond)
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGTERM {
+			t.Errorf("child exited with %v, want killed by SIGTERM", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second SIGTERM did not end the process")
+	}
+}
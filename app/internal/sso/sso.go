@@ -0,0 +1,334 @@
+// Package sso implements single sign-on with an OpenID Connect provider:
+// the authorization code flow with PKCE, and finding, linking or creating
+// the user an ID token identifies.
+package sso
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/oauth2"
+)
+
+// StateExpiry is how long a login may take at the identity provider.
+const StateExpiry = 10 * time.Minute
+
+var (
+	// ErrInvalidState is returned when the callback does not belong to a
+	// login started by this browser, or the login took too long.
+	ErrInvalidState = errors.New("invalid or expired single sign-on state")
+	// ErrDenied is returned when the identity provider reports an error,
+	// for example because the user cancelled.
+	ErrDenied = errors.New("single sign-on was denied")
+	// ErrInvalidToken is returned when the code cannot be exchanged or the
+	// ID token fails validation.
+	ErrInvalidToken = errors.New("invalid ID token")
+	// ErrEmailNotVerified is returned for an identity that is not linked
+	// yet and whose email the identity provider has not verified.
+	ErrEmailNotVerified = errors.New("email not verified by the identity provider")
+	// ErrNotProvisioned is returned for an identity with no matching user
+	// and an email outside the allowed domains.
+	ErrNotProvisioned = errors.New("no account for this identity")
+	// ErrNotReauthenticated is returned when a reauthentication did not
+	// log the same user in again at the identity provider.
+	ErrNotReauthenticated = errors.New("single sign-on did not confirm the user")
+)
+
+// Config describes the identity provider and the client registered with
+// it. Discovery is done at IssuerURL. Users whose verified email is in one
+// of AllowedDomains get an account on their first login; with none, only
+// existing users can log in.
+type Config struct {
+	IssuerURL      string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string
+	AllowedDomains []string
+	// ButtonLabel is the text of the login page's single sign-on button.
+	ButtonLabel string
+}
+
+// Service runs single sign-on logins. The provider's discovery document is
+// fetched on the first login rather than at startup, so the app starts
+// while the identity provider is unreachable.
+type Service struct {
+	cfg      Config
+	users    database.UserStore
+	stateKey []byte
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// NewService returns a Service. secret signs the state kept in the browser
+// during a login, under a key derived from it.
+func NewService(users database.UserStore, cfg Config, secret string) *Service {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("login-sso"))
+	return &Service{cfg: cfg, users: users, stateKey: mac.Sum(nil)}
+}
+
+// ButtonLabel returns the text of the login page's single sign-on button,
+// or "" when s is nil and there is no button.
+func (s *Service) ButtonLabel() string {
+	if s == nil {
+		return ""
+	}
+	return s.cfg.ButtonLabel
+}
+
+// Start begins a login. It returns the identity provider URL to send the
+// browser to, and the state the browser has to bring back to Finish.
+func (s *Service) Start(ctx context.Context) (authURL, state string, err error) {
+	return s.start(ctx, stateClaims{})
+}
+
+// StartReauth begins confirming that the logged-in user is still at the
+// keyboard, for users without a password. The identity provider is asked
+// to log them in again rather than reuse its own session. The browser is
+// to be sent back to next afterwards, and has to bring the state back to
+// FinishReauth.
+func (s *Service) StartReauth(ctx context.Context, userID primitive.ObjectID, next string) (authURL, state string, err error) {
+	return s.start(ctx, stateClaims{UserID: userID.Hex(), Next: next},
+		oauth2.SetAuthURLParam("prompt", "login"), oauth2.SetAuthURLParam("max_age", "0"))
+}
+
+func (s *Service) start(ctx context.Context, claims stateClaims, opts ...oauth2.AuthCodeOption) (authURL, state string, err error) {
+	_, oauth, err := s.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	claims.State = rand.Text()
+	claims.Nonce = rand.Text()
+	claims.Verifier = oauth2.GenerateVerifier()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(StateExpiry)),
+	}
+	state, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.stateKey)
+	if err != nil {
+		return "", "", err
+	}
+	opts = append(opts, oidc.Nonce(claims.Nonce), oauth2.S256ChallengeOption(claims.Verifier))
+	authURL = oauth.AuthCodeURL(claims.State, opts...)
+	return authURL, state, nil
+}
+
+// Reauthenticating reports whether state belongs to a reauthentication
+// rather than a login, and so goes to FinishReauth.
+func (s *Service) Reauthenticating(state string) bool {
+	claims, err := s.parseState(state)
+	return err == nil && claims.UserID != ""
+}
+
+// Finish completes a login from the query of the identity provider's
+// redirect and the state returned by Start, and returns the user.
+//
+// A user already linked to the identity is returned as is. Otherwise the
+// identity is linked to the user with its email, if the email is verified,
+// or a user is created if the email's domain is allowed.
+func (s *Service) Finish(ctx context.Context, state string, query url.Values) (*models.User, error) {
+	claims, err := s.parseState(state)
+	if err != nil || claims.UserID != "" {
+		return nil, ErrInvalidState
+	}
+	idToken, err := s.exchange(ctx, claims, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var identity struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&identity); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return s.user(ctx, idToken.Issuer, idToken.Subject, identity.Email, identity.EmailVerified, identity.Name)
+}
+
+// FinishReauth completes a reauthentication from the query of the identity
+// provider's redirect and the state returned by StartReauth. It returns the
+// user, and where StartReauth was asked to send them next, if the identity
+// provider logged in the user it was started for, after it was started.
+func (s *Service) FinishReauth(ctx context.Context, state string, query url.Values) (user *models.User, next string, err error) {
+	claims, err := s.parseState(state)
+	if err != nil || claims.UserID == "" {
+		return nil, "", ErrInvalidState
+	}
+	idToken, err := s.exchange(ctx, claims, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Providers that ignore max_age may answer from an earlier login;
+	// auth_time tells. A minute of clock skew is allowed.
+	var login struct {
+		AuthTime int64 `json:"auth_time"`
+	}
+	if err := idToken.Claims(&login); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if login.AuthTime == 0 || time.Unix(login.AuthTime, 0).Before(claims.IssuedAt.Add(-time.Minute)) {
+		return nil, "", fmt.Errorf("%w: no login since the reauthentication started", ErrNotReauthenticated)
+	}
+
+	user, err = s.users.FindByOIDCSubject(ctx, idToken.Issuer, idToken.Subject)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && user.ID.Hex() != claims.UserID) {
+		return nil, "", fmt.Errorf("%w: a different identity logged in", ErrNotReauthenticated)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return user, claims.Next, nil
+}
+
+func (s *Service) parseState(state string) (*stateClaims, error) {
+	var claims stateClaims
+	_, err := jwt.ParseWithClaims(state, &claims, func(*jwt.Token) (interface{}, error) {
+		return s.stateKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// exchange checks the identity provider's redirect against the state,
+// exchanges the code and returns the verified ID token.
+func (s *Service) exchange(ctx context.Context, claims *stateClaims, query url.Values) (*oidc.IDToken, error) {
+	provider, oauth, err := s.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if query.Get("state") != claims.State {
+		return nil, ErrInvalidState
+	}
+	if e := query.Get("error"); e != "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrDenied, e, query.Get("error_description"))
+	}
+
+	token, err := oauth.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(claims.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("%w: exchange code: %v", ErrInvalidToken, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: no id_token in token response", ErrInvalidToken)
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: s.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if idToken.Nonce != claims.Nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	return idToken, nil
+}
+
+// user finds, links or creates the user for an identity.
+func (s *Service) user(ctx context.Context, issuer, subject, email string, emailVerified bool, name string) (*models.User, error) {
+	user, err := s.users.FindByOIDCSubject(ctx, issuer, subject)
+	if !errors.Is(err, database.ErrNotFound) {
+		return user, err
+	}
+	// An unverified email could belong to anyone, so it must not grant
+	// access to the account registered with it.
+	if email == "" || !emailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	user, err = s.users.FindByEmail(ctx, email)
+	if err == nil {
+		if err := s.users.LinkOIDC(ctx, user.ID, issuer, subject); err != nil {
+			return nil, err
+		}
+		user.OIDCIssuer, user.OIDCSubject = issuer, subject
+		return user, nil
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+
+	if !s.DomainAllowed(email) {
+		return nil, ErrNotProvisioned
+	}
+	if strings.TrimSpace(name) == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	user = &models.User{
+		Email:       email,
+		Name:        name,
+		Role:        models.RoleUser,
+		OIDCIssuer:  issuer,
+		OIDCSubject: subject,
+	}
+	if err := user.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.users.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DomainAllowed reports whether a user with email is created on their
+// first login.
+func (s *Service) DomainAllowed(email string) bool {
+	_, domain, ok := strings.Cut(email, "@")
+	return ok && slices.ContainsFunc(s.cfg.AllowedDomains, func(d string) bool {
+		return strings.EqualFold(d, domain)
+	})
+}
+
+// discover returns the provider, fetching its discovery document on first
+// use. A failed discovery is retried on the next login.
+func (s *Service) discover(ctx context.Context) (*oidc.Provider, *oauth2.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.provider == nil {
+		provider, err := oidc.NewProvider(ctx, s.cfg.IssuerURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("discover identity provider: %w", err)
+		}
+		s.provider = provider
+	}
+	return s.provider, &oauth2.Config{
+		ClientID:     s.cfg.ClientID,
+		ClientSecret: s.cfg.ClientSecret,
+		RedirectURL:  s.cfg.RedirectURL,
+		Endpoint:     s.provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}, nil
+}
+
+// stateClaims are kept in the browser between Start and Finish: the state
+// and nonce that tie the callback and ID token to this login, and the PKCE
+// code verifier. A reauthentication also names the user and where to go
+// next.
+type stateClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	UserID   string `json:"user_id,omitempty"`
+	Next     string `json:"next,omitempty"`
+	jwt.RegisteredClaims
+}
@@ -0,0 +1,82 @@
+package sso_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/sso"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/sso/ssotest"
+)
+
+func TestLogin(t *testing.T) {
+	provider := ssotest.NewProvider(t)
+	users := memory.NewUserRepository()
+	existing := &models.User{Email: "alice@example.com", Name: "Alice", Role: models.RoleUser}
+	if err := users.Create(t.Context(), existing); err != nil {
+		t.Fatal(err)
+	}
+	s := sso.NewService(users, sso.Config{
+		IssuerURL:      provider.URL,
+		ClientID:       ssotest.ClientID,
+		ClientSecret:   ssotest.ClientSecret,
+		RedirectURL:    "http://app.test/login/sso/callback",
+		AllowedDomains: []string{"corp.example.com"},
+	}, "app-secret")
+
+	// login runs the flow for identity and returns the callback query.
+	login := func(identity ssotest.Identity) (string, url.Values) {
+		t.Helper()
+		authURL, state, err := s.Start(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		callback, err := url.Parse(provider.Authorize(authURL, identity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state, callback.Query()
+	}
+
+	// An unverified email does not link to the existing account.
+	state, query := login(ssotest.Identity{Subject: "sub-alice", Email: existing.Email})
+	if _, err := s.Finish(t.Context(), state, query); !errors.Is(err, sso.ErrEmailNotVerified) {
+		t.Fatalf("unverified email: err = %v, want ErrEmailNotVerified", err)
+	}
+
+	// A verified one does, and from then on the subject is enough.
+	state, query = login(ssotest.Identity{Subject: "sub-alice", Email: existing.Email, EmailVerified: true})
+	user, err := s.Finish(t.Context(), state, query)
+	if err != nil || user.ID != existing.ID {
+		t.Fatalf("linking by email: user = %+v, err = %v", user, err)
+	}
+	state, query = login(ssotest.Identity{Subject: "sub-alice", Email: "alice@elsewhere.test"})
+	if user, err := s.Finish(t.Context(), state, query); err != nil || user.ID != existing.ID {
+		t.Fatalf("linked subject: user = %+v, err = %v", user, err)
+	}
+
+	// A code is good for one login, and a state for its own login only.
+	if _, err := s.Finish(t.Context(), state, query); !errors.Is(err, sso.ErrInvalidToken) {
+		t.Errorf("replayed code: err = %v, want ErrInvalidToken", err)
+	}
+	_, query = login(ssotest.Identity{Subject: "sub-alice"})
+	if _, err := s.Finish(t.Context(), state, query); !errors.Is(err, sso.ErrInvalidState) {
+		t.Errorf("another login's state: err = %v, want ErrInvalidState", err)
+	}
+
+	// Unknown users get an account only in an allowed domain.
+	state, query = login(ssotest.Identity{Subject: "sub-mallory", Email: "mallory@other.test", EmailVerified: true})
+	if _, err := s.Finish(t.Context(), state, query); !errors.Is(err, sso.ErrNotProvisioned) {
+		t.Errorf("outside the allowed domains: err = %v, want ErrNotProvisioned", err)
+	}
+	state, query = login(ssotest.Identity{Subject: "sub-bob", Email: "bob@CORP.example.com", EmailVerified: true, Name: "Bob"})
+	user, err = s.Finish(t.Context(), state, query)
+	if err != nil {
+		t.Fatalf("provisioning: %v", err)
+	}
+	if user.ID.IsZero() || user.Name != "Bob" || user.Role != models.RoleUser || user.PasswordHash != "" || user.OIDCSubject != "sub-bob" {
+		t.Errorf("provisioned user = %+v", user)
+	}
+}
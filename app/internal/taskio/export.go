@@ -0,0 +1,164 @@
+// Package taskio converts tasks to and from the CSV, JSON and NDJSON files
+// used to export and import them.
+package taskio
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+)
+
+type Format string
+
+const (
+	CSV    Format = "csv"
+	JSON   Format = "json"
+	NDJSON Format = "ndjson"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{CSV, JSON, NDJSON}
+
+var ErrUnknownFormat = errors.New("format must be csv, json or ndjson")
+
+// ParseFormat accepts a format name in any case.
+func ParseFormat(s string) (Format, error) {
+	f := Format(strings.ToLower(strings.TrimSpace(s)))
+	switch f {
+	case CSV, JSON, NDJSON:
+		return f, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// FormatFromFilename picks the format from a file extension. JSONL files
+// are NDJSON.
+func FormatFromFilename(name string) (Format, error) {
+	ext := name[strings.LastIndex(name, ".")+1:]
+	if strings.EqualFold(ext, "jsonl") {
+		return NDJSON, nil
+	}
+	return ParseFormat(ext)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case NDJSON:
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+// Columns are the CSV export columns: the JSON names of the task's fields.
+// Only active tasks are exported, so deleted_at is left out.
+var Columns = []string{"id", "user_id", "title", "description", "status", "due_date", "created_at", "updated_at", "version"}
+
+// Writer writes tasks one at a time, so an export never holds more than
+// one task in memory. Close must be called to complete the file.
+type Writer struct {
+	format Format
+	w      io.Writer
+	csv    *csv.Writer
+	count  int
+}
+
+func NewWriter(w io.Writer, format Format) *Writer {
+	writer := &Writer{format: format, w: w}
+	if format == CSV {
+		writer.csv = csv.NewWriter(w)
+	}
+	return writer
+}
+
+func (w *Writer) Write(task *models.Task) error {
+	defer func() { w.count++ }()
+
+	switch w.format {
+	case CSV:
+		if w.count == 0 {
+			if err := w.csv.Write(Columns); err != nil {
+				return err
+			}
+		}
+		if err := w.csv.Write(csvRecord(task)); err != nil {
+			return err
+		}
+		// Flush every row so the export streams instead of buffering.
+		w.csv.Flush()
+		return w.csv.Error()
+	case JSON:
+		sep := ",\n"
+		if w.count == 0 {
+			sep = "[\n"
+		}
+		if _, err := io.WriteString(w.w, sep); err != nil {
+			return err
+		}
+		return writeJSON(w.w, task)
+	}
+	if err := writeJSON(w.w, task); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.w, "\n")
+	return err
+}
+
+// Close finishes the file. A CSV export of no tasks still has its header
+// and a JSON export is an empty array.
+func (w *Writer) Close() error {
+	switch w.format {
+	case CSV:
+		if w.count == 0 {
+			w.csv.Write(Columns)
+		}
+		w.csv.Flush()
+		return w.csv.Error()
+	case JSON:
+		end := "\n]\n"
+		if w.count == 0 {
+			end = "[]\n"
+		}
+		_, err := io.WriteString(w.w, end)
+		return err
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, task *models.Task) error {
+	b, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func csvRecord(task *models.Task) []string {
+	due := ""
+	if task.DueDate != nil {
+		due = formatTime(*task.DueDate)
+	}
+	return []string{
+		task.ID.Hex(),
+		task.UserID.Hex(),
+		task.Title,
+		task.Description,
+		task.Status,
+		due,
+		formatTime(task.CreatedAt),
+		formatTime(task.UpdatedAt),
+		strconv.FormatInt(task.Version, 10),
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,292 @@
+package taskio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrTooManyRows is returned by Read for files over the row limit.
+var ErrTooManyRows = errors.New("too many rows")
+
+// Table is an import file read into rows of text cells, one per column.
+type Table struct {
+	Columns []string
+	Rows    [][]string
+}
+
+// Read parses an import file of at most maxRows rows. CSV files need a
+// header row. JSON files hold an array of objects and NDJSON files one
+// object per line; their columns are the object keys, and values other than
+// strings keep their JSON text.
+func Read(r io.Reader, format Format, maxRows int) (*Table, error) {
+	// Spreadsheet programs often start UTF-8 files with a byte order mark.
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+
+	switch format {
+	case CSV:
+		return readCSV(br, maxRows)
+	case JSON, NDJSON:
+		return readJSON(br, format, maxRows)
+	}
+	return nil, ErrUnknownFormat
+}
+
+func readCSV(r io.Reader, maxRows int) (*Table, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	table := &Table{Columns: header}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return table, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(table.Rows) == maxRows {
+			return nil, ErrTooManyRows
+		}
+		// Short rows are padded; extra cells are dropped.
+		row := make([]string, len(header))
+		copy(row, record)
+		table.Rows = append(table.Rows, row)
+	}
+}
+
+func readJSON(r io.Reader, format Format, maxRows int) (*Table, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if format == JSON {
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return nil, errors.New("a JSON import must be an array of objects")
+		}
+	}
+
+	table := &Table{}
+	index := map[string]int{}
+	var objects []map[string]any
+	for n := 1; format == NDJSON || dec.More(); n++ {
+		var object map[string]any
+		err := dec.Decode(&object)
+		if format == NDJSON && errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil || object == nil {
+			return nil, fmt.Errorf("record %d is not a JSON object", n)
+		}
+		if len(objects) == maxRows {
+			return nil, ErrTooManyRows
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if _, ok := index[key]; !ok {
+				index[key] = len(table.Columns)
+				table.Columns = append(table.Columns, key)
+			}
+		}
+		objects = append(objects, object)
+	}
+	if format == JSON {
+		if _, err := dec.Token(); err != nil {
+			return nil, errors.New("a JSON import must be an array of objects")
+		}
+	}
+
+	for _, object := range objects {
+		row := make([]string, len(table.Columns))
+		for key, value := range object {
+			row[index[key]] = cell(value)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+func cell(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
+// Fields are the task fields an import can map a column to. The id column
+// is not imported; it recognises tasks exported from this app.
+var Fields = []string{"title", "description", "status", "due_date", "id"}
+
+// Mapping maps a task field to the column that holds it. Unmapped fields
+// are left empty.
+type Mapping map[string]string
+
+// fieldAliases are the column names DefaultMapping recognises, compared
+// ignoring case, spaces, dashes and underscores.
+var fieldAliases = map[string][]string{
+	"title":       {"title", "name", "summary", "task"},
+	"description": {"description", "notes", "details", "body"},
+	"status":      {"status", "state"},
+	"due_date":    {"duedate", "due", "deadline"},
+	"id":          {"id"},
+}
+
+// DefaultMapping guesses the mapping from the column names, so a file
+// exported from this app maps onto itself.
+func DefaultMapping(columns []string) Mapping {
+	normalize := strings.NewReplacer(" ", "", "-", "", "_", "")
+	mapping := Mapping{}
+	for _, field := range Fields {
+		for _, column := range columns {
+			name := normalize.Replace(strings.ToLower(strings.TrimSpace(column)))
+			if slices.Contains(fieldAliases[field], name) {
+				mapping[field] = column
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+// Row is the outcome of importing one row of a table.
+type Row struct {
+	// Number counts data rows from 1.
+	Number int
+	Task   models.Task
+	Errors models.ValidationErrors
+	// Duplicate is set for valid rows that match an existing task or an
+	// earlier row.
+	Duplicate bool
+}
+
+func (r *Row) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Plan converts every row to a task with the mapping, validates it with
+// Task.Validate and flags duplicates against index. Valid rows are added
+// to the index, so a row repeated in the file is flagged as well. Nothing
+// is stored.
+func Plan(table *Table, mapping Mapping, index *Index) []Row {
+	columns := map[string]int{}
+	for field, column := range mapping {
+		if i := slices.Index(table.Columns, column); i >= 0 {
+			columns[field] = i
+		}
+	}
+	value := func(record []string, field string) string {
+		if i, ok := columns[field]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := make([]Row, len(table.Rows))
+	for n, record := range table.Rows {
+		row := &rows[n]
+		row.Number = n + 1
+		row.Task = models.Task{
+			Title:       value(record, "title"),
+			Description: value(record, "description"),
+			Status:      normalizeStatus(value(record, "status")),
+		}
+
+		var errs models.ValidationErrors
+		if due := value(record, "due_date"); due != "" {
+			if dueDate, ok := parseDueDate(due); ok {
+				row.Task.DueDate = &dueDate
+			} else {
+				errs.Add("due_date", "due date must be a date (YYYY-MM-DD) or an RFC 3339 date-time")
+			}
+		}
+		errs.Merge(row.Task.Validate())
+		if len(errs) > 0 {
+			row.Errors = errs
+			continue
+		}
+
+		sourceID, _ := primitive.ObjectIDFromHex(value(record, "id"))
+		row.Duplicate = index.contains(sourceID, &row.Task)
+		index.add(sourceID, &row.Task)
+	}
+	return rows
+}
+
+// normalizeStatus accepts statuses as people write them, such as
+// "In progress".
+func normalizeStatus(s string) string {
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(s))
+}
+
+func parseDueDate(s string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Index recognises duplicate tasks: by ID, for tasks exported from this
+// app, or by title (ignoring case) and due date.
+type Index struct {
+	ids  map[primitive.ObjectID]bool
+	keys map[string]bool
+}
+
+func NewIndex() *Index {
+	return &Index{ids: map[primitive.ObjectID]bool{}, keys: map[string]bool{}}
+}
+
+// Add records an existing task.
+func (x *Index) Add(task *models.Task) {
+	x.add(task.ID, task)
+}
+
+func (x *Index) add(id primitive.ObjectID, task *models.Task) {
+	if !id.IsZero() {
+		x.ids[id] = true
+	}
+	x.keys[duplicateKey(task)] = true
+}
+
+func (x *Index) contains(id primitive.ObjectID, task *models.Task) bool {
+	return (!id.IsZero() && x.ids[id]) || x.keys[duplicateKey(task)]
+}
+
+func duplicateKey(task *models.Task) string {
+	due := ""
+	if task.DueDate != nil {
+		due = task.DueDate.UTC().Format("2006-01-02")
+	}
+	return strings.ToLower(strings.TrimSpace(task.Title)) + "\x00" + due
+}
@@ -0,0 +1,155 @@
+package taskio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func exportedTasks() []models.Task {
+	due := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	created := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	return []models.Task{
+		{ID: primitive.NewObjectID(), Title: "Plan, \"quoted\"", Description: "line one\nline two", Status: models.StatusPending, DueDate: &due, CreatedAt: created, UpdatedAt: created, Version: 1},
+		{ID: primitive.NewObjectID(), Title: "Ship", Status: models.StatusCompleted, CreatedAt: created, UpdatedAt: created, Version: 3},
+	}
+}
+
+func export(t *testing.T, format Format, tasks []models.Task) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf, format)
+	for i := range tasks {
+		if err := w.Write(&tasks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	tasks := exportedTasks()
+	for _, format := range Formats {
+		t.Run(string(format), func(t *testing.T) {
+			table, err := Read(bytes.NewReader(export(t, format, tasks)), format, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(table.Rows) != len(tasks) {
+				t.Fatalf("read %d rows, want %d", len(table.Rows), len(tasks))
+			}
+
+			rows := Plan(table, DefaultMapping(table.Columns), NewIndex())
+			for i, row := range rows {
+				want := tasks[i]
+				if !row.Valid() || row.Duplicate {
+					t.Fatalf("row %d: errors %v, duplicate %v", row.Number, row.Errors, row.Duplicate)
+				}
+				got := row.Task
+				if got.Title != want.Title || got.Description != want.Description || got.Status != want.Status ||
+					(got.DueDate == nil) != (want.DueDate == nil) || (got.DueDate != nil && !got.DueDate.Equal(*want.DueDate)) {
+					t.Errorf("row %d = %+v, want %+v", row.Number, got, want)
+				}
+			}
+
+			// Importing the export again finds every task by its ID, even
+			// if it was renamed since.
+			index := NewIndex()
+			for _, task := range tasks {
+				task.Title = "Renamed since the export"
+				index.Add(&task)
+			}
+			for _, row := range Plan(table, DefaultMapping(table.Columns), index) {
+				if !row.Duplicate {
+					t.Errorf("row %d was not recognised as a duplicate", row.Number)
+				}
+			}
+		})
+	}
+}
+
+func TestEmptyExport(t *testing.T) {
+	if got := string(export(t, CSV, nil)); got != strings.Join(Columns, ",")+"\n" {
+		t.Errorf("CSV = %q", got)
+	}
+	var tasks []models.Task
+	if err := json.Unmarshal(export(t, JSON, nil), &tasks); err != nil || tasks == nil {
+		t.Errorf("JSON = %v, %v; want an empty array", tasks, err)
+	}
+	if got := export(t, NDJSON, nil); len(got) != 0 {
+		t.Errorf("NDJSON = %q", got)
+	}
+}
+
+func TestPlanMappingAndValidation(t *testing.T) {
+	file := "\xef\xbb\xbfName,Notes,State,Deadline\n" +
+		"Buy milk,,In progress,2026-11-01\n" +
+		",no title,,\n" +
+		"Call Bob,,someday,31/12/2026\n" +
+		"buy MILK,again,,2026-11-01\n" +
+		"Buy milk,other day,,2026-11-02\n"
+	table, err := Read(strings.NewReader(file), CSV, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := DefaultMapping(table.Columns)
+	want := Mapping{"title": "Name", "description": "Notes", "status": "State", "due_date": "Deadline"}
+	if fmt.Sprint(mapping) != fmt.Sprint(want) {
+		t.Fatalf("DefaultMapping = %v, want %v", mapping, want)
+	}
+
+	rows := Plan(table, mapping, NewIndex())
+	if rows[0].Task.Status != models.StatusInProgress || rows[0].Task.DueDate == nil {
+		t.Errorf("row 1 = %+v", rows[0].Task)
+	}
+	if rows[1].Errors.For("title") == "" {
+		t.Errorf("row 2 errors = %v, want a title error", rows[1].Errors)
+	}
+	if rows[2].Errors.For("status") == "" || rows[2].Errors.For("due_date") == "" {
+		t.Errorf("row 3 errors = %v, want status and due date errors", rows[2].Errors)
+	}
+	if !rows[3].Duplicate || rows[4].Duplicate {
+		t.Errorf("duplicates = %v, %v; want the same title and due date only", rows[3].Duplicate, rows[4].Duplicate)
+	}
+
+	// Remapping changes what is imported.
+	rows = Plan(table, Mapping{"title": "Notes"}, NewIndex())
+	if rows[0].Valid() || rows[1].Task.Title != "no title" {
+		t.Errorf("remapped rows = %+v", rows[:2])
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		format Format
+		file   string
+	}{
+		{CSV, ""},
+		{CSV, "title\n\"unterminated\n"},
+		{JSON, `{"title":"not an array"}`},
+		{JSON, `[{"title":"a"}, 3]`},
+		{JSON, `[{"title":"a"}`},
+		{NDJSON, "{\"title\":\"a\"}\n[1]\n"},
+	} {
+		if _, err := Read(strings.NewReader(tc.file), tc.format, 10); err == nil {
+			t.Errorf("%s %q: no error", tc.format, tc.file)
+		}
+	}
+
+	if _, err := Read(strings.NewReader("title\na\nb\nc\n"), CSV, 2); !errors.Is(err, ErrTooManyRows) {
+		t.Errorf("CSV over the limit: %v", err)
+	}
+	if _, err := Read(strings.NewReader("{}\n{}\n{}\n"), NDJSON, 2); !errors.Is(err, ErrTooManyRows) {
+		t.Errorf("NDJSON over the limit: %v", err)
+	}
+}
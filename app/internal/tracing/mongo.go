@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type commandKey struct {
+	connectionID string
+	requestID    int64
+}
+
+// CommandMonitor returns a driver command monitor that records one client
+// span per MongoDB command, parented to the span in the operation context.
+// Command documents are not recorded since they may contain user data.
+func CommandMonitor() *event.CommandMonitor {
+	var spans sync.Map
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			collection := commandCollection(evt.Command, evt.CommandName)
+
+			name := evt.CommandName
+			if collection != "" {
+				name = collection + "." + evt.CommandName
+			}
+
+			attrs := []attribute.KeyValue{
+				semconv.DBSystemMongoDB,
+				semconv.DBNamespace(evt.DatabaseName),
+				semconv.DBOperationName(evt.CommandName),
+				attribute.String("network.connection.id", evt.ConnectionID),
+			}
+			if collection != "" {
+				attrs = append(attrs, semconv.DBCollectionName(collection))
+			}
+
+			_, span := Tracer().Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attrs...),
+			)
+			spans.Store(commandKey{evt.ConnectionID, evt.RequestID}, span)
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			if span, ok := spans.LoadAndDelete(commandKey{evt.ConnectionID, evt.RequestID}); ok {
+				span.(trace.Span).End()
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			if span, ok := spans.LoadAndDelete(commandKey{evt.ConnectionID, evt.RequestID}); ok {
+				s := span.(trace.Span)
+				s.RecordError(errors.New(evt.Failure))
+				s.SetStatus(codes.Error, evt.Failure)
+				s.End()
+			}
+		},
+	}
+}
+
+// commandCollection returns the collection a command targets. For most
+// commands it is the string value of the first element, keyed by the command
+// name (e.g. {"find": "tasks", ...}).
+func commandCollection(cmd bson.Raw, commandName string) string {
+	value, err := cmd.LookupErr(commandName)
+	if err != nil {
+		return ""
+	}
+	// Commands such as {"ping": 1} carry a number instead and have no
+	// collection.
+	collection, _ := value.StringValueOK()
+	return collection
+}
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCommandMonitor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	monitor := CommandMonitor()
+	ctx := context.Background()
+	started := func(conn string, id int64, name string, cmd bson.D) {
+		raw, err := bson.Marshal(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		monitor.Started(ctx, &event.CommandStartedEvent{
+			Command: raw, DatabaseName: "tasksdb", CommandName: name, RequestID: id, ConnectionID: conn,
+		})
+	}
+	finished := func(conn string, id int64, name string) event.CommandFinishedEvent {
+		return event.CommandFinishedEvent{CommandName: name, DatabaseName: "tasksdb", RequestID: id, ConnectionID: conn}
+	}
+
+	// Two connections reuse a request ID, and the commands finish in a
+	// different order than they started.
+	started("conn-1", 1, "find", bson.D{{Key: "find", Value: "tasks"}})
+	started("conn-2", 1, "insert", bson.D{{Key: "insert", Value: "users"}})
+	started("conn-1", 2, "ping", bson.D{{Key: "ping", Value: 1}})
+	monitor.Failed(ctx, &event.CommandFailedEvent{CommandFinishedEvent: finished("conn-2", 1, "insert"), Failure: "duplicate key"})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finished("conn-1", 2, "ping")})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finished("conn-1", 1, "find")})
+	// A second event for a command already finished is ignored.
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finished("conn-1", 1, "find")})
+
+	if n := len(recorder.Started()); n != 3 {
+		t.Fatalf("%d spans started, want 3", n)
+	}
+	ended := recorder.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("%d spans ended, want 3", len(ended))
+	}
+	want := []struct {
+		name   string
+		status codes.Code
+	}{
+		{"users.insert", codes.Error},
+		{"ping", codes.Unset},
+		{"tasks.find", codes.Unset},
+	}
+	for i, span := range ended {
+		if span.Name() != want[i].name || span.Status().Code != want[i].status {
+			t.Errorf("span %d = %s (%v), want %s (%v)", i, span.Name(), span.Status().Code, want[i].name, want[i].status)
+		}
+	}
+	if events := ended[0].Events(); len(events) != 1 || ended[0].Status().Description != "duplicate key" {
+		t.Errorf("failed span: events %v, status %v", events, ended[0].Status())
+	}
+}
@@ -0,0 +1,41 @@
+// Package trash removes tasks that have stayed in the trash longer than the
+// retention period.
+package trash
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/server"
+)
+
+// Purger returns a worker that permanently deletes tasks trashed more than
+// retention ago, once on start and then every interval. Running it on every
+// replica is safe: purging is idempotent.
+func Purger(tasks database.TaskStore, retention, interval time.Duration, logger *slog.Logger) server.Worker {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			purge(ctx, tasks, retention, logger)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+func purge(ctx context.Context, tasks database.TaskStore, retention time.Duration, logger *slog.Logger) {
+	purged, err := tasks.PurgeDeletedBefore(ctx, time.Now().Add(-retention))
+	switch {
+	case err != nil && ctx.Err() == nil:
+		logger.Error("failed to purge trashed tasks", "error", err)
+	case purged > 0:
+		logger.Info("purged trashed tasks", "count", purged, "retention", retention)
+	}
+}
@@ -0,0 +1,59 @@
+package trash
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPurgerRemovesExpiredTrash(t *testing.T) {
+	tasks := memory.NewTaskRepository()
+	owner := primitive.NewObjectID()
+
+	var created []*models.Task
+	for _, title := range []string{"old", "kept"} {
+		task := &models.Task{UserID: owner, Title: title}
+		if err := tasks.Create(t.Context(), task); err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, task)
+	}
+	if err := tasks.DeleteByUserID(t.Context(), created[0].ID.Hex(), owner); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		Purger(tasks, 10*time.Millisecond, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		trash, err := tasks.FindDeletedByUserID(t.Context(), owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(trash) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("trash was not purged: %v", trash)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if active, _ := tasks.FindByUserID(t.Context(), owner); len(active) != 1 {
+		t.Errorf("purge touched active tasks: %v", active)
+	}
+}
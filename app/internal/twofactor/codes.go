@@ -0,0 +1,127 @@
+package twofactor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	period = 30
+	// skew is how many time steps a code may be early or late, allowing
+	// for clock drift and slow typing.
+	skew = 1
+
+	recoveryCodeCount = 10
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    period,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// checkTOTP returns the time step of the user's TOTP secret that code was
+// generated for.
+func (s *Service) checkTOTP(user *models.User, code string, now time.Time) (int64, error) {
+	if !s.Available() {
+		return 0, ErrUnavailable
+	}
+	code = normalizeCode(code)
+	if user.TOTPSecret == "" || !isTOTPCode(code) {
+		return 0, ErrInvalidCode
+	}
+	secret, err := s.open(user.ID, user.TOTPSecret)
+	if err != nil {
+		return 0, err
+	}
+
+	current := now.Unix() / period
+	for _, step := range []int64{current, current - skew, current + skew} {
+		want, err := totp.GenerateCodeCustom(secret, time.Unix(step*period, 0), totpOpts)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(want)) == 1 {
+			return step, nil
+		}
+	}
+	return 0, ErrInvalidCode
+}
+
+// seal encrypts a TOTP secret. The user ID is authenticated along with it,
+// so a secret copied to another user does not decrypt.
+func (s *Service) seal(userID primitive.ObjectID, secret string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(secret), userID[:])
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Service) open(userID primitive.ObjectID, sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return "", errors.New("malformed TOTP secret")
+	}
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	secret, err := s.aead.Open(nil, nonce, ciphertext, userID[:])
+	if err != nil {
+		return "", errors.New("cannot decrypt TOTP secret; was the encryption key changed?")
+	}
+	return string(secret), nil
+}
+
+// normalizeCode drops the spaces and dashes people type or paste into
+// codes.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns recovery codes formatted for display, such as
+// "7kq2m-xd4fa", and their hashes for storage. Each carries 50 random
+// bits, so a fast hash is enough.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for range recoveryCodeCount {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a normalized recovery code.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,267 @@
+// Package twofactor implements TOTP two-factor authentication: enrolling
+// a user's authenticator app, single-use recovery codes, and checking the
+// code asked for as the second login step.
+package twofactor
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrUnavailable is returned when enrolling without an encryption key
+	// configured for the TOTP secrets.
+	ErrUnavailable = errors.New("two-factor authentication is not configured")
+	// ErrInvalidCode is returned for a wrong, expired or reused code.
+	ErrInvalidCode = errors.New("invalid two-factor code")
+	// ErrTooManyAttempts is returned, whatever the code, once a user has
+	// made MaxAttempts attempts within AttemptWindow without success.
+	ErrTooManyAttempts = errors.New("too many two-factor attempts")
+)
+
+// Codes are six digits, and Verify accepts three time steps, so guessing
+// is limited to MaxAttempts codes per AttemptWindow. The window is longer
+// than a pending login lasts, so a login that runs out of attempts cannot
+// be finished; the user has to wait and start again.
+const (
+	MaxAttempts   = 5
+	AttemptWindow = 15 * time.Minute
+)
+
+// Service enrolls users and verifies their codes. TOTP secrets are stored
+// on the user encrypted with AES-GCM, bound to the user's ID.
+type Service struct {
+	users            database.UserStore
+	settings         database.SettingsStore
+	aead             cipher.AEAD
+	issuer           string
+	requireForAdmins bool
+}
+
+// NewService returns a Service encrypting secrets with key, a 32-byte AES
+// key. Without a key users cannot enroll, and users already enrolled
+// cannot log in. issuer names the app in authenticator apps.
+// requireForAdmins requires two-factor authentication for admins whatever
+// the setting in settings says.
+func NewService(users database.UserStore, settings database.SettingsStore, key []byte, issuer string, requireForAdmins bool) (*Service, error) {
+	s := &Service{users: users, settings: settings, issuer: issuer, requireForAdmins: requireForAdmins}
+	if len(key) == 0 {
+		return s, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("two-factor encryption key: %w", err)
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Available reports whether an encryption key is configured.
+func (s *Service) Available() bool {
+	return s.aead != nil
+}
+
+// Required reports whether the user must enroll before they can log in.
+func (s *Service) Required(ctx context.Context, user *models.User) (bool, error) {
+	if user.Role != models.RoleAdmin {
+		return false, nil
+	}
+	return s.RequiredForAdmins(ctx)
+}
+
+// RequiredForAdmins reports whether admins must use two-factor
+// authentication, by the configuration or the admins' own setting. The
+// setting has no effect without an encryption key, so removing the key
+// cannot lock every admin out.
+func (s *Service) RequiredForAdmins(ctx context.Context) (bool, error) {
+	if s.requireForAdmins {
+		return true, nil
+	}
+	if !s.Available() {
+		return false, nil
+	}
+	settings, err := s.settings.Get(ctx)
+	if err != nil {
+		return false, err
+	}
+	return settings.RequireTwoFactorForAdmins, nil
+}
+
+// RequiredByConfig reports whether the configuration requires two-factor
+// authentication for admins, so the setting cannot turn it off.
+func (s *Service) RequiredByConfig() bool {
+	return s.requireForAdmins
+}
+
+// SetRequiredForAdmins changes the admins' setting. It fails with
+// ErrUnavailable when turning it on without an encryption key.
+func (s *Service) SetRequiredForAdmins(ctx context.Context, required bool) error {
+	if required && !s.Available() {
+		return ErrUnavailable
+	}
+	settings, err := s.settings.Get(ctx)
+	if err != nil {
+		return err
+	}
+	settings.RequireTwoFactorForAdmins = required
+	return s.settings.Save(ctx, settings)
+}
+
+// Enrollment is a new TOTP secret to be added to an authenticator app,
+// either by scanning QRCode or by typing in Secret.
+type Enrollment struct {
+	Secret string
+	URL    string
+	// QRCode is a PNG image of URL as a data URI.
+	QRCode string
+}
+
+// Begin generates a TOTP secret for the user and stores it unconfirmed,
+// replacing any earlier enrollment that was not finished.
+func (s *Service) Begin(ctx context.Context, user *models.User) (*Enrollment, error) {
+	if !s.Available() {
+		return nil, ErrUnavailable
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: s.issuer, AccountName: user.Email})
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.seal(user.ID, key.Secret())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.SetTOTPSecret(ctx, user.ID, sealed); err != nil {
+		return nil, err
+	}
+	return newEnrollment(key)
+}
+
+// Pending returns the enrollment Begin started, to show it again after a
+// wrong code.
+func (s *Service) Pending(user *models.User) (*Enrollment, error) {
+	if !s.Available() {
+		return nil, ErrUnavailable
+	}
+	if user.TwoFactorEnabled || user.TOTPSecret == "" {
+		return nil, errors.New("no two-factor enrollment in progress")
+	}
+	secret, err := s.open(user.ID, user.TOTPSecret)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base32NoPadding.DecodeString(secret)
+	if err != nil {
+		return nil, err
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: s.issuer, AccountName: user.Email, Secret: raw})
+	if err != nil {
+		return nil, err
+	}
+	return newEnrollment(key)
+}
+
+func newEnrollment(key *otp.Key) (*Enrollment, error) {
+	png, err := qrcode.Encode(key.URL(), qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+	return &Enrollment{
+		Secret: key.Secret(),
+		URL:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// Confirm turns two-factor authentication on once the user enters a code
+// from the secret stored by Begin, and returns their recovery codes. The
+// codes are stored hashed and cannot be shown again.
+func (s *Service) Confirm(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.TwoFactorEnabled {
+		return nil, database.ErrTwoFactorEnabled
+	}
+	step, err := s.checkTOTP(user, code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.EnableTwoFactor(ctx, user.ID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks a code from the user's authenticator app, or one of their
+// recovery codes, and uses it up. It reports whether a recovery code was
+// used. Every call counts towards MaxAttempts until one succeeds.
+func (s *Service) Verify(ctx context.Context, user *models.User, code string) (recovery bool, err error) {
+	if !user.TwoFactorEnabled {
+		return false, ErrInvalidCode
+	}
+	err = s.users.AddTwoFactorAttempt(ctx, user.ID, time.Now(), MaxAttempts, AttemptWindow)
+	if errors.Is(err, database.ErrTooManyAttempts) {
+		return false, ErrTooManyAttempts
+	}
+	if err != nil {
+		return false, err
+	}
+	if recovery, err = s.verify(ctx, user, code); err != nil {
+		return recovery, err
+	}
+	return recovery, s.users.ResetTwoFactorAttempts(ctx, user.ID)
+}
+
+func (s *Service) verify(ctx context.Context, user *models.User, code string) (recovery bool, err error) {
+	code = normalizeCode(code)
+	if !isTOTPCode(code) {
+		err := s.users.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code))
+		if errors.Is(err, database.ErrCodeUsed) {
+			return true, ErrInvalidCode
+		}
+		return true, err
+	}
+
+	step, err := s.checkTOTP(user, code, time.Now())
+	if err != nil {
+		return false, err
+	}
+	err = s.users.UseTOTPStep(ctx, user.ID, step)
+	if errors.Is(err, database.ErrCodeUsed) {
+		return false, ErrInvalidCode
+	}
+	return false, err
+}
+
+// Disable turns two-factor authentication off.
+func (s *Service) Disable(ctx context.Context, userID primitive.ObjectID) error {
+	return s.users.DisableTwoFactor(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with new ones
+// and returns them.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID primitive.ObjectID) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.SetRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
@@ -0,0 +1,184 @@
+package twofactor
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/database/memory"
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+	"github.com/pquerna/otp/totp"
+)
+
+var testKey = bytes.Repeat([]byte{7}, 32)
+
+func TestEnrollAndVerify(t *testing.T) {
+	ctx := t.Context()
+	users := memory.NewUserRepository()
+	s, err := NewService(users, memory.NewSettingsRepository(), testKey, "Task Manager", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Email: "ada@example.com", Name: "Ada", Role: models.RoleUser}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	reload := func() *models.User {
+		t.Helper()
+		u, err := users.FindByID(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	codeAt := func(secret string, at time.Time) string {
+		t.Helper()
+		code, err := totp.GenerateCodeCustom(secret, at, totpOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	enrollment, err := s.Begin(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.QRCode, "data:image/png;base64,") || !strings.Contains(enrollment.URL, "issuer=Task") {
+		t.Errorf("enrollment = %+v", enrollment)
+	}
+	if stored := reload(); stored.TwoFactorEnabled || stored.TOTPSecret == "" || strings.Contains(stored.TOTPSecret, enrollment.Secret) {
+		t.Fatalf("the secret must be stored encrypted and unconfirmed: %+v", stored)
+	}
+
+	if again, err := s.Pending(reload()); err != nil || again.Secret != enrollment.Secret || again.URL != enrollment.URL {
+		t.Errorf("Pending = %+v, %v; want the same enrollment", again, err)
+	}
+
+	code := codeAt(enrollment.Secret, time.Now())
+	wrong := string('0'+(code[0]-'0'+1)%10) + code[1:]
+	if _, err := s.Confirm(ctx, reload(), wrong); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Confirm with a wrong code: %v", err)
+	}
+	codes, err := s.Confirm(ctx, reload(), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || !reload().TwoFactorEnabled {
+		t.Fatalf("Confirm returned %d codes, enabled = %v", len(codes), reload().TwoFactorEnabled)
+	}
+
+	// The code that confirmed enrollment cannot log in; the next one can,
+	// once.
+	if _, err := s.Verify(ctx, reload(), codeAt(enrollment.Secret, time.Now())); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replayed code: %v", err)
+	}
+	next := codeAt(enrollment.Secret, time.Now().Add(period*time.Second))
+	if recovery, err := s.Verify(ctx, reload(), next[:3]+" "+next[3:]); err != nil || recovery {
+		t.Fatalf("Verify = %v, %v", recovery, err)
+	}
+	if _, err := s.Verify(ctx, reload(), next); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replayed code: %v", err)
+	}
+
+	// Recovery codes work once, in any case.
+	if recovery, err := s.Verify(ctx, reload(), strings.ToUpper(codes[0])); err != nil || !recovery {
+		t.Fatalf("Verify with a recovery code = %v, %v", recovery, err)
+	}
+	if _, err := s.Verify(ctx, reload(), codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("reused recovery code: %v", err)
+	}
+	if n := len(reload().RecoveryCodes); n != recoveryCodeCount-1 {
+		t.Errorf("%d recovery codes left", n)
+	}
+
+	fresh, err := s.RegenerateRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(ctx, reload(), codes[1]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("replaced recovery code: %v", err)
+	}
+	if _, err := s.Verify(ctx, reload(), fresh[0]); err != nil {
+		t.Errorf("new recovery code: %v", err)
+	}
+
+	// Another key cannot decrypt the secret.
+	other, _ := NewService(users, memory.NewSettingsRepository(), bytes.Repeat([]byte{8}, 32), "Task Manager", false)
+	later := codeAt(enrollment.Secret, time.Now().Add(2*period*time.Second))
+	if _, err := other.Verify(ctx, reload(), later); err == nil || errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Verify with the wrong key: %v", err)
+	}
+
+	if err := s.Disable(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(ctx, reload(), later); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Verify after Disable: %v", err)
+	}
+}
+
+func TestUnavailableWithoutKey(t *testing.T) {
+	s, err := NewService(memory.NewUserRepository(), memory.NewSettingsRepository(), nil, "Task Manager", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Available() {
+		t.Error("Available without a key")
+	}
+	if _, err := s.Begin(t.Context(), &models.User{Email: "a@example.com"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Begin = %v, want ErrUnavailable", err)
+	}
+	if required, _ := s.Required(t.Context(), &models.User{Role: models.RoleAdmin}); !required {
+		t.Error("Required should hold for admins")
+	}
+	if required, _ := s.Required(t.Context(), &models.User{Role: models.RoleUser}); required {
+		t.Error("Required should only hold for admins")
+	}
+	if err := s.SetRequiredForAdmins(t.Context(), true); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("SetRequiredForAdmins = %v, want ErrUnavailable", err)
+	}
+}
+
+func TestRequiredForAdminsSetting(t *testing.T) {
+	ctx := t.Context()
+	settings := memory.NewSettingsRepository()
+	s, err := NewService(memory.NewUserRepository(), settings, testKey, "Task Manager", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, user := &models.User{Role: models.RoleAdmin}, &models.User{Role: models.RoleUser}
+	check := func(want bool) {
+		t.Helper()
+		if required, err := s.Required(ctx, admin); err != nil || required != want {
+			t.Errorf("Required for an admin = %v, %v; want %v", required, err, want)
+		}
+		if required, err := s.Required(ctx, user); err != nil || required {
+			t.Errorf("Required for a user = %v, %v; want false", required, err)
+		}
+	}
+
+	check(false)
+	if err := s.SetRequiredForAdmins(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	check(true)
+	if stored, _ := settings.Get(ctx); !stored.RequireTwoFactorForAdmins {
+		t.Error("the setting was not saved")
+	}
+	if err := s.SetRequiredForAdmins(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	check(false)
+
+	// Without a key the setting is ignored, so admins are not locked out.
+	if err := s.SetRequiredForAdmins(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	keyless, _ := NewService(memory.NewUserRepository(), settings, nil, "Task Manager", false)
+	if required, _ := keyless.Required(ctx, admin); required {
+		t.Error("Required without a key")
+	}
+}
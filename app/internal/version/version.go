@@ -0,0 +1,40 @@
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// Build metadata, injected at link time:
+//
+//	go build -ldflags "-X github.com/cfegela/azure-aca-go-templ-mongo/internal/version.Version=v1.2.3 \
+//	  -X github.com/cfegela/azure-aca-go-templ-mongo/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/cfegela/azure-aca-go-templ-mongo/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// Handler serves the build metadata as JSON.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Get())
+}
@@ -43,17 +43,27 @@ func Dashboard(userName string, tasks []models.Task) templ.Component {
 				}()
 			}
 			ctx = templ.InitializeContext(ctx)
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<div class=\"container\"><div class=\"dashboard-header\"><h2>My Tasks</h2><a href=\"/tasks/new\" class=\"btn btn-primary\">+ New Task</a></div>")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<div class=\"container\"><div class=\"dashboard-header\"><h2>My Tasks</h2><div class=\"header-actions\"><a href=\"/tasks/import\" class=\"btn btn-secondary\">Import</a> ")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			if len(tasks) > 0 {
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "<span class=\"export-links\">Export: <a href=\"/tasks/export?format=csv\">CSV</a> <a href=\"/tasks/export?format=json\">JSON</a> <a href=\"/tasks/export?format=ndjson\">NDJSON</a></span> ")
+				if templ_7745c5c3_Err != nil {
+					return templ_7745c5c3_Err
+				}
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "<a href=\"/tasks/new\" class=\"btn btn-primary\">+ New Task</a></div></div>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
 			if len(tasks) == 0 {
-				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "<div class=\"empty-state\"><p>No tasks yet. Create your first task to get started!</p><a href=\"/tasks/new\" class=\"btn btn-primary\">Create Task</a></div>")
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 4, "<div class=\"empty-state\"><p>No tasks yet. Create your first task to get started!</p><a href=\"/tasks/new\" class=\"btn btn-primary\">Create Task</a></div>")
 				if templ_7745c5c3_Err != nil {
 					return templ_7745c5c3_Err
 				}
 			} else {
-				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "<div class=\"tasks-grid\">")
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "<form id=\"bulk-form\" action=\"/tasks/bulk\" method=\"post\" class=\"bulk-toolbar\"><label for=\"bulk-action\">With selected:</label> <select id=\"bulk-action\" name=\"action\"><option value=\"complete\">Mark completed</option> <option value=\"due_date\">Set due date</option> <option value=\"delete\">Delete</option></select> <input type=\"date\" name=\"due_date\" aria-label=\"Due date\"> <small>Leave the date empty to clear it.</small> <button type=\"submit\" class=\"btn btn-small btn-primary\">Apply</button></form><div class=\"tasks-grid\">")
 				if templ_7745c5c3_Err != nil {
 					return templ_7745c5c3_Err
 				}
@@ -63,12 +73,12 @@ func Dashboard(userName string, tasks []models.Task) templ.Component {
 						return templ_7745c5c3_Err
 					}
 				}
-				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 4, "</div>")
+				templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "</div>")
 				if templ_7745c5c3_Err != nil {
 					return templ_7745c5c3_Err
 				}
 			}
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "</div>")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 7, "</div>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
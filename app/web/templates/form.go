@@ -0,0 +1,63 @@
+package templates
+
+import (
+	"net/url"
+
+	"github.com/cfegela/azure-aca-go-templ-mongo/internal/models"
+)
+
+// FormState carries a rejected submission back into its form: the values
+// exactly as submitted and the error for each invalid field. A nil
+// *FormState means the form is shown for the first time.
+type FormState struct {
+	Values url.Values
+	Errors models.ValidationErrors
+	// Stale is set when the record was changed by someone else after the
+	// form was loaded. The form keeps the submitted values but takes the
+	// current version, so submitting again overwrites deliberately.
+	Stale bool
+}
+
+// NewFormState captures the submitted form values of a request that failed
+// validation.
+func NewFormState(values url.Values, errs models.ValidationErrors) *FormState {
+	return &FormState{Values: values, Errors: errs}
+}
+
+func (f *FormState) submitted() bool {
+	return f != nil && f.Values != nil
+}
+
+// IsStale reports whether the submission was based on an outdated record.
+func (f *FormState) IsStale() bool {
+	return f != nil && f.Stale
+}
+
+// HasErrors reports whether any field failed validation.
+func (f *FormState) HasErrors() bool {
+	return f != nil && len(f.Errors) > 0
+}
+
+// Value returns the submitted value for name.
+func (f *FormState) Value(name string) string {
+	if f == nil {
+		return ""
+	}
+	return f.Values.Get(name)
+}
+
+// Error returns the error message for name, or "".
+func (f *FormState) Error(name string) string {
+	if f == nil {
+		return ""
+	}
+	return f.Errors.For(name)
+}
+
+// fieldClass marks inputs with an error for styling.
+func fieldClass(f *FormState, name string) string {
+	if f.Error(name) != "" {
+		return "invalid"
+	}
+	return ""
+}
@@ -42,7 +42,7 @@ func Header(userName string) templ.Component {
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "</span> <a href=\"/admin/invites\" class=\"nav-link\">Invites</a><form action=\"/logout\" method=\"post\" style=\"display: inline;\"><button type=\"submit\" class=\"btn btn-secondary\">Logout</button></form></nav></div></div></header>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "</span> <a href=\"/trash\" class=\"nav-link\">Trash</a> <a href=\"/account\" class=\"nav-link\">Account</a> <a href=\"/admin/invites\" class=\"nav-link\">Invites</a> <a href=\"/admin/users\" class=\"nav-link\">Users</a> <a href=\"/admin/2fa\" class=\"nav-link\">2FA</a><form action=\"/logout\" method=\"post\" class=\"inline-form\"><button type=\"submit\" class=\"btn btn-secondary\">Logout</button></form></nav></div></div></header>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
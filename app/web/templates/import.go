@@ -0,0 +1,37 @@
+package templates
+
+import "github.com/cfegela/azure-aca-go-templ-mongo/internal/taskio"
+
+// ImportForm is an import being previewed. The file travels with the form
+// in Data, so the preview can be changed and the import confirmed without
+// keeping uploads on the server.
+type ImportForm struct {
+	Format            taskio.Format
+	Data              string
+	Columns           []string
+	Mapping           taskio.Mapping
+	Rows              []taskio.Row
+	IncludeDuplicates bool
+	// IdempotencyKey is fresh for every preview, so confirming the same
+	// preview twice imports once.
+	IdempotencyKey string
+}
+
+// Importable counts the rows that confirming the preview would create.
+func (f *ImportForm) Importable() int {
+	n := 0
+	for _, row := range f.Rows {
+		if row.Valid() && (!row.Duplicate || f.IncludeDuplicates) {
+			n++
+		}
+	}
+	return n
+}
+
+var importFieldLabels = map[string]string{
+	"title":       "Title",
+	"description": "Description",
+	"status":      "Status",
+	"due_date":    "Due date",
+	"id":          "Exported ID (duplicate check only)",
+}
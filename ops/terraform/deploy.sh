@@ -59,7 +59,11 @@ echo "$ACR_PASSWORD" | docker login "$ACR_LOGIN_SERVER" -u "$ACR_USERNAME" --pas
 # Build and push image
 echo -e "${YELLOW}Building Docker image...${NC}"
 cd ../../app
-docker build -t "$ACR_LOGIN_SERVER/go-tasks-app:latest" .
+docker build \
+    --build-arg VERSION="$(git describe --tags --always --dirty 2>/dev/null || echo dev)" \
+    --build-arg COMMIT="$(git rev-parse HEAD 2>/dev/null || echo unknown)" \
+    --build-arg BUILD_DATE="$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+    -t "$ACR_LOGIN_SERVER/go-tasks-app:latest" .
 
 echo -e "${YELLOW}Pushing image to ACR...${NC}"
 docker push "$ACR_LOGIN_SERVER/go-tasks-app:latest"
@@ -119,6 +119,17 @@ resource "azurerm_key_vault_secret" "jwt_secret" {
   ]
 }
 
+# Key Vault Secret for the seeded admin password
+resource "azurerm_key_vault_secret" "admin_password" {
+  name         = "admin-password"
+  value        = var.admin_password
+  key_vault_id = azurerm_key_vault.main.id
+
+  depends_on = [
+    azurerm_role_assignment.kv_admin
+  ]
+}
+
 # Role assignment for current user to manage Key Vault
 resource "azurerm_role_assignment" "kv_admin" {
   scope                = azurerm_key_vault.main.id
@@ -187,6 +198,12 @@ resource "azurerm_container_app" "main" {
     identity            = azurerm_user_assigned_identity.app.id
   }
 
+  secret {
+    name                = "admin-password"
+    key_vault_secret_id = azurerm_key_vault_secret.admin_password.id
+    identity            = azurerm_user_assigned_identity.app.id
+  }
+
   template {
     min_replicas = var.min_replicas
     max_replicas = var.max_replicas
@@ -222,16 +239,21 @@ resource "azurerm_container_app" "main" {
         value = var.jwt_expiry
       }
 
+      env {
+        name        = "ADMIN_PASSWORD"
+        secret_name = "admin-password"
+      }
+
       liveness_probe {
         transport = "HTTP"
         port      = 8080
-        path      = "/health"
+        path      = "/livez"
       }
 
       readiness_probe {
         transport = "HTTP"
         port      = 8080
-        path      = "/health"
+        path      = "/readyz"
       }
     }
   }
@@ -65,10 +65,17 @@ variable "jwt_secret" {
   default     = ""
 }
 
+variable "admin_password" {
+  description = "Initial admin password used by the seed tool (required outside dev mode)"
+  type        = string
+  sensitive   = true
+  default     = ""
+}
+
 variable "jwt_expiry" {
-  description = "JWT token expiry duration"
+  description = "Access token lifetime; sessions are renewed with refresh tokens"
   type        = string
-  default     = "24h"
+  default     = "15m"
 }
 
 variable "min_replicas" {